@@ -40,9 +40,22 @@ check_docker_compose_file () {
     fi
 }
 
+# The secret shared by nanocloud and plaza is generated at the first start
+# unless PLAZA_SECRET is set already.
+export_plaza_secret () {
+    SECRETFILE=${PWD}/nanocloud/plaza.secret
+
+    if [ -z "${PLAZA_SECRET}" ] && [ ! -s "${SECRETFILE}" ]; then
+        od -An -tx1 -N32 /dev/urandom | tr -d ' \n' > "${SECRETFILE}"
+        chmod 600 "${SECRETFILE}"
+    fi
+    export PLAZA_SECRET=${PLAZA_SECRET:-$(cat "${SECRETFILE}")}
+}
+
 case $COMMAND in
     "start")
         check_docker_compose_file
+        export_plaza_secret
         docker-compose -f ${PWD}/nanocloud/docker-compose.yml up -d
         ;;
     "stop")
@@ -75,5 +88,6 @@ esac
 if [ -z "${COMMAND}" ]; then
     # If no argument is provided then install Nanocloud
     docker run -e HOST_UID=$SCRIPT_UID -v ${PWD}/nanocloud:/var/lib/nanocloud --rm nanocloud/community:${COMMUNITY_TAG}
+    export_plaza_secret
     docker-compose -f ${PWD}/nanocloud/docker-compose.yml up -d
 fi
@@ -161,7 +161,7 @@ func CheckVMStates(response VMstatus) []VmInfo {
 }
 
 func CheckRDS() bool {
-	resp, err := http.Get("http://" + conf.Server + ":9090/checkrds")
+	resp, err := plazaGet("http://" + conf.Server + ":9090/checkrds")
 	if err != nil {
 		log.Error(err)
 		return false
@@ -252,7 +252,7 @@ func GetList() (VMstatus, error) {
 
 func Stop(name string) error {
 	log.Info("stopping : ", name)
-	resp, err := http.Get("http://" + conf.Server + ":9090/shutdown")
+	resp, err := plazaGet("http://" + conf.Server + ":9090/shutdown")
 	if err != nil {
 		log.Error(err)
 		return VMShutdownFailed
@@ -310,6 +310,11 @@ func createIso() error {
 		return err
 	}
 
+	err = ioutil.WriteFile(path.Join(disk, "plaza.secret"), []byte(conf.secret), 0600)
+	if err != nil {
+		return err
+	}
+
 	cmd := exec.Command(
 		"genisoimage",
 		"-o",
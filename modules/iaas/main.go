@@ -39,6 +39,7 @@ type Configuration struct {
 	User       string
 	Password   string
 	windowsURL string
+	secret     string
 }
 
 type hash map[string]interface{}
@@ -206,6 +207,12 @@ func main() {
 	conf.Password = env("PASSWORD", "ItsPass1942+")
 	conf.User = env("USER", "Administrator")
 	conf.instDir = os.Getenv("INSTALLATION_DIR")
+	conf.secret = os.Getenv("PLAZA_SECRET")
+
+	if len(conf.secret) == 0 {
+		log.Error("PLAZA_SECRET is not set, plaza would reject the requests of the iaas module")
+		return
+	}
 
 	if os.Getenv("PWD") != "" {
 		conf.root = os.Getenv("PWD")
@@ -0,0 +1,70 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2015 Nanocloud Software
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Requests to plaza are signed like the ones of nanocloud: an HMAC-SHA256 of
+// the method, the request URI, a timestamp, a nonce and the payload hash keyed
+// with PLAZA_SECRET.
+func signPlazaRequest(req *http.Request, body []byte) error {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return err
+	}
+
+	nonce := hex.EncodeToString(b)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	sum := sha256.Sum256(body)
+	payload := hex.EncodeToString(sum[:])
+
+	mac := hmac.New(sha256.New, []byte(conf.secret))
+	mac.Write([]byte(req.Method + "\n" + req.URL.RequestURI() + "\n" + timestamp + "\n" + nonce + "\n" + payload))
+
+	req.Header.Set("X-Plaza-Timestamp", timestamp)
+	req.Header.Set("X-Plaza-Nonce", nonce)
+	req.Header.Set("X-Plaza-Content-Sha256", payload)
+	req.Header.Set("X-Plaza-Signature", hex.EncodeToString(mac.Sum(nil)))
+	return nil
+}
+
+// plazaGet sends a signed GET request to plaza.
+func plazaGet(url string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = signPlazaRequest(req, nil)
+	if err != nil {
+		return nil, err
+	}
+	return http.DefaultClient.Do(req)
+}
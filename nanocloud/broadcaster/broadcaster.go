@@ -5,20 +5,107 @@ import (
 	"sync"
 )
 
+// Only the last kMaxHistory bytes written are replayed to late writers.
+const kMaxHistory = 1024 * 1024
+
 type Broadcaster struct {
-	outs []io.Writer
-	mut  sync.Mutex
+	outs    []io.Writer
+	history []byte
+	mut     sync.Mutex
+}
+
+// replayer forwards the writes to its writer once the history has been
+// replayed to it. They are buffered until then.
+type replayer struct {
+	w         io.Writer
+	mut       sync.Mutex
+	replaying bool
+	pending   []byte
+	err       error
 }
 
+func (r *replayer) Write(buff []byte) (int, error) {
+	r.mut.Lock()
+	if r.err != nil {
+		r.mut.Unlock()
+		return 0, r.err
+	}
+	if r.replaying {
+		r.pending = append(r.pending, buff...)
+		r.mut.Unlock()
+		return len(buff), nil
+	}
+	r.mut.Unlock()
+
+	return r.w.Write(buff)
+}
+
+// replay writes the history then what has been written meanwhile.
+func (r *replayer) replay(history []byte) {
+	buff := history
+	for {
+		if len(buff) > 0 {
+			_, err := r.w.Write(buff)
+			if err != nil {
+				r.mut.Lock()
+				r.err = err
+				r.pending = nil
+				r.mut.Unlock()
+				return
+			}
+		}
+
+		r.mut.Lock()
+		if len(r.pending) == 0 {
+			r.replaying = false
+			r.mut.Unlock()
+			return
+		}
+		buff = r.pending
+		r.pending = nil
+		r.mut.Unlock()
+	}
+}
+
+// Add registers w. Everything previously written to the broadcaster is written
+// to w first so it doesn't miss the beginning of the output. The history is
+// written without holding the lock so a slow writer doesn't block the others.
 func (b *Broadcaster) Add(w io.Writer) {
 	b.mut.Lock()
-	b.outs = append(b.outs, w)
+	if len(b.history) == 0 {
+		b.outs = append(b.outs, w)
+		b.mut.Unlock()
+		return
+	}
+
+	history := make([]byte, len(b.history))
+	copy(history, b.history)
+
+	r := &replayer{w: w, replaying: true}
+	b.outs = append(b.outs, r)
 	b.mut.Unlock()
+
+	r.replay(history)
+}
+
+// History returns a copy of what has been written to the broadcaster.
+func (b *Broadcaster) History() []byte {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	rt := make([]byte, len(b.history))
+	copy(rt, b.history)
+	return rt
 }
 
 func (b *Broadcaster) Write(buff []byte) (total int, err error) {
 	b.mut.Lock()
 
+	b.history = append(b.history, buff...)
+	if len(b.history) > kMaxHistory {
+		b.history = b.history[len(b.history)-kMaxHistory:]
+	}
+
 	newOutLength := 0
 
 	for i, out := range b.outs {
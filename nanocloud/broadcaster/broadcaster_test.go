@@ -0,0 +1,125 @@
+package broadcaster
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+type failingWriter struct {
+	calls int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.calls++
+	return 0, errors.New("fake-generated-error")
+}
+
+func TestWrite(t *testing.T) {
+	var b Broadcaster
+	var out1, out2 bytes.Buffer
+
+	b.Add(&out1)
+	b.Add(&out2)
+	b.Write([]byte("hello"))
+
+	if out1.String() != "hello" || out2.String() != "hello" {
+		t.Errorf("Every output should receive the written data")
+	}
+}
+
+func TestLateWriter(t *testing.T) {
+	var b Broadcaster
+	var early, late bytes.Buffer
+
+	b.Add(&early)
+	b.Write([]byte("hello "))
+	b.Add(&late)
+	b.Write([]byte("world"))
+
+	if early.String() != "hello world" {
+		t.Errorf("Unexpected output: %q", early.String())
+	}
+	if late.String() != "hello world" {
+		t.Errorf("Late writers should receive the history first, got: %q", late.String())
+	}
+	if string(b.History()) != "hello world" {
+		t.Errorf("Unexpected history: %q", string(b.History()))
+	}
+}
+
+func TestFailingWriter(t *testing.T) {
+	var b Broadcaster
+	var out bytes.Buffer
+	failing := &failingWriter{}
+
+	b.Add(failing)
+	b.Add(&out)
+	b.Write([]byte("hello"))
+	b.Write([]byte("world"))
+
+	if failing.calls != 1 {
+		t.Errorf("Failing writers should be removed, called %d times", failing.calls)
+	}
+	if out.String() != "helloworld" {
+		t.Errorf("Unexpected output: %q", out.String())
+	}
+}
+
+func TestHistoryLimit(t *testing.T) {
+	var b Broadcaster
+
+	b.Write(bytes.Repeat([]byte("a"), kMaxHistory))
+	b.Write([]byte("b"))
+
+	history := b.History()
+	if len(history) != kMaxHistory {
+		t.Errorf("History should be limited to %d bytes, got %d", kMaxHistory, len(history))
+	}
+	if history[len(history)-1] != 'b' {
+		t.Errorf("History should keep the last written bytes")
+	}
+}
+
+type blockingWriter struct {
+	bytes.Buffer
+	release chan struct{}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return w.Buffer.Write(p)
+}
+
+func TestSlowLateWriter(t *testing.T) {
+	var b Broadcaster
+	late := &blockingWriter{release: make(chan struct{})}
+
+	b.Write([]byte("hello "))
+
+	added := make(chan struct{})
+	go func() {
+		b.Add(late)
+		close(added)
+	}()
+
+	written := make(chan struct{})
+	go func() {
+		b.Write([]byte("world"))
+		close(written)
+	}()
+
+	select {
+	case <-written:
+	case <-time.After(time.Second):
+		t.Fatal("Writes should not wait for the history to be replayed")
+	}
+
+	close(late.release)
+	<-added
+
+	if late.String() != "hello world" {
+		t.Errorf("Late writers should receive the history then the new writes, got: %q", late.String())
+	}
+}
@@ -23,6 +23,12 @@
 package vms
 
 import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+
 	log "github.com/Sirupsen/logrus"
 
 	"github.com/Nanocloud/community/nanocloud/vms"
@@ -37,31 +43,210 @@ const (
 	StatusCreating   = vms.StatusCreating
 )
 
-var vm *vms.VM
+// Machine and machine type IDs exposed by this package are prefixed by the
+// name of the driver that owns them: "{driver}:{id}".
+const separator = ":"
+
+var (
+	DriverNotFound = errors.New("driver not found")
+	InvalidID      = errors.New("invalid machine id")
+)
+
+var (
+	drivers = make(map[string]vms.VM)
+	mutex   sync.RWMutex
+)
+
+// machine wraps a driver machine so its ID is namespaced by the driver name.
+type machine struct {
+	vms.Machine
+	driver string
+}
+
+func (m *machine) Id() string {
+	return JoinID(m.driver, m.Machine.Id())
+}
+
+func (m *machine) Driver() string {
+	return m.driver
+}
+
+func (m *machine) Type() (vms.MachineType, error) {
+	t, err := m.Machine.Type()
+	if err != nil || t == nil {
+		return t, err
+	}
+	return &machineType{t, m.driver}, nil
+}
+
+// machineType wraps a driver machine type so its ID is namespaced by the
+// driver name.
+type machineType struct {
+	vms.MachineType
+	driver string
+}
+
+func (t *machineType) GetID() string {
+	return JoinID(t.driver, t.MachineType.GetID())
+}
+
+func (t *machineType) Driver() string {
+	return t.driver
+}
+
+// MarshalJSON serializes the wrapped type so its attributes aren't nested
+// in the JSON API payloads.
+func (t *machineType) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.MachineType)
+}
+
+// SplitID returns the driver name and the driver specific ID of a namespaced
+// machine or machine type ID.
+func SplitID(id string) (string, string, error) {
+	splt := strings.SplitN(id, separator, 2)
+	if len(splt) != 2 || splt[0] == "" || splt[1] == "" {
+		return "", "", InvalidID
+	}
+	return splt[0], splt[1], nil
+}
+
+// JoinID returns the namespaced ID of a driver machine or machine type.
+func JoinID(driver, id string) string {
+	return driver + separator + id
+}
+
+func getDriver(name string) (vms.VM, error) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	v, ok := drivers[name]
+	if !ok {
+		return nil, DriverNotFound
+	}
+	return v, nil
+}
+
+// Add registers an opened driver under the specified name.
+func Add(name string, v *vms.VM) {
+	if v == nil || (*v) == nil {
+		log.Fatalf("Driver error (%s): Please fix your configuration", name)
+	}
+
+	mutex.Lock()
+	drivers[name] = *v
+	mutex.Unlock()
+}
+
+// Drivers returns the sorted names of the registered drivers.
+func Drivers() []string {
+	mutex.RLock()
+	defer mutex.RUnlock()
 
-func SetVM(v *vms.VM) {
-	if (*v) == nil {
-		log.Fatal("Driver error: Please fix your configuration")
+	names := make([]string, 0, len(drivers))
+	for name := range drivers {
+		names = append(names, name)
 	}
-	vm = v
+	sort.Strings(names)
+	return names
 }
 
+// Machines returns the machines of every registered driver.
+// A driver failing to list its machines is logged and skipped so it doesn't
+// hide the machines of the other drivers.
 func Machines() ([]vms.Machine, error) {
-	return (*vm).Machines()
+	rt := make([]vms.Machine, 0)
+
+	for _, name := range Drivers() {
+		v, err := getDriver(name)
+		if err != nil {
+			return nil, err
+		}
+
+		machines, err := v.Machines()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"driver": name,
+			}).Error(err)
+			continue
+		}
+
+		for _, m := range machines {
+			rt = append(rt, &machine{m, name})
+		}
+	}
+	return rt, nil
 }
 
 func Machine(id string) (vms.Machine, error) {
-	return (*vm).Machine(id)
+	name, machineId, err := SplitID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := getDriver(name)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := v.Machine(machineId)
+	if err != nil || m == nil {
+		return nil, err
+	}
+	return &machine{m, name}, nil
 }
 
-func Create(attr vms.MachineAttributes) (vms.Machine, error) {
-	return (*vm).Create(attr)
+func Create(driver string, attr vms.MachineAttributes) (vms.Machine, error) {
+	v, err := getDriver(driver)
+	if err != nil {
+		return nil, err
+	}
+
+	if t, ok := attr.Type.(*machineType); ok {
+		if t.driver != driver {
+			return nil, errors.New("machine type does not belong to the driver " + driver)
+		}
+		attr.Type = t.MachineType
+	}
+
+	m, err := v.Create(attr)
+	if err != nil || m == nil {
+		return nil, err
+	}
+	return &machine{m, driver}, nil
 }
 
-func Types() ([]vms.MachineType, error) {
-	return (*vm).Types()
+func Types(driver string) ([]vms.MachineType, error) {
+	v, err := getDriver(driver)
+	if err != nil {
+		return nil, err
+	}
+
+	types, err := v.Types()
+	if err != nil || types == nil {
+		return nil, err
+	}
+
+	rt := make([]vms.MachineType, len(types))
+	for i, t := range types {
+		rt[i] = &machineType{t, driver}
+	}
+	return rt, nil
 }
 
 func Type(id string) (vms.MachineType, error) {
-	return (*vm).Type(id)
+	name, typeId, err := SplitID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := getDriver(name)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := v.Type(typeId)
+	if err != nil || t == nil {
+		return nil, err
+	}
+	return &machineType{t, name}, nil
 }
@@ -108,4 +108,88 @@ var (
 		http.StatusInternalServerError,
 		"The specified machine type does not exists",
 	}
+
+	InvalidMachineType = &apiError{
+		0x000014,
+		http.StatusBadRequest,
+		"The specified machine type is not valid.",
+	}
+
+	JobNotFound = &apiError{
+		0x000015,
+		http.StatusNotFound,
+		"The specified job does not exist.",
+	}
+
+	MachineNotFound = &apiError{
+		0x000016,
+		http.StatusNotFound,
+		"The specified machine does not exist.",
+	}
+
+	ProvisioningNotFound = &apiError{
+		0x000017,
+		http.StatusNotFound,
+		"The specified machine has never been provisioned.",
+	}
+
+	AppNotFound = &apiError{
+		0x000018,
+		http.StatusNotFound,
+		"The specified application does not exist.",
+	}
+
+	GroupNotFound = &apiError{
+		0x000019,
+		http.StatusNotFound,
+		"The specified group does not exist.",
+	}
+
+	AssignmentNotFound = &apiError{
+		0x00001A,
+		http.StatusNotFound,
+		"The specified application is not assigned to this user or group.",
+	}
+
+	MembershipNotFound = &apiError{
+		0x00001B,
+		http.StatusNotFound,
+		"The specified user is not a member of this group.",
+	}
+
+	PermissionRequired = &apiError{
+		0x00001C,
+		http.StatusForbidden,
+		"You don't have the permission to perform this action.",
+	}
+
+	RoleNotFound = &apiError{
+		0x00001D,
+		http.StatusNotFound,
+		"The specified role does not exist.",
+	}
+
+	OrganizationNotFound = &apiError{
+		0x00001E,
+		http.StatusNotFound,
+		"The specified organization does not exist.",
+	}
+
+	IdentityProviderNotFound = &apiError{
+		0x00001F,
+		http.StatusNotFound,
+		"The specified identity provider does not exist.",
+	}
+
+	OAuthClientNotFound = &apiError{
+		0x000020,
+		http.StatusNotFound,
+		"The specified OAuth client does not exist.",
+	}
+
+	ServiceAccountNotFound = &apiError{
+		0x000021,
+		http.StatusNotFound,
+		"The specified service account does not exist.",
+	}
 )
@@ -0,0 +1,175 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package health polls the execution servers and keeps track of the ones
+// able to accept connections.
+package health
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	StatusUnknown   = "unknown"
+	StatusHealthy   = "healthy"
+	StatusUnhealthy = "unhealthy"
+)
+
+// CheckFunc returns an error if the server at address is not healthy.
+type CheckFunc func(address string) error
+
+// TargetsFunc returns the addresses of the servers to check.
+type TargetsFunc func() []string
+
+type server struct {
+	status    string
+	successes int
+	failures  int
+}
+
+// Checker keeps the health status of a set of servers.
+// A server becomes healthy after Rise consecutive successful checks and
+// unhealthy after Fall consecutive failed ones, so a single failed check
+// doesn't take a server out of rotation. The first check of a server sets its
+// status directly.
+type Checker struct {
+	Check   CheckFunc
+	Targets TargetsFunc
+	Rise    int
+	Fall    int
+
+	mutex   sync.RWMutex
+	servers map[string]*server
+}
+
+func NewChecker(targets TargetsFunc, check CheckFunc, rise int, fall int) *Checker {
+	return &Checker{
+		Check:   check,
+		Targets: targets,
+		Rise:    rise,
+		Fall:    fall,
+		servers: make(map[string]*server),
+	}
+}
+
+func (c *Checker) record(address string, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	s, ok := c.servers[address]
+	if !ok {
+		s = &server{status: StatusUnknown}
+		c.servers[address] = s
+	}
+
+	previous := s.status
+	if err == nil {
+		s.successes++
+		s.failures = 0
+		if s.status == StatusUnknown || s.successes >= c.Rise {
+			s.status = StatusHealthy
+		}
+	} else {
+		s.failures++
+		s.successes = 0
+		if s.status == StatusUnknown || s.failures >= c.Fall {
+			s.status = StatusUnhealthy
+		}
+	}
+
+	if s.status == previous {
+		return
+	}
+
+	fields := log.Fields{
+		"server": address,
+		"status": s.status,
+	}
+	if err != nil {
+		log.WithFields(fields).Warn("Execution server is unhealthy: ", err)
+	} else {
+		log.WithFields(fields).Info("Execution server is healthy")
+	}
+}
+
+// CheckAll checks every target once, concurrently. Servers that are no longer
+// targeted are forgotten.
+func (c *Checker) CheckAll() {
+	targets := c.Targets()
+
+	var wg sync.WaitGroup
+	for _, address := range targets {
+		wg.Add(1)
+		go func(address string) {
+			defer wg.Done()
+			c.record(address, c.Check(address))
+		}(address)
+	}
+	wg.Wait()
+
+	known := make(map[string]bool, len(targets))
+	for _, address := range targets {
+		known[address] = true
+	}
+
+	c.mutex.Lock()
+	for address := range c.servers {
+		if !known[address] {
+			delete(c.servers, address)
+		}
+	}
+	c.mutex.Unlock()
+}
+
+// Run checks the targets every interval, forever.
+func (c *Checker) Run(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		c.CheckAll()
+	}
+}
+
+// Status returns the health status of the server at address.
+func (c *Checker) Status(address string) string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	s, ok := c.servers[address]
+	if !ok {
+		return StatusUnknown
+	}
+	return s.status
+}
+
+// Healthy returns the addresses of servers that are healthy.
+func (c *Checker) Healthy(addresses []string) []string {
+	healthy := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		if c.Status(address) == StatusHealthy {
+			healthy = append(healthy, address)
+		}
+	}
+	return healthy
+}
@@ -0,0 +1,103 @@
+package health
+
+import (
+	"errors"
+	"testing"
+)
+
+type fakeServers map[string]error
+
+func (f fakeServers) targets() []string {
+	var rt []string
+	for address := range f {
+		rt = append(rt, address)
+	}
+	return rt
+}
+
+func (f fakeServers) check(address string) error {
+	return f[address]
+}
+
+var errDown = errors.New("fake-generated-error")
+
+func TestFirstCheck(t *testing.T) {
+	servers := fakeServers{"up": nil, "down": errDown}
+	checker := NewChecker(servers.targets, servers.check, 2, 3)
+
+	if checker.Status("up") != StatusUnknown {
+		t.Errorf("Unchecked server should be unknown")
+	}
+
+	checker.CheckAll()
+
+	if checker.Status("up") != StatusHealthy {
+		t.Errorf("Server should be healthy after its first successful check")
+	}
+	if checker.Status("down") != StatusUnhealthy {
+		t.Errorf("Server should be unhealthy after its first failed check")
+	}
+
+	healthy := checker.Healthy([]string{"up", "down", "unknown"})
+	if len(healthy) != 1 || healthy[0] != "up" {
+		t.Errorf("Only the healthy server should be returned, got: %v", healthy)
+	}
+}
+
+func TestHysteresis(t *testing.T) {
+	servers := fakeServers{"server": nil}
+	checker := NewChecker(servers.targets, servers.check, 2, 3)
+	checker.CheckAll()
+
+	servers["server"] = errDown
+	for i := 0; i < 2; i++ {
+		checker.CheckAll()
+		if checker.Status("server") != StatusHealthy {
+			t.Fatalf("Server should stay healthy after %d failed checks", i+1)
+		}
+	}
+	checker.CheckAll()
+	if checker.Status("server") != StatusUnhealthy {
+		t.Fatalf("Server should be unhealthy after 3 failed checks")
+	}
+
+	servers["server"] = nil
+	checker.CheckAll()
+	if checker.Status("server") != StatusUnhealthy {
+		t.Fatalf("Server should stay unhealthy after 1 successful check")
+	}
+	checker.CheckAll()
+	if checker.Status("server") != StatusHealthy {
+		t.Fatalf("Server should be healthy after 2 successful checks")
+	}
+}
+
+func TestFlapping(t *testing.T) {
+	servers := fakeServers{"server": nil}
+	checker := NewChecker(servers.targets, servers.check, 2, 3)
+	checker.CheckAll()
+
+	for i := 0; i < 10; i++ {
+		if i%2 == 0 {
+			servers["server"] = errDown
+		} else {
+			servers["server"] = nil
+		}
+		checker.CheckAll()
+		if checker.Status("server") != StatusHealthy {
+			t.Fatalf("Server should not flap on intermittent failures")
+		}
+	}
+}
+
+func TestForget(t *testing.T) {
+	servers := fakeServers{"server": nil}
+	checker := NewChecker(servers.targets, servers.check, 2, 3)
+	checker.CheckAll()
+
+	delete(servers, "server")
+	checker.CheckAll()
+	if checker.Status("server") != StatusUnknown {
+		t.Errorf("Servers no longer targeted should be forgotten")
+	}
+}
@@ -0,0 +1,138 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package health
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/vms"
+	"github.com/Nanocloud/community/nanocloud/models/organizations"
+	"github.com/Nanocloud/community/nanocloud/plaza"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+)
+
+var kChecker *Checker
+
+// servers returns the execution servers of every organization and the address
+// of every machine of the opened drivers.
+func servers() []string {
+	seen := make(map[string]bool)
+	var rt []string
+
+	add := func(address string) {
+		address = strings.TrimSpace(address)
+		if address != "" && !seen[address] {
+			seen[address] = true
+			rt = append(rt, address)
+		}
+	}
+
+	pools, err := organizations.ExecutionServers()
+	if err != nil {
+		log.Error("Unable to list the execution servers of the organizations: ", err)
+		pools = strings.Split(utils.Env("EXECUTION_SERVERS", ""), ",")
+	}
+	for _, address := range pools {
+		add(address)
+	}
+
+	machines, err := vms.Machines()
+	if err != nil {
+		log.Error("Unable to list the machines to check: ", err)
+		return rt
+	}
+
+	for _, machine := range machines {
+		ip, err := machine.IP()
+		if err != nil || ip == nil {
+			continue
+		}
+		add(ip.String())
+	}
+	return rt
+}
+
+func envInt(key string, def int) int {
+	value, err := strconv.Atoi(utils.Env(key, strconv.Itoa(def)))
+	if err != nil || value < 1 {
+		log.Errorf("Invalid %s, using %d", key, def)
+		return def
+	}
+	return value
+}
+
+// Start runs the first check of every execution server and keeps checking them
+// in background. Setting HEALTH_CHECK_INTERVAL to 0 disables health checking.
+func Start() error {
+	interval, err := strconv.Atoi(utils.Env("HEALTH_CHECK_INTERVAL", "10"))
+	if err != nil {
+		return err
+	}
+	if interval == 0 {
+		log.Info("Health checking of the execution servers is disabled")
+		return nil
+	}
+
+	port, err := strconv.Atoi(utils.Env("PLAZA_PORT", "9090"))
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{
+		Timeout: time.Duration(envInt("HEALTH_CHECK_TIMEOUT", 5)) * time.Second,
+	}
+
+	kChecker = NewChecker(
+		servers,
+		func(address string) error {
+			return plaza.CheckHealth(client, address, port)
+		},
+		envInt("HEALTH_CHECK_RISE", 2),
+		envInt("HEALTH_CHECK_FALL", 3),
+	)
+
+	kChecker.CheckAll()
+	go kChecker.Run(time.Duration(interval) * time.Second)
+	return nil
+}
+
+// Status returns the health status of the server at address.
+func Status(address string) string {
+	if kChecker == nil {
+		return StatusUnknown
+	}
+	return kChecker.Status(address)
+}
+
+// Healthy returns the addresses of servers that are healthy. Every address is
+// returned when health checking is disabled.
+func Healthy(addresses []string) []string {
+	if kChecker == nil {
+		return addresses
+	}
+	return kChecker.Healthy(addresses)
+}
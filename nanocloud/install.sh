@@ -69,3 +69,7 @@ clone golang.org/x/crypto de93d05161db39bcbd84d3da2e54c4a18f37f0b1 https://go.go
 clone golang.org/x/net e7da8edaa52631091740908acaf2c2d4c9b3ce90 https://go.googlesource.com/net
 clone gopkg.in/asn1-ber.v1 4e86f4367175e39f69d9358a5f17b4dda270378d https://gopkg.in/asn1-ber.v1
 clone gopkg.in/ldap.v2 07a7330929b9ee80495c88a4439657d89c7dbd87 https://gopkg.in/ldap.v2
+clone github.com/libvirt/libvirt-go v4.10.0
+clone github.com/beevik/etree v1.1.0
+clone github.com/jonboulle/clockwork v0.2.2
+clone github.com/russellhaering/goxmldsig v1.1.1
@@ -23,27 +23,47 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
+	"strings"
 
 	vmsConn "github.com/Nanocloud/community/nanocloud/connectors/vms"
 	apiErrors "github.com/Nanocloud/community/nanocloud/errors"
+	"github.com/Nanocloud/community/nanocloud/health"
 	m "github.com/Nanocloud/community/nanocloud/middlewares"
 	"github.com/Nanocloud/community/nanocloud/migration"
+	"github.com/Nanocloud/community/nanocloud/models/directory"
+	groupsModel "github.com/Nanocloud/community/nanocloud/models/groups"
+	jobsModel "github.com/Nanocloud/community/nanocloud/models/jobs"
 	_ "github.com/Nanocloud/community/nanocloud/models/oauth"
+	rolesModel "github.com/Nanocloud/community/nanocloud/models/roles"
+	"github.com/Nanocloud/community/nanocloud/placement"
+	"github.com/Nanocloud/community/nanocloud/plaza"
 	"github.com/Nanocloud/community/nanocloud/routes/apps"
 	"github.com/Nanocloud/community/nanocloud/routes/files"
 	"github.com/Nanocloud/community/nanocloud/routes/front"
+	"github.com/Nanocloud/community/nanocloud/routes/groups"
 	"github.com/Nanocloud/community/nanocloud/routes/histories"
+	"github.com/Nanocloud/community/nanocloud/routes/jobs"
 	"github.com/Nanocloud/community/nanocloud/routes/machine-drivers"
+	"github.com/Nanocloud/community/nanocloud/routes/machine-types"
 	"github.com/Nanocloud/community/nanocloud/routes/machines"
 	"github.com/Nanocloud/community/nanocloud/routes/oauth"
+	"github.com/Nanocloud/community/nanocloud/routes/oauth-clients"
+	"github.com/Nanocloud/community/nanocloud/routes/oidc"
+	"github.com/Nanocloud/community/nanocloud/routes/organizations"
+	"github.com/Nanocloud/community/nanocloud/routes/roles"
+	"github.com/Nanocloud/community/nanocloud/routes/saml"
+	"github.com/Nanocloud/community/nanocloud/routes/service-accounts"
 	"github.com/Nanocloud/community/nanocloud/routes/sessions"
 	"github.com/Nanocloud/community/nanocloud/routes/tokens"
 	"github.com/Nanocloud/community/nanocloud/routes/upload"
 	"github.com/Nanocloud/community/nanocloud/routes/users"
 	"github.com/Nanocloud/community/nanocloud/utils"
 	"github.com/Nanocloud/community/nanocloud/vms"
+	_ "github.com/Nanocloud/community/nanocloud/vms/drivers/libvirt"
 	_ "github.com/Nanocloud/community/nanocloud/vms/drivers/manual"
 	_ "github.com/Nanocloud/community/nanocloud/vms/drivers/qemu"
 	_ "github.com/Nanocloud/community/nanocloud/vms/drivers/vmwarefusion"
@@ -53,11 +73,7 @@ import (
 	logger "github.com/labstack/gommon/log"
 )
 
-func initVms() error {
-	iaas := os.Getenv("IAAS")
-	if len(iaas) == 0 {
-		return errors.New("No iaas provided")
-	}
+func openVm(iaas string) error {
 	m := make(map[string]string, 0)
 
 	switch iaas {
@@ -69,6 +85,12 @@ func initVms() error {
 	case "qemu":
 		m["ad"] = utils.Env("PLAZA_ADDRESS", "iaas-module")
 
+	case "libvirt":
+		m["URI"] = utils.Env("LIBVIRT_URI", "qemu:///system")
+		m["STORAGE_POOL"] = utils.Env("LIBVIRT_STORAGE_POOL", "default")
+		m["NETWORK"] = utils.Env("LIBVIRT_NETWORK", "default")
+		m["IMAGE"] = os.Getenv("LIBVIRT_IMAGE")
+
 	case "manual":
 		/* env variables are now used in migration. The following variables must be set:
 		- EXECUTION_SERVERS
@@ -79,16 +101,85 @@ func initVms() error {
 	if err != nil {
 		return err
 	}
-	vmsConn.SetVM(vm)
+	vmsConn.Add(iaas, vm)
+	return nil
+}
+
+// reconcile prints what differs between the users and the directory and
+// returns the exit status, 1 when something does.
+func reconcile() int {
+	r, err := directory.Reconcile()
+	if err != nil {
+		log.Error(err)
+		return 2
+	}
+
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		log.Error(err)
+		return 2
+	}
+	fmt.Println(string(b))
+
+	if !r.Consistent() {
+		return 1
+	}
+	return 0
+}
+
+// IAAS is a comma separated list of the drivers to open, e.g. "manual,qemu"
+func initVms() error {
+	drivers := strings.Split(os.Getenv("IAAS"), ",")
+
+	count := 0
+	for _, iaas := range drivers {
+		iaas = strings.TrimSpace(iaas)
+		if len(iaas) == 0 {
+			continue
+		}
+
+		err := openVm(iaas)
+		if err != nil {
+			return fmt.Errorf("Unable to open driver %s: %s", iaas, err)
+		}
+		count++
+	}
+
+	if count == 0 {
+		return errors.New("No iaas provided")
+	}
 	return nil
 }
 
 func main() {
-	err := migration.Migrate()
+	err := plaza.Setup()
 	if err != nil {
 		log.Error(err)
 		return
 	}
+
+	err = migration.Migrate()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "reconcile" {
+		os.Exit(reconcile())
+	}
+
+	provisionings, err := jobsModel.FindUnfinished(machines.ActionProvision)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	err = jobsModel.FailInterrupted()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
 	p := echo.New()
 	go p.Run(":8181")
 
@@ -98,6 +189,48 @@ func main() {
 		return
 	}
 
+	err = machines.AdoptMachines()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	err = machines.ResumeProvisioning(provisionings)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	err = placement.Setup()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	err = health.Start()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	err = groupsModel.StartSync()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	err = directory.StartSync()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	err = directory.StartQueue()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
 	e := echo.New()
 	e.SetLogLevel(logger.DEBUG)
 	e.Use(middleware.Logger())
@@ -109,23 +242,29 @@ func main() {
 	 * APPS
 	 */
 	e.Get("/api/apps", m.OAuth2(apps.ListApplications))
-	e.Delete("/api/apps/:app_id", m.OAuth2(m.Admin(apps.UnpublishApplication)))
-	e.Post("/api/apps", m.OAuth2(m.Admin(apps.PublishApplication)))
-	e.Get("/api/apps/connections", m.OAuth2(apps.GetConnections))
-	e.Patch("/api/apps/:app_id", m.OAuth2(m.Admin(apps.ChangeAppName)))
+	e.Delete("/api/apps/:app_id", m.OAuth2(m.Require(rolesModel.AppsPublish)(apps.UnpublishApplication)))
+	e.Post("/api/apps", m.OAuth2(m.Require(rolesModel.AppsPublish)(apps.PublishApplication)))
+	e.Get("/api/apps/connections", m.OAuth2(m.RequireScope(rolesModel.SessionsScope)(apps.GetConnections)))
+	e.Patch("/api/apps/:app_id", m.OAuth2(m.Require(rolesModel.AppsPublish)(apps.ChangeAppName)))
+	e.Get("/api/apps/:app_id/users", m.OAuth2(m.Require(rolesModel.AppsRead)(apps.ListAppUsers)))
+	e.Put("/api/apps/:app_id/users/:id", m.OAuth2(m.Require(rolesModel.AppsAssign)(apps.GrantUser)))
+	e.Delete("/api/apps/:app_id/users/:id", m.OAuth2(m.Require(rolesModel.AppsAssign)(apps.RevokeUser)))
+	e.Get("/api/apps/:app_id/groups", m.OAuth2(m.Require(rolesModel.AppsRead)(apps.ListAppGroups)))
+	e.Put("/api/apps/:app_id/groups/:id", m.OAuth2(m.Require(rolesModel.AppsAssign)(apps.GrantGroup)))
+	e.Delete("/api/apps/:app_id/groups/:id", m.OAuth2(m.Require(rolesModel.AppsAssign)(apps.RevokeGroup)))
 
 	/**
 	 * SESSIONS
 	 */
 
-	e.Get("/api/sessions", m.OAuth2(sessions.List))
-	e.Delete("/api/sessions", m.OAuth2(sessions.Logoff))
+	e.Get("/api/sessions", m.OAuth2(m.RequireScope(rolesModel.SessionsScope)(sessions.List)))
+	e.Delete("/api/sessions", m.OAuth2(m.RequireScope(rolesModel.SessionsScope)(sessions.Logoff)))
 
 	/**
 	 * HISTORY
 	 */
-	e.Get("/api/histories", m.OAuth2(histories.List))
-	e.Post("/api/histories", m.OAuth2(histories.Add))
+	e.Get("/api/histories", m.OAuth2(m.Require(rolesModel.HistoriesRead)(histories.List)))
+	e.Post("/api/histories", m.OAuth2(m.RequireScope(rolesModel.SessionsScope)(histories.Add)))
 	//m.OAuth2(
 
 	/**
@@ -133,30 +272,107 @@ func main() {
 	 */
 	e.Patch("/api/users/:id", m.OAuth2(users.Update))
 	e.Get("/api/users", m.OAuth2(users.Get))
-	e.Post("/api/users", m.OAuth2(m.Admin(users.Post)))
-	e.Delete("/api/users/:id", m.OAuth2(m.Admin(users.Delete)))
-	e.Put("/api/users/:id", m.OAuth2(m.Admin(users.UpdatePassword)))
+	e.Post("/api/users", m.OAuth2(m.Require(rolesModel.UsersWrite)(users.Post)))
+	e.Post("/api/users/sync", m.OAuth2(m.Require(rolesModel.UsersWrite)(users.Sync)))
+	e.Delete("/api/users/:id", m.OAuth2(m.Require(rolesModel.UsersWrite)(users.Delete)))
+	e.Put("/api/users/:id", m.OAuth2(m.Require(rolesModel.UsersWrite)(users.UpdatePassword)))
 	e.Get("/api/users/:id", m.OAuth2(users.GetUser))
 
+	/**
+	 * GROUPS
+	 */
+	e.Get("/api/groups", m.OAuth2(m.Require(rolesModel.GroupsRead)(groups.FindAll)))
+	e.Get("/api/groups/:id", m.OAuth2(m.Require(rolesModel.GroupsRead)(groups.FindById)))
+	e.Post("/api/groups", m.OAuth2(m.Require(rolesModel.GroupsWrite)(groups.Create)))
+	e.Patch("/api/groups/:id", m.OAuth2(m.Require(rolesModel.GroupsWrite)(groups.Update)))
+	e.Delete("/api/groups/:id", m.OAuth2(m.Require(rolesModel.GroupsWrite)(groups.Delete)))
+	e.Get("/api/groups/:id/members", m.OAuth2(m.Require(rolesModel.GroupsRead)(groups.Members)))
+	e.Put("/api/groups/:id/members/:user_id", m.OAuth2(m.Require(rolesModel.GroupsWrite)(groups.AddMember)))
+	e.Delete("/api/groups/:id/members/:user_id", m.OAuth2(m.Require(rolesModel.GroupsWrite)(groups.RemoveMember)))
+	e.Post("/api/groups/sync", m.OAuth2(m.Require(rolesModel.GroupsWrite)(groups.Sync)))
+
+	/**
+	 * ROLES
+	 */
+	e.Get("/api/roles", m.OAuth2(m.Require(rolesModel.RolesRead)(roles.FindAll)))
+	e.Get("/api/roles/permissions", m.OAuth2(m.Require(rolesModel.RolesRead)(roles.Permissions)))
+	e.Get("/api/roles/:id", m.OAuth2(m.Require(rolesModel.RolesRead)(roles.FindById)))
+	e.Post("/api/roles", m.OAuth2(m.Require(rolesModel.RolesWrite)(roles.Create)))
+	e.Patch("/api/roles/:id", m.OAuth2(m.Require(rolesModel.RolesWrite)(roles.Update)))
+	e.Delete("/api/roles/:id", m.OAuth2(m.Require(rolesModel.RolesWrite)(roles.Delete)))
+	e.Get("/api/roles/:id/users", m.OAuth2(m.Require(rolesModel.RolesRead)(roles.Users)))
+	e.Put("/api/roles/:id/users/:user_id", m.OAuth2(m.Require(rolesModel.RolesWrite)(roles.AssignUser)))
+	e.Delete("/api/roles/:id/users/:user_id", m.OAuth2(m.Require(rolesModel.RolesWrite)(roles.UnassignUser)))
+	e.Get("/api/roles/:id/groups", m.OAuth2(m.Require(rolesModel.RolesRead)(roles.Groups)))
+	e.Put("/api/roles/:id/groups/:group_id", m.OAuth2(m.Require(rolesModel.RolesWrite)(roles.AssignGroup)))
+	e.Delete("/api/roles/:id/groups/:group_id", m.OAuth2(m.Require(rolesModel.RolesWrite)(roles.UnassignGroup)))
+
+	/**
+	 * ORGANIZATIONS
+	 */
+	e.Get("/api/organizations", m.OAuth2(organizations.Manage(m.Require(rolesModel.OrganizationsRead)(organizations.FindAll))))
+	e.Get("/api/organizations/:id", m.OAuth2(organizations.Manage(m.Require(rolesModel.OrganizationsRead)(organizations.FindById))))
+	e.Post("/api/organizations", m.OAuth2(organizations.Manage(m.Require(rolesModel.OrganizationsWrite)(organizations.Create))))
+	e.Patch("/api/organizations/:id", m.OAuth2(organizations.Manage(m.Require(rolesModel.OrganizationsWrite)(organizations.Update))))
+	e.Delete("/api/organizations/:id", m.OAuth2(organizations.Manage(m.Require(rolesModel.OrganizationsWrite)(organizations.Delete))))
+
+	/**
+	 * OAUTH CLIENTS
+	 */
+	e.Get("/api/oauth-clients", m.OAuth2(m.Require(rolesModel.OAuthClientsRead)(oauthclients.FindAll)))
+	e.Get("/api/oauth-clients/:id", m.OAuth2(m.Require(rolesModel.OAuthClientsRead)(oauthclients.FindById)))
+	e.Post("/api/oauth-clients", m.OAuth2(m.Require(rolesModel.OAuthClientsWrite)(oauthclients.Create)))
+	e.Patch("/api/oauth-clients/:id", m.OAuth2(m.Require(rolesModel.OAuthClientsWrite)(oauthclients.Update)))
+	e.Delete("/api/oauth-clients/:id", m.OAuth2(m.Require(rolesModel.OAuthClientsWrite)(oauthclients.Delete)))
+	e.Post("/api/oauth-clients/:id/secret", m.OAuth2(m.Require(rolesModel.OAuthClientsWrite)(oauthclients.ResetSecret)))
+
+	/**
+	 * SERVICE ACCOUNTS
+	 */
+	e.Get("/api/service-accounts", m.OAuth2(m.Require(rolesModel.ServiceAccountsRead)(serviceaccounts.FindAll)))
+	e.Get("/api/service-accounts/:id", m.OAuth2(m.Require(rolesModel.ServiceAccountsRead)(serviceaccounts.FindById)))
+	e.Get("/api/service-accounts/:id/tokens", m.OAuth2(m.Require(rolesModel.ServiceAccountsRead)(serviceaccounts.Tokens)))
+	e.Post("/api/service-accounts", m.OAuth2(m.Require(rolesModel.ServiceAccountsWrite)(serviceaccounts.Create)))
+	e.Patch("/api/service-accounts/:id", m.OAuth2(m.Require(rolesModel.ServiceAccountsWrite)(serviceaccounts.Update)))
+	e.Delete("/api/service-accounts/:id", m.OAuth2(m.Require(rolesModel.ServiceAccountsWrite)(serviceaccounts.Delete)))
+	e.Post("/api/service-accounts/:id/secret", m.OAuth2(m.Require(rolesModel.ServiceAccountsWrite)(serviceaccounts.ResetSecret)))
+
 	/**
 	 * MACHINES
 	 */
-	e.Get("/api/machines", m.OAuth2(m.Admin(machines.Machines)))
-	e.Get("/api/machines/:id", m.OAuth2(m.Admin(machines.GetMachine)))
-	e.Patch("/api/machines/:id", m.OAuth2(m.Admin(machines.PatchMachine)))
-	e.Post("/api/machines", m.OAuth2(m.Admin(machines.CreateMachine)))
-	e.Delete("/api/machines/:id", m.OAuth2(m.Admin(machines.DeleteMachine)))
+	e.Get("/api/machines", m.OAuth2(m.Require(rolesModel.MachinesRead)(machines.Machines)))
+	e.Get("/api/machines/:id", m.OAuth2(m.Require(rolesModel.MachinesRead)(machines.GetMachine)))
+	e.Patch("/api/machines/:id", m.OAuth2(m.Require(rolesModel.MachinesPower)(machines.PatchMachine)))
+	e.Post("/api/machines", m.OAuth2(m.Require(rolesModel.MachinesWrite)(machines.CreateMachine)))
+	e.Delete("/api/machines/:id", m.OAuth2(m.Require(rolesModel.MachinesWrite)(machines.DeleteMachine)))
+	e.Post("/api/machines/:id/provisioning", m.OAuth2(m.Require(rolesModel.MachinesProvision)(machines.Provision)))
+	e.Get("/api/machines/:id/provisioning", m.OAuth2(m.Require(rolesModel.MachinesRead)(machines.ProvisioningLog)))
+	e.Get("/api/machines/:id/provisioning-steps", m.OAuth2(m.Require(rolesModel.MachinesRead)(machines.ProvisioningSteps)))
+
+	/**
+	 * JOBS
+	 */
+	e.Get("/api/jobs/:id", m.OAuth2(m.Require(rolesModel.MachinesRead)(jobs.GetJob)))
 
 	/**
 	 * MACHINES DRIVERS
 	 */
-	e.Get("/api/machine-drivers", m.OAuth2(m.Admin(machinedrivers.FindAll)))
+	e.Get("/api/machine-drivers", m.OAuth2(m.Require(rolesModel.MachinesRead)(machinedrivers.FindAll)))
+
+	/**
+	 * MACHINES TYPES
+	 */
+	e.Get("/api/machine-types", m.OAuth2(m.Require(rolesModel.MachinesRead)(machinetypes.FindAll)))
+	e.Get("/api/machine-types/:id", m.OAuth2(m.Require(rolesModel.MachinesRead)(machinetypes.FindById)))
+	e.Post("/api/machine-types", m.OAuth2(m.Require(rolesModel.MachinesWrite)(machinetypes.Create)))
+	e.Patch("/api/machine-types/:id", m.OAuth2(m.Require(rolesModel.MachinesWrite)(machinetypes.Update)))
+	e.Delete("/api/machine-types/:id", m.OAuth2(m.Require(rolesModel.MachinesWrite)(machinetypes.Delete)))
 
 	/**
 	 * Files
 	 */
 	e.Get("/api/files", files.Get)
-	e.Get("/api/files/token", m.OAuth2(files.GetDownloadToken))
+	e.Get("/api/files/token", m.OAuth2(m.RequireScope(rolesModel.FilesScope)(files.GetDownloadToken)))
 
 	/**
 	 * FRONT
@@ -166,13 +382,31 @@ func main() {
 	/**
 	 * OAUTH
 	 */
+	e.Get("/oauth/authorize", oauth.Authorize)
+	e.Get("/api/oauth/authorize", m.OAuth2(m.FullScope(oauth.Consent)))
+	e.Post("/api/oauth/authorize", m.OAuth2(m.FullScope(oauth.Decide)))
 	e.Any("/oauth/*", oauth.Handler)
 
+	/**
+	 * OPENID CONNECT
+	 */
+	e.Get("/api/oidc/providers", oidc.Providers)
+	e.Get("/oidc/:provider/login", oidc.Login)
+	e.Get("/oidc/:provider/callback", oidc.Callback)
+
+	/**
+	 * SAML
+	 */
+	e.Get("/api/saml/providers", saml.Providers)
+	e.Get("/saml/:provider/metadata", saml.Metadata)
+	e.Get("/saml/:provider/login", saml.Login)
+	e.Post("/saml/:provider/acs", saml.ACS)
+
 	/**
 	 * TOKENS
 	 */
-	e.Get("/api/tokens", m.OAuth2(tokens.Get))
-	e.Delete("/api/tokens/:id", m.OAuth2(tokens.Delete))
+	e.Get("/api/tokens", m.OAuth2(m.FullScope(tokens.Get)))
+	e.Delete("/api/tokens/:id", m.OAuth2(m.FullScope(tokens.Delete)))
 
 	/**
 	 * UPLOAD
@@ -53,6 +53,9 @@ func oAuth2(c *echo.Context, handler echo.HandlerFunc) error {
 	return errors.New("unable to authenticate the user")
 }
 
+// OAuth2 authenticates the user with their access token. The scopes the token
+// grants are kept along with the user, for Require, FullScope and the
+// handlers checking permissions to enforce.
 func OAuth2(handler echo.HandlerFunc) echo.HandlerFunc {
 	return func(c *echo.Context) error {
 		return oAuth2(c, handler)
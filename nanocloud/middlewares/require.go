@@ -0,0 +1,88 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package middlewares
+
+import (
+	"github.com/Nanocloud/community/nanocloud/errors"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+func require(c *echo.Context, permission string, handler echo.HandlerFunc) error {
+	user := c.Get("user").(*users.User)
+
+	if !user.InScope(permission) {
+		return errors.PermissionRequired.Detail("The access token isn't granted the " + permission + " scope")
+	}
+
+	can, err := user.Can(permission)
+	if err != nil {
+		log.Error(err)
+		return errors.InternalError
+	}
+
+	if !can {
+		return errors.PermissionRequired.Detail("The " + permission + " permission is required")
+	}
+	return handler(c)
+}
+
+// Require only lets the users having permission through, if their access
+// token grants it. It must be wrapped by OAuth2.
+func Require(permission string) func(echo.HandlerFunc) echo.HandlerFunc {
+	return func(handler echo.HandlerFunc) echo.HandlerFunc {
+		return func(c *echo.Context) error {
+			return require(c, permission, handler)
+		}
+	}
+}
+
+// RequireScope only lets the access tokens granting scope through. It guards
+// what every user can do, such as opening their apps. It must be wrapped by
+// OAuth2.
+func RequireScope(scope string) func(echo.HandlerFunc) echo.HandlerFunc {
+	return func(handler echo.HandlerFunc) echo.HandlerFunc {
+		return func(c *echo.Context) error {
+			user := c.Get("user").(*users.User)
+			if !user.InScope(scope) {
+				return errors.PermissionRequired.Detail("The access token isn't granted the " + scope + " scope")
+			}
+			return handler(c)
+		}
+	}
+}
+
+// FullScope only lets the access tokens granting every permission through.
+// It guards what could give a scoped token more than it was granted, such as
+// approving authorization requests or changing the credentials of the user.
+// It must be wrapped by OAuth2.
+func FullScope(handler echo.HandlerFunc) echo.HandlerFunc {
+	return func(c *echo.Context) error {
+		user := c.Get("user").(*users.User)
+		if !user.FullScope() {
+			return errors.PermissionRequired.Detail("The access token must be granted every scope")
+		}
+		return handler(c)
+	}
+}
@@ -2,7 +2,7 @@
  * Nanocloud Community, a comprehensive platform to turn any application
  * into a cloud solution.
  *
- * Copyright (C) 2015 Nanocloud Software
+ * Copyright (C) 2016 Nanocloud Software
  *
  * This file is part of Nanocloud community.
  *
@@ -28,7 +28,7 @@ import (
 	uuid "github.com/satori/go.uuid"
 )
 
-func Migrate() error {
+func createAppsTable() error {
 	rows, err := db.Query(
 		`SELECT table_name
 		FROM information_schema.tables
@@ -72,3 +72,78 @@ func Migrate() error {
 	rows.Close()
 	return nil
 }
+
+// createAssignmentTable creates the table granting the access to apps to the
+// users or groups of the target table. It returns false if the table already
+// exists.
+func createAssignmentTable(table string, column string, target string) (bool, error) {
+	rows, err := db.Query(
+		`SELECT table_name
+		FROM information_schema.tables
+		WHERE table_name = $1::varchar`,
+		table)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		log.Infof("%s table already set up", table)
+		return false, nil
+	}
+
+	rows, err = db.Query(
+		`CREATE TABLE ` + table + ` (
+			app_id varchar(36)
+			REFERENCES apps(id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			` + column + ` varchar(36)
+			REFERENCES ` + target + `(id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			PRIMARY KEY (app_id, ` + column + `)
+		);`)
+	if err != nil {
+		log.Errorf("Unable to create %s table: %s", table, err)
+		return false, err
+	}
+
+	rows.Close()
+	return true, nil
+}
+
+// grantExistingApps gives the users already there the access to every app,
+// which they all had before apps were assigned.
+func grantExistingApps() error {
+	_, err := db.Exec(
+		`INSERT INTO apps_users (app_id, user_id)
+		SELECT apps.id, users.id
+		FROM apps, users`,
+	)
+	if err != nil {
+		log.Errorf("Unable to grant the existing apps to the existing users: %s", err)
+	}
+	return err
+}
+
+func Migrate() error {
+	err := createAppsTable()
+	if err != nil {
+		return err
+	}
+
+	created, err := createAssignmentTable("apps_users", "user_id", "users")
+	if err != nil {
+		return err
+	}
+	if created {
+		err = grantExistingApps()
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = createAssignmentTable("apps_groups", "group_id", "groups")
+	return err
+}
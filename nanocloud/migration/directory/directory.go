@@ -0,0 +1,65 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package directory
+
+import (
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	log "github.com/Sirupsen/logrus"
+)
+
+// Migrate creates the queue of the operations to make on the Windows accounts
+// Nanocloud creates.
+func Migrate() error {
+	rows, err := db.Query(
+		`SELECT table_name
+		FROM information_schema.tables
+		WHERE table_name = 'directory_operations'`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		log.Info("directory_operations table already set up")
+		return nil
+	}
+
+	rows, err = db.Query(
+		`CREATE TABLE directory_operations (
+			id		serial PRIMARY KEY,
+			operation	varchar(36) NOT NULL,
+			account		varchar(36) NOT NULL,
+			ou		varchar(255) NOT NULL DEFAULT '',
+			password	varchar(255) NOT NULL DEFAULT '',
+			attempts	integer NOT NULL DEFAULT 0,
+			last_error	text NOT NULL DEFAULT '',
+			next_attempt	timestamp NOT NULL DEFAULT current_timestamp,
+			created_at	timestamp NOT NULL DEFAULT current_timestamp
+		);`)
+	if err != nil {
+		log.Errorf("Unable to create directory_operations table: %s", err)
+		return err
+	}
+	rows.Close()
+	return nil
+}
@@ -0,0 +1,137 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package groups
+
+import (
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	log "github.com/Sirupsen/logrus"
+)
+
+func createGroupsTable() error {
+	rows, err := db.Query(
+		`SELECT table_name
+		FROM information_schema.tables
+		WHERE table_name = 'groups'`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		log.Info("groups table already set up")
+		return nil
+	}
+
+	rows, err = db.Query(
+		`CREATE TABLE groups (
+			id		varchar(36) PRIMARY KEY,
+			name		varchar(255) NOT NULL UNIQUE
+		);`)
+	if err != nil {
+		log.Errorf("Unable to create groups table: %s", err)
+		return err
+	}
+
+	rows.Close()
+	return nil
+}
+
+// addGroupsColumn adds column to the groups table of the installations
+// created without it.
+func addGroupsColumn(column string, definition string) error {
+	rows, err := db.Query(
+		`SELECT column_name
+		FROM information_schema.columns
+		WHERE table_name = 'groups' AND column_name = $1::varchar`,
+		column)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		return nil
+	}
+
+	_, err = db.Exec(`ALTER TABLE groups ADD COLUMN ` + column + ` ` + definition)
+	if err != nil {
+		log.Errorf("Unable to add %s to groups: %s", column, err)
+	}
+	return err
+}
+
+func createUsersGroupsTable() error {
+	rows, err := db.Query(
+		`SELECT table_name
+		FROM information_schema.tables
+		WHERE table_name = 'users_groups'`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		log.Info("users_groups table already set up")
+		return nil
+	}
+
+	rows, err = db.Query(
+		`CREATE TABLE users_groups (
+			user_id varchar(36)
+			REFERENCES users(id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			group_id varchar(36)
+			REFERENCES groups(id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			PRIMARY KEY (user_id, group_id)
+		);`)
+	if err != nil {
+		log.Errorf("Unable to create users_groups table: %s", err)
+		return err
+	}
+
+	rows.Close()
+	return nil
+}
+
+func Migrate() error {
+	err := createGroupsTable()
+	if err != nil {
+		return err
+	}
+
+	err = addGroupsColumn("description", "text NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+
+	// the DN of the Active Directory group a synced group mirrors
+	err = addGroupsColumn("directory_dn", "varchar(255) NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+
+	return createUsersGroupsTable()
+}
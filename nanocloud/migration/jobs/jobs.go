@@ -0,0 +1,89 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package jobs
+
+import (
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	log "github.com/Sirupsen/logrus"
+)
+
+// addOutputColumn stores the output of the jobs, replayed once they are done.
+func addOutputColumn() error {
+	rows, err := db.Query(
+		`SELECT column_name
+		FROM information_schema.columns
+		WHERE table_name = 'jobs' AND column_name = 'output'`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		return nil
+	}
+
+	_, err = db.Exec(
+		`ALTER TABLE jobs
+		ADD COLUMN output text NOT NULL DEFAULT ''`)
+	if err != nil {
+		log.Errorf("Unable to add output to jobs: %s", err)
+	}
+	return err
+}
+
+func Migrate() error {
+	rows, err := db.Query(
+		`SELECT table_name
+		FROM information_schema.tables
+		WHERE table_name = 'jobs'`)
+	if err != nil {
+		log.Error("Select tables names failed: ", err.Error())
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		log.Info("jobs table already set up")
+		return addOutputColumn()
+	}
+
+	rows, err = db.Query(
+		`CREATE TABLE jobs (
+			id		varchar(36) PRIMARY KEY,
+			action		varchar(36) NOT NULL,
+			target		varchar(255) NOT NULL DEFAULT '',
+			state		varchar(36) NOT NULL,
+			progress	integer NOT NULL DEFAULT 0,
+			error		text NOT NULL DEFAULT '',
+			user_id		varchar(36) NOT NULL DEFAULT '',
+			created_at	timestamp NOT NULL DEFAULT current_timestamp,
+			updated_at	timestamp NOT NULL DEFAULT current_timestamp
+		);`)
+	if err != nil {
+		log.Errorf("Unable to create jobs table: %s", err)
+		return err
+	}
+
+	rows.Close()
+	return addOutputColumn()
+}
@@ -0,0 +1,83 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package machinetypes
+
+import (
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	log "github.com/Sirupsen/logrus"
+)
+
+// Every driver used to hardcode the same default type. They are now seeded
+// with the id "default" so existing "{driver}:default" references still work.
+var drivers = []string{"manual", "qemu", "vmwarefusion", "libvirt"}
+
+func Migrate() error {
+	rows, err := db.Query(
+		`SELECT table_name
+		FROM information_schema.tables
+		WHERE table_name = 'machine_types'`)
+	if err != nil {
+		log.Error("Select tables names failed: ", err.Error())
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		log.Info("machine_types table already set up")
+		return nil
+	}
+
+	rows, err = db.Query(
+		`CREATE TABLE machine_types (
+			id		varchar(36) NOT NULL,
+			driver		varchar(36) NOT NULL,
+			name		varchar(255) NOT NULL DEFAULT '',
+			cpu		integer NOT NULL,
+			ram		integer NOT NULL,
+			disk		integer NOT NULL,
+			image		varchar(255) NOT NULL DEFAULT '',
+			options		text NOT NULL DEFAULT '{}',
+			PRIMARY KEY (driver, id)
+		);`)
+	if err != nil {
+		log.Errorf("Unable to create machine_types table: %s", err)
+		return err
+	}
+	rows.Close()
+
+	for _, driver := range drivers {
+		rows, err = db.Query(
+			`INSERT INTO machine_types
+			(id, driver, name, cpu, ram, disk)
+			VALUES ('default', $1::varchar, 'Default', 2, 4096, 60)`,
+			driver,
+		)
+		if err != nil {
+			log.Errorf("Unable to insert the %s default machine type: %s", driver, err)
+			return err
+		}
+		rows.Close()
+	}
+
+	return nil
+}
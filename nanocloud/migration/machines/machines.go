@@ -32,6 +32,40 @@ import (
 	uuid "github.com/satori/go.uuid"
 )
 
+func hasDriver(name string) bool {
+	for _, iaas := range strings.Split(os.Getenv("IAAS"), ",") {
+		if strings.TrimSpace(iaas) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// addMachineTypeColumn stores the machine type the machines of the manual
+// and qemu drivers have been created with.
+func addMachineTypeColumn() error {
+	rows, err := db.Query(
+		`SELECT column_name
+		FROM information_schema.columns
+		WHERE table_name = 'machines' AND column_name = 'machine_type'`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		return nil
+	}
+
+	_, err = db.Exec(
+		`ALTER TABLE machines
+		ADD COLUMN machine_type varchar(36) NOT NULL DEFAULT 'default'`)
+	if err != nil {
+		log.Errorf("Unable to add machine_type to machines: %s", err)
+	}
+	return err
+}
+
 func Migrate() error {
 	rows, err := db.Query(
 		`SELECT table_name
@@ -45,7 +79,7 @@ func Migrate() error {
 
 	if rows.Next() {
 		log.Info("Machines table already set up")
-		return nil
+		return addMachineTypeColumn()
 	}
 
 	rows, err = db.Query(
@@ -62,7 +96,7 @@ func Migrate() error {
 		log.Errorf("Unable to create machines table: %s", err)
 		return err
 	}
-	if os.Getenv("IAAS") == "manual" {
+	if hasDriver("manual") {
 		servers := os.Getenv("EXECUTION_SERVERS")
 		password := os.Getenv("WINDOWS_PASSWORD")
 		user := os.Getenv("WINDOWS_USER")
@@ -89,5 +123,5 @@ func Migrate() error {
 		}
 	}
 	rows.Close()
-	return nil
+	return addMachineTypeColumn()
 }
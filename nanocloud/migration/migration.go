@@ -25,27 +25,66 @@ package migration
 import (
 	"github.com/Nanocloud/community/nanocloud/migration/apps"
 	"github.com/Nanocloud/community/nanocloud/migration/config"
+	"github.com/Nanocloud/community/nanocloud/migration/directory"
+	"github.com/Nanocloud/community/nanocloud/migration/groups"
 	"github.com/Nanocloud/community/nanocloud/migration/history"
+	"github.com/Nanocloud/community/nanocloud/migration/jobs"
+	"github.com/Nanocloud/community/nanocloud/migration/machine-types"
 	"github.com/Nanocloud/community/nanocloud/migration/machines"
 	"github.com/Nanocloud/community/nanocloud/migration/oauth"
+	"github.com/Nanocloud/community/nanocloud/migration/oidc"
+	"github.com/Nanocloud/community/nanocloud/migration/organizations"
+	"github.com/Nanocloud/community/nanocloud/migration/provisioning"
+	"github.com/Nanocloud/community/nanocloud/migration/roles"
+	"github.com/Nanocloud/community/nanocloud/migration/saml"
 	"github.com/Nanocloud/community/nanocloud/migration/users"
 
 	log "github.com/Sirupsen/logrus"
 )
 
 func Migrate() error {
-	err := users.Migrate()
+	err := organizations.Migrate()
+	if err != nil {
+		log.Error("organizations migration failed")
+		return err
+	}
+
+	err = users.Migrate()
 	if err != nil {
 		log.Error("users migration failed")
 		return err
 	}
 
+	err = groups.Migrate()
+	if err != nil {
+		log.Error("groups migration failed")
+		return err
+	}
+
+	err = roles.Migrate()
+	if err != nil {
+		log.Error("roles migration failed")
+		return err
+	}
+
 	err = oauth.Migrate()
 	if err != nil {
 		log.Error("oauth migration failed")
 		return err
 	}
 
+	err = oidc.Migrate()
+	if err != nil {
+		log.Error("oidc migration failed")
+		return err
+	}
+
+	err = saml.Migrate()
+	if err != nil {
+		log.Error("saml migration failed")
+		return err
+	}
+
 	err = apps.Migrate()
 	if err != nil {
 		log.Error("apps migration failed")
@@ -64,11 +103,47 @@ func Migrate() error {
 		return err
 	}
 
+	err = machinetypes.Migrate()
+	if err != nil {
+		log.Error("machine types migration failed")
+		return err
+	}
+
+	err = jobs.Migrate()
+	if err != nil {
+		log.Error("jobs migration failed")
+		return err
+	}
+
+	err = provisioning.Migrate()
+	if err != nil {
+		log.Error("provisioning migration failed")
+		return err
+	}
+
 	err = config.Migrate()
 	if err != nil {
 		log.Error("config migration failed")
 		return err
 	}
 
+	err = directory.Migrate()
+	if err != nil {
+		log.Error("directory migration failed")
+		return err
+	}
+
+	err = organizations.Scope()
+	if err != nil {
+		log.Error("organizations scoping failed")
+		return err
+	}
+
+	err = users.CreateAdmin()
+	if err != nil {
+		log.Error("admin creation failed")
+		return err
+	}
+
 	return nil
 }
@@ -25,6 +25,7 @@ package oauth
 import (
 	"github.com/Nanocloud/community/nanocloud/connectors/db"
 	log "github.com/Sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func Migrate() error {
@@ -101,5 +102,235 @@ func Migrate() error {
 		}
 		defer rows.Close()
 	}
-	return nil
+
+	// the lifetimes of the tokens of each client, in seconds
+	err = addColumn("oauth_clients", "access_token_lifetime", "integer NOT NULL DEFAULT 86400")
+	if err != nil {
+		return err
+	}
+	err = addColumn("oauth_clients", "refresh_token_lifetime", "integer NOT NULL DEFAULT 2592000")
+	if err != nil {
+		return err
+	}
+
+	// the refresh token family the access token was issued with, if any
+	err = addColumn("oauth_access_tokens", "family_id", "varchar(36)")
+	if err != nil {
+		return err
+	}
+
+	err = createRefreshTokensTable()
+	if err != nil {
+		return err
+	}
+
+	// the permissions the tokens were granted, all of them for the tokens
+	// issued before scopes
+	err = addColumn("oauth_access_tokens", "scope", "text NOT NULL DEFAULT '*'")
+	if err != nil {
+		return err
+	}
+	err = addColumn("oauth_refresh_tokens", "scope", "text NOT NULL DEFAULT '*'")
+	if err != nil {
+		return err
+	}
+
+	// public clients, such as the web interface, can't keep a secret
+	err = addColumn("oauth_clients", "confidential", "boolean NOT NULL DEFAULT true")
+	if err != nil {
+		return err
+	}
+	// space separated lists, as in OAuth requests
+	err = addColumn("oauth_clients", "redirect_uris", "text NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	err = addColumn("oauth_clients", "scopes", "text NOT NULL DEFAULT '*'")
+	if err != nil {
+		return err
+	}
+
+	err = hashSecrets()
+	if err != nil {
+		return err
+	}
+
+	err = createAuthorizationCodesTable()
+	if err != nil {
+		return err
+	}
+
+	// the user the client_credentials grant issues tokens to
+	return addColumn(
+		"oauth_clients", "service_account_id",
+		"varchar(36) REFERENCES users (id) ON UPDATE CASCADE ON DELETE CASCADE",
+	)
+}
+
+func hasColumn(table string, column string) (bool, error) {
+	rows, err := db.Query(
+		`SELECT column_name
+		FROM information_schema.columns
+		WHERE table_name = $1::varchar AND column_name = $2::varchar`,
+		table, column)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	return rows.Next(), nil
+}
+
+// addColumn adds column to table unless it is already there.
+func addColumn(table string, column string, definition string) error {
+	exists, err := hasColumn(table, column)
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = db.Exec(`ALTER TABLE ` + table + ` ADD COLUMN ` + column + ` ` + definition)
+	if err != nil {
+		log.Errorf("Unable to add %s to %s: %s", column, table, err)
+	}
+	return err
+}
+
+// createRefreshTokensTable creates the table of the refresh tokens, which are
+// only stored hashed. A refresh token is used once, the token it is traded for
+// belonging to the same family. Used tokens are kept until they expire, to
+// tell when one is replayed.
+func createRefreshTokensTable() error {
+	rows, err := db.Query(
+		`SELECT table_name
+		FROM information_schema.tables
+		WHERE table_name = 'oauth_refresh_tokens'`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		log.Info("oauth_refresh_tokens table already set up")
+		return nil
+	}
+
+	_, err = db.Exec(
+		`CREATE TABLE oauth_refresh_tokens (
+			id              varchar(36) PRIMARY KEY,
+			token_hash      varchar(64) NOT NULL UNIQUE,
+			family_id       varchar(36) NOT NULL,
+			oauth_client_id integer NOT NULL REFERENCES oauth_clients (id)
+				ON DELETE CASCADE,
+			user_id         varchar(36) NOT NULL REFERENCES users (id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			created_at      timestamp NOT NULL,
+			expires_at      timestamp NOT NULL,
+			used_at         timestamp
+		);
+		CREATE INDEX oauth_refresh_tokens_family_id
+			ON oauth_refresh_tokens (family_id);`)
+	if err != nil {
+		log.Errorf("Unable to create oauth_refresh_tokens table: %s", err)
+	}
+	return err
+}
+
+// hashSecrets replaces the secrets of the clients, which used to be stored as
+// is, with their hash. The web interface becomes a public client, as its
+// secret was never checked.
+func hashSecrets() error {
+	exists, err := hasColumn("oauth_clients", "secret")
+	if err != nil || !exists {
+		return err
+	}
+
+	err = addColumn("oauth_clients", "secret_hash", "varchar(255) NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+
+	rows, err := db.Query(`SELECT id, secret FROM oauth_clients WHERE secret != ''`)
+	if err != nil {
+		return err
+	}
+
+	secrets := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var secret string
+		err = rows.Scan(&id, &secret)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		secrets[id] = secret
+	}
+	rows.Close()
+
+	for id, secret := range secrets {
+		hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+
+		_, err = db.Exec(
+			`UPDATE oauth_clients SET secret_hash = $1::varchar
+			WHERE id = $2::integer`,
+			string(hash), id,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = db.Exec(
+		`UPDATE oauth_clients SET confidential = false
+		WHERE name = 'Nanocloud'`,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`ALTER TABLE oauth_clients DROP COLUMN secret`)
+	if err != nil {
+		log.Errorf("Unable to drop the secrets of oauth_clients: %s", err)
+	}
+	return err
+}
+
+// createAuthorizationCodesTable creates the table of the authorization codes,
+// which are stored hashed and used once.
+func createAuthorizationCodesTable() error {
+	rows, err := db.Query(
+		`SELECT table_name
+		FROM information_schema.tables
+		WHERE table_name = 'oauth_authorization_codes'`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		log.Info("oauth_authorization_codes table already set up")
+		return nil
+	}
+
+	_, err = db.Exec(
+		`CREATE TABLE oauth_authorization_codes (
+			code_hash             varchar(64) PRIMARY KEY,
+			oauth_client_id       integer NOT NULL REFERENCES oauth_clients (id)
+				ON DELETE CASCADE,
+			user_id               varchar(36) NOT NULL REFERENCES users (id)
+				ON UPDATE CASCADE
+				ON DELETE CASCADE,
+			redirect_uri          text NOT NULL,
+			scope                 text NOT NULL,
+			code_challenge        varchar(128) NOT NULL,
+			expires_at            timestamp NOT NULL
+		);`)
+	if err != nil {
+		log.Errorf("Unable to create oauth_authorization_codes table: %s", err)
+	}
+	return err
 }
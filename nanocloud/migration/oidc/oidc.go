@@ -0,0 +1,78 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package oidc
+
+import (
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	log "github.com/Sirupsen/logrus"
+)
+
+func createTable(name string, schema string) error {
+	rows, err := db.Query(
+		`SELECT table_name
+		FROM information_schema.tables
+		WHERE table_name = $1::varchar`,
+		name)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		log.Infof("%s table already set up", name)
+		return nil
+	}
+
+	rows, err = db.Query(`CREATE TABLE ` + name + ` (` + schema + `);`)
+	if err != nil {
+		log.Errorf("Unable to create %s table: %s", name, err)
+		return err
+	}
+
+	rows.Close()
+	return nil
+}
+
+// Migrate creates the tables of the OpenID Connect logins: the identities
+// linked to the users and the logins in progress.
+func Migrate() error {
+	err := createTable("oidc_identities", `
+		provider varchar(255) NOT NULL,
+		subject varchar(255) NOT NULL,
+		user_id varchar(36)
+		REFERENCES users(id)
+			ON UPDATE CASCADE
+			ON DELETE CASCADE,
+		PRIMARY KEY (provider, subject)`)
+	if err != nil {
+		return err
+	}
+
+	return createTable("oidc_states", `
+		state varchar(64) PRIMARY KEY,
+		provider varchar(255) NOT NULL,
+		nonce varchar(64) NOT NULL,
+		verifier varchar(64) NOT NULL,
+		redirect text NOT NULL DEFAULT '',
+		expires_at timestamp NOT NULL`)
+}
@@ -0,0 +1,279 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package organizations
+
+import (
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/models/organizations"
+	log "github.com/Sirupsen/logrus"
+)
+
+// Migrate creates the organizations table and the default organization. It
+// runs before the tables of the other models.
+func Migrate() error {
+	rows, err := db.Query(
+		`SELECT table_name
+		FROM information_schema.tables
+		WHERE table_name = 'organizations'`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		log.Info("organizations table already set up")
+		return nil
+	}
+
+	rows, err = db.Query(
+		`CREATE TABLE organizations (
+			id			varchar(36) PRIMARY KEY,
+			name			varchar(255) NOT NULL UNIQUE,
+			ldap_ou			varchar(255) NOT NULL DEFAULT '',
+			windows_domain		varchar(255) NOT NULL DEFAULT '',
+			execution_servers	text NOT NULL DEFAULT ''
+		);`)
+	if err != nil {
+		log.Errorf("Unable to create organizations table: %s", err)
+		return err
+	}
+	rows.Close()
+
+	// the settings of the default organization are left empty so it keeps
+	// using the environment
+	_, err = db.Exec(
+		`INSERT INTO organizations (id, name)
+		VALUES ($1::varchar, $1::varchar)`,
+		organizations.Default,
+	)
+	if err != nil {
+		log.Errorf("Unable to create the default organization: %s", err)
+		return err
+	}
+	return nil
+}
+
+func hasColumn(table string, column string) (bool, error) {
+	rows, err := db.Query(
+		`SELECT column_name
+		FROM information_schema.columns
+		WHERE table_name = $1::varchar AND column_name = $2::varchar`,
+		table, column)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	return rows.Next(), nil
+}
+
+// addOrganizationColumn scopes the rows of table to an organization. Existing
+// rows belong to the default organization and keep it from being deleted,
+// unless the column is nullable: rows without organization are shared by all
+// of them and the ones of an organization are deleted with it.
+func addOrganizationColumn(table string, nullable bool) (bool, error) {
+	exists, err := hasColumn(table, "organization_id")
+	if err != nil {
+		return false, err
+	}
+	if exists {
+		if nullable {
+			return false, nil
+		}
+		return false, restrictOrganizationDelete(table)
+	}
+
+	column := `varchar(36) NOT NULL DEFAULT '` + organizations.Default + `'`
+	onDelete := "RESTRICT"
+	if nullable {
+		column = `varchar(36)`
+		onDelete = "CASCADE"
+	}
+
+	_, err = db.Exec(
+		`ALTER TABLE ` + table + ` ADD COLUMN organization_id ` + column + `
+		REFERENCES organizations(id)
+			ON UPDATE CASCADE
+			ON DELETE ` + onDelete)
+	if err != nil {
+		log.Errorf("Unable to scope %s to organizations: %s", table, err)
+		return false, err
+	}
+	return true, nil
+}
+
+// restrictOrganizationDelete replaces the foreign key of table which deleted
+// its rows along with their organization by one refusing to delete it.
+func restrictOrganizationDelete(table string) error {
+	rows, err := db.Query(
+		`SELECT tc.constraint_name
+		FROM information_schema.table_constraints tc
+		JOIN information_schema.key_column_usage kcu
+			ON kcu.constraint_name = tc.constraint_name
+		JOIN information_schema.referential_constraints rc
+			ON rc.constraint_name = tc.constraint_name
+		WHERE tc.table_name = $1::varchar
+		AND tc.constraint_type = 'FOREIGN KEY'
+		AND kcu.column_name = 'organization_id'
+		AND rc.delete_rule = 'CASCADE'`,
+		table)
+	if err != nil {
+		return err
+	}
+
+	var constraint string
+	found := rows.Next()
+	if found {
+		err = rows.Scan(&constraint)
+	}
+	rows.Close()
+	if err != nil || !found {
+		return err
+	}
+
+	_, err = db.Exec(
+		`ALTER TABLE ` + table + `
+		DROP CONSTRAINT ` + constraint + `,
+		ADD CONSTRAINT ` + constraint + ` FOREIGN KEY (organization_id)
+		REFERENCES organizations(id)
+			ON UPDATE CASCADE
+			ON DELETE RESTRICT`)
+	if err != nil {
+		log.Errorf("Unable to restrict the deletion of the organizations of %s: %s", table, err)
+	}
+	return err
+}
+
+// scopeUnique makes the values of column unique per organization instead of
+// globally.
+func scopeUnique(table string, column string) error {
+	constraint := table + "_" + column + "_organization_key"
+
+	rows, err := db.Query(
+		`SELECT constraint_name
+		FROM information_schema.table_constraints
+		WHERE table_name = $1::varchar AND constraint_name = $2::varchar`,
+		table, constraint)
+	if err != nil {
+		return err
+	}
+	exists := rows.Next()
+	rows.Close()
+
+	if exists {
+		return nil
+	}
+
+	_, err = db.Exec(
+		`ALTER TABLE ` + table + `
+		DROP CONSTRAINT IF EXISTS ` + table + `_` + column + `_key,
+		ADD CONSTRAINT ` + constraint + ` UNIQUE (` + column + `, organization_id)`)
+	if err != nil {
+		log.Errorf("Unable to scope %s.%s to organizations: %s", table, column, err)
+	}
+	return err
+}
+
+func createMachinesOrganizationsTable() error {
+	rows, err := db.Query(
+		`SELECT table_name
+		FROM information_schema.tables
+		WHERE table_name = 'machines_organizations'`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		return restrictOrganizationDelete("machines_organizations")
+	}
+
+	// machines come from the drivers, the ones missing from this table are
+	// given to the default organization when Nanocloud starts
+	rows, err = db.Query(
+		`CREATE TABLE machines_organizations (
+			machine_id		varchar(255) PRIMARY KEY,
+			organization_id		varchar(36) NOT NULL
+			REFERENCES organizations(id)
+				ON UPDATE CASCADE
+				ON DELETE RESTRICT
+		);`)
+	if err != nil {
+		log.Errorf("Unable to create machines_organizations table: %s", err)
+		return err
+	}
+
+	rows.Close()
+	return nil
+}
+
+// Scope links the models to organizations. It runs once their tables are
+// created.
+func Scope() error {
+	for _, table := range []string{"users", "apps", "groups", "histories"} {
+		_, err := addOrganizationColumn(table, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the default OAuth client is shared
+	_, err := addOrganizationColumn("oauth_clients", true)
+	if err != nil {
+		return err
+	}
+
+	// so are the built-in roles, the others belong to the default
+	// organization
+	added, err := addOrganizationColumn("roles", true)
+	if err != nil {
+		return err
+	}
+	if added {
+		_, err = db.Exec(
+			`UPDATE roles SET organization_id = $1::varchar
+			WHERE NOT builtin`,
+			organizations.Default,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = scopeUnique("apps", "alias")
+	if err != nil {
+		return err
+	}
+
+	err = scopeUnique("groups", "name")
+	if err != nil {
+		return err
+	}
+
+	err = scopeUnique("roles", "name")
+	if err != nil {
+		return err
+	}
+
+	return createMachinesOrganizationsTable()
+}
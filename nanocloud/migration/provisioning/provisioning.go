@@ -0,0 +1,85 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package provisioning
+
+import (
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	log "github.com/Sirupsen/logrus"
+)
+
+// addPositionColumn keeps the position of the steps in the provisioning, so
+// they are listed in the order they run rather than the order they changed.
+func addPositionColumn() error {
+	rows, err := db.Query(
+		`SELECT column_name
+			FROM information_schema.columns
+			WHERE table_name = 'provisioning_steps' AND column_name = 'position'`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		return nil
+	}
+
+	_, err = db.Exec(
+		`ALTER TABLE provisioning_steps
+		ADD COLUMN position integer NOT NULL DEFAULT 0`)
+	return err
+}
+
+func Migrate() error {
+	rows, err := db.Query(
+		`SELECT table_name
+		FROM information_schema.tables
+		WHERE table_name = 'provisioning_steps'`)
+	if err != nil {
+		log.Error("Select tables names failed: ", err.Error())
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		log.Info("provisioning_steps table already set up")
+		return addPositionColumn()
+	}
+
+	rows, err = db.Query(
+		`CREATE TABLE provisioning_steps (
+			machine_id	varchar(255) NOT NULL,
+			step		varchar(255) NOT NULL,
+			state		varchar(36) NOT NULL,
+			attempts	integer NOT NULL DEFAULT 0,
+			error		text NOT NULL DEFAULT '',
+			updated_at	timestamp NOT NULL DEFAULT current_timestamp,
+			PRIMARY KEY (machine_id, step)
+		);`)
+	if err != nil {
+		log.Errorf("Unable to create provisioning_steps table: %s", err)
+		return err
+	}
+
+	rows.Close()
+	return addPositionColumn()
+}
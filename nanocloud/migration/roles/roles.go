@@ -0,0 +1,185 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package roles
+
+import (
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/models/roles"
+	log "github.com/Sirupsen/logrus"
+)
+
+func createTable(name string, schema string) error {
+	rows, err := db.Query(
+		`SELECT table_name
+		FROM information_schema.tables
+		WHERE table_name = $1::varchar`,
+		name)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		log.Infof("%s table already set up", name)
+		return nil
+	}
+
+	rows, err = db.Query(`CREATE TABLE ` + name + ` (` + schema + `);`)
+	if err != nil {
+		log.Errorf("Unable to create %s table: %s", name, err)
+		return err
+	}
+
+	rows.Close()
+	return nil
+}
+
+func createAdministratorRole() error {
+	_, err := db.Exec(
+		`INSERT INTO roles (id, name, description, builtin)
+		SELECT $1::varchar, $1::varchar, 'Every permission', true
+		WHERE NOT EXISTS (SELECT 1 FROM roles WHERE id = $1::varchar)`,
+		roles.Administrator,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(
+		`INSERT INTO roles_permissions (role_id, permission)
+		SELECT $1::varchar, $2::varchar
+		WHERE NOT EXISTS (
+			SELECT 1 FROM roles_permissions
+			WHERE role_id = $1::varchar AND permission = $2::varchar
+		)`,
+		roles.Administrator, roles.All,
+	)
+	return err
+}
+
+// migrateAdmins gives the administrator role to the users flagged with the
+// is_admin column it replaces, then drops the column.
+func migrateAdmins() error {
+	rows, err := db.Query(
+		`SELECT column_name
+		FROM information_schema.columns
+		WHERE table_name = 'users' AND column_name = 'is_admin'`)
+	if err != nil {
+		return err
+	}
+	exists := rows.Next()
+	rows.Close()
+
+	if !exists {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.Exec(
+		`INSERT INTO users_roles (user_id, role_id)
+		SELECT id, $1::varchar
+		FROM users
+		WHERE is_admin`,
+		roles.Administrator,
+	)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec(`ALTER TABLE users DROP COLUMN is_admin`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	migrated, _ := res.RowsAffected()
+	log.Infof("%d administrators migrated to the %s role", migrated, roles.Administrator)
+	return nil
+}
+
+func Migrate() error {
+	err := createTable("roles", `
+		id		varchar(36) PRIMARY KEY,
+		name		varchar(255) NOT NULL UNIQUE,
+		description	text NOT NULL DEFAULT '',
+		builtin		boolean NOT NULL DEFAULT false`)
+	if err != nil {
+		return err
+	}
+
+	err = createTable("roles_permissions", `
+		role_id varchar(36)
+		REFERENCES roles(id)
+			ON UPDATE CASCADE
+			ON DELETE CASCADE,
+		permission varchar(255) NOT NULL,
+		PRIMARY KEY (role_id, permission)`)
+	if err != nil {
+		return err
+	}
+
+	err = createTable("users_roles", `
+		user_id varchar(36)
+		REFERENCES users(id)
+			ON UPDATE CASCADE
+			ON DELETE CASCADE,
+		role_id varchar(36)
+		REFERENCES roles(id)
+			ON UPDATE CASCADE
+			ON DELETE CASCADE,
+		PRIMARY KEY (user_id, role_id)`)
+	if err != nil {
+		return err
+	}
+
+	err = createTable("groups_roles", `
+		group_id varchar(36)
+		REFERENCES groups(id)
+			ON UPDATE CASCADE
+			ON DELETE CASCADE,
+		role_id varchar(36)
+		REFERENCES roles(id)
+			ON UPDATE CASCADE
+			ON DELETE CASCADE,
+		PRIMARY KEY (group_id, role_id)`)
+	if err != nil {
+		return err
+	}
+
+	err = createAdministratorRole()
+	if err != nil {
+		return err
+	}
+
+	return migrateAdmins()
+}
@@ -0,0 +1,61 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package saml
+
+import (
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	log "github.com/Sirupsen/logrus"
+)
+
+// Migrate creates the table of the authentication requests sent to the
+// identity providers and not answered yet.
+func Migrate() error {
+	rows, err := db.Query(
+		`SELECT table_name
+		FROM information_schema.tables
+		WHERE table_name = 'saml_requests'`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		log.Info("saml_requests table already set up")
+		return nil
+	}
+
+	rows, err = db.Query(
+		`CREATE TABLE saml_requests (
+			id varchar(64) PRIMARY KEY,
+			provider varchar(255) NOT NULL,
+			redirect text NOT NULL DEFAULT '',
+			expires_at timestamp NOT NULL
+		);`)
+	if err != nil {
+		log.Errorf("Unable to create saml_requests table: %s", err)
+		return err
+	}
+
+	rows.Close()
+	return nil
+}
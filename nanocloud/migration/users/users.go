@@ -24,6 +24,7 @@ package users
 
 import (
 	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/models/organizations"
 	"github.com/Nanocloud/community/nanocloud/models/users"
 	"github.com/Nanocloud/community/nanocloud/utils"
 	log "github.com/Sirupsen/logrus"
@@ -92,18 +93,18 @@ func createUsersWindowsUserTable() error {
 	return nil
 }
 
-func createUsersTable() (bool, error) {
+func createUsersTable() error {
 	rows, err := db.Query(
 		`SELECT table_name
 			FROM information_schema.tables
 			WHERE table_name = 'users'`)
 	if err != nil {
-		return false, err
+		return err
 	}
 	defer rows.Close()
 
 	if rows.Next() {
-		return false, nil
+		return nil
 	}
 
 	rows, err = db.Query(
@@ -114,19 +115,74 @@ func createUsersTable() (bool, error) {
 			email            varchar(36)                NOT NULL DEFAULT '' UNIQUE,
 			password         varchar(60)                NOT NULL DEFAULT '',
 			signup_date      timestamp with time zone   NOT NULL DEFAULT current_timestamp,
-			is_admin         boolean,
-			activated        boolean
+			activated        boolean,
+			directory_dn     varchar(255)               NOT NULL DEFAULT '',
+			service_account  boolean                    NOT NULL DEFAULT false
 		);`)
 	if err != nil {
-		return false, err
+		return err
 	}
 
 	rows.Close()
-	return true, nil
+	return nil
+}
+
+// addDirectoryColumn links the users to their directory entry. Local users
+// have none.
+func addDirectoryColumn() error {
+	rows, err := db.Query(
+		`SELECT column_name
+			FROM information_schema.columns
+			WHERE table_name = 'users' AND column_name = 'directory_dn'`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		return nil
+	}
+
+	_, err = db.Exec(
+		`ALTER TABLE users
+		ADD COLUMN directory_dn varchar(255) NOT NULL DEFAULT ''`)
+	return err
+}
+
+// addServiceAccountColumn tells the service accounts from the users. They
+// authenticate as OAuth clients and have no Windows account.
+func addServiceAccountColumn() error {
+	rows, err := db.Query(
+		`SELECT column_name
+			FROM information_schema.columns
+			WHERE table_name = 'users' AND column_name = 'service_account'`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		return nil
+	}
+
+	_, err = db.Exec(
+		`ALTER TABLE users
+		ADD COLUMN service_account boolean NOT NULL DEFAULT false`)
+	return err
 }
 
 func Migrate() error {
-	insertAdmin, err := createUsersTable()
+	err := createUsersTable()
+	if err != nil {
+		return err
+	}
+
+	err = addDirectoryColumn()
+	if err != nil {
+		return err
+	}
+
+	err = addServiceAccountColumn()
 	if err != nil {
 		return err
 	}
@@ -136,40 +192,51 @@ func Migrate() error {
 		return err
 	}
 
-	err = createUsersWindowsUserTable()
+	return createUsersWindowsUserTable()
+}
+
+// CreateAdmin creates the first administrator when there is no user yet. It
+// runs once every table is set up.
+func CreateAdmin() error {
+	rows, err := db.Query(`SELECT id FROM users LIMIT 1`)
 	if err != nil {
 		return err
 	}
+	exists := rows.Next()
+	rows.Close()
 
-	if insertAdmin {
-		adminpwd := utils.Env("ADMIN_PASSWORD", "Nanocloud123+")
-		adminfirstname := utils.Env("ADMIN_FIRSTNAME", "Admin")
-		adminlastname := utils.Env("ADMIN_LASTNAME", "Nanocloud")
-		adminmail := utils.Env("ADMIN_MAIL", "admin@nanocloud.com")
+	if exists {
+		return nil
+	}
 
-		admin, err := users.CreateUser(
-			true,
-			adminmail,
-			adminfirstname,
-			adminlastname,
-			adminpwd,
-			true,
-		)
+	adminpwd := utils.Env("ADMIN_PASSWORD", "Nanocloud123+")
+	adminfirstname := utils.Env("ADMIN_FIRSTNAME", "Admin")
+	adminlastname := utils.Env("ADMIN_LASTNAME", "Nanocloud")
+	adminmail := utils.Env("ADMIN_MAIL", "admin@nanocloud.com")
+
+	admin, err := users.CreateUser(
+		true,
+		adminmail,
+		adminfirstname,
+		adminlastname,
+		adminpwd,
+		true,
+		organizations.Default,
+	)
 
-		if err != nil {
-			return err
-		}
+	if err != nil {
+		return err
+	}
 
-		password := utils.Env("WINDOWS_PASSWORD", "")
-		sam := utils.Env("WINDOWS_USER", "")
-		domain := utils.Env("WINDOWS_DOMAIN", "")
+	password := utils.Env("WINDOWS_PASSWORD", "")
+	sam := utils.Env("WINDOWS_USER", "")
+	domain := utils.Env("WINDOWS_DOMAIN", "")
 
-		err = users.UpdateUserAd(admin.Id, sam, password, domain)
+	err = users.UpdateUserAd(admin.Id, sam, password, domain)
 
-		if err != nil {
-			log.Error("Failed to update admin account: ", err)
-			return err
-		}
+	if err != nil {
+		log.Error("Failed to update admin account: ", err)
+		return err
 	}
 
 	return nil
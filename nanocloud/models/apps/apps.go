@@ -23,15 +23,17 @@
 package apps
 
 import (
-	"encoding/json"
+	"database/sql"
 	"errors"
-	"math/rand"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/health"
+	"github.com/Nanocloud/community/nanocloud/models/organizations"
+	"github.com/Nanocloud/community/nanocloud/models/roles"
 	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/placement"
 	"github.com/Nanocloud/community/nanocloud/plaza"
 	"github.com/Nanocloud/community/nanocloud/utils"
 	log "github.com/Sirupsen/logrus"
@@ -47,11 +49,11 @@ var (
 	PublishFailed       = errors.New("Publish application failed")
 	AppsListUnavailable = errors.New("Apps list isn't available")
 	FailedNameChange    = errors.New("Failed to change the app name")
+	NoServerAvailable   = errors.New("No execution server available")
+	AssignmentNotFound  = errors.New("Assignment not found")
 )
 
 var (
-	kServer               string
-	kExecutionServers     []string
 	kRDPPort              string
 	kXMLConfigurationFile string
 	kProtocol             string
@@ -65,6 +67,8 @@ type App struct {
 	FilePath       string `json:"file-path"`
 	Path           string `json:"path"`
 	IconContents   []byte `json:"icon-content"`
+
+	OrganizationId string `json:"-"`
 }
 
 func (a *App) GetID() string {
@@ -76,15 +80,6 @@ func (a *App) SetID(id string) error {
 	return nil
 }
 
-type ApplicationWin struct {
-	Id             int
-	CollectionName string
-	Alias          string
-	DisplayName    string
-	FilePath       string
-	IconContents   []byte
-}
-
 type Connection struct {
 	Hostname  string `json:"hostname"`
 	Port      string `json:"port"`
@@ -145,7 +140,7 @@ func GetApp(appId string) (*App, error) {
 		`SELECT id, collection_name,
 		alias, display_name,
 		file_path,
-		icon_content
+		icon_content, organization_id
 		FROM apps WHERE id = $1::varchar`, appId)
 
 	if err != nil {
@@ -163,6 +158,7 @@ func GetApp(appId string) (*App, error) {
 			&application.DisplayName,
 			&application.FilePath,
 			&application.IconContents,
+			&application.OrganizationId,
 		)
 		if err != nil {
 			return nil, err
@@ -173,7 +169,8 @@ func GetApp(appId string) (*App, error) {
 	return nil, nil
 }
 
-func GetAllApps() ([]*App, error) {
+// GetAllApps returns the apps of an organization.
+func GetAllApps(organizationId string) ([]*App, error) {
 	rows, err := db.Query(
 		`SELECT
 		id,
@@ -181,8 +178,12 @@ func GetAllApps() ([]*App, error) {
 		collection_name,
 		alias,
 		icon_content,
-		file_path
-		FROM apps`)
+		file_path,
+		organization_id
+		FROM apps
+		WHERE organization_id = $1::varchar`,
+		organizationId,
+	)
 
 	if err != nil {
 		log.Error("Connection to postgres failed: ", err.Error())
@@ -203,6 +204,7 @@ func GetAllApps() ([]*App, error) {
 			&appParam.Alias,
 			&appParam.IconContents,
 			&appParam.FilePath,
+			&appParam.OrganizationId,
 		)
 		applications = append(applications, &appParam)
 
@@ -212,13 +214,28 @@ func GetAllApps() ([]*App, error) {
 
 }
 
+// kEntitledApps selects the ids of the apps granted to the user $1, directly
+// or through one of their groups.
+const kEntitledApps = `SELECT app_id
+	FROM apps_users
+	WHERE user_id = $1::varchar
+	UNION
+	SELECT apps_groups.app_id
+	FROM apps_groups
+	JOIN users_groups
+		ON users_groups.group_id = apps_groups.group_id
+	WHERE users_groups.user_id = $1::varchar`
+
+// GetUserApps returns the apps the user is entitled to.
 func GetUserApps(userId string) ([]*App, error) {
 	rows, err := db.Query(
 		`SELECT id, collection_name,
 		alias, display_name,
 		file_path,
-		icon_content
-		FROM apps`,
+		icon_content, organization_id
+		FROM apps
+		WHERE id IN (`+kEntitledApps+`)`,
+		userId,
 	)
 
 	if err != nil {
@@ -240,6 +257,7 @@ func GetUserApps(userId string) ([]*App, error) {
 			&appParam.DisplayName,
 			&appParam.FilePath,
 			&appParam.IconContents,
+			&appParam.OrganizationId,
 		)
 		if appParam.Alias != "hapticPowershell" && appParam.Alias != "Desktop" {
 			applications = append(applications, &appParam)
@@ -249,25 +267,90 @@ func GetUserApps(userId string) ([]*App, error) {
 	return applications, nil
 }
 
-func getCredentials() (string, string) {
+func grant(table string, column string, appId string, id string) error {
+	_, err := db.Exec(
+		`INSERT INTO `+table+` (app_id, `+column+`)
+		SELECT $1::varchar, $2::varchar
+		WHERE NOT EXISTS (
+			SELECT 1 FROM `+table+`
+			WHERE app_id = $1::varchar AND `+column+` = $2::varchar
+		)`,
+		appId, id,
+	)
+	return err
+}
+
+func revoke(table string, column string, appId string, id string) error {
+	res, err := db.Exec(
+		`DELETE FROM `+table+`
+		WHERE app_id = $1::varchar AND `+column+` = $2::varchar`,
+		appId, id,
+	)
+	if err != nil {
+		return err
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return AssignmentNotFound
+	}
+	return nil
+}
+
+func assignees(table string, column string, appId string) ([]string, error) {
 	rows, err := db.Query(
-		`SELECT sam, windows_password
-		FROM users
-		WHERE is_admin = $1::boolean`,
-		true)
+		`SELECT `+column+` FROM `+table+`
+		WHERE app_id = $1::varchar`,
+		appId,
+	)
 	if err != nil {
-		return "", ""
+		return nil, err
 	}
-	var name string
-	var pwd string
 	defer rows.Close()
-	if rows.Next() {
-		err = rows.Scan(&name, &pwd)
+
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		err = rows.Scan(&id)
 		if err != nil {
-			return "", ""
+			return nil, err
 		}
+		ids = append(ids, id)
 	}
-	return name, pwd
+	return ids, rows.Err()
+}
+
+// GrantUser gives a user access to an app. Granting it twice is a no-op.
+func GrantUser(appId string, userId string) error {
+	return grant("apps_users", "user_id", appId, userId)
+}
+
+// RevokeUser removes the access to an app granted to a user. Access the user
+// gets through their groups is kept.
+func RevokeUser(appId string, userId string) error {
+	return revoke("apps_users", "user_id", appId, userId)
+}
+
+// AppUsers returns the ids of the users granted access to an app.
+func AppUsers(appId string) ([]string, error) {
+	return assignees("apps_users", "user_id", appId)
+}
+
+// GrantGroup gives every member of a group access to an app.
+func GrantGroup(appId string, groupId string) error {
+	return grant("apps_groups", "group_id", appId, groupId)
+}
+
+// RevokeGroup removes the access to an app granted to a group.
+func RevokeGroup(appId string, groupId string) error {
+	return revoke("apps_groups", "group_id", appId, groupId)
+}
+
+// AppGroups returns the ids of the groups granted access to an app.
+func AppGroups(appId string) ([]string, error) {
+	return assignees("apps_groups", "group_id", appId)
 }
 
 // ========================================================================================================================
@@ -278,8 +361,9 @@ func getCredentials() (string, string) {
 // ========================================================================================================================
 func UnpublishApp(user *users.User, id string) error {
 	rows, err := db.Query(
-		`SELECT alias, collection_name FROM apps WHERE id = $1::varchar`,
-		id,
+		`SELECT alias, collection_name FROM apps
+		WHERE id = $1::varchar AND organization_id = $2::varchar`,
+		id, user.OrganizationId,
 	)
 	if err != nil {
 		log.Error(err)
@@ -296,9 +380,9 @@ func UnpublishApp(user *users.User, id string) error {
 
 	rows.Scan(&alias, &collection)
 
-	plazaAddress := utils.Env("EXECUTION_SERVERS", "iaas-module")
-	if plazaAddress == "" {
-		return errors.New("plaza address unknown")
+	plazaAddress, err := publicationServer(user)
+	if err != nil {
+		return err
 	}
 
 	plazaPort, err := strconv.Atoi(utils.Env("PLAZA_PORT", "9090"))
@@ -306,23 +390,26 @@ func UnpublishApp(user *users.User, id string) error {
 		return err
 	}
 
-	winUser, err := user.WindowsCredentials()
+	winUser, err := publisherAccount(user)
 	if err != nil {
 		return err
 	}
 
-	_, err = plaza.UnpublishApp(
+	err = plaza.UnpublishRemoteApp(
 		plazaAddress, plazaPort,
 		winUser.Sam,
 		winUser.Domain,
-		winUser.Password,
 		collection,
 		alias,
 	)
 
 	if err != nil {
-		log.Error(err)
-		return UnpublishFailed
+		published, lerr := remoteAppPublished(plazaAddress, plazaPort, winUser, collection, alias)
+		if lerr != nil || published {
+			log.Error(err)
+			return UnpublishFailed
+		}
+		log.Warn("App ", alias, " was already unpublished from ", collection)
 	}
 
 	_, err = db.Query("DELETE FROM apps WHERE id = $1::varchar", id)
@@ -333,10 +420,60 @@ func UnpublishApp(user *users.User, id string) error {
 	return nil
 }
 
+// remoteAppPublished returns whether the app alias is still published on the
+// collection.
+func remoteAppPublished(
+	address string, port int,
+	winUser *users.WindowsUser,
+	collection string, alias string,
+) (bool, error) {
+	remoteApps, err := plaza.RemoteApps(address, port, winUser.Sam, winUser.Domain, collection)
+	if err != nil {
+		return false, err
+	}
+	for _, a := range remoteApps {
+		if strings.EqualFold(a.Alias, alias) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// publicationServer returns the server the apps of the organization of the
+// user are published on, the first of its pool.
+func publicationServer(user *users.User) (string, error) {
+	org, err := organizations.GetOrganization(user.OrganizationId)
+	if err != nil {
+		return "", err
+	}
+	if org == nil {
+		return "", organizations.OrganizationNotFound
+	}
+
+	servers := org.Servers()
+	if len(servers) == 0 {
+		return "", errors.New("plaza address unknown")
+	}
+	return servers[0], nil
+}
+
+// publisherAccount returns the Windows account apps are published and
+// unpublished with on behalf of user. Service accounts, which have none, use
+// the Windows administrator Nanocloud was installed with.
+func publisherAccount(user *users.User) (*users.WindowsUser, error) {
+	if user.ServiceAccount {
+		return &users.WindowsUser{
+			Sam:    utils.Env("WINDOWS_USER", ""),
+			Domain: utils.Env("WINDOWS_DOMAIN", ""),
+		}, nil
+	}
+	return user.WindowsCredentials()
+}
+
 func PublishApp(user *users.User, app *App) error {
-	plazaAddress := utils.Env("EXECUTION_SERVERS", "iaas-module")
-	if plazaAddress == "" {
-		return errors.New("plaza address unknown")
+	plazaAddress, err := publicationServer(user)
+	if err != nil {
+		return err
 	}
 
 	plazaPort, err := strconv.Atoi(utils.Env("PLAZA_PORT", "9090"))
@@ -344,12 +481,12 @@ func PublishApp(user *users.User, app *App) error {
 		return err
 	}
 
-	winUser, err := user.WindowsCredentials()
+	winUser, err := publisherAccount(user)
 	if err != nil {
 		return err
 	}
 
-	res, err := plaza.PublishApp(
+	a, err := plaza.PublishRemoteApp(
 		plazaAddress, plazaPort,
 		winUser.Sam,
 		winUser.Domain,
@@ -363,20 +500,15 @@ func PublishApp(user *users.User, app *App) error {
 		return PublishFailed
 	}
 
-	a := ApplicationWin{}
-	err = json.Unmarshal(res, &a)
-	if err != nil {
-		return err
-	}
-
 	id := uuid.NewV4().String()
 
 	_, err = db.Query(
 		`INSERT INTO apps
-		(id, collection_name, alias, display_name, file_path, icon_content)
-		VALUES ( $1::varchar, $2::varchar, $3::varchar, $4::varchar, $5::varchar, $6::bytea)
+		(id, collection_name, alias, display_name, file_path, icon_content, organization_id)
+		VALUES ( $1::varchar, $2::varchar, $3::varchar, $4::varchar, $5::varchar, $6::bytea, $7::varchar)
 		`,
 		id, a.CollectionName, a.Alias, a.DisplayName, a.FilePath, a.IconContents,
+		user.OrganizationId,
 	)
 
 	if err != nil {
@@ -387,20 +519,28 @@ func PublishApp(user *users.User, app *App) error {
 	app.DisplayName = a.DisplayName
 	app.FilePath = a.FilePath
 	app.IconContents = a.IconContents
+	app.OrganizationId = user.OrganizationId
 	app.Id = id
 
 	return nil
 }
 
+// CreateApp inserts an app in the organization of app, the default one when
+// it is not set.
 func CreateApp(app *App) (*App, error) {
 	id := uuid.NewV4().String()
 
+	if app.OrganizationId == "" {
+		app.OrganizationId = organizations.Default
+	}
+
 	rows, err := db.Query(
 		`INSERT INTO apps
-		(id, collection_name, alias, display_name, file_path, icon_content)
-		VALUES ( $1::varchar, $2::varchar, $3::varchar, $4::varchar, $5::varchar, $6::bytea)
+		(id, collection_name, alias, display_name, file_path, icon_content, organization_id)
+		VALUES ( $1::varchar, $2::varchar, $3::varchar, $4::varchar, $5::varchar, $6::bytea, $7::varchar)
 		RETURNING id, collection_name, alias, display_name, file_path, icon_content`,
 		id, app.CollectionName, app.Alias, app.DisplayName, app.FilePath, app.IconContents,
+		app.OrganizationId,
 	)
 
 	if err != nil {
@@ -416,34 +556,77 @@ func CreateApp(app *App) (*App, error) {
 	return app, err
 }
 
+// UserServer returns the server of the pool of the organization of the user
+// their sessions are opened on.
+func UserServer(user *users.User, sam string) (string, error) {
+	org, err := organizations.GetOrganization(user.OrganizationId)
+	if err != nil {
+		return "", err
+	}
+	if org == nil {
+		return "", organizations.OrganizationNotFound
+	}
+
+	execServ, err := placement.Place(health.Healthy(org.Servers()), sam)
+	if err != nil {
+		log.Error("Unable to place the session of user ", user.Id, ": ", err)
+		return "", NoServerAvailable
+	}
+	return execServ, nil
+}
+
+// RetrieveConnections returns a connection for every app the user is entitled
+// to. Users allowed to read every app of their organization are entitled to
+// all of them. Service accounts have no Windows account to open them with.
 func RetrieveConnections(user *users.User) ([]Connection, error) {
-	rand.Seed(time.Now().UTC().UnixNano())
 	var connections []Connection
+	if user.ServiceAccount {
+		return connections, nil
+	}
 
-	rows, err := db.Query("SELECT alias FROM apps")
+	all, err := user.Can(roles.AppsRead)
+	if err != nil {
+		log.Error("Unable to retrieve the permissions of user ", user.Id, ": ", err)
+		return nil, AppsListUnavailable
+	}
+
+	var rows *sql.Rows
+	if all {
+		rows, err = db.Query(
+			`SELECT alias FROM apps WHERE organization_id = $1::varchar`,
+			user.OrganizationId,
+		)
+	} else {
+		rows, err = db.Query(
+			`SELECT alias FROM apps
+			WHERE id IN (`+kEntitledApps+`)
+			AND organization_id = $2::varchar`,
+			user.Id, user.OrganizationId,
+		)
+	}
 	if err != nil {
 		log.Error("Unable to retrieve apps list from Postgres: ", err.Error())
 		return nil, AppsListUnavailable
 	}
 	defer rows.Close()
-	var execServ string
+
+	winUser, err := user.WindowsCredentials()
+	if err != nil {
+		return nil, err
+	}
+
+	// every app of the user is opened on the same server
+	execServ, err := UserServer(user, winUser.Sam)
+	if err != nil {
+		return nil, err
+	}
+
 	for rows.Next() {
 		appParam := App{}
 		rows.Scan(
 			&appParam.Alias,
 		)
 
-		if count := len(kExecutionServers); count > 0 {
-			execServ = kExecutionServers[rand.Intn(count)]
-		} else {
-			execServ = kServer
-		}
-
-		winUser, err := user.WindowsCredentials()
-		if err != nil {
-			return nil, err
-		}
-
 		username := winUser.Sam
 
 		if len(winUser.Domain) > 0 {
@@ -483,14 +666,4 @@ func RetrieveConnections(user *users.User) ([]Connection, error) {
 func init() {
 	kProtocol = utils.Env("PROTOCOL", "rdp")
 	kRDPPort = utils.Env("RDP_PORT", "3389")
-	kServer = utils.Env("EXECUTION_SERVERS", "iaas-module")
-	kExecutionServers = strings.Split(utils.Env("EXECUTION_SERVERS", ""), ",")
-
-	if kServer == "" {
-		panic("EXECUTION_SERVERS not set")
-	}
-
-	if len(kExecutionServers) == 0 {
-		panic("EXECUTION_SERVERS not set")
-	}
 }
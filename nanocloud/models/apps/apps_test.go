@@ -4,6 +4,7 @@ import (
 	"log"
 	"testing"
 
+	"github.com/Nanocloud/community/nanocloud/models/organizations"
 	"github.com/Nanocloud/community/nanocloud/models/users"
 	uuid "github.com/satori/go.uuid"
 )
@@ -29,6 +30,7 @@ func init() {
 		"user",
 		"secret",
 		false,
+		organizations.Default,
 	)
 
 	if err != nil {
@@ -71,7 +73,7 @@ func compareApp(get_app *App, i int) {
 }
 
 func TestCreateApp(t *testing.T) {
-	new_app := &App{id, collectionName, alias, displayName, filePath, path, iconContents}
+	new_app := &App{id, collectionName, alias, displayName, filePath, path, iconContents, organizations.Default}
 
 	new_app, err := CreateApp(new_app)
 	if err != nil {
@@ -131,6 +133,49 @@ func TestGetUserApps(t *testing.T) {
 	if err != nil {
 		t.Error("Unable to get user apps")
 	}
+	if len(apps) != 0 {
+		t.Errorf("Apps should not be listed until they are granted, got %d", len(apps))
+	}
+
+	for _, app := range list_apps {
+		err = GrantUser(app.Id, user.GetID())
+		if err != nil {
+			t.Fatalf("Can't grant app: %s", err.Error())
+		}
+	}
+	err = GrantUser(list_apps[0].Id, user.GetID())
+	if err != nil {
+		t.Errorf("Granting an app twice should not fail: %s", err.Error())
+	}
+
+	ids, err := AppUsers(list_apps[0].Id)
+	if err != nil {
+		t.Errorf("Can't list app users: %s", err.Error())
+	}
+	if len(ids) != 1 || ids[0] != user.GetID() {
+		t.Errorf("The user should be assigned once to the app, got %v", ids)
+	}
+
+	err = RevokeUser(list_apps[0].Id, user.GetID())
+	if err != nil {
+		t.Errorf("Can't revoke app: %s", err.Error())
+	}
+	err = RevokeUser(list_apps[0].Id, user.GetID())
+	if err != AssignmentNotFound {
+		t.Errorf("Revoking a revoked app should fail with AssignmentNotFound, got %v", err)
+	}
+	err = GrantUser(list_apps[0].Id, user.GetID())
+	if err != nil {
+		t.Fatalf("Can't grant app: %s", err.Error())
+	}
+
+	apps, err = GetUserApps(user.GetID())
+	if err != nil {
+		t.Error("Unable to get user apps")
+	}
+	if len(apps) != len(list_apps) {
+		t.Errorf("Every granted app should be listed, got %d, expected %d", len(apps), len(list_apps))
+	}
 
 	for _, get_app := range apps {
 		if get_app == nil {
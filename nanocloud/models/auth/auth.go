@@ -0,0 +1,85 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package auth
+
+import (
+	"strings"
+
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+)
+
+// Provider checks the credentials of users. A provider returns
+// users.UserNotFound for the users it doesn't know, so that the next provider
+// of the chain is tried.
+type Provider interface {
+	Authenticate(username, password string) (*users.User, error)
+}
+
+var providers map[string]Provider
+
+// Register makes a provider available to AUTH_PROVIDERS.
+func Register(name string, provider Provider) {
+	if providers == nil {
+		providers = make(map[string]Provider, 0)
+	}
+	providers[name] = provider
+}
+
+// chain returns the providers named in AUTH_PROVIDERS, a comma separated list
+// tried in order.
+func chain() []Provider {
+	var rt []Provider
+	for _, name := range strings.Split(utils.Env("AUTH_PROVIDERS", "local"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		provider := providers[name]
+		if provider == nil {
+			log.Errorf("Unknown authentication provider %s", name)
+			continue
+		}
+		rt = append(rt, provider)
+	}
+	return rt
+}
+
+func authenticate(chain []Provider, username, password string) (*users.User, error) {
+	for _, provider := range chain {
+		user, err := provider.Authenticate(username, password)
+		if err == users.UserNotFound {
+			continue
+		}
+		return user, err
+	}
+	return nil, users.UserNotFound
+}
+
+// Authenticate returns the user the first provider of the chain knowing
+// username authenticates.
+func Authenticate(username, password string) (*users.User, error) {
+	return authenticate(chain(), username, password)
+}
@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/Nanocloud/community/nanocloud/models/users"
+)
+
+type fakeProvider struct {
+	user *users.User
+	err  error
+}
+
+func (p fakeProvider) Authenticate(username, password string) (*users.User, error) {
+	return p.user, p.err
+}
+
+func TestChain(t *testing.T) {
+	alice := &users.User{Id: "alice"}
+	bob := &users.User{Id: "bob"}
+
+	tests := []struct {
+		chain    []Provider
+		expected *users.User
+		err      error
+	}{
+		{nil, nil, users.UserNotFound},
+		{[]Provider{fakeProvider{nil, users.UserNotFound}}, nil, users.UserNotFound},
+		{[]Provider{fakeProvider{nil, users.UserNotFound}, fakeProvider{alice, nil}}, alice, nil},
+		{[]Provider{fakeProvider{bob, nil}, fakeProvider{alice, nil}}, bob, nil},
+		{[]Provider{fakeProvider{nil, users.InvalidCredentials}, fakeProvider{alice, nil}}, nil, users.InvalidCredentials},
+		{[]Provider{fakeProvider{nil, users.UserDisabled}}, nil, users.UserDisabled},
+	}
+
+	for i, test := range tests {
+		user, err := authenticate(test.chain, "user", "secret")
+		if user != test.expected || err != test.err {
+			t.Errorf("Test %d: expected (%v, %v), got (%v, %v)", i, test.expected, test.err, user, err)
+		}
+	}
+}
+
+func TestProviders(t *testing.T) {
+	for _, name := range []string{"local", "ldap"} {
+		if providers[name] == nil {
+			t.Errorf("The %s provider should be registered", name)
+		}
+	}
+}
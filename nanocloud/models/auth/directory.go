@@ -0,0 +1,61 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package auth
+
+import (
+	"github.com/Nanocloud/community/nanocloud/models/directory"
+	"github.com/Nanocloud/community/nanocloud/models/ldap"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+)
+
+// directoryProvider authenticates the users by binding to the directory, Active
+// Directory or OpenLDAP, as the entry of the organisation unit of an
+// organization matching their username. Users are created on their first
+// login.
+type directoryProvider struct{}
+
+func (p directoryProvider) Authenticate(username, password string) (*users.User, error) {
+	orgs, err := directory.Organizations()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, org := range orgs {
+		entry, err := ldap.Authenticate(org.LDAPOU, username, password)
+		switch err {
+		case nil:
+			return directory.Provision(org, entry, password)
+		case ldap.NotInDirectory:
+			continue
+		case ldap.InvalidCredentials:
+			return nil, users.InvalidCredentials
+		default:
+			return nil, err
+		}
+	}
+	return nil, users.UserNotFound
+}
+
+func init() {
+	Register("ldap", directoryProvider{})
+}
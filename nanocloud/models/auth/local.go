@@ -20,26 +20,18 @@
  * along with this program.  If not, see <http://www.gnu.org/licenses/>.
  */
 
-package manual
+package auth
 
-type machineType struct {
-	id   string
-	size string
-	cpu  int
-	ram  int
-}
+import "github.com/Nanocloud/community/nanocloud/models/users"
 
-func (t *machineType) GetID() string {
-	return t.id
-}
+// localProvider authenticates the users by their email and the password
+// stored by Nanocloud.
+type localProvider struct{}
 
-var defaultType *machineType
+func (p localProvider) Authenticate(username, password string) (*users.User, error) {
+	return users.GetUserFromEmailPassword(username, password)
+}
 
 func init() {
-	defaultType = &machineType{
-		id:   "default",
-		size: "60GB",
-		cpu:  2,
-		ram:  4096,
-	}
+	Register("local", localProvider{})
 }
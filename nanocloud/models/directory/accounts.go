@@ -0,0 +1,400 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package directory
+
+import (
+	"database/sql"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/models/ldap"
+	"github.com/Nanocloud/community/nanocloud/models/organizations"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+)
+
+// The operations made on the Windows accounts Nanocloud creates for its
+// users, which are named after their id.
+const (
+	OperationDelete   = "delete"
+	OperationDisable  = "disable"
+	OperationPassword = "password"
+)
+
+// Operation is a change to make to a Windows account. Operations are queued
+// within the transaction changing the user and retried until the directory
+// accepts them.
+type Operation struct {
+	Id          int
+	Operation   string
+	Account     string
+	OU          string
+	Attempts    int
+	LastError   string
+	NextAttempt time.Time
+	password    string
+}
+
+// kQueueMutex prevents an operation from being made twice, by the queue and
+// right after being queued.
+var kQueueMutex sync.Mutex
+
+// backoff returns how long to wait before trying an operation again after it
+// failed attempts times, from 30 seconds up to an hour.
+func backoff(attempts int) time.Duration {
+	delay := 30 * time.Second
+	for i := 1; i < attempts && delay < time.Hour; i++ {
+		delay *= 2
+	}
+	if delay > time.Hour {
+		delay = time.Hour
+	}
+	return delay
+}
+
+// organisationUnit returns the organisation unit the Windows account of a
+// user was created in.
+func organisationUnit(user *users.User) (string, error) {
+	org, err := organizations.GetOrganization(user.OrganizationId)
+	if err != nil {
+		return "", err
+	}
+	if org == nil {
+		return "", nil
+	}
+	return org.LDAPOU, nil
+}
+
+// enqueue adds an operation on account to the queue within tx. A pending
+// password change is replaced by the new one.
+func enqueue(tx *sql.Tx, operation, account, ou, password string) (int, error) {
+	if operation == OperationPassword {
+		_, err := tx.Exec(
+			`DELETE FROM directory_operations
+			WHERE operation = $1::varchar AND account = $2::varchar`,
+			operation, account,
+		)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	var id int
+	err := tx.QueryRow(
+		`INSERT INTO directory_operations
+		(operation, account, ou, password)
+		VALUES ($1::varchar, $2::varchar, $3::varchar, $4::varchar)
+		RETURNING id`,
+		operation, account, ou, password,
+	).Scan(&id)
+	return id, err
+}
+
+// apply makes op in the directory. Accounts missing from the directory, which
+// were already removed or weren't created by Nanocloud, have nothing to
+// change.
+func apply(op *Operation) error {
+	var err error
+
+	switch op.Operation {
+	case OperationDelete:
+		err = ldap.DeleteAccount(op.Account, op.OU)
+	case OperationDisable:
+		err = ldap.DisableUser(op.Account, op.OU)
+	case OperationPassword:
+		err = ldap.ChangePassword(op.Account, op.password, op.OU)
+		if err == nil {
+			err = users.UpdateWindowsPassword(op.Account, op.password)
+		}
+	}
+
+	if err == ldap.UnknownUser {
+		return nil
+	}
+	return err
+}
+
+// run makes the operation id, removing it from the queue once done or
+// scheduling its next attempt.
+func run(id int) error {
+	kQueueMutex.Lock()
+	defer kQueueMutex.Unlock()
+
+	op := Operation{}
+	rows, err := db.Query(
+		`SELECT id, operation, account, ou, password, attempts
+		FROM directory_operations
+		WHERE id = $1::integer`,
+		id,
+	)
+	if err != nil {
+		return err
+	}
+	found := rows.Next()
+	if found {
+		err = rows.Scan(&op.Id, &op.Operation, &op.Account, &op.OU, &op.password, &op.Attempts)
+	}
+	rows.Close()
+	if !found || err != nil {
+		return err
+	}
+
+	opErr := apply(&op)
+	if opErr == nil {
+		_, err = db.Exec(`DELETE FROM directory_operations WHERE id = $1::integer`, id)
+		return err
+	}
+
+	op.Attempts++
+	_, err = db.Exec(
+		`UPDATE directory_operations
+		SET attempts = $2::integer, last_error = $3::varchar,
+			next_attempt = current_timestamp + $4::integer * interval '1 second'
+		WHERE id = $1::integer`,
+		id, op.Attempts, opErr.Error(), int(backoff(op.Attempts).Seconds()),
+	)
+	if err != nil {
+		return err
+	}
+	return opErr
+}
+
+// commit commits tx and makes the operation id right away. Failed operations
+// are left to the queue.
+func commit(tx *sql.Tx, id int) error {
+	err := tx.Commit()
+	if err != nil {
+		return err
+	}
+	if id == 0 {
+		return nil
+	}
+
+	err = run(id)
+	if err != nil {
+		log.Error("Directory operation failed, it will be retried: ", err)
+	}
+	return nil
+}
+
+// CreateAccount creates the Windows account of a local user in the
+// organisation unit of their organization.
+func CreateAccount(user *users.User, org *organizations.Organization) error {
+	password := utils.RandomString(8) + "s4D+"
+	sam, err := ldap.AddUser(user.Id, password, org.LDAPOU)
+	if err != nil {
+		return err
+	}
+	return users.UpdateUserAd(user.Id, sam, password, org.Domain())
+}
+
+// CreateLocalUser creates a user of the organization who logs in with an
+// identity provider, along with their Windows account. Their local password
+// is random.
+func CreateLocalUser(org *organizations.Organization, email, firstName, lastName string) (*users.User, error) {
+	user, err := users.CreateUser(
+		true,
+		email,
+		firstName,
+		lastName,
+		utils.RandomString(32),
+		false,
+		org.Id,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = CreateAccount(user, org)
+	if err != nil {
+		DeleteUser(user)
+		return nil, err
+	}
+	return user, nil
+}
+
+// DeleteUser deletes a user along with the Windows account Nanocloud created
+// for them. The accounts of directory users are left to the directory.
+func DeleteUser(user *users.User) error {
+	ou, err := organisationUnit(user)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	id := 0
+	err = users.DeleteUserTx(tx, user.Id)
+	if err == nil && !user.FromDirectory() {
+		id, err = enqueue(tx, OperationDelete, user.Id, ou, "")
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return commit(tx, id)
+}
+
+// DisableUser disables a user along with the Windows account Nanocloud
+// created for them.
+func DisableUser(user *users.User) error {
+	ou, err := organisationUnit(user)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	id := 0
+	err = users.DisableUserTx(tx, user.Id)
+	if err == nil && !user.FromDirectory() {
+		id, err = enqueue(tx, OperationDisable, user.Id, ou, "")
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return commit(tx, id)
+}
+
+// UpdatePassword changes the password of a user, and of the Windows account
+// Nanocloud created for them. Passwords Active Directory would refuse are
+// replaced by a random one for the Windows account, which still changes.
+func UpdatePassword(user *users.User, password string) error {
+	ou, err := organisationUnit(user)
+	if err != nil {
+		return err
+	}
+
+	winPassword := password
+	if !ldap.StrongPassword(winPassword) {
+		winPassword = utils.RandomString(8) + "s4D+"
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	id := 0
+	err = users.UpdateUserPasswordTx(tx, user.Id, password)
+	if err == nil && !user.FromDirectory() {
+		id, err = enqueue(tx, OperationPassword, user.Id, ou, winPassword)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return commit(tx, id)
+}
+
+// PendingOperations returns the operations waiting for the directory.
+func PendingOperations() ([]*Operation, error) {
+	rows, err := db.Query(
+		`SELECT id, operation, account, ou, attempts, last_error, next_attempt
+		FROM directory_operations
+		ORDER BY id`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ops := make([]*Operation, 0)
+	for rows.Next() {
+		op := Operation{}
+		err = rows.Scan(
+			&op.Id, &op.Operation, &op.Account, &op.OU,
+			&op.Attempts, &op.LastError, &op.NextAttempt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, &op)
+	}
+	return ops, rows.Err()
+}
+
+// ProcessQueue makes the operations due, oldest first.
+func ProcessQueue() error {
+	rows, err := db.Query(
+		`SELECT id FROM directory_operations
+		WHERE next_attempt <= current_timestamp
+		ORDER BY id`,
+	)
+	if err != nil {
+		return err
+	}
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		err = run(id)
+		if err != nil {
+			log.Error("Directory operation failed: ", err)
+		}
+	}
+	return nil
+}
+
+// StartQueue retries the failed directory operations every
+// LDAP_RETRY_INTERVAL seconds, 60 by default.
+func StartQueue() error {
+	interval, err := strconv.Atoi(utils.Env("LDAP_RETRY_INTERVAL", "60"))
+	if err != nil {
+		return err
+	}
+	if interval <= 0 {
+		interval = 60
+	}
+
+	go func() {
+		for {
+			err := ProcessQueue()
+			if err != nil {
+				log.Error("Unable to process the directory operations: ", err)
+			}
+			time.Sleep(time.Duration(interval) * time.Second)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,24 @@
+package directory
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	expected := map[int]time.Duration{
+		0:  30 * time.Second,
+		1:  30 * time.Second,
+		2:  time.Minute,
+		3:  2 * time.Minute,
+		7:  32 * time.Minute,
+		8:  time.Hour,
+		50: time.Hour,
+	}
+
+	for attempts, delay := range expected {
+		if d := backoff(attempts); d != delay {
+			t.Errorf("Expected %s after %d attempts, got %s", delay, attempts, d)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package directory keeps the users of the organizations in line with their
+// entries in the directory, Active Directory or OpenLDAP.
+package directory
+
+import (
+	"github.com/Nanocloud/community/nanocloud/models/groups"
+	"github.com/Nanocloud/community/nanocloud/models/ldap"
+	"github.com/Nanocloud/community/nanocloud/models/organizations"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	log "github.com/Sirupsen/logrus"
+)
+
+// Organizations returns the organizations whose organisation unit holds
+// directory users, the default organization first. Organizations sharing an
+// organisation unit, most likely LDAP_OU, give its users to the first one.
+func Organizations() ([]*organizations.Organization, error) {
+	orgs, err := organizations.FindAll()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, org := range orgs {
+		if org.Id == organizations.Default {
+			orgs[0], orgs[i] = orgs[i], orgs[0]
+		}
+	}
+
+	owners := make([]*organizations.Organization, 0, len(orgs))
+	owned := make(map[string]bool, len(orgs))
+	for _, org := range orgs {
+		if owned[org.LDAPOU] {
+			continue
+		}
+		owned[org.LDAPOU] = true
+		owners = append(owners, org)
+	}
+	return owners, nil
+}
+
+// createdByNanocloud tells whether entry is one of the Windows accounts
+// Nanocloud creates for its users, which are named after their id and aren't
+// directory users.
+func createdByNanocloud(entry *ldap.Entry) (bool, error) {
+	return users.UserExists(entry.CN)
+}
+
+// Provision returns the user of entry, created the first time they log in
+// with password. Their names, email, directory groups and Windows account are
+// refreshed at every login.
+func Provision(org *organizations.Organization, entry *ldap.Entry, password string) (*users.User, error) {
+	if entry.Disabled {
+		return nil, users.UserDisabled
+	}
+
+	nanocloud, err := createdByNanocloud(entry)
+	if err != nil {
+		return nil, err
+	}
+	if nanocloud {
+		return nil, users.UserNotFound
+	}
+
+	user, err := users.GetDirectoryUser(entry.DN)
+	if err != nil {
+		return nil, err
+	}
+
+	if user == nil {
+		if entry.Email == "" {
+			log.Errorf("Directory user %s has no email, unable to create them", entry.DN)
+			return nil, users.UserNotFound
+		}
+
+		user, err = users.CreateDirectoryUser(org.Id, entry.DN, entry.Email, entry.FirstName, entry.LastName)
+		if err == users.UserDuplicated {
+			log.Errorf("A local user already uses the email of directory user %s", entry.DN)
+			return nil, users.UserNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		log.WithFields(log.Fields{
+			"user":         user.Id,
+			"dn":           entry.DN,
+			"organization": org.Id,
+		}).Info("Directory user created")
+	} else {
+		if !user.Activated {
+			return nil, users.UserDisabled
+		}
+
+		_, err = refresh(user, entry)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = groups.SetDirectoryGroups(user.OrganizationId, user.Id, entry.MemberOf)
+	if err != nil {
+		return nil, err
+	}
+
+	// Active Directory users open their sessions with their own account
+	if entry.Sam != "" {
+		domain := org.Domain()
+		winUser, err := user.WindowsCredentials()
+		if err != nil || winUser.Sam != entry.Sam || winUser.Password != password || winUser.Domain != domain {
+			err = users.UpdateUserAd(user.Id, entry.Sam, password, domain)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return users.GetUser(user.Id)
+}
+
+// refresh copies the attributes of entry which changed to user. It returns
+// whether any did.
+func refresh(user *users.User, entry *ldap.Entry) (bool, error) {
+	var err error
+	changed := false
+
+	if entry.Email != "" && entry.Email != user.Email {
+		err = users.UpdateUserEmail(user.Id, entry.Email)
+		if err != nil {
+			return false, err
+		}
+		changed = true
+	}
+
+	if entry.FirstName != user.FirstName {
+		err = users.UpdateUserFirstName(user.Id, entry.FirstName)
+		if err != nil {
+			return false, err
+		}
+		changed = true
+	}
+
+	if entry.LastName != user.LastName {
+		err = users.UpdateUserLastName(user.Id, entry.LastName)
+		if err != nil {
+			return false, err
+		}
+		changed = true
+	}
+	return changed, nil
+}
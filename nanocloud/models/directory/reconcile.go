@@ -0,0 +1,106 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package directory
+
+import (
+	"github.com/Nanocloud/community/nanocloud/models/ldap"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Account is a Windows account of the directory.
+type Account struct {
+	Organization string `json:"organization"`
+	DN           string `json:"dn"`
+	Sam          string `json:"sam"`
+}
+
+// Reconciliation lists what differs between the users of Nanocloud and the
+// directory.
+type Reconciliation struct {
+	OrphanedAccounts           []Account     `json:"orphaned-accounts"`
+	UsersWithoutWindowsAccount []*users.User `json:"users-without-windows-account"`
+	PendingOperations          int           `json:"pending-operations"`
+}
+
+// Consistent tells whether nothing differs.
+func (r *Reconciliation) Consistent() bool {
+	return len(r.OrphanedAccounts) == 0 && len(r.UsersWithoutWindowsAccount) == 0
+}
+
+// Reconcile finds the Windows accounts Nanocloud created for users who no
+// longer exist, and the users who have no Windows account. Accounts with a
+// pending operation are left out, the queue takes care of them.
+func Reconcile() (*Reconciliation, error) {
+	ops, err := PendingOperations()
+	if err != nil {
+		return nil, err
+	}
+	pending := make(map[string]bool, len(ops))
+	for _, op := range ops {
+		pending[op.Account] = true
+	}
+
+	orgs, err := Organizations()
+	if err != nil {
+		return nil, err
+	}
+
+	r := Reconciliation{
+		OrphanedAccounts:  make([]Account, 0),
+		PendingOperations: len(ops),
+	}
+
+	for _, org := range orgs {
+		res, err := ldap.GetUsers(org.LDAPOU)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range res.Users {
+			cn := entry["cn"]
+			_, err := uuid.FromString(cn)
+			if err != nil || pending[cn] {
+				continue
+			}
+
+			exists, err := users.UserExists(cn)
+			if err != nil {
+				return nil, err
+			}
+			if !exists {
+				r.OrphanedAccounts = append(r.OrphanedAccounts, Account{
+					Organization: org.Id,
+					DN:           entry["dn"],
+					Sam:          entry["samaccountname"],
+				})
+			}
+		}
+	}
+
+	r.UsersWithoutWindowsAccount, err = users.FindUsersWithoutWindowsAccount()
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
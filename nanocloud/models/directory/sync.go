@@ -0,0 +1,277 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package directory
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nanocloud/community/nanocloud/models/groups"
+	"github.com/Nanocloud/community/nanocloud/models/ldap"
+	"github.com/Nanocloud/community/nanocloud/models/organizations"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+)
+
+var (
+	SharedOrganisationUnit = errors.New("the organisation unit belongs to another organization")
+)
+
+// The changes a sync makes to a user.
+const (
+	Created  = "created"
+	Updated  = "updated"
+	Disabled = "disabled"
+	Linked   = "linked"
+)
+
+// Change is a change a sync made to a user.
+type Change struct {
+	Action string `json:"action"`
+	UserId string `json:"user-id"`
+	Email  string `json:"email"`
+	DN     string `json:"dn"`
+}
+
+// Report tells what a sync changed. Errors lists the entries which couldn't
+// be synced, the others are synced anyway.
+type Report struct {
+	Organization string   `json:"organization"`
+	Entries      int      `json:"entries"`
+	Created      int      `json:"created"`
+	Updated      int      `json:"updated"`
+	Disabled     int      `json:"disabled"`
+	Linked       int      `json:"linked"`
+	Changes      []Change `json:"changes"`
+	Errors       []string `json:"errors"`
+}
+
+func (r *Report) record(action string, user *users.User) {
+	switch action {
+	case Created:
+		r.Created++
+	case Updated:
+		r.Updated++
+	case Disabled:
+		r.Disabled++
+	case Linked:
+		r.Linked++
+	}
+	r.Changes = append(r.Changes, Change{
+		Action: action,
+		UserId: user.Id,
+		Email:  user.Email,
+		DN:     user.DirectoryDN,
+	})
+}
+
+// kSyncMutex prevents the periodic sync and a sync requested by an
+// administrator from running concurrently.
+var kSyncMutex sync.Mutex
+
+// syncEntry imports the user of entry, or refreshes them.
+func syncEntry(org *organizations.Organization, entry *ldap.Entry, report *Report) error {
+	user, err := users.GetDirectoryUser(entry.DN)
+	if err != nil {
+		return err
+	}
+
+	if user == nil {
+		// disabled accounts aren't imported
+		if entry.Disabled {
+			return nil
+		}
+		if entry.Email == "" {
+			return fmt.Errorf("%s has no email", entry.DN)
+		}
+
+		user, err = users.CreateDirectoryUser(org.Id, entry.DN, entry.Email, entry.FirstName, entry.LastName)
+		if err == users.UserDuplicated {
+			return fmt.Errorf("a local user already uses the email of %s", entry.DN)
+		}
+		if err != nil {
+			return err
+		}
+		report.record(Created, user)
+	} else {
+		if user.OrganizationId != org.Id {
+			return fmt.Errorf("%s belongs to another organization", entry.DN)
+		}
+
+		changed, err := refresh(user, entry)
+		if err != nil {
+			return err
+		}
+		if changed {
+			user, err = users.GetUser(user.Id)
+			if err != nil {
+				return err
+			}
+			report.record(Updated, user)
+		}
+
+		if entry.Disabled {
+			if user.Activated {
+				err = users.DisableUser(user.Id)
+				if err != nil {
+					return err
+				}
+				report.record(Disabled, user)
+			}
+			return nil
+		}
+	}
+
+	err = groups.SetDirectoryGroups(org.Id, user.Id, entry.MemberOf)
+	if err != nil {
+		return err
+	}
+
+	if entry.Sam != "" {
+		linked, err := users.LinkWindowsUser(user.Id, entry.Sam, org.Domain())
+		if err != nil {
+			return err
+		}
+		if linked {
+			report.record(Linked, user)
+		}
+	}
+	return nil
+}
+
+// Sync imports the users of the organisation unit of the organization and
+// refreshes their names, emails and directory groups. Users whose account is
+// disabled or who were removed from the directory are disabled. Active
+// Directory users get their account as Windows account, its password is set
+// when they log in.
+func Sync(org *organizations.Organization) (*Report, error) {
+	kSyncMutex.Lock()
+	defer kSyncMutex.Unlock()
+
+	owners, err := Organizations()
+	if err != nil {
+		return nil, err
+	}
+	owner := false
+	for _, o := range owners {
+		if o.Id == org.Id {
+			owner = true
+		}
+	}
+	if !owner {
+		return nil, SharedOrganisationUnit
+	}
+
+	entries, err := ldap.GetEntries(org.LDAPOU)
+	if err != nil {
+		return nil, err
+	}
+
+	report := Report{
+		Organization: org.Id,
+		Changes:      make([]Change, 0),
+		Errors:       make([]string, 0),
+	}
+	synced := make(map[string]bool, len(entries))
+
+	for _, entry := range entries {
+		nanocloud, err := createdByNanocloud(entry)
+		if err != nil {
+			return nil, err
+		}
+		if nanocloud {
+			continue
+		}
+
+		report.Entries++
+		synced[strings.ToLower(entry.DN)] = true
+
+		err = syncEntry(org, entry, &report)
+		if err != nil {
+			log.Error("Unable to sync directory user: ", err)
+			report.Errors = append(report.Errors, err.Error())
+		}
+	}
+
+	dirUsers, err := users.FindDirectoryUsers(org.Id)
+	if err != nil {
+		return nil, err
+	}
+	for _, user := range dirUsers {
+		if !user.Activated || synced[strings.ToLower(user.DirectoryDN)] {
+			continue
+		}
+		err = users.DisableUser(user.Id)
+		if err != nil {
+			return nil, err
+		}
+		report.record(Disabled, user)
+	}
+	return &report, nil
+}
+
+// StartSync syncs the users of every organization every
+// LDAP_USER_SYNC_INTERVAL seconds. Users aren't synced when it is 0, the
+// default.
+func StartSync() error {
+	interval, err := strconv.Atoi(utils.Env("LDAP_USER_SYNC_INTERVAL", "0"))
+	if err != nil {
+		return err
+	}
+	if interval == 0 {
+		return nil
+	}
+
+	go func() {
+		for {
+			orgs, err := Organizations()
+			if err != nil {
+				log.Error("Unable to retrieve the organizations: ", err)
+			}
+
+			for _, org := range orgs {
+				report, err := Sync(org)
+				if err != nil {
+					log.Error("Unable to sync the users from the directory: ", err)
+					continue
+				}
+				log.WithFields(log.Fields{
+					"organization": org.Name,
+					"entries":      report.Entries,
+					"created":      report.Created,
+					"updated":      report.Updated,
+					"disabled":     report.Disabled,
+					"linked":       report.Linked,
+					"errors":       len(report.Errors),
+				}).Info("Users synced from the directory")
+			}
+			time.Sleep(time.Duration(interval) * time.Second)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,28 @@
+package directory
+
+import (
+	"testing"
+
+	"github.com/Nanocloud/community/nanocloud/models/users"
+)
+
+func TestReportRecord(t *testing.T) {
+	report := Report{}
+	user := &users.User{Id: "id", Email: "user@nanocloud.com", DirectoryDN: "cn=user"}
+
+	report.record(Created, user)
+	report.record(Linked, user)
+	report.record(Disabled, user)
+	report.record(Disabled, user)
+
+	if report.Created != 1 || report.Updated != 0 || report.Disabled != 2 || report.Linked != 1 {
+		t.Errorf("Unexpected counts: %+v", report)
+	}
+	if len(report.Changes) != 4 {
+		t.Fatalf("Expected 4 changes, got %d", len(report.Changes))
+	}
+	change := report.Changes[1]
+	if change.Action != Linked || change.UserId != "id" || change.Email != "user@nanocloud.com" || change.DN != "cn=user" {
+		t.Errorf("Unexpected change: %+v", change)
+	}
+}
@@ -1,5 +1,3 @@
-// +build !windows
-
 /*
  * Nanocloud Community, a comprehensive platform to turn any application
  * into a cloud solution.
@@ -22,18 +20,29 @@
  * along with this program.  If not, see <http://www.gnu.org/licenses/>.
  */
 
-package utils
+package groups
+
+// Group is a set of users. Groups synced from Active Directory have the DN of
+// the directory group they mirror and their membership is read-only.
+type Group struct {
+	Id          string `json:"-"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	DirectoryDN string `json:"directory-dn"`
+
+	OrganizationId string `json:"-"`
+}
 
-import (
-	"os/exec"
+func (g *Group) GetID() string {
+	return g.Id
+}
 
-	"github.com/labstack/gommon/log"
-)
+func (g *Group) SetID(id string) error {
+	g.Id = id
+	return nil
+}
 
-func ExecuteCommandAsAdmin(cmd, username, pwd, domain string) {
-	out, err := exec.Command(cmd).Output()
-	if err != nil {
-		log.Error(err)
-	}
-	log.Info(out)
+// Synced returns whether the group is synced from Active Directory.
+func (g *Group) Synced() bool {
+	return g.DirectoryDN != ""
 }
@@ -0,0 +1,274 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package groups
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	uuid "github.com/satori/go.uuid"
+)
+
+var (
+	GroupNotFound      = errors.New("group not found")
+	GroupDuplicated    = errors.New("a group with this name already exists")
+	MembershipNotFound = errors.New("the user is not a member of this group")
+)
+
+func scan(rows *sql.Rows) (*Group, error) {
+	g := Group{}
+	err := rows.Scan(&g.Id, &g.Name, &g.Description, &g.DirectoryDN, &g.OrganizationId)
+	if err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+func find(query string, args ...interface{}) ([]*Group, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := make([]*Group, 0)
+	for rows.Next() {
+		g, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, g)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// FindAll returns the groups of an organization.
+func FindAll(organizationId string) ([]*Group, error) {
+	return find(
+		`SELECT id, name, description, directory_dn, organization_id
+		FROM groups
+		WHERE organization_id = $1::varchar
+		ORDER BY name`,
+		organizationId,
+	)
+}
+
+// UserGroups returns the groups the user is a member of.
+func UserGroups(userId string) ([]*Group, error) {
+	return find(
+		`SELECT groups.id, groups.name, groups.description, groups.directory_dn,
+			groups.organization_id
+		FROM groups
+		JOIN users_groups
+			ON users_groups.group_id = groups.id
+		WHERE users_groups.user_id = $1::varchar
+		ORDER BY groups.name`,
+		userId,
+	)
+}
+
+// GetGroup returns nil if the group doesn't exist.
+func GetGroup(id string) (*Group, error) {
+	groups, err := find(
+		`SELECT id, name, description, directory_dn, organization_id
+		FROM groups
+		WHERE id = $1::varchar`,
+		id,
+	)
+	if err != nil || len(groups) == 0 {
+		return nil, err
+	}
+	return groups[0], nil
+}
+
+func GroupExists(id string) (bool, error) {
+	rows, err := db.Query(
+		`SELECT id
+		FROM groups
+		WHERE id = $1::varchar`,
+		id)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		return true, nil
+	}
+	return false, nil
+}
+
+// nameTaken returns whether another group of the organization has this name.
+func nameTaken(organizationId string, name string, id string) (bool, error) {
+	rows, err := db.Query(
+		`SELECT id
+		FROM groups
+		WHERE name = $1::varchar AND id != $2::varchar
+		AND organization_id = $3::varchar`,
+		name, id, organizationId,
+	)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	return rows.Next(), nil
+}
+
+func CreateGroup(organizationId string, name string, description string) (*Group, error) {
+	taken, err := nameTaken(organizationId, name, "")
+	if err != nil {
+		return nil, err
+	}
+	if taken {
+		return nil, GroupDuplicated
+	}
+
+	id := uuid.NewV4().String()
+	_, err = db.Exec(
+		`INSERT INTO groups
+		(id, name, description, organization_id)
+		VALUES ($1::varchar, $2::varchar, $3::text, $4::varchar)`,
+		id, name, description, organizationId,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return GetGroup(id)
+}
+
+func (g *Group) Update() error {
+	taken, err := nameTaken(g.OrganizationId, g.Name, g.Id)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return GroupDuplicated
+	}
+
+	res, err := db.Exec(
+		`UPDATE groups
+		SET name = $2::varchar, description = $3::text
+		WHERE id = $1::varchar`,
+		g.Id, g.Name, g.Description,
+	)
+	if err != nil {
+		return err
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return GroupNotFound
+	}
+	return nil
+}
+
+// Delete removes the group, its memberships and the apps assigned to it.
+func (g *Group) Delete() error {
+	res, err := db.Exec(
+		`DELETE FROM groups
+		WHERE id = $1::varchar`,
+		g.Id,
+	)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return GroupNotFound
+	}
+	return nil
+}
+
+// Members returns the ids of the members of the group.
+func (g *Group) Members() ([]string, error) {
+	rows, err := db.Query(
+		`SELECT user_id
+		FROM users_groups
+		WHERE group_id = $1::varchar`,
+		g.Id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// AddMember adds a user to the group. Adding a member twice is a no-op.
+func (g *Group) AddMember(userId string) error {
+	_, err := db.Exec(
+		`INSERT INTO users_groups (user_id, group_id)
+		SELECT $1::varchar, $2::varchar
+		WHERE NOT EXISTS (
+			SELECT 1 FROM users_groups
+			WHERE user_id = $1::varchar AND group_id = $2::varchar
+		)`,
+		userId, g.Id,
+	)
+	return err
+}
+
+func (g *Group) RemoveMember(userId string) error {
+	res, err := db.Exec(
+		`DELETE FROM users_groups
+		WHERE user_id = $1::varchar AND group_id = $2::varchar`,
+		userId, g.Id,
+	)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return MembershipNotFound
+	}
+	return nil
+}
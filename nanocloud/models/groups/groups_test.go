@@ -0,0 +1,238 @@
+package groups
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/models/ldap"
+	"github.com/Nanocloud/community/nanocloud/models/organizations"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+)
+
+var created *Group
+
+func createUser(t *testing.T, email string, sam string) *users.User {
+	user, err := users.CreateUser(true, email, "Test", "user", "secret", false, organizations.Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = users.UpdateUserAd(user.Id, sam, "Secret123+", "intra.localdomain.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestCreateGroup(t *testing.T) {
+	g, err := CreateGroup(organizations.Default, "accounting", "The accounting department")
+	if err != nil {
+		t.Fatal(err)
+	}
+	switch {
+	case g.Id == "":
+		t.Errorf("'Id' field should be generated")
+	case g.Name != "accounting":
+		t.Errorf("'Name' field doesn't match the inserted value")
+	case g.Description != "The accounting department":
+		t.Errorf("'Description' field doesn't match the inserted value")
+	case g.Synced():
+		t.Errorf("Created groups should not be synced")
+	}
+	created = g
+
+	_, err = CreateGroup(organizations.Default, "accounting", "")
+	if err != GroupDuplicated {
+		t.Errorf("Group names should be unique, got %v", err)
+	}
+}
+
+func TestUpdateGroup(t *testing.T) {
+	created.Name = "finance"
+	err := created.Update()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := GetGroup(created.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g == nil || g.Name != "finance" {
+		t.Errorf("The group should be renamed, got %+v", g)
+	}
+}
+
+func TestMembers(t *testing.T) {
+	user := createUser(t, "member@nanocloud.com", "member")
+	defer users.DeleteUser(user.Id)
+
+	err := created.AddMember(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = created.AddMember(user.Id)
+	if err != nil {
+		t.Errorf("Adding a member twice should not fail: %s", err)
+	}
+
+	members, err := created.Members()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 1 || members[0] != user.Id {
+		t.Errorf("The user should be a member once, got %v", members)
+	}
+
+	userGroups, err := UserGroups(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(userGroups) != 1 || userGroups[0].Id != created.Id {
+		t.Errorf("The user should be in the group, got %v", userGroups)
+	}
+
+	err = created.RemoveMember(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = created.RemoveMember(user.Id)
+	if err != MembershipNotFound {
+		t.Errorf("Removing a removed member should fail with MembershipNotFound, got %v", err)
+	}
+}
+
+func TestSyncGroups(t *testing.T) {
+	user := createUser(t, "synced@nanocloud.com", "synced")
+	defer users.DeleteUser(user.Id)
+
+	local := createUser(t, "local@nanocloud.com", "local")
+	defer users.DeleteUser(local.Id)
+
+	err := created.AddMember(local.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dirGroups := []ldap.Group{
+		{
+			DN:      "CN=finance,OU=NanocloudUsers,DC=intra,DC=localdomain,DC=com",
+			Name:    "finance",
+			Members: []string{"SYNCED", "unknown"},
+		},
+		{
+			DN:      "CN=sales,OU=NanocloudUsers,DC=intra,DC=localdomain,DC=com",
+			Name:    "sales",
+			Members: []string{},
+		},
+		{
+			DN:      "CN=sales,OU=Paris,OU=NanocloudUsers,DC=intra,DC=localdomain,DC=com",
+			Name:    "sales",
+			Members: []string{},
+		},
+	}
+
+	sync := func() *SyncResult {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := syncGroups(tx, organizations.Default, dirGroups)
+		if err != nil {
+			tx.Rollback()
+			t.Fatal(err)
+		}
+		err = tx.Commit()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	synced := func() map[string]*Group {
+		groups, err := FindAll(organizations.Default)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rt := make(map[string]*Group)
+		for _, g := range groups {
+			if g.Synced() {
+				rt[g.DirectoryDN] = g
+			}
+		}
+		return rt
+	}
+
+	result := sync()
+	if result.Created != 3 || result.Updated != 0 || result.Deleted != 0 || result.Skipped != 0 {
+		t.Errorf("Unexpected sync result: %+v", result)
+	}
+
+	g, err := GetGroup(created.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Synced() {
+		t.Errorf("The local group with the same name should not be adopted, got %+v", g)
+	}
+
+	members, err := g.Members()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 1 || members[0] != local.Id {
+		t.Errorf("The members of the local group should be kept, got %v", members)
+	}
+
+	groups := synced()
+	finance := groups[dirGroups[0].DN]
+	if finance == nil || finance.Name != "finance ("+dirGroups[0].DN+")" {
+		t.Errorf("The synced group should be named after its DN, got %+v", finance)
+	} else {
+		members, err = finance.Members()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(members) != 1 || members[0] != user.Id {
+			t.Errorf("Members should be matched by their Windows account, got %v", members)
+		}
+	}
+
+	sales := groups[dirGroups[1].DN]
+	paris := groups[dirGroups[2].DN]
+	if sales == nil || paris == nil || sales.Name != "sales" || paris.Name != "sales ("+dirGroups[2].DN+")" {
+		t.Errorf("Groups with the same name should be told apart by their DN, got %+v and %+v", sales, paris)
+	}
+
+	dirGroups[1].DN = strings.ToUpper(dirGroups[1].DN)
+	result = sync()
+	if result.Created != 0 || result.Updated != 3 || result.Deleted != 0 {
+		t.Errorf("DNs should be matched case-insensitively, got %+v", result)
+	}
+
+	dirGroups = dirGroups[:1]
+	result = sync()
+	if result.Created != 0 || result.Updated != 1 || result.Deleted != 2 {
+		t.Errorf("Groups removed from the directory should be deleted, got %+v", result)
+	}
+}
+
+func TestDeleteGroup(t *testing.T) {
+	err := created.Delete()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = created.Delete()
+	if err != GroupNotFound {
+		t.Errorf("Deleting a deleted group should fail with GroupNotFound, got %v", err)
+	}
+
+	exists, err := GroupExists(created.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Errorf("The group should be deleted")
+	}
+}
@@ -0,0 +1,349 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package groups
+
+import (
+	"database/sql"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/models/ldap"
+	"github.com/Nanocloud/community/nanocloud/models/organizations"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+	uuid "github.com/satori/go.uuid"
+)
+
+// SyncResult counts the groups changed by a sync.
+type SyncResult struct {
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+	Deleted int `json:"deleted"`
+	Skipped int `json:"skipped"`
+}
+
+// kNameMaxLength is the length of the name column of the groups table.
+const kNameMaxLength = 255
+
+// kSyncMutex prevents the periodic sync and a sync requested by an
+// administrator from running concurrently.
+var kSyncMutex sync.Mutex
+
+// nameTakenTx is nameTaken within a transaction.
+func nameTakenTx(tx *sql.Tx, organizationId string, name string, id string) (bool, error) {
+	rows, err := tx.Query(
+		`SELECT id FROM groups
+		WHERE name = $1::varchar AND id != $2::varchar
+		AND organization_id = $3::varchar`,
+		name, id, organizationId,
+	)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	return rows.Next(), nil
+}
+
+// syncedName returns the name of the group id mirroring dg. It is the name of
+// dg, qualified with its DN when another group of the organization already
+// has it. It is empty when both are taken.
+func syncedName(tx *sql.Tx, organizationId string, id string, dg *ldap.Group) (string, error) {
+	names := []string{dg.Name}
+	if qualified := dg.Name + " (" + dg.DN + ")"; utf8.RuneCountInString(qualified) <= kNameMaxLength {
+		names = append(names, qualified)
+	}
+
+	for _, name := range names {
+		taken, err := nameTakenTx(tx, organizationId, name, id)
+		if err != nil || !taken {
+			return name, err
+		}
+	}
+	return "", nil
+}
+
+// syncedGroupId returns the id of the group of the organization mirroring dg,
+// matched on its DN, which is case-insensitive. Local groups are never adopted: a group named like one
+// of them is created with its DN in its name. The id is empty when the group
+// couldn't be named.
+func syncedGroupId(tx *sql.Tx, organizationId string, dg *ldap.Group) (string, bool, error) {
+	var id string
+
+	err := tx.QueryRow(
+		`SELECT id FROM groups
+		WHERE lower(directory_dn) = lower($1::varchar) AND organization_id = $2::varchar`,
+		dg.DN, organizationId,
+	).Scan(&id)
+	if err != nil && err != sql.ErrNoRows {
+		return "", false, err
+	}
+	created := err == sql.ErrNoRows
+
+	name, err := syncedName(tx, organizationId, id, dg)
+	if err != nil {
+		return "", false, err
+	}
+
+	if !created {
+		if name == "" {
+			log.WithField("dn", dg.DN).Warn("Unable to rename the synced group, its name is taken")
+			_, err = tx.Exec(
+				`UPDATE groups SET directory_dn = $2::varchar WHERE id = $1::varchar`,
+				id, dg.DN,
+			)
+			return id, false, err
+		}
+		_, err = tx.Exec(
+			`UPDATE groups SET name = $2::varchar, directory_dn = $3::varchar
+			WHERE id = $1::varchar`,
+			id, name, dg.DN,
+		)
+		return id, false, err
+	}
+
+	if name == "" {
+		log.WithField("dn", dg.DN).Warn("Unable to sync the group, its name is taken")
+		return "", false, nil
+	}
+
+	id = uuid.NewV4().String()
+	_, err = tx.Exec(
+		`INSERT INTO groups
+		(id, name, directory_dn, organization_id)
+		VALUES ($1::varchar, $2::varchar, $3::varchar, $4::varchar)`,
+		id, name, dg.DN, organizationId,
+	)
+	return id, true, err
+}
+
+// syncMembers replaces the members of a group with the users of the
+// organization whose Windows account is one of sams.
+func syncMembers(tx *sql.Tx, organizationId string, id string, sams []string) error {
+	_, err := tx.Exec(
+		`DELETE FROM users_groups WHERE group_id = $1::varchar`,
+		id,
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, sam := range sams {
+		_, err = tx.Exec(
+			`INSERT INTO users_groups (user_id, group_id)
+			SELECT DISTINCT users_windows_user.user_id, $1::varchar
+			FROM users_windows_user
+			JOIN windows_users
+				ON windows_users.id = users_windows_user.windows_user_id
+			JOIN users
+				ON users.id = users_windows_user.user_id
+			WHERE lower(windows_users.sam) = lower($2::varchar)
+			AND users.organization_id = $3::varchar
+			AND NOT EXISTS (
+				SELECT 1 FROM users_groups
+				WHERE user_id = users_windows_user.user_id
+				AND group_id = $1::varchar
+			)`,
+			id, sam, organizationId,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func syncGroups(tx *sql.Tx, organizationId string, dirGroups []ldap.Group) (*SyncResult, error) {
+	result := SyncResult{}
+	synced := make(map[string]bool, len(dirGroups))
+
+	for i := range dirGroups {
+		id, created, err := syncedGroupId(tx, organizationId, &dirGroups[i])
+		if err != nil {
+			return nil, err
+		}
+		if id == "" {
+			result.Skipped++
+			continue
+		}
+		if created {
+			result.Created++
+		} else {
+			result.Updated++
+		}
+		synced[id] = true
+
+		err = syncMembers(tx, organizationId, id, dirGroups[i].Members)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := tx.Query(
+		`SELECT id FROM groups
+		WHERE directory_dn != '' AND organization_id = $1::varchar`,
+		organizationId,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	for rows.Next() {
+		var id string
+		err = rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if !synced[id] {
+			removed = append(removed, id)
+		}
+	}
+	rows.Close()
+
+	for _, id := range removed {
+		_, err = tx.Exec(`DELETE FROM groups WHERE id = $1::varchar`, id)
+		if err != nil {
+			return nil, err
+		}
+		result.Deleted++
+	}
+	return &result, nil
+}
+
+// Sync mirrors the groups of the Active Directory organisation unit of the
+// organization and their members. Groups removed from the directory are
+// deleted, local groups are left untouched.
+func Sync(org *organizations.Organization) (*SyncResult, error) {
+	kSyncMutex.Lock()
+	defer kSyncMutex.Unlock()
+
+	dirGroups, err := ldap.GetGroups(org.LDAPOU)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := syncGroups(tx, org.Id, dirGroups)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// StartSync syncs the groups of every organization every
+// LDAP_GROUP_SYNC_INTERVAL seconds. Groups aren't synced when it is 0, the
+// default.
+func StartSync() error {
+	interval, err := strconv.Atoi(utils.Env("LDAP_GROUP_SYNC_INTERVAL", "0"))
+	if err != nil {
+		return err
+	}
+	if interval == 0 {
+		return nil
+	}
+
+	go func() {
+		for {
+			orgs, err := organizations.FindAll()
+			if err != nil {
+				log.Error("Unable to retrieve the organizations: ", err)
+			}
+
+			for _, org := range orgs {
+				result, err := Sync(org)
+				if err != nil {
+					log.Error("Unable to sync the groups from Active Directory: ", err)
+					continue
+				}
+				log.WithFields(log.Fields{
+					"organization": org.Name,
+					"created":      result.Created,
+					"updated":      result.Updated,
+					"deleted":      result.Deleted,
+					"skipped":      result.Skipped,
+				}).Info("Groups synced from Active Directory")
+			}
+			time.Sleep(time.Duration(interval) * time.Second)
+		}
+	}()
+	return nil
+}
+
+// SetDirectoryGroups makes a user a member of the synced groups of their
+// organization mirroring the directory groups dns, and only of those. Their
+// membership of local groups is left untouched.
+func SetDirectoryGroups(organizationId string, userId string, dns []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		`DELETE FROM users_groups
+		WHERE user_id = $1::varchar
+		AND group_id IN (
+			SELECT id FROM groups
+			WHERE directory_dn != '' AND organization_id = $2::varchar
+		)`,
+		userId, organizationId,
+	)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, dn := range dns {
+		_, err = tx.Exec(
+			`INSERT INTO users_groups (user_id, group_id)
+			SELECT $1::varchar, id
+			FROM groups
+			WHERE lower(directory_dn) = lower($2::varchar)
+			AND organization_id = $3::varchar
+			AND NOT EXISTS (
+				SELECT 1 FROM users_groups
+				WHERE user_id = $1::varchar AND group_id = groups.id
+			)`,
+			userId, dn, organizationId,
+		)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -30,13 +30,17 @@ func escapeId(id string) string {
 	return "'" + id + "'"
 }
 
-func FindAll() ([]*History, error) {
+// FindAll returns the histories of an organization.
+func FindAll(organizationId string) ([]*History, error) {
 
 	result := make([]*History, 0)
 
 	res, err := db.Query(
 		`SELECT id, userid, usermail, userfirstname,
-		userlastname, connectionid, startdate, enddate FROM histories`,
+		userlastname, connectionid, startdate, enddate, organization_id
+		FROM histories
+		WHERE organization_id = $1::varchar`,
+		organizationId,
 	)
 	if err != nil {
 		return nil, err
@@ -56,6 +60,7 @@ func FindAll() ([]*History, error) {
 			&h.ConnectionId,
 			&h.StartDate,
 			&h.EndDate,
+			&h.OrganizationId,
 		)
 
 		result = append(result, &h)
@@ -65,6 +70,7 @@ func FindAll() ([]*History, error) {
 }
 
 func CreateHistory(
+	organizationId string,
 	userId string,
 	userMail string,
 	userFirstname string,
@@ -77,9 +83,9 @@ func CreateHistory(
 
 	rows, err := db.Query(
 		`INSERT INTO histories
-		(id, userid, usermail, userfirstname, userlastname, connectionid, startdate, enddate)
-		VALUES(	$1::varchar, $2::varchar, $3::varchar, $4::varchar, $5::varchar, $6::varchar, $7::varchar, $8::varchar)`,
-		id, userId, userMail, userFirstname, userLastname, connectionId, startDate, endDate)
+		(id, userid, usermail, userfirstname, userlastname, connectionid, startdate, enddate, organization_id)
+		VALUES(	$1::varchar, $2::varchar, $3::varchar, $4::varchar, $5::varchar, $6::varchar, $7::varchar, $8::varchar, $9::varchar)`,
+		id, userId, userMail, userFirstname, userLastname, connectionId, startDate, endDate, organizationId)
 
 	if err != nil {
 		return nil, err
@@ -88,7 +94,7 @@ func CreateHistory(
 	rows.Close()
 
 	rows, err = db.Query(
-		`SELECT id, userid, usermail, userfirstname, userlastname, connectionid, startdate, enddate
+		`SELECT id, userid, usermail, userfirstname, userlastname, connectionid, startdate, enddate, organization_id
 		FROM histories WHERE id = $1::varchar`, id)
 
 	if err != nil {
@@ -109,6 +115,7 @@ func CreateHistory(
 		&history.ConnectionId,
 		&history.StartDate,
 		&history.EndDate,
+		&history.OrganizationId,
 	)
 
 	rows.Close()
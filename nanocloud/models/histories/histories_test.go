@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/Nanocloud/community/nanocloud/models/organizations"
 	"github.com/Nanocloud/community/nanocloud/models/users"
 )
 
@@ -25,6 +26,7 @@ func init() {
 		"user",
 		"secret",
 		false,
+		organizations.Default,
 	)
 
 	if err != nil {
@@ -37,7 +39,7 @@ func init() {
 }
 
 func countEntries() {
-	histories, err := FindAll()
+	histories, err := FindAll(organizations.Default)
 	if err != nil {
 		log.Panicln("Can't retreive histories:", err.Error())
 	}
@@ -49,7 +51,7 @@ func countEntries() {
 func TestCreateHistory(t *testing.T) {
 	startDate = append(startDate, time.Now().Format(time.RFC3339))
 	endDate = append(endDate, time.Now().Format(time.RFC3339))
-	history, err := CreateHistory(user.GetID(), user.Email, user.FirstName, user.LastName, connectionId, startDate[history_num], endDate[history_num])
+	history, err := CreateHistory(organizations.Default, user.GetID(), user.Email, user.FirstName, user.LastName, connectionId, startDate[history_num], endDate[history_num])
 	if err != nil {
 		t.Errorf("Cannot create history: %s", err.Error())
 	}
@@ -80,7 +82,7 @@ func TestFindAll(t *testing.T) {
 
 	startDate = append(startDate, time.Now().Format(time.RFC3339))
 	endDate = append(endDate, time.Now().Format(time.RFC3339))
-	_, err := CreateHistory(user.GetID(), user.Email, user.FirstName, user.LastName, connectionId, startDate[history_num], endDate[history_num])
+	_, err := CreateHistory(organizations.Default, user.GetID(), user.Email, user.FirstName, user.LastName, connectionId, startDate[history_num], endDate[history_num])
 	if err != nil {
 		log.Panicln("Can't add historic:", err.Error())
 	}
@@ -9,6 +9,8 @@ type History struct {
 	ConnectionId  string `json:"connection-id"`
 	StartDate     string `json:"start-date"`
 	EndDate       string `json:"end-date"`
+
+	OrganizationId string `json:"-"`
 }
 
 func (h *History) GetID() string {
@@ -0,0 +1,57 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package jobs
+
+import "time"
+
+const (
+	StatePending   = "pending"
+	StateRunning   = "running"
+	StateSucceeded = "succeeded"
+	StateFailed    = "failed"
+)
+
+type Job struct {
+	Id        string    `json:"-"`
+	Action    string    `json:"action"`
+	Target    string    `json:"target"`
+	State     string    `json:"state"`
+	Progress  int       `json:"progress"`
+	Error     string    `json:"error"`
+	UserId    string    `json:"user-id"`
+	CreatedAt time.Time `json:"created-at"`
+	UpdatedAt time.Time `json:"updated-at"`
+}
+
+func (j *Job) GetID() string {
+	return j.Id
+}
+
+func (j *Job) SetID(id string) error {
+	j.Id = id
+	return nil
+}
+
+func (j *Job) Done() bool {
+	return j.State == StateSucceeded || j.State == StateFailed
+}
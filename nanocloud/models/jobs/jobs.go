@@ -0,0 +1,348 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package jobs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/provisioner"
+	log "github.com/Sirupsen/logrus"
+	uuid "github.com/satori/go.uuid"
+)
+
+var JobNotFound = errors.New("job not found")
+
+// JobFunc does the work of a job. Everything written to output is stored with
+// the job and broadcast to the job's provisioner outputs. Returning an error
+// marks the job as failed.
+type JobFunc func(job *Job, output io.Writer) error
+
+var (
+	running = make(map[string]*provisioner.Provisioner)
+	mutex   sync.Mutex
+)
+
+func CreateJob(action, target, userId string) (*Job, error) {
+	id := uuid.NewV4().String()
+
+	_, err := db.Exec(
+		`INSERT INTO jobs
+		(id, action, target, state, user_id)
+		VALUES ($1::varchar, $2::varchar, $3::varchar, $4::varchar, $5::varchar)`,
+		id, action, target, StatePending, userId,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return GetJob(id)
+}
+
+// GetJob returns nil if the job doesn't exist.
+func GetJob(id string) (*Job, error) {
+	rows, err := db.Query(
+		`SELECT id, action, target,
+		state, progress, error,
+		user_id, created_at, updated_at
+		FROM jobs
+		WHERE id = $1::varchar`,
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+
+	job := Job{}
+	err = rows.Scan(
+		&job.Id, &job.Action, &job.Target,
+		&job.State, &job.Progress, &job.Error,
+		&job.UserId, &job.CreatedAt, &job.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// FindLatest returns the last job of the specified action on target, or nil.
+func FindLatest(action, target string) (*Job, error) {
+	rows, err := db.Query(
+		`SELECT id
+		FROM jobs
+		WHERE action = $1::varchar AND target = $2::varchar
+		ORDER BY created_at DESC
+		LIMIT 1`,
+		action, target,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+
+	var id string
+	err = rows.Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+	return GetJob(id)
+}
+
+// FindUnfinished returns the jobs of the specified action that are pending or
+// running.
+func FindUnfinished(action string) ([]*Job, error) {
+	rows, err := db.Query(
+		`SELECT id
+		FROM jobs
+		WHERE action = $1::varchar
+		AND state IN ($2::varchar, $3::varchar)
+		ORDER BY created_at`,
+		action, StatePending, StateRunning,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		err = rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	rt := make([]*Job, 0, len(ids))
+	for _, id := range ids {
+		job, err := GetJob(id)
+		if err != nil {
+			return nil, err
+		}
+		if job != nil {
+			rt = append(rt, job)
+		}
+	}
+	return rt, nil
+}
+
+func (j *Job) update(column string, value interface{}) error {
+	res, err := db.Exec(
+		fmt.Sprintf(
+			`UPDATE jobs
+			SET %s = $2, updated_at = current_timestamp
+			WHERE id = $1::varchar`,
+			column,
+		),
+		j.Id, value,
+	)
+	if err != nil {
+		return err
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return JobNotFound
+	}
+	return nil
+}
+
+func (j *Job) SetTarget(target string) error {
+	j.Target = target
+	return j.update("target", target)
+}
+
+func (j *Job) SetProgress(progress int) error {
+	j.Progress = progress
+	return j.update("progress", progress)
+}
+
+func (j *Job) setState(state string) error {
+	j.State = state
+	return j.update("state", state)
+}
+
+func (j *Job) fail(e error) error {
+	j.Error = e.Error()
+	err := j.update("error", j.Error)
+	if err != nil {
+		return err
+	}
+	return j.setState(StateFailed)
+}
+
+func (j *Job) succeed() error {
+	err := j.SetProgress(100)
+	if err != nil {
+		return err
+	}
+	return j.setState(StateSucceeded)
+}
+
+// jobOutput appends what a job writes to its output column, so that it can be
+// replayed once the job is done. Failing to store it doesn't fail the job.
+type jobOutput struct {
+	job *Job
+}
+
+func (o *jobOutput) Write(p []byte) (int, error) {
+	_, err := db.Exec(
+		`UPDATE jobs SET output = output || $2::text WHERE id = $1::varchar`,
+		o.job.Id, string(p),
+	)
+	if err != nil {
+		log.WithField("job", o.job.Id).Error("Unable to store the job output: ", err)
+	}
+	return len(p), nil
+}
+
+// Output returns what the job wrote, including what it is still writing.
+func (j *Job) Output() (string, error) {
+	rows, err := db.Query(
+		`SELECT output FROM jobs WHERE id = $1::varchar`,
+		j.Id,
+	)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		err = rows.Err()
+		if err == nil {
+			err = JobNotFound
+		}
+		return "", err
+	}
+
+	var output string
+	err = rows.Scan(&output)
+	return output, err
+}
+
+// Enqueue persists a new job and runs fn in the background through a
+// provisioner. The returned job is a snapshot of its pending state.
+func Enqueue(action, target, userId string, fn JobFunc) (*Job, error) {
+	job, err := CreateJob(action, target, userId)
+	if err != nil {
+		return nil, err
+	}
+	rt := *job
+
+	p := provisioner.New(func(output io.Writer) {
+		logger := log.WithFields(log.Fields{
+			"job":    job.Id,
+			"action": job.Action,
+		})
+
+		output = io.MultiWriter(&jobOutput{job}, output)
+
+		err := job.setState(StateRunning)
+		if err != nil {
+			logger.Error(err)
+		}
+
+		err = fn(job, output)
+		if err != nil {
+			logger.Error(err)
+			fmt.Fprintln(output, err)
+			err = job.fail(err)
+		} else {
+			err = job.succeed()
+		}
+		if err != nil {
+			logger.Error(err)
+		}
+
+		mutex.Lock()
+		delete(running, job.Id)
+		mutex.Unlock()
+	})
+
+	mutex.Lock()
+	running[job.Id] = p
+	mutex.Unlock()
+
+	p.Run()
+	return &rt, nil
+}
+
+// AddOutput adds w to the outputs of a running job. w first receives what the
+// job already wrote. It returns nil if the job isn't run by this process.
+func AddOutput(id string, w io.Writer) *provisioner.Provisioner {
+	mutex.Lock()
+	p, ok := running[id]
+	mutex.Unlock()
+
+	if !ok {
+		return nil
+	}
+	p.AddOutput(w)
+	return p
+}
+
+// Wait blocks until the job, if it's run by this process, is done.
+func Wait(id string) {
+	mutex.Lock()
+	p, ok := running[id]
+	mutex.Unlock()
+
+	if ok {
+		p.Wait()
+	}
+}
+
+// FailInterrupted marks as failed the jobs left unfinished by a previous
+// process. Their functions can't be resumed.
+func FailInterrupted() error {
+	_, err := db.Exec(
+		`UPDATE jobs
+		SET state = $1::varchar, error = 'interrupted',
+		updated_at = current_timestamp
+		WHERE state IN ($2::varchar, $3::varchar)`,
+		StateFailed, StatePending, StateRunning,
+	)
+	return err
+}
@@ -0,0 +1,162 @@
+package jobs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func getJob(t *testing.T, id string) *Job {
+	job, err := GetJob(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job == nil {
+		t.Fatalf("Job %s not found", id)
+	}
+	return job
+}
+
+func TestCreateJob(t *testing.T) {
+	job, err := CreateJob("test-action", "test-target", "test-user")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	switch {
+	case job.Id == "":
+		t.Errorf("'Id' field should be generated")
+	case job.Action != "test-action":
+		t.Errorf("'Action' field doesn't match the inserted value")
+	case job.Target != "test-target":
+		t.Errorf("'Target' field doesn't match the inserted value")
+	case job.UserId != "test-user":
+		t.Errorf("'UserId' field doesn't match the inserted value")
+	case job.State != StatePending:
+		t.Errorf("New jobs should be pending")
+	}
+
+	missing, err := GetJob("missing-job")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if missing != nil {
+		t.Errorf("GetJob should return nil for missing jobs")
+	}
+}
+
+func TestEnqueueSucceeded(t *testing.T) {
+	job, err := Enqueue("test-action", "", "test-user", func(job *Job, output io.Writer) error {
+		err := job.SetTarget("created-target")
+		if err != nil {
+			return err
+		}
+		return job.SetProgress(50)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.State != StatePending {
+		t.Errorf("Enqueue should return the pending job")
+	}
+
+	Wait(job.Id)
+
+	job = getJob(t, job.Id)
+	switch {
+	case job.State != StateSucceeded:
+		t.Errorf("Job should have succeeded, it is: %s", job.State)
+	case job.Progress != 100:
+		t.Errorf("Succeeded jobs should be at 100%%, got %d", job.Progress)
+	case job.Target != "created-target":
+		t.Errorf("Job target not updated")
+	case !job.Done():
+		t.Errorf("Job should be done")
+	}
+}
+
+func TestEnqueueFailed(t *testing.T) {
+	job, err := Enqueue("test-action", "", "test-user", func(job *Job, w io.Writer) error {
+		return errors.New("fake-generated-error")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Wait(job.Id)
+
+	job = getJob(t, job.Id)
+	if job.State != StateFailed {
+		t.Errorf("Job should have failed, it is: %s", job.State)
+	}
+	if job.Error != "fake-generated-error" {
+		t.Errorf("Job error doesn't match the returned error: %s", job.Error)
+	}
+}
+
+func TestFailInterrupted(t *testing.T) {
+	job, err := CreateJob("test-action", "", "test-user")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = FailInterrupted()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job = getJob(t, job.Id)
+	if job.State != StateFailed || job.Error != "interrupted" {
+		t.Errorf("Unfinished jobs should be marked as interrupted")
+	}
+}
+
+func TestAddOutput(t *testing.T) {
+	started := make(chan bool)
+	resume := make(chan bool)
+
+	job, err := Enqueue("test-provision", "test-machine", "test-user", func(job *Job, w io.Writer) error {
+		w.Write([]byte("step 1\n"))
+		started <- true
+		<-resume
+		w.Write([]byte("step 2\n"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	<-started
+	var output bytes.Buffer
+	p := AddOutput(job.Id, &output)
+	if p == nil {
+		t.Fatalf("AddOutput should return the provisioner of a running job")
+	}
+	resume <- true
+	p.Wait()
+
+	if output.String() != "step 1\nstep 2\n" {
+		t.Errorf("Late outputs should receive the whole output, got: %q", output.String())
+	}
+
+	if AddOutput(job.Id, &output) != nil {
+		t.Errorf("AddOutput should return nil once the job is done")
+	}
+
+	stored, err := job.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored != "step 1\nstep 2\n" {
+		t.Errorf("The output should be stored to be replayed once the job is done, got: %q", stored)
+	}
+
+	latest, err := FindLatest("test-provision", "test-machine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest == nil || latest.Id != job.Id {
+		t.Errorf("FindLatest should return the last job")
+	}
+}
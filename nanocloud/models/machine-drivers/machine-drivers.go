@@ -2,7 +2,6 @@ package machinedrivers
 
 import (
 	"github.com/Nanocloud/community/nanocloud/connectors/vms"
-	"github.com/Nanocloud/community/nanocloud/utils"
 	"github.com/labstack/gommon/log"
 	"github.com/manyminds/api2go/jsonapi"
 )
@@ -30,7 +29,7 @@ func (d *MachineDriver) GetReferences() []jsonapi.Reference {
 }
 
 func (d *MachineDriver) GetReferencedIDs() []jsonapi.ReferenceID {
-	types, err := vms.Types()
+	types, err := vms.Types(d.ID)
 	if err != nil {
 		log.Error(err)
 		return nil
@@ -51,7 +50,7 @@ func (d *MachineDriver) GetReferencedIDs() []jsonapi.ReferenceID {
 }
 
 func (d *MachineDriver) GetReferencedStructs() []jsonapi.MarshalIdentifier {
-	types, err := vms.Types()
+	types, err := vms.Types(d.ID)
 	if err != nil {
 		log.Error(err)
 		return nil
@@ -67,9 +66,13 @@ func (d *MachineDriver) GetReferencedStructs() []jsonapi.MarshalIdentifier {
 }
 
 func FindAll() ([]*MachineDriver, error) {
-	drivers := make([]*MachineDriver, 1)
-	drivers[0] = &MachineDriver{
-		ID: utils.Env("IAAS", ""),
+	names := vms.Drivers()
+
+	drivers := make([]*MachineDriver, len(names))
+	for i, name := range names {
+		drivers[i] = &MachineDriver{
+			ID: name,
+		}
 	}
 	return drivers, nil
 }
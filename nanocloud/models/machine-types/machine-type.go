@@ -0,0 +1,54 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package machinetypes
+
+// MachineType describes the hardware of the machines created by a driver.
+// ID is only unique for a given driver.
+type MachineType struct {
+	ID      string            `json:"-"`
+	Driver  string            `json:"driver"`
+	Name    string            `json:"name"`
+	CPU     int               `json:"cpu"`
+	RAM     int               `json:"ram"`  // MB
+	Disk    int               `json:"disk"` // GB
+	Image   string            `json:"image"`
+	Options map[string]string `json:"options"`
+}
+
+func (t *MachineType) GetID() string {
+	return t.ID
+}
+
+func (t *MachineType) SetID(id string) error {
+	t.ID = id
+	return nil
+}
+
+// Option returns the value of a driver specific option or def if it isn't set.
+func (t *MachineType) Option(key, def string) string {
+	v, ok := t.Options[key]
+	if !ok || v == "" {
+		return def
+	}
+	return v
+}
@@ -0,0 +1,231 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package machinetypes
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/vms"
+	uuid "github.com/satori/go.uuid"
+)
+
+// DefaultID is the id of the type used when a machine is created without
+// specifying one.
+const DefaultID = "default"
+
+var (
+	MachineTypeNotFound   = errors.New("machine type not found")
+	MachineTypeDuplicated = errors.New("machine type duplicated")
+)
+
+func scan(rows *sql.Rows) (*MachineType, error) {
+	t := MachineType{}
+	var options string
+
+	err := rows.Scan(
+		&t.ID, &t.Driver, &t.Name,
+		&t.CPU, &t.RAM, &t.Disk,
+		&t.Image, &options,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal([]byte(options), &t.Options)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func FindByDriver(driver string) ([]*MachineType, error) {
+	rows, err := db.Query(
+		`SELECT id, driver, name,
+		cpu, ram, disk,
+		image, options
+		FROM machine_types
+		WHERE driver = $1::varchar
+		ORDER BY name`,
+		driver,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	types := make([]*MachineType, 0)
+	for rows.Next() {
+		t, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, t)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return types, nil
+}
+
+// GetMachineType returns nil if the driver has no type with the specified id.
+func GetMachineType(driver, id string) (*MachineType, error) {
+	rows, err := db.Query(
+		`SELECT id, driver, name,
+		cpu, ram, disk,
+		image, options
+		FROM machine_types
+		WHERE driver = $1::varchar AND id = $2::varchar`,
+		driver, id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	return scan(rows)
+}
+
+func CreateMachineType(t *MachineType) (*MachineType, error) {
+	if t.ID == "" {
+		t.ID = uuid.NewV4().String()
+	}
+
+	options, err := json.Marshal(t.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(
+		`SELECT id FROM machine_types
+		WHERE driver = $1::varchar AND id = $2::varchar`,
+		t.Driver, t.ID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	exists := rows.Next()
+	rows.Close()
+
+	if exists {
+		return nil, MachineTypeDuplicated
+	}
+
+	_, err = db.Exec(
+		`INSERT INTO machine_types
+		(id, driver, name, cpu, ram, disk, image, options)
+		VALUES ($1::varchar, $2::varchar, $3::varchar,
+		$4::integer, $5::integer, $6::integer,
+		$7::varchar, $8::text)`,
+		t.ID, t.Driver, t.Name,
+		t.CPU, t.RAM, t.Disk,
+		t.Image, string(options),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return GetMachineType(t.Driver, t.ID)
+}
+
+func (t *MachineType) Update() error {
+	options, err := json.Marshal(t.Options)
+	if err != nil {
+		return err
+	}
+
+	res, err := db.Exec(
+		`UPDATE machine_types
+		SET name = $3::varchar,
+		cpu = $4::integer, ram = $5::integer, disk = $6::integer,
+		image = $7::varchar, options = $8::text
+		WHERE driver = $1::varchar AND id = $2::varchar`,
+		t.Driver, t.ID, t.Name,
+		t.CPU, t.RAM, t.Disk,
+		t.Image, string(options),
+	)
+	if err != nil {
+		return err
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return MachineTypeNotFound
+	}
+	return nil
+}
+
+func (t *MachineType) Delete() error {
+	res, err := db.Exec(
+		`DELETE FROM machine_types
+		WHERE driver = $1::varchar AND id = $2::varchar`,
+		t.Driver, t.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return MachineTypeNotFound
+	}
+	return nil
+}
+
+// Types implements vms.VM.Types for the drivers backed by the catalog.
+func Types(driver string) ([]vms.MachineType, error) {
+	types, err := FindByDriver(driver)
+	if err != nil {
+		return nil, err
+	}
+
+	rt := make([]vms.MachineType, len(types))
+	for i, t := range types {
+		rt[i] = t
+	}
+	return rt, nil
+}
+
+// Type implements vms.VM.Type for the drivers backed by the catalog.
+// It returns a nil interface, not a nil *MachineType, when the type doesn't
+// exist.
+func Type(driver, id string) (vms.MachineType, error) {
+	t, err := GetMachineType(driver, id)
+	if err != nil || t == nil {
+		return nil, err
+	}
+	return t, nil
+}
@@ -0,0 +1,133 @@
+package machinetypes
+
+import (
+	"testing"
+)
+
+var created *MachineType
+
+func TestDefaultTypes(t *testing.T) {
+	for _, driver := range []string{"manual", "qemu", "vmwarefusion", "libvirt"} {
+		mt, err := GetMachineType(driver, DefaultID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mt == nil {
+			t.Fatalf("No default machine type for %s", driver)
+		}
+		if mt.CPU != 2 || mt.RAM != 4096 || mt.Disk != 60 {
+			t.Errorf("Unexpected default machine type for %s: %+v", driver, mt)
+		}
+	}
+}
+
+func TestCreateMachineType(t *testing.T) {
+	mt, err := CreateMachineType(&MachineType{
+		Driver: "manual",
+		Name:   "large",
+		CPU:    8,
+		RAM:    16384,
+		Disk:   200,
+		Image:  "windows-2012r2",
+		Options: map[string]string{
+			"flavour": "m4.xlarge",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	switch {
+	case mt.ID == "":
+		t.Errorf("'ID' field should be generated")
+	case mt.Name != "large":
+		t.Errorf("'Name' field doesn't match the inserted value")
+	case mt.CPU != 8 || mt.RAM != 16384 || mt.Disk != 200:
+		t.Errorf("Hardware fields don't match the inserted values")
+	case mt.Image != "windows-2012r2":
+		t.Errorf("'Image' field doesn't match the inserted value")
+	case mt.Option("flavour", "") != "m4.xlarge":
+		t.Errorf("'flavour' option doesn't match the inserted value")
+	case mt.Option("missing", "def") != "def":
+		t.Errorf("Missing options should return the default value")
+	}
+	created = mt
+
+	_, err = CreateMachineType(&MachineType{
+		ID:     mt.ID,
+		Driver: "manual",
+		Name:   "duplicated",
+		CPU:    1,
+		RAM:    1,
+		Disk:   1,
+	})
+	if err != MachineTypeDuplicated {
+		t.Errorf("Creating a duplicated machine type should fail, got: %v", err)
+	}
+}
+
+func TestFindByDriver(t *testing.T) {
+	types, err := FindByDriver("manual")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := false
+	for _, mt := range types {
+		if mt.Driver != "manual" {
+			t.Errorf("Machine type of driver %s returned", mt.Driver)
+		}
+		if mt.ID == created.ID {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Created machine type not listed")
+	}
+
+	vmTypes, err := Types("manual")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vmTypes) != len(types) {
+		t.Errorf("Types should return the catalog entries of the driver")
+	}
+}
+
+func TestUpdateMachineType(t *testing.T) {
+	created.CPU = 4
+	created.Name = "medium"
+
+	err := created.Update()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mt, err := GetMachineType("manual", created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mt.CPU != 4 || mt.Name != "medium" {
+		t.Errorf("Machine type not updated")
+	}
+}
+
+func TestDeleteMachineType(t *testing.T) {
+	err := created.Delete()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mt, err := Type("manual", created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mt != nil {
+		t.Errorf("Deleted machine type still exists")
+	}
+
+	err = created.Delete()
+	if err != MachineTypeNotFound {
+		t.Errorf("Deleting a missing machine type should fail, got: %v", err)
+	}
+}
@@ -0,0 +1,256 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package oauth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/models/roles"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+)
+
+// The errors of the authorization requests which can't be sent back to the
+// client, as its redirect URI isn't known for sure.
+var (
+	UnknownClient      = errors.New("unknown client_id")
+	UnknownRedirectURI = errors.New("redirect_uri isn't registered for this client")
+	ClientNotAllowed   = errors.New("this client is reserved to the users of another organization")
+)
+
+// AuthorizationError is an error of an authorization request sent back to the
+// client, RFC 6749 section 4.1.2.1.
+type AuthorizationError struct {
+	Code        string
+	Description string
+}
+
+func (e *AuthorizationError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+// AuthorizationRequest is a request of a client to be granted access to the
+// account of a user, with the authorization code grant and PKCE, RFC 7636.
+type AuthorizationRequest struct {
+	Client        *Client
+	RedirectURI   string
+	Scopes        []string
+	State         string
+	CodeChallenge string
+}
+
+// s256 returns the S256 PKCE challenge of verifier.
+func s256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// ParseAuthorizationRequest checks the authorization request of query. Errors
+// sent back to the client are AuthorizationErrors, returned along with the
+// request.
+func ParseAuthorizationRequest(query url.Values) (*AuthorizationRequest, error) {
+	client, err := GetClientByKey(query.Get("client_id"))
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		return nil, UnknownClient
+	}
+
+	r := AuthorizationRequest{
+		Client:        client,
+		RedirectURI:   query.Get("redirect_uri"),
+		State:         query.Get("state"),
+		CodeChallenge: query.Get("code_challenge"),
+	}
+
+	registered := false
+	for _, uri := range client.RedirectURIs {
+		if uri == r.RedirectURI {
+			registered = true
+		}
+	}
+	if !registered {
+		return nil, UnknownRedirectURI
+	}
+
+	if query.Get("response_type") != "code" {
+		return &r, &AuthorizationError{"unsupported_response_type", "response_type must be code"}
+	}
+
+	if len(r.CodeChallenge) < 43 || len(r.CodeChallenge) > 128 {
+		return &r, &AuthorizationError{"invalid_request", "code_challenge is required"}
+	}
+	if query.Get("code_challenge_method") != "S256" {
+		return &r, &AuthorizationError{"invalid_request", "code_challenge_method must be S256"}
+	}
+
+	r.Scopes = strings.Fields(query.Get("scope"))
+	if len(r.Scopes) == 0 {
+		r.Scopes = client.Scopes
+	}
+	for _, scope := range r.Scopes {
+		if !roles.ValidScope(scope) || !roles.Has(client.Scopes, scope) {
+			return &r, &AuthorizationError{"invalid_scope", "the client can't be granted " + scope}
+		}
+	}
+	return &r, nil
+}
+
+// redirect returns the redirect URI of the request with params.
+func (r *AuthorizationRequest) redirect(params url.Values) string {
+	if r.State != "" {
+		params.Set("state", r.State)
+	}
+
+	u, err := url.Parse(r.RedirectURI)
+	if err != nil {
+		return r.RedirectURI
+	}
+	query := u.Query()
+	for k, v := range params {
+		query[k] = v
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// ErrorRedirect returns where to send the user back to the client with e.
+func (r *AuthorizationRequest) ErrorRedirect(e *AuthorizationError) string {
+	return r.redirect(url.Values{
+		"error":             {e.Code},
+		"error_description": {e.Description},
+	})
+}
+
+// Allowed returns whether user may grant access to the client.
+func (r *AuthorizationRequest) Allowed(user *users.User) bool {
+	return r.Client.OrganizationId == "" || r.Client.OrganizationId == user.OrganizationId
+}
+
+// Deny returns where to send the user back to the client, who was refused
+// access.
+func (r *AuthorizationRequest) Deny() string {
+	return r.ErrorRedirect(&AuthorizationError{"access_denied", "the user denied the request"})
+}
+
+// Approve grants the request on behalf of user, and returns where to send the
+// user back to the client with an authorization code. The code can be traded
+// once, within 10 minutes, for tokens.
+func (r *AuthorizationRequest) Approve(user *users.User) (string, error) {
+	if !r.Allowed(user) {
+		return "", ClientNotAllowed
+	}
+
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	code := base64.RawURLEncoding.EncodeToString(b)
+
+	db.Exec(`DELETE FROM oauth_authorization_codes WHERE expires_at < NOW()`)
+	_, err = db.Exec(
+		`INSERT INTO oauth_authorization_codes
+		(code_hash, oauth_client_id, user_id, redirect_uri, scope,
+		 code_challenge, expires_at)
+		VALUES ($1::varchar, $2::integer, $3::varchar, $4::text, $5::text,
+			$6::varchar, NOW() + interval '10 minutes')`,
+		hashToken(code), r.Client.Id, user.Id, r.RedirectURI,
+		strings.Join(r.Scopes, " "), r.CodeChallenge,
+	)
+	if err != nil {
+		return "", err
+	}
+	return r.redirect(url.Values{"code": {code}}), nil
+}
+
+// exchange trades an authorization code for tokens, nil if it can't be.
+func exchange(tx *sql.Tx, client *Client, code string, redirectURI string, verifier string, req *http.Request) (*AccessToken, error) {
+	var clientId int
+	var userId, codeRedirectURI, scope, challenge string
+	var valid bool
+
+	err := tx.QueryRow(
+		`DELETE FROM oauth_authorization_codes
+		WHERE code_hash = $1::varchar
+		RETURNING oauth_client_id, user_id, redirect_uri, scope,
+		code_challenge, expires_at > NOW()`,
+		hashToken(code),
+	).Scan(&clientId, &userId, &codeRedirectURI, &scope, &challenge, &valid)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !valid || clientId != client.Id || codeRedirectURI != redirectURI ||
+		subtle.ConstantTimeCompare([]byte(s256(verifier)), []byte(challenge)) != 1 {
+		return nil, nil
+	}
+
+	user, err := users.GetUser(userId)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || !user.Activated ||
+		(client.OrganizationId != "" && client.OrganizationId != user.OrganizationId) {
+		return nil, nil
+	}
+
+	return issueTokens(tx, user, client, req, "", scope)
+}
+
+// ExchangeAuthorizationCode trades an authorization code issued to client for
+// tokens. It returns nil if the code is unknown, expired, was issued to
+// another client or for another redirect URI, or if verifier isn't the PKCE
+// verifier of its challenge. Codes are only used once.
+func (c oauthConnector) ExchangeAuthorizationCode(rawClient interface{}, code string, redirectURI string, verifier string, req *http.Request) (interface{}, error) {
+	client := rawClient.(*Client)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := exchange(tx, client, code, redirectURI, verifier, req)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil || accessToken == nil {
+		return nil, err
+	}
+	return *accessToken, nil
+}
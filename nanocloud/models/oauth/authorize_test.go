@@ -0,0 +1,35 @@
+package oauth
+
+import (
+	"testing"
+)
+
+func TestS256(t *testing.T) {
+	// Example of RFC 7636, appendix B
+	challenge := s256("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	if challenge != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Errorf("Unexpected code challenge: %s", challenge)
+	}
+}
+
+func TestValidRedirectURI(t *testing.T) {
+	uris := map[string]bool{
+		"https://example.com/callback":     true,
+		"https://example.com:8443/cb?a=b":  true,
+		"http://localhost:8080/callback":   true,
+		"http://127.0.0.1/callback":        true,
+		"http://[::1]:3000/callback":       true,
+		"http://example.com/callback":      false,
+		"https://example.com/callback#top": false,
+		"https://example.com/callback#":    false,
+		"ftp://example.com/callback":       false,
+		"/callback":                        false,
+		"":                                 false,
+	}
+
+	for uri, valid := range uris {
+		if validRedirectURI(uri) != valid {
+			t.Errorf("validRedirectURI(%q) should be %t", uri, valid)
+		}
+	}
+}
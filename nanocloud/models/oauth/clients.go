@@ -0,0 +1,414 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package oauth
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/models/organizations"
+	"github.com/Nanocloud/community/nanocloud/models/roles"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ClientNotFound      = errors.New("OAuth client not found")
+	ClientDuplicated    = errors.New("an OAuth client with this name already exists")
+	DefaultClientChange = errors.New("the client of the web interface can't be renamed or deleted")
+	InvalidRedirectURI  = errors.New("redirect URIs must be https URLs, or http URLs of localhost, without fragment")
+	InvalidScope        = errors.New("scopes must be one or more known permissions")
+	InvalidLifetime     = errors.New("access tokens must last at least a second, and refresh tokens can't last less than nothing")
+)
+
+// The lifetimes of the tokens of new clients, in seconds, unless they are
+// given others.
+const (
+	DefaultAccessTokenLifetime  = 24 * 60 * 60
+	DefaultRefreshTokenLifetime = 30 * 24 * 60 * 60
+)
+
+// Client is an OAuth client. Clients with an organization only issue tokens
+// to its users, the others are shared by every organization and managed by
+// the default one. The lifetimes of its tokens are in seconds, clients whose
+// refresh tokens have no lifetime don't issue any. Public clients, which
+// can't keep a secret, have none. The clients of service accounts are
+// managed along with them.
+type Client struct {
+	Id                   int      `json:"-"`
+	Name                 string   `json:"name"`
+	Key                  string   `json:"key"`
+	Secret               string   `json:"secret,omitempty"`
+	Confidential         bool     `json:"confidential"`
+	RedirectURIs         []string `json:"redirect-uris"`
+	Scopes               []string `json:"scopes"`
+	AccessTokenLifetime  int      `json:"access-token-lifetime"`
+	RefreshTokenLifetime int      `json:"refresh-token-lifetime"`
+
+	OrganizationId   string `json:"-"`
+	ServiceAccountId string `json:"-"`
+	secretHash       string
+}
+
+func (c *Client) GetID() string {
+	return strconv.Itoa(c.Id)
+}
+
+func (c *Client) SetID(id string) error {
+	var err error
+	c.Id, err = strconv.Atoi(id)
+	return err
+}
+
+// GetName is the type of the clients in the API.
+func (c *Client) GetName() string {
+	return "oauth-clients"
+}
+
+// CheckSecret returns whether secret is the secret of the client. Public
+// clients accept any.
+func (c *Client) CheckSecret(secret string) bool {
+	if !c.Confidential {
+		return true
+	}
+	if c.secretHash == "" {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(c.secretHash), []byte(secret)) == nil
+}
+
+// kClientColumns are the columns findClients expects.
+const kClientColumns = `id, name, key, COALESCE(organization_id, ''),
+	secret_hash, confidential, redirect_uris, scopes,
+	access_token_lifetime, refresh_token_lifetime,
+	COALESCE(service_account_id, '')`
+
+func findClients(condition string, args ...interface{}) ([]*Client, error) {
+	rows, err := db.Query(
+		`SELECT `+kClientColumns+`
+		FROM oauth_clients
+		WHERE `+condition+`
+		ORDER BY name`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients := make([]*Client, 0)
+	for rows.Next() {
+		c := Client{}
+		var redirectURIs, scopes string
+		err = rows.Scan(
+			&c.Id, &c.Name, &c.Key, &c.OrganizationId,
+			&c.secretHash, &c.Confidential, &redirectURIs, &scopes,
+			&c.AccessTokenLifetime, &c.RefreshTokenLifetime,
+			&c.ServiceAccountId,
+		)
+		if err != nil {
+			return nil, err
+		}
+		c.RedirectURIs = strings.Fields(redirectURIs)
+		c.Scopes = strings.Fields(scopes)
+		clients = append(clients, &c)
+	}
+	return clients, rows.Err()
+}
+
+// findClient returns the client whose column is value, nil if there is none.
+func findClient(column string, value string) (*Client, error) {
+	clients, err := findClients(column+` = $1::varchar`, value)
+	if err != nil || len(clients) == 0 {
+		return nil, err
+	}
+	return clients[0], nil
+}
+
+// GetClientByName returns the client named name, nil if there is none.
+func GetClientByName(name string) (*Client, error) {
+	return findClient("name", name)
+}
+
+// GetClientByKey returns the client identified by key in OAuth requests, nil
+// if there is none.
+func GetClientByKey(key string) (*Client, error) {
+	return findClient("key", key)
+}
+
+// FindClients returns the clients an organization manages, without those of
+// its service accounts.
+func FindClients(organizationId string) ([]*Client, error) {
+	return findClients(
+		`service_account_id IS NULL
+		AND (organization_id = $1::varchar
+		OR (organization_id IS NULL AND $1::varchar = $2::varchar))`,
+		organizationId, organizations.Default,
+	)
+}
+
+// GetClientOf returns the client if the organization manages it and it isn't
+// the client of a service account, nil otherwise.
+func GetClientOf(organizationId string, id string) (*Client, error) {
+	clients, err := findClients(
+		`id::varchar = $1::varchar AND service_account_id IS NULL
+		AND (organization_id = $2::varchar
+		OR (organization_id IS NULL AND $2::varchar = $3::varchar))`,
+		id, organizationId, organizations.Default,
+	)
+	if err != nil || len(clients) == 0 {
+		return nil, err
+	}
+	return clients[0], nil
+}
+
+// GetServiceAccountClient returns the client of a service account, nil if
+// there is none.
+func GetServiceAccountClient(serviceAccountId string) (*Client, error) {
+	return findClient("service_account_id", serviceAccountId)
+}
+
+// randomHex returns 32 random bytes, hex encoded, as the keys and secrets of
+// the clients are.
+func randomHex() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// validRedirectURI returns whether tokens may be sent to uri: an https URL,
+// or an http one if it stays on the machine of the user.
+func validRedirectURI(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || u.Host == "" || u.Fragment != "" || strings.Contains(uri, "#") {
+		return false
+	}
+
+	switch u.Scheme {
+	case "https":
+		return true
+	case "http":
+		host := u.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.Trim(host, "[]")
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	}
+	return false
+}
+
+func validate(c *Client) error {
+	clients, err := findClients(`name = $1::varchar AND id != $2::integer`, c.Name, c.Id)
+	if err != nil {
+		return err
+	}
+	if len(clients) > 0 {
+		return ClientDuplicated
+	}
+
+	for _, uri := range c.RedirectURIs {
+		if !validRedirectURI(uri) {
+			return InvalidRedirectURI
+		}
+	}
+
+	if len(c.Scopes) == 0 {
+		return InvalidScope
+	}
+	for _, scope := range c.Scopes {
+		if !roles.ValidScope(scope) {
+			return InvalidScope
+		}
+	}
+
+	if c.AccessTokenLifetime <= 0 || c.RefreshTokenLifetime < 0 {
+		return InvalidLifetime
+	}
+	return nil
+}
+
+// CreateClient creates a client of the organization. Its secret, if it is
+// confidential, is only known from the returned client.
+func CreateClient(organizationId string, c *Client) (*Client, error) {
+	c.Id = 0
+	c.OrganizationId = organizationId
+	if len(c.Scopes) == 0 {
+		c.Scopes = []string{roles.All}
+	}
+	if c.RedirectURIs == nil {
+		c.RedirectURIs = []string{}
+	}
+
+	err := validate(c)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Key, err = randomHex()
+	if err != nil {
+		return nil, err
+	}
+
+	c.Secret, c.secretHash = "", ""
+	if c.Confidential {
+		c.Secret, err = randomHex()
+		if err != nil {
+			return nil, err
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(c.Secret), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+		c.secretHash = string(hash)
+	}
+
+	serviceAccount := sql.NullString{String: c.ServiceAccountId, Valid: c.ServiceAccountId != ""}
+
+	rows, err := db.Query(
+		`INSERT INTO oauth_clients
+		(name, key, secret_hash, confidential, redirect_uris, scopes,
+		 access_token_lifetime, refresh_token_lifetime, organization_id,
+		 service_account_id)
+		VALUES ($1::varchar, $2::varchar, $3::varchar, $4::boolean, $5::text,
+			$6::text, $7::integer, $8::integer, $9::varchar, $10::varchar)
+		RETURNING id`,
+		c.Name, c.Key, c.secretHash, c.Confidential,
+		strings.Join(c.RedirectURIs, " "), strings.Join(c.Scopes, " "),
+		c.AccessTokenLifetime, c.RefreshTokenLifetime, organizationId,
+		serviceAccount,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	err = rows.Scan(&c.Id)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Update saves the name, redirect URIs, scopes and token lifetimes of the
+// client. Whether it is confidential can't change.
+func (c *Client) Update() error {
+	if c.Scopes == nil {
+		c.Scopes = []string{}
+	}
+	if c.RedirectURIs == nil {
+		c.RedirectURIs = []string{}
+	}
+
+	clients, err := findClients(`id = $1::integer`, c.Id)
+	if err != nil {
+		return err
+	}
+	if len(clients) == 0 {
+		return ClientNotFound
+	}
+	if old := clients[0]; old.Name == DefaultClient && c.Name != old.Name {
+		return DefaultClientChange
+	}
+
+	err = validate(c)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(
+		`UPDATE oauth_clients
+		SET name = $2::varchar, redirect_uris = $3::text, scopes = $4::text,
+		access_token_lifetime = $5::integer, refresh_token_lifetime = $6::integer
+		WHERE id = $1::integer`,
+		c.Id, c.Name,
+		strings.Join(c.RedirectURIs, " "), strings.Join(c.Scopes, " "),
+		c.AccessTokenLifetime, c.RefreshTokenLifetime,
+	)
+	return err
+}
+
+// ResetSecret gives a confidential client a new secret, which is only known
+// from the client once it returns.
+func (c *Client) ResetSecret() error {
+	if !c.Confidential {
+		return nil
+	}
+
+	secret, err := randomHex()
+	if err != nil {
+		return err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(
+		`UPDATE oauth_clients SET secret_hash = $2::varchar
+		WHERE id = $1::integer`,
+		c.Id, string(hash),
+	)
+	if err != nil {
+		return err
+	}
+	c.Secret, c.secretHash = secret, string(hash)
+	return nil
+}
+
+// Delete deletes the client along with its tokens.
+func (c *Client) Delete() error {
+	if c.Name == DefaultClient {
+		return DefaultClientChange
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		`DELETE FROM oauth_access_tokens WHERE oauth_client_id = $1::integer`,
+		c.Id,
+	)
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM oauth_clients WHERE id = $1::integer`, c.Id)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,155 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package oauth
+
+import (
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/oauth2"
+)
+
+// Introspection describes a token to the client introspecting it, RFC 7662.
+// Only Active is set for the tokens which aren't active.
+type Introspection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientId  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+}
+
+// findAccessToken describes an unexpired access token, nil if there is none.
+func findAccessToken(token string) (*Introspection, string, error) {
+	var userId string
+	i := Introspection{TokenType: "Bearer"}
+
+	rows, err := db.Query(
+		`SELECT t.user_id, t.scope, c.key,
+		EXTRACT(EPOCH FROM t.expires_at)::bigint,
+		EXTRACT(EPOCH FROM COALESCE(t.created_at, t.expires_at))::bigint
+		FROM oauth_access_tokens t
+		JOIN oauth_clients c ON c.id = t.oauth_client_id
+		WHERE t.token = $1::varchar
+		AND t.expires_at > NOW()`,
+		token,
+	)
+	if err != nil {
+		return nil, "", err
+	}
+
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, "", nil
+	}
+
+	err = rows.Scan(&userId, &i.Scope, &i.ClientId, &i.Exp, &i.Iat)
+	if err != nil {
+		return nil, "", err
+	}
+	return &i, userId, nil
+}
+
+// findRefreshToken describes a refresh token which is neither expired nor
+// used yet, nil if there is none.
+func findRefreshToken(token string) (*Introspection, string, error) {
+	var userId string
+	var i Introspection
+
+	rows, err := db.Query(
+		`SELECT t.user_id, t.scope, c.key,
+		EXTRACT(EPOCH FROM t.expires_at)::bigint,
+		EXTRACT(EPOCH FROM t.created_at)::bigint
+		FROM oauth_refresh_tokens t
+		JOIN oauth_clients c ON c.id = t.oauth_client_id
+		WHERE t.token_hash = $1::varchar
+		AND t.used_at IS NULL
+		AND t.expires_at > NOW()`,
+		hashToken(token),
+	)
+	if err != nil {
+		return nil, "", err
+	}
+
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, "", nil
+	}
+
+	err = rows.Scan(&userId, &i.Scope, &i.ClientId, &i.Exp, &i.Iat)
+	if err != nil {
+		return nil, "", err
+	}
+	return &i, userId, nil
+}
+
+// IntrospectToken describes an access token or a refresh token to a
+// confidential client, looking for the kind of token hinted first. Tokens
+// which are unknown, expired or whose user is disabled are inactive, as are
+// the tokens of other organizations for the clients of an organization.
+func (c oauthConnector) IntrospectToken(rawClient interface{}, token, hint string) (interface{}, error) {
+	client := rawClient.(*Client)
+
+	if !client.Confidential {
+		return nil, oauth2.ErrIntrospectionForbidden
+	}
+
+	find := []func(string) (*Introspection, string, error){findAccessToken, findRefreshToken}
+	if hint == "refresh_token" {
+		find[0], find[1] = find[1], find[0]
+	}
+
+	var i *Introspection
+	var userId string
+	var err error
+	for _, f := range find {
+		i, userId, err = f(token)
+		if err != nil {
+			return nil, err
+		}
+		if i != nil {
+			break
+		}
+	}
+	if i == nil {
+		return nil, nil
+	}
+
+	user, err := users.GetUser(userId)
+	if err != nil || user == nil || !user.Activated {
+		return nil, err
+	}
+	if client.OrganizationId != "" && client.OrganizationId != user.OrganizationId {
+		return nil, nil
+	}
+
+	i.Active = true
+	i.Sub = user.Id
+	i.Username = user.Email
+	if user.ServiceAccount {
+		i.Username = user.FirstName
+	}
+	return *i, nil
+}
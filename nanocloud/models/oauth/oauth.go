@@ -23,39 +23,43 @@
 package oauth
 
 import (
+	"database/sql"
 	"net/http"
 	"os"
 	"strings"
 	"time"
 
 	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/models/auth"
 	"github.com/Nanocloud/community/nanocloud/models/users"
 	"github.com/Nanocloud/community/nanocloud/oauth2"
 	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
 	uuid "github.com/satori/go.uuid"
 )
 
 type oauthConnector struct{}
 
-type Client struct {
-	Id   int
-	Name string
-	Key  string
-}
+// DefaultClient is the client of the web interface.
+const DefaultClient = "Nanocloud"
 
 type AccessToken struct {
-	Token     string        `json:"access_token"`
-	Type      string        `json:"token_type"`
-	ExpiresIn time.Duration `json:"expires_in"`
+	Token        string        `json:"access_token"`
+	Type         string        `json:"token_type"`
+	ExpiresIn    time.Duration `json:"expires_in"`
+	RefreshToken string        `json:"refresh_token,omitempty"`
+	Scope        string        `json:"scope,omitempty"`
 }
 
 func (c oauthConnector) AuthenticateUser(username, password string) (interface{}, error) {
-	return users.GetUserFromEmailPassword(username, password)
+	return auth.Authenticate(username, password)
 }
 
+// GetUserFromAccessToken returns the user of the access token, along with the
+// scopes it grants.
 func (c oauthConnector) GetUserFromAccessToken(accessToken string) (interface{}, error) {
 	rows, err := db.Query(
-		`SELECT user_id
+		`SELECT user_id, scope
 		FROM oauth_access_tokens
 		WHERE token = $1::varchar
 		AND expires_at > NOW()
@@ -72,35 +76,26 @@ func (c oauthConnector) GetUserFromAccessToken(accessToken string) (interface{},
 		return nil, nil
 	}
 
-	var userId string
-	err = rows.Scan(&userId)
+	var userId, scope string
+	err = rows.Scan(&userId, &scope)
 	if err != nil {
 		return nil, err
 	}
 
-	return users.GetUser(userId)
+	user, err := users.GetUser(userId)
+	if err != nil || user == nil {
+		return nil, err
+	}
+	user.Scopes = strings.Fields(scope)
+	return user, nil
 }
 
 func (c oauthConnector) GetClient(key string, secret string) (interface{}, error) {
-	rows, err := db.Query(
-		`SELECT id, name,
-		key
-		FROM oauth_clients
-		WHERE key = $1::varchar`,
-		key,
-	)
-	if err != nil {
+	client, err := GetClientByKey(key)
+	if err != nil || client == nil || !client.CheckSecret(secret) {
 		return nil, err
 	}
-
-	defer rows.Close()
-	if rows.Next() {
-		client := Client{}
-
-		err = rows.Scan(&client.Id, &client.Name, &client.Key)
-		return &client, nil
-	}
-	return nil, nil
+	return client, nil
 }
 
 func removeExpiredTokens() {
@@ -108,14 +103,94 @@ func removeExpiredTokens() {
 		`DELETE FROM oauth_access_tokens
 		WHERE expires_at < NOW()`,
 	)
+	db.Exec(
+		`DELETE FROM oauth_refresh_tokens
+		WHERE expires_at < NOW()`,
+	)
 }
 
 func (c oauthConnector) GetAccessToken(rawUser, rawClient interface{}, req *http.Request) (interface{}, error) {
-	removeExpiredTokens()
-
 	user := rawUser.(*users.User)
 	client := rawClient.(*Client)
 
+	if client.ServiceAccountId != "" {
+		return nil, nil
+	}
+	if client.OrganizationId != "" && client.OrganizationId != user.OrganizationId {
+		return nil, nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := issueTokens(tx, user, client, req, "", strings.Join(client.Scopes, " "))
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return *accessToken, nil
+}
+
+// GetClientAccessToken issues an access token to the service account of a
+// confidential client. It returns nil if the client has none or if it is
+// disabled. No refresh token is issued, the client asks for a new token
+// instead.
+func (c oauthConnector) GetClientAccessToken(rawClient interface{}, req *http.Request) (interface{}, error) {
+	client := rawClient.(*Client)
+
+	if client.ServiceAccountId == "" || !client.Confidential {
+		return nil, nil
+	}
+
+	user, err := users.GetUser(client.ServiceAccountId)
+	if err != nil || user == nil || !user.Activated {
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := createAccessToken(tx, user, client, req, "", strings.Join(client.Scopes, " "))
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	log.WithFields(log.Fields{
+		"service-account": user.Id,
+		"client":          client.Name,
+	}).Info("Access token issued to a service account")
+	return *accessToken, nil
+}
+
+func (c oauthConnector) GetDefaultClient() (interface{}, error) {
+	client, err := GetClientByName(DefaultClient)
+	if err != nil || client == nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// createAccessToken creates an access token of client for user, who logged in
+// from req, with the refresh token family familyId if it isn't empty. scope is
+// the space separated list of the permissions granted to the client.
+func createAccessToken(tx *sql.Tx, user *users.User, client *Client, req *http.Request, familyId string, scope string) (*AccessToken, error) {
+	removeExpiredTokens()
+
 	ua := req.UserAgent()
 
 	// Get IP client address
@@ -136,29 +211,31 @@ func (c oauthConnector) GetAccessToken(rawUser, rawClient interface{}, req *http
 	id := uuid.NewV4().String()
 	token := utils.RandomString(25)
 
-	rows, err := db.Query(
+	family := sql.NullString{String: familyId, Valid: familyId != ""}
+
+	_, err := tx.Exec(
 		`INSERT INTO oauth_access_tokens
 		(id, token, oauth_client_id, user_id,
-		 created_at, user_agent, ip, expires_at)
+		 created_at, user_agent, ip, expires_at, family_id, scope)
 		VALUES
 		($1::varchar, $2::varchar, $3::integer, $4::varchar,
-		 NOW(), $5::varchar, $6::varchar, NOW() + interval '1 day')`,
+		 NOW(), $5::varchar, $6::varchar,
+		 NOW() + $7::integer * interval '1 second', $8::varchar, $9::text)`,
 		id, token, client.Id, user.Id,
-		ua, ip,
+		ua, ip, client.AccessTokenLifetime, family, scope,
 	)
 
 	if err != nil {
 		return nil, err
 	}
 
-	rows.Close()
-
 	accessToken := AccessToken{
 		Token:     token,
 		Type:      "Bearer",
-		ExpiresIn: 60 * 60 * 24,
+		ExpiresIn: time.Duration(client.AccessTokenLifetime),
+		Scope:     scope,
 	}
-	return accessToken, nil
+	return &accessToken, nil
 }
 
 func (c oauthConnector) RevokeAccessToken(rawClient interface{}, rawUser interface{}, accessToken string) error {
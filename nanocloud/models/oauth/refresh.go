@@ -0,0 +1,226 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package oauth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	log "github.com/Sirupsen/logrus"
+	uuid "github.com/satori/go.uuid"
+)
+
+// hashToken returns the hash a refresh token is stored as.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// createRefreshToken creates a refresh token of client for user in the family
+// familyId, granting scope.
+func createRefreshToken(tx *sql.Tx, user *users.User, client *Client, familyId string, scope string) (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	_, err = tx.Exec(
+		`INSERT INTO oauth_refresh_tokens
+		(id, token_hash, family_id, oauth_client_id, user_id,
+		 created_at, expires_at, scope)
+		VALUES
+		($1::varchar, $2::varchar, $3::varchar, $4::integer, $5::varchar,
+		 NOW(), NOW() + $6::integer * interval '1 second', $7::text)`,
+		uuid.NewV4().String(), hashToken(token), familyId, client.Id, user.Id,
+		client.RefreshTokenLifetime, scope,
+	)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// issueTokens creates an access token of client for user, along with a
+// refresh token of the family familyId if the client issues them, both
+// granting scope. A new family is started if familyId is empty.
+func issueTokens(tx *sql.Tx, user *users.User, client *Client, req *http.Request, familyId string, scope string) (*AccessToken, error) {
+	if client.RefreshTokenLifetime <= 0 {
+		return createAccessToken(tx, user, client, req, "", scope)
+	}
+
+	if familyId == "" {
+		familyId = uuid.NewV4().String()
+	}
+
+	accessToken, err := createAccessToken(tx, user, client, req, familyId, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken.RefreshToken, err = createRefreshToken(tx, user, client, familyId, scope)
+	if err != nil {
+		return nil, err
+	}
+	return accessToken, nil
+}
+
+// revokeFamily deletes the refresh tokens of the family familyId and the
+// access tokens issued with them.
+func revokeFamily(tx *sql.Tx, familyId string) error {
+	_, err := tx.Exec(
+		`DELETE FROM oauth_refresh_tokens
+		WHERE family_id = $1::varchar`,
+		familyId,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		`DELETE FROM oauth_access_tokens
+		WHERE family_id = $1::varchar`,
+		familyId,
+	)
+	return err
+}
+
+// refresh trades refreshToken for a new access token and refresh token of
+// client, nil if it can't be.
+func refresh(tx *sql.Tx, client *Client, refreshToken string, req *http.Request) (*AccessToken, error) {
+	var familyId, userId, scope string
+	var clientId int
+	var valid, used bool
+
+	err := tx.QueryRow(
+		`SELECT family_id, oauth_client_id, user_id, scope,
+		expires_at > NOW(), used_at IS NOT NULL
+		FROM oauth_refresh_tokens
+		WHERE token_hash = $1::varchar
+		FOR UPDATE`,
+		hashToken(refreshToken),
+	).Scan(&familyId, &clientId, &userId, &scope, &valid, &used)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if clientId != client.Id || !valid {
+		return nil, nil
+	}
+
+	user, err := users.GetUser(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	if used || user == nil || !user.Activated ||
+		(client.OrganizationId != "" && client.OrganizationId != user.OrganizationId) {
+		if used {
+			log.WithFields(log.Fields{
+				"user":   userId,
+				"client": client.Name,
+			}).Warn("Refresh token reused, revoking its family")
+		}
+		return nil, revokeFamily(tx, familyId)
+	}
+
+	_, err = tx.Exec(
+		`UPDATE oauth_refresh_tokens
+		SET used_at = NOW()
+		WHERE token_hash = $1::varchar`,
+		hashToken(refreshToken),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return issueTokens(tx, user, client, req, familyId, scope)
+}
+
+// RefreshAccessToken trades a refresh token of client for a new access token
+// and refresh token of the same family. It returns nil if the refresh token is
+// unknown, expired or was issued to another client. A refresh token can only
+// be used once: using it again revokes its family, as either the legitimate
+// client or an attacker holds a stolen token.
+func (c oauthConnector) RefreshAccessToken(rawClient interface{}, refreshToken string, req *http.Request) (interface{}, error) {
+	client := rawClient.(*Client)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := refresh(tx, client, refreshToken, req)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil || accessToken == nil {
+		return nil, err
+	}
+	return *accessToken, nil
+}
+
+// RevokeRefreshToken revokes the family of a refresh token of client: every
+// refresh token traded for another from the same login, and the access tokens
+// issued with them. Unknown tokens are ignored.
+func (c oauthConnector) RevokeRefreshToken(rawClient interface{}, refreshToken string) error {
+	client := rawClient.(*Client)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	var familyId string
+	err = tx.QueryRow(
+		`SELECT family_id
+		FROM oauth_refresh_tokens
+		WHERE token_hash = $1::varchar
+		AND oauth_client_id = $2::integer`,
+		hashToken(refreshToken), client.Id,
+	).Scan(&familyId)
+	if err == nil {
+		err = revokeFamily(tx, familyId)
+	}
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,101 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package oauth
+
+import (
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+)
+
+// Token describes an access token without giving it away.
+type Token struct {
+	Id        string `json:"-"`
+	Client    string `json:"client"`
+	Scope     string `json:"scope"`
+	CreatedAt string `json:"created-at"`
+	ExpiresAt string `json:"expires-at"`
+	UserAgent string `json:"user-agent"`
+	IP        string `json:"ip"`
+}
+
+func (t *Token) GetID() string {
+	return t.Id
+}
+
+func (t *Token) SetID(id string) error {
+	t.Id = id
+	return nil
+}
+
+// GetName is the type of the tokens in the API.
+func (t *Token) GetName() string {
+	return "tokens"
+}
+
+// FindTokens returns the access tokens of a user which haven't expired, the
+// latest first.
+func FindTokens(userId string) ([]*Token, error) {
+	rows, err := db.Query(
+		`SELECT oauth_access_tokens.id, oauth_clients.name,
+			oauth_access_tokens.scope, oauth_access_tokens.created_at,
+			oauth_access_tokens.expires_at,
+			COALESCE(oauth_access_tokens.user_agent, ''),
+			COALESCE(oauth_access_tokens.ip, '')
+		FROM oauth_access_tokens
+		JOIN oauth_clients
+			ON oauth_clients.id = oauth_access_tokens.oauth_client_id
+		WHERE oauth_access_tokens.user_id = $1::varchar
+		AND oauth_access_tokens.expires_at > NOW()
+		ORDER BY oauth_access_tokens.created_at DESC`,
+		userId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]*Token, 0)
+	for rows.Next() {
+		t := Token{}
+		err = rows.Scan(
+			&t.Id, &t.Client,
+			&t.Scope, &t.CreatedAt,
+			&t.ExpiresAt,
+			&t.UserAgent,
+			&t.IP,
+		)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, &t)
+	}
+	return tokens, rows.Err()
+}
+
+// RevokeTokens revokes the access tokens of a user.
+func RevokeTokens(userId string) error {
+	_, err := db.Exec(
+		`DELETE FROM oauth_access_tokens WHERE user_id = $1::varchar`,
+		userId,
+	)
+	return err
+}
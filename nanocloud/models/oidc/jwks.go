@@ -0,0 +1,174 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+)
+
+// jwk is a JSON Web Key, RFC 7517.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// publicKey returns the RSA or elliptic curve public key of k.
+func (k *jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if e.BitLen() > 31 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("invalid elliptic curve point")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
+
+// keySet is the JWKS of a provider. Keys are fetched again when a token is
+// signed with an unknown one, as providers rotate them, but at most once a
+// minute.
+type keySet struct {
+	uri     string
+	mutex   sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+func newKeySet(uri string) *keySet {
+	return &keySet{uri: uri}
+}
+
+func (s *keySet) fetch() error {
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	err := getJSON(s.uri, &doc)
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(doc.Keys))
+	for i := range doc.Keys {
+		k := &doc.Keys[i]
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			// keys Nanocloud can't use don't prevent using the others
+			continue
+		}
+		keys[k.Kid] = key
+	}
+
+	s.keys = keys
+	s.fetched = time.Now()
+	return nil
+}
+
+// key returns the key identified by kid. Tokens without kid may only be used
+// with a single key.
+func (s *keySet) key(kid string) (crypto.PublicKey, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	lookup := func() crypto.PublicKey {
+		if kid == "" && len(s.keys) == 1 {
+			for _, key := range s.keys {
+				return key
+			}
+		}
+		return s.keys[kid]
+	}
+
+	key := lookup()
+	if key == nil && time.Since(s.fetched) > time.Minute {
+		err := s.fetch()
+		if err != nil {
+			return nil, err
+		}
+		key = lookup()
+	}
+	if key == nil {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	return key, nil
+}
@@ -0,0 +1,290 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/models/directory"
+	"github.com/Nanocloud/community/nanocloud/models/organizations"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	log "github.com/Sirupsen/logrus"
+)
+
+// randomToken returns a random URL safe string, for the values an attacker
+// mustn't guess.
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// challenge returns the S256 PKCE challenge of verifier, RFC 7636.
+func challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Login is a login in progress, from the redirection to the provider to its
+// callback.
+type Login struct {
+	State    string
+	Provider string
+	Nonce    string
+	Verifier string
+	Redirect string
+}
+
+// AuthCodeURL starts a login and returns the URL of the provider to redirect
+// the user to. redirectURI is the callback of Nanocloud, redirect where the
+// user goes once logged in.
+func (p *Provider) AuthCodeURL(redirectURI string, redirect string) (string, error) {
+	m, err := p.discover()
+	if err != nil {
+		log.Error(err)
+		return "", DiscoveryFailed
+	}
+
+	login := Login{Provider: p.Name, Redirect: redirect}
+	for _, v := range []*string{&login.State, &login.Nonce, &login.Verifier} {
+		*v, err = randomToken()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	db.Exec(`DELETE FROM oidc_states WHERE expires_at < NOW()`)
+	_, err = db.Exec(
+		`INSERT INTO oidc_states
+		(state, provider, nonce, verifier, redirect, expires_at)
+		VALUES ($1::varchar, $2::varchar, $3::varchar, $4::varchar,
+			$5::varchar, NOW() + interval '10 minutes')`,
+		login.State, login.Provider, login.Nonce, login.Verifier, login.Redirect,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientId},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {p.Scopes},
+		"state":                 {login.State},
+		"nonce":                 {login.Nonce},
+		"code_challenge":        {challenge(login.Verifier)},
+		"code_challenge_method": {"S256"},
+	}
+
+	sep := "?"
+	if strings.Contains(m.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return m.AuthorizationEndpoint + sep + query.Encode(), nil
+}
+
+// TakeLogin returns the login of the provider started with state, which can
+// only be taken once. It returns InvalidState if there is none.
+func (p *Provider) TakeLogin(state string) (*Login, error) {
+	rows, err := db.Query(
+		`DELETE FROM oidc_states
+		WHERE state = $1::varchar AND provider = $2::varchar
+		AND expires_at > NOW()
+		RETURNING state, provider, nonce, verifier, redirect`,
+		state, p.Name,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, InvalidState
+	}
+
+	login := Login{}
+	err = rows.Scan(&login.State, &login.Provider, &login.Nonce, &login.Verifier, &login.Redirect)
+	if err != nil {
+		return nil, err
+	}
+	return &login, nil
+}
+
+// Exchange trades the authorization code of login for its ID token, and
+// returns its verified claims.
+func (p *Provider) Exchange(login *Login, code string, redirectURI string) (*Claims, error) {
+	m, err := p.discover()
+	if err != nil {
+		log.Error(err)
+		return nil, DiscoveryFailed
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {login.Verifier},
+	}
+	req, err := http.NewRequest("POST", m.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.ClientId), url.QueryEscape(p.ClientSecret))
+
+	res, err := kHTTPClient.Do(req)
+	if err != nil {
+		log.Error(err)
+		return nil, ExchangeFailed
+	}
+	defer res.Body.Close()
+
+	var body struct {
+		IdToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&body)
+	if err != nil || res.StatusCode != http.StatusOK || body.IdToken == "" {
+		log.Errorf("Token request to %s failed: %s %s %s", p.Name, res.Status, body.Error, body.ErrorDescription)
+		return nil, ExchangeFailed
+	}
+
+	return p.Verify(body.IdToken, login.Nonce)
+}
+
+// identityUser returns the user the identity of claims is linked to, nil if
+// there is none.
+func (p *Provider) identityUser(claims *Claims) (*users.User, error) {
+	rows, err := db.Query(
+		`SELECT user_id FROM oidc_identities
+		WHERE provider = $1::varchar AND subject = $2::varchar`,
+		p.Name, claims.Subject,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var userId string
+	found := rows.Next()
+	if found {
+		err = rows.Scan(&userId)
+	}
+	rows.Close()
+	if !found || err != nil {
+		return nil, err
+	}
+	return users.GetUser(userId)
+}
+
+// createUser creates the user of claims in the organization of the provider.
+func (p *Provider) createUser(claims *Claims) (*users.User, error) {
+	org, err := organizations.GetOrganization(p.OrganizationId)
+	if err != nil {
+		return nil, err
+	}
+	if org == nil {
+		return nil, fmt.Errorf("the organization %s of %s doesn't exist", p.OrganizationId, p.Name)
+	}
+
+	firstName, lastName := claims.Names()
+	user, err := directory.CreateLocalUser(org, claims.Email, firstName, lastName)
+	if err != nil {
+		return nil, err
+	}
+
+	log.WithFields(log.Fields{
+		"user":         user.Id,
+		"provider":     p.Name,
+		"organization": org.Id,
+	}).Info("OpenID Connect user created")
+	return user, nil
+}
+
+// User returns the user of claims. Identities are linked on their first login
+// to the user of the organization of the provider with the same verified
+// email, who is created if there is none and the provider creates users.
+func (p *Provider) User(claims *Claims) (*users.User, error) {
+	user, err := p.identityUser(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	if user == nil {
+		if claims.Email == "" {
+			return nil, EmailMissing
+		}
+		// a provider which doesn't say the email is verified could let
+		// anyone claim the account of its owner
+		if claims.EmailVerified == nil || !*claims.EmailVerified {
+			return nil, EmailNotVerified
+		}
+
+		user, err = users.GetUserByEmail(claims.Email)
+		if err != nil {
+			return nil, err
+		}
+
+		// the users of other organizations aren't the provider's to vouch for
+		if user != nil && user.OrganizationId != p.OrganizationId {
+			log.Errorf("%s asserted the email of a user of another organization", p.Name)
+			return nil, users.UserNotFound
+		}
+
+		if user == nil {
+			if !p.CreateUsers {
+				return nil, users.UserNotFound
+			}
+			user, err = p.createUser(claims)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		_, err = db.Exec(
+			`INSERT INTO oidc_identities (provider, subject, user_id)
+			VALUES ($1::varchar, $2::varchar, $3::varchar)`,
+			p.Name, claims.Subject, user.Id,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !user.Activated {
+		return nil, users.UserDisabled
+	}
+	return user, nil
+}
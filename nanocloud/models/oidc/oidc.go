@@ -0,0 +1,197 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package oidc makes Nanocloud an OpenID Connect relying party: users log in
+// with an external identity provider, which proves who they are with a signed
+// ID token.
+package oidc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nanocloud/community/nanocloud/models/organizations"
+	"github.com/Nanocloud/community/nanocloud/utils"
+)
+
+var (
+	DiscoveryFailed  = errors.New("unable to discover the identity provider")
+	ExchangeFailed   = errors.New("unable to exchange the authorization code")
+	InvalidToken     = errors.New("invalid ID token")
+	InvalidState     = errors.New("invalid or expired login state")
+	EmailMissing     = errors.New("the ID token has no email")
+	EmailNotVerified = errors.New("the email of the ID token isn't verified")
+)
+
+// kHTTPClient is used to reach the identity providers.
+var kHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// Provider is an identity provider, configured with the environment:
+// OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID and OIDC_<NAME>_CLIENT_SECRET are
+// mandatory, OIDC_<NAME>_ORGANIZATION, OIDC_<NAME>_SCOPES,
+// OIDC_<NAME>_REDIRECT_URI and OIDC_<NAME>_CREATE_USERS are optional.
+type Provider struct {
+	Name           string
+	Issuer         string
+	ClientId       string
+	ClientSecret   string
+	OrganizationId string
+	Scopes         string
+	RedirectURI    string
+	CreateUsers    bool
+
+	mutex    sync.Mutex
+	metadata *metadata
+	keys     *keySet
+}
+
+// metadata is the part of the discovery document of a provider Nanocloud
+// uses.
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// envName returns the name of a provider as used in its environment
+// variables.
+func envName(name string) string {
+	return strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+func newProvider(name string) (*Provider, error) {
+	prefix := "OIDC_" + envName(name) + "_"
+
+	p := Provider{
+		Name:           name,
+		Issuer:         utils.Env(prefix+"ISSUER", ""),
+		ClientId:       utils.Env(prefix+"CLIENT_ID", ""),
+		ClientSecret:   utils.Env(prefix+"CLIENT_SECRET", ""),
+		OrganizationId: utils.Env(prefix+"ORGANIZATION", organizations.Default),
+		Scopes:         utils.Env(prefix+"SCOPES", "openid email profile"),
+		RedirectURI:    utils.Env(prefix+"REDIRECT_URI", ""),
+		CreateUsers:    utils.Env(prefix+"CREATE_USERS", "true") == "true",
+	}
+
+	if p.Issuer == "" || p.ClientId == "" || p.ClientSecret == "" {
+		return nil, fmt.Errorf("%sISSUER, %sCLIENT_ID and %sCLIENT_SECRET are mandatory", prefix, prefix, prefix)
+	}
+	return &p, nil
+}
+
+var (
+	kProviders     map[string]*Provider
+	kProviderNames []string
+	kProvidersErr  error
+	kProvidersOnce sync.Once
+)
+
+func loadProviders() {
+	kProviders = make(map[string]*Provider)
+
+	for _, name := range strings.Split(utils.Env("OIDC_PROVIDERS", ""), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		p, err := newProvider(name)
+		if err != nil {
+			kProvidersErr = err
+			return
+		}
+		kProviders[name] = p
+		kProviderNames = append(kProviderNames, name)
+	}
+}
+
+// Providers returns the identity providers of OIDC_PROVIDERS, a comma
+// separated list of names, in order.
+func Providers() ([]*Provider, error) {
+	kProvidersOnce.Do(loadProviders)
+	if kProvidersErr != nil {
+		return nil, kProvidersErr
+	}
+
+	providers := make([]*Provider, len(kProviderNames))
+	for i, name := range kProviderNames {
+		providers[i] = kProviders[name]
+	}
+	return providers, nil
+}
+
+// GetProvider returns the identity provider named name, nil if there is none.
+func GetProvider(name string) (*Provider, error) {
+	kProvidersOnce.Do(loadProviders)
+	if kProvidersErr != nil {
+		return nil, kProvidersErr
+	}
+	return kProviders[name], nil
+}
+
+// getJSON decodes the JSON document at url into v.
+func getJSON(url string, v interface{}) error {
+	res, err := kHTTPClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// discover returns the metadata of the provider, fetched from its discovery
+// document the first time.
+func (p *Provider) discover() (*metadata, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	m := metadata{}
+	err := getJSON(strings.TrimSuffix(p.Issuer, "/")+"/.well-known/openid-configuration", &m)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.Issuer != p.Issuer {
+		return nil, fmt.Errorf("the discovery document is issued by %s", m.Issuer)
+	}
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" {
+		return nil, errors.New("the discovery document misses an endpoint")
+	}
+
+	p.metadata = &m
+	p.keys = newKeySet(m.JWKSURI)
+	return p.metadata, nil
+}
@@ -0,0 +1,317 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	kClientId     = "nanocloud"
+	kClientSecret = "secret"
+	kCode         = "code"
+)
+
+// mockIdP is a local identity provider issuing ID tokens signed with its
+// keys.
+type mockIdP struct {
+	server *httptest.Server
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+	kid    string
+	nonce  string
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idp := &mockIdP{rsaKey: rsaKey, ecKey: ecKey, kid: "rsa"}
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{
+				{
+					"kty": "RSA",
+					"kid": idp.kid,
+					"use": "sig",
+					"n":   b64(rsaKey.N.Bytes()),
+					"e":   b64(big.NewInt(int64(rsaKey.E)).Bytes()),
+				},
+				{
+					"kty": "EC",
+					"kid": "ec",
+					"crv": "P-256",
+					"x":   b64(ecKey.X.Bytes()),
+					"y":   b64(ecKey.Y.Bytes()),
+				},
+				{
+					"kty": "RSA",
+					"kid": "enc",
+					"use": "enc",
+					"n":   b64(rsaKey.N.Bytes()),
+					"e":   b64(big.NewInt(int64(rsaKey.E)).Bytes()),
+				},
+			},
+		})
+	})
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != kClientId || secret != kClientSecret {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"invalid_client"}`))
+			return
+		}
+		if r.FormValue("grant_type") != "authorization_code" || r.FormValue("code") != kCode || r.FormValue("code_verifier") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "at",
+			"token_type":   "Bearer",
+			"id_token":     idp.sign(t, "RS256", idp.kid, idp.claims()),
+		})
+	})
+
+	idp.server = httptest.NewServer(mux)
+	return idp
+}
+
+func (idp *mockIdP) provider() *Provider {
+	return &Provider{
+		Name:         "mock",
+		Issuer:       idp.server.URL,
+		ClientId:     kClientId,
+		ClientSecret: kClientSecret,
+	}
+}
+
+func (idp *mockIdP) claims() map[string]interface{} {
+	return map[string]interface{}{
+		"iss":            idp.server.URL,
+		"sub":            "248289761001",
+		"aud":            kClientId,
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          idp.nonce,
+		"email":          "jane@nanocloud.com",
+		"email_verified": true,
+		"name":           "Jane Doe",
+	}
+}
+
+func (idp *mockIdP) sign(t *testing.T, alg string, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	var err error
+	switch alg {
+	case "RS256":
+		signature, err = rsa.SignPKCS1v15(rand.Reader, idp.rsaKey, crypto.SHA256, digest[:])
+	case "ES256":
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, idp.ecKey, digest[:])
+		signature = make([]byte, 64)
+		rb, sb := r.Bytes(), s.Bytes()
+		copy(signature[32-len(rb):32], rb)
+		copy(signature[64-len(sb):], sb)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + b64(signature)
+}
+
+func TestVerify(t *testing.T) {
+	idp := newMockIdP(t)
+	defer idp.server.Close()
+	idp.nonce = "nonce"
+	p := idp.provider()
+
+	claims, err := p.Verify(idp.sign(t, "RS256", "rsa", idp.claims()), "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "248289761001" || claims.Email != "jane@nanocloud.com" || claims.EmailVerified == nil || !*claims.EmailVerified {
+		t.Errorf("Unexpected claims: %+v", claims)
+	}
+
+	_, err = p.Verify(idp.sign(t, "ES256", "ec", idp.claims()), "nonce")
+	if err != nil {
+		t.Errorf("ES256 token rejected: %s", err)
+	}
+
+	invalid := map[string]func(map[string]interface{}){
+		"audience": func(c map[string]interface{}) { c["aud"] = "another" },
+		"issuer":   func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" },
+		"expired":  func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"future":   func(c map[string]interface{}) { c["iat"] = time.Now().Add(time.Hour).Unix() },
+		"nonce":    func(c map[string]interface{}) { c["nonce"] = "another" },
+		"subject":  func(c map[string]interface{}) { delete(c, "sub") },
+		"azp": func(c map[string]interface{}) {
+			c["aud"] = []string{kClientId, "another"}
+			c["azp"] = "another"
+		},
+	}
+	for name, change := range invalid {
+		claims := idp.claims()
+		change(claims)
+		_, err = p.Verify(idp.sign(t, "RS256", "rsa", claims), "nonce")
+		if err == nil {
+			t.Errorf("Token with invalid %s accepted", name)
+		}
+	}
+
+	token := idp.sign(t, "RS256", "rsa", idp.claims())
+	parts := strings.Split(token, ".")
+	claims2 := idp.claims()
+	claims2["email"] = "admin@nanocloud.com"
+	payload, _ := json.Marshal(claims2)
+	_, err = p.Verify(parts[0]+"."+b64(payload)+"."+parts[2], "nonce")
+	if err == nil {
+		t.Error("Tampered token accepted")
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "none", "kid": "rsa"})
+	payload, _ = json.Marshal(idp.claims())
+	_, err = p.Verify(b64(header)+"."+b64(payload)+".", "nonce")
+	if err == nil {
+		t.Error("Unsigned token accepted")
+	}
+
+	_, err = p.Verify(idp.sign(t, "RS256", "enc", idp.claims()), "nonce")
+	if err == nil {
+		t.Error("Token signed with an encryption key accepted")
+	}
+
+	_, err = p.Verify(idp.sign(t, "ES256", "rsa", idp.claims()), "nonce")
+	if err == nil {
+		t.Error("Token with a mismatching algorithm accepted")
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	idp := newMockIdP(t)
+	defer idp.server.Close()
+	p := idp.provider()
+
+	_, err := p.Verify(idp.sign(t, "RS256", "rsa", idp.claims()), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idp.kid = "rotated"
+	_, err = p.Verify(idp.sign(t, "RS256", "rotated", idp.claims()), "")
+	if err == nil {
+		t.Error("Keys fetched again within a minute")
+	}
+
+	p.keys.fetched = time.Now().Add(-2 * time.Minute)
+	_, err = p.Verify(idp.sign(t, "RS256", "rotated", idp.claims()), "")
+	if err != nil {
+		t.Errorf("Rotated key not fetched: %s", err)
+	}
+}
+
+func TestExchange(t *testing.T) {
+	idp := newMockIdP(t)
+	defer idp.server.Close()
+	idp.nonce = "nonce"
+	p := idp.provider()
+
+	login := &Login{Provider: "mock", Nonce: "nonce", Verifier: "verifier"}
+	claims, err := p.Exchange(login, kCode, "http://localhost/oidc/mock/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Email != "jane@nanocloud.com" {
+		t.Errorf("Unexpected email %s", claims.Email)
+	}
+
+	_, err = p.Exchange(login, "wrong", "http://localhost/oidc/mock/callback")
+	if err != ExchangeFailed {
+		t.Errorf("Expected ExchangeFailed, got %v", err)
+	}
+
+	p.ClientSecret = "wrong"
+	_, err = p.Exchange(login, kCode, "http://localhost/oidc/mock/callback")
+	if err != ExchangeFailed {
+		t.Errorf("Expected ExchangeFailed, got %v", err)
+	}
+
+	login.Nonce = "another"
+	p.ClientSecret = kClientSecret
+	_, err = p.Exchange(login, kCode, "http://localhost/oidc/mock/callback")
+	if err == nil {
+		t.Error("Token of another login accepted")
+	}
+}
+
+func TestChallenge(t *testing.T) {
+	// RFC 7636, appendix B
+	c := challenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	if c != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Errorf("Unexpected challenge %s", c)
+	}
+}
+
+func TestClaims(t *testing.T) {
+	claims := Claims{}
+	err := json.Unmarshal([]byte(`{"aud":["a","b"],"email_verified":"false","name":"Jane van Doe"}`), &claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claims.Audience) != 2 || !claims.Audience.contains("b") {
+		t.Errorf("Unexpected audience %v", claims.Audience)
+	}
+	if claims.EmailVerified == nil || *claims.EmailVerified {
+		t.Error("email_verified should be false")
+	}
+
+	first, last := claims.Names()
+	if first != "Jane" || last != "van Doe" {
+		t.Errorf("Unexpected names %s %s", first, last)
+	}
+
+	claims.GivenName = "J."
+	first, last = claims.Names()
+	if first != "J." || last != "" {
+		t.Errorf("Unexpected names %s %s", first, last)
+	}
+}
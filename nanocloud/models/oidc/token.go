@@ -0,0 +1,235 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// kClockSkew is the difference tolerated between the clocks of Nanocloud and
+// of the providers.
+const kClockSkew = time.Minute
+
+// audience is the aud claim, a string or an array of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if json.Unmarshal(b, &s) == nil {
+		*a = audience{s}
+		return nil
+	}
+	var l []string
+	err := json.Unmarshal(b, &l)
+	if err != nil {
+		return err
+	}
+	*a = audience(l)
+	return nil
+}
+
+func (a audience) contains(s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// flag is a boolean claim, which some providers send as a string.
+type flag bool
+
+func (f *flag) UnmarshalJSON(b []byte) error {
+	var s string
+	if json.Unmarshal(b, &s) == nil {
+		*f = flag(s == "true")
+		return nil
+	}
+	var v bool
+	err := json.Unmarshal(b, &v)
+	*f = flag(v)
+	return err
+}
+
+// Claims are the claims of an ID token Nanocloud uses.
+type Claims struct {
+	Issuer          string   `json:"iss"`
+	Subject         string   `json:"sub"`
+	Audience        audience `json:"aud"`
+	AuthorizedParty string   `json:"azp"`
+	Expiry          int64    `json:"exp"`
+	IssuedAt        int64    `json:"iat"`
+	Nonce           string   `json:"nonce"`
+	Email           string   `json:"email"`
+	EmailVerified   *flag    `json:"email_verified"`
+	Name            string   `json:"name"`
+	GivenName       string   `json:"given_name"`
+	FamilyName      string   `json:"family_name"`
+}
+
+// Names returns the first and last names of the user, split from their full
+// name when the provider doesn't send them.
+func (c *Claims) Names() (string, string) {
+	if c.GivenName != "" || c.FamilyName != "" {
+		return c.GivenName, c.FamilyName
+	}
+
+	names := strings.SplitN(strings.TrimSpace(c.Name), " ", 2)
+	if len(names) == 2 {
+		return names[0], names[1]
+	}
+	return names[0], ""
+}
+
+var kHashes = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+	"ES512": crypto.SHA512,
+}
+
+// verifySignature checks the signature of the JWS signed with alg.
+func verifySignature(key crypto.PublicKey, alg string, signed string, signature []byte) error {
+	hash, ok := kHashes[alg]
+	if !ok {
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if alg[0] != 'R' {
+			break
+		}
+		return rsa.VerifyPKCS1v15(key, hash, digest, signature)
+
+	case *ecdsa.PublicKey:
+		if alg[0] != 'E' {
+			break
+		}
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errors.New("invalid signature length")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(key, digest, r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("the key can't verify %s signatures", alg)
+}
+
+// Verify checks the signature of the ID token raw against the keys of the
+// provider, and that it was issued by the provider for Nanocloud, during the
+// login matching nonce, and hasn't expired.
+func (p *Provider) Verify(raw string, nonce string) (*Claims, error) {
+	_, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, InvalidToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(b, &header) != nil {
+		return nil, InvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, InvalidToken
+	}
+
+	key, err := p.keys.key(header.Kid)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", InvalidToken, err)
+	}
+
+	err = verifySignature(key, header.Alg, parts[0]+"."+parts[1], signature)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", InvalidToken, err)
+	}
+
+	claims := Claims{}
+	b, err = base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(b, &claims) != nil {
+		return nil, InvalidToken
+	}
+
+	err = p.validate(&claims, nonce, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", InvalidToken, err)
+	}
+	return &claims, nil
+}
+
+// validate checks the claims of an ID token as of now.
+func (p *Provider) validate(c *Claims, nonce string, now time.Time) error {
+	if c.Issuer != p.Issuer {
+		return fmt.Errorf("issued by %s", c.Issuer)
+	}
+	if c.Subject == "" {
+		return errors.New("no subject")
+	}
+	if !c.Audience.contains(p.ClientId) {
+		return errors.New("issued for another client")
+	}
+	if len(c.Audience) > 1 && c.AuthorizedParty != p.ClientId {
+		return errors.New("authorized for another client")
+	}
+	if c.Expiry == 0 || now.Add(-kClockSkew).After(time.Unix(c.Expiry, 0)) {
+		return errors.New("expired")
+	}
+	if c.IssuedAt != 0 && now.Add(kClockSkew).Before(time.Unix(c.IssuedAt, 0)) {
+		return errors.New("issued in the future")
+	}
+	if c.Nonce != nonce {
+		return errors.New("nonce mismatch")
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package organizations
+
+import (
+	"strings"
+
+	"github.com/Nanocloud/community/nanocloud/utils"
+)
+
+// Default is the id of the organization users belong to unless stated
+// otherwise. Its members manage the other organizations.
+const Default = "default"
+
+// Organization is a tenant. Its users, apps, groups, roles, histories and
+// machines aren't visible to the other organizations. Empty settings fall back
+// to the environment.
+type Organization struct {
+	Id               string   `json:"-"`
+	Name             string   `json:"name"`
+	LDAPOU           string   `json:"ldap-ou"`
+	WindowsDomain    string   `json:"windows-domain"`
+	ExecutionServers []string `json:"execution-servers"`
+}
+
+func (o *Organization) GetID() string {
+	return o.Id
+}
+
+func (o *Organization) SetID(id string) error {
+	o.Id = id
+	return nil
+}
+
+// Domain returns the Windows domain of the accounts of the organization.
+func (o *Organization) Domain() string {
+	if o.WindowsDomain != "" {
+		return o.WindowsDomain
+	}
+	return "intra.localdomain.com"
+}
+
+// Servers returns the pool of execution servers the sessions of the members
+// of the organization are opened on.
+func (o *Organization) Servers() []string {
+	if len(o.ExecutionServers) > 0 {
+		return o.ExecutionServers
+	}
+	return splitServers(utils.Env("EXECUTION_SERVERS", "iaas-module"))
+}
+
+func splitServers(servers string) []string {
+	rt := make([]string, 0)
+	for _, server := range strings.Split(servers, ",") {
+		server = strings.TrimSpace(server)
+		if server != "" {
+			rt = append(rt, server)
+		}
+	}
+	return rt
+}
@@ -0,0 +1,334 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package organizations
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	uuid "github.com/satori/go.uuid"
+)
+
+var (
+	OrganizationNotFound   = errors.New("organization not found")
+	OrganizationDuplicated = errors.New("an organization with this name already exists")
+	OrganizationNotEmpty   = errors.New("the organization still has users or machines")
+	DefaultOrganization    = errors.New("the default organization can't be deleted")
+	MachineNotFound        = errors.New("machine not found in any organization")
+)
+
+func find(query string, args ...interface{}) ([]*Organization, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orgs := make([]*Organization, 0)
+	for rows.Next() {
+		o := Organization{}
+		var servers string
+
+		err = rows.Scan(&o.Id, &o.Name, &o.LDAPOU, &o.WindowsDomain, &servers)
+		if err != nil {
+			return nil, err
+		}
+		o.ExecutionServers = splitServers(servers)
+		orgs = append(orgs, &o)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return orgs, nil
+}
+
+func FindAll() ([]*Organization, error) {
+	return find(
+		`SELECT id, name, ldap_ou, windows_domain, execution_servers
+		FROM organizations
+		ORDER BY name`,
+	)
+}
+
+// GetOrganization returns nil if the organization doesn't exist.
+func GetOrganization(id string) (*Organization, error) {
+	orgs, err := find(
+		`SELECT id, name, ldap_ou, windows_domain, execution_servers
+		FROM organizations
+		WHERE id = $1::varchar`,
+		id,
+	)
+	if err != nil || len(orgs) == 0 {
+		return nil, err
+	}
+	return orgs[0], nil
+}
+
+func nameTaken(name string, id string) (bool, error) {
+	rows, err := db.Query(
+		`SELECT id FROM organizations
+		WHERE name = $1::varchar AND id != $2::varchar`,
+		name, id,
+	)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	return rows.Next(), nil
+}
+
+// CreateOrganization creates an organization with its desktop app.
+func CreateOrganization(o *Organization) (*Organization, error) {
+	taken, err := nameTaken(o.Name, "")
+	if err != nil {
+		return nil, err
+	}
+	if taken {
+		return nil, OrganizationDuplicated
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	id := uuid.NewV4().String()
+	_, err = tx.Exec(
+		`INSERT INTO organizations
+		(id, name, ldap_ou, windows_domain, execution_servers)
+		VALUES ($1::varchar, $2::varchar, $3::varchar, $4::varchar, $5::text)`,
+		id, o.Name, o.LDAPOU, o.WindowsDomain, strings.Join(o.ExecutionServers, ","),
+	)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO apps
+		(id, collection_name, alias, display_name, file_path, icon_content, organization_id)
+		VALUES ($1::varchar, '', 'Desktop', 'Desktop', 'C:\\Windows\\explorer.exe', NULL, $2::varchar)`,
+		uuid.NewV4().String(), id,
+	)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return GetOrganization(id)
+}
+
+func (o *Organization) Update() error {
+	taken, err := nameTaken(o.Name, o.Id)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return OrganizationDuplicated
+	}
+
+	res, err := db.Exec(
+		`UPDATE organizations
+		SET name = $2::varchar, ldap_ou = $3::varchar,
+		windows_domain = $4::varchar, execution_servers = $5::text
+		WHERE id = $1::varchar`,
+		o.Id, o.Name, o.LDAPOU, o.WindowsDomain, strings.Join(o.ExecutionServers, ","),
+	)
+	if err != nil {
+		return err
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return OrganizationNotFound
+	}
+	return nil
+}
+
+func owns(table string, id string) (bool, error) {
+	rows, err := db.Query(
+		`SELECT organization_id FROM `+table+`
+		WHERE organization_id = $1::varchar LIMIT 1`,
+		id,
+	)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	return rows.Next(), nil
+}
+
+// HasMachines returns whether machines belong to the organization.
+func (o *Organization) HasMachines() (bool, error) {
+	return owns("machines_organizations", o.Id)
+}
+
+// Delete removes an organization along with its apps, groups, roles and
+// histories. Organizations with users or machines can't be deleted, they have
+// to be deleted first.
+func (o *Organization) Delete() error {
+	if o.Id == Default {
+		return DefaultOrganization
+	}
+
+	for _, table := range []string{"users", "machines_organizations"} {
+		notEmpty, err := owns(table, o.Id)
+		if err != nil {
+			return err
+		}
+		if notEmpty {
+			return OrganizationNotEmpty
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, table := range []string{"histories", "apps", "groups"} {
+		_, err = tx.Exec(
+			`DELETE FROM `+table+` WHERE organization_id = $1::varchar`,
+			o.Id,
+		)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	res, err := tx.Exec(
+		`DELETE FROM organizations WHERE id = $1::varchar`,
+		o.Id,
+	)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if deleted == 0 {
+		tx.Rollback()
+		return OrganizationNotFound
+	}
+	return tx.Commit()
+}
+
+// ExecutionServers returns the execution servers of every organization.
+func ExecutionServers() ([]string, error) {
+	orgs, err := FindAll()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	rt := make([]string, 0)
+	for _, o := range orgs {
+		for _, server := range o.Servers() {
+			if !seen[server] {
+				seen[server] = true
+				rt = append(rt, server)
+			}
+		}
+	}
+	return rt, nil
+}
+
+// MachineOrganization returns the id of the organization owning a machine, or
+// MachineNotFound if none does.
+func MachineOrganization(machineId string) (string, error) {
+	rows, err := db.Query(
+		`SELECT organization_id FROM machines_organizations
+		WHERE machine_id = $1::varchar`,
+		machineId,
+	)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		err = rows.Err()
+		if err == nil {
+			err = MachineNotFound
+		}
+		return "", err
+	}
+
+	var id string
+	err = rows.Scan(&id)
+	return id, err
+}
+
+// SetMachineOrganization gives a machine to an organization.
+func SetMachineOrganization(machineId string, organizationId string) error {
+	_, err := db.Exec(
+		`INSERT INTO machines_organizations (machine_id, organization_id)
+		VALUES ($1::varchar, $2::varchar)
+		ON CONFLICT (machine_id)
+		DO UPDATE SET organization_id = EXCLUDED.organization_id`,
+		machineId, organizationId,
+	)
+	return err
+}
+
+// AdoptMachines gives the machines which belong to no organization yet to the
+// default one.
+func AdoptMachines(machineIds []string) error {
+	for _, id := range machineIds {
+		_, err := db.Exec(
+			`INSERT INTO machines_organizations (machine_id, organization_id)
+			VALUES ($1::varchar, $2::varchar)
+			ON CONFLICT (machine_id) DO NOTHING`,
+			id, Default,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ForgetMachine(machineId string) error {
+	_, err := db.Exec(
+		`DELETE FROM machines_organizations WHERE machine_id = $1::varchar`,
+		machineId,
+	)
+	return err
+}
@@ -0,0 +1,152 @@
+package organizations
+
+import (
+	"os"
+	"testing"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+)
+
+var created *Organization
+
+func TestServers(t *testing.T) {
+	os.Setenv("EXECUTION_SERVERS", "10.0.0.1, 10.0.0.2")
+	defer os.Unsetenv("EXECUTION_SERVERS")
+
+	o := &Organization{}
+	servers := o.Servers()
+	if len(servers) != 2 || servers[0] != "10.0.0.1" || servers[1] != "10.0.0.2" {
+		t.Errorf("Organizations without servers should use EXECUTION_SERVERS, got %v", servers)
+	}
+
+	o.ExecutionServers = []string{"10.0.1.1"}
+	servers = o.Servers()
+	if len(servers) != 1 || servers[0] != "10.0.1.1" {
+		t.Errorf("The pool of the organization should be used, got %v", servers)
+	}
+}
+
+func TestDefault(t *testing.T) {
+	o, err := GetOrganization(Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o == nil {
+		t.Fatal("The default organization should exist")
+	}
+	if o.Delete() != DefaultOrganization {
+		t.Errorf("The default organization should not be deletable")
+	}
+}
+
+func TestCreateOrganization(t *testing.T) {
+	o, err := CreateOrganization(&Organization{
+		Name:             "acme",
+		LDAPOU:           "OU=Acme,DC=intra,DC=localdomain,DC=com",
+		WindowsDomain:    "acme.localdomain.com",
+		ExecutionServers: []string{"10.0.1.1", "10.0.1.2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	switch {
+	case o.Id == "" || o.Id == Default:
+		t.Errorf("'Id' field should be generated")
+	case o.Name != "acme":
+		t.Errorf("'Name' field doesn't match the inserted value")
+	case o.Domain() != "acme.localdomain.com":
+		t.Errorf("'WindowsDomain' field doesn't match the inserted value")
+	case len(o.ExecutionServers) != 2:
+		t.Errorf("'ExecutionServers' field doesn't match the inserted value: %v", o.ExecutionServers)
+	}
+	created = o
+
+	rows, err := db.Query(
+		`SELECT id FROM apps
+		WHERE alias = 'Desktop' AND organization_id = $1::varchar`,
+		o.Id,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rows.Next() {
+		t.Errorf("The organization should have its desktop")
+	}
+	rows.Close()
+
+	_, err = CreateOrganization(&Organization{Name: "acme"})
+	if err != OrganizationDuplicated {
+		t.Errorf("Organization names should be unique, got %v", err)
+	}
+}
+
+func TestUpdateOrganization(t *testing.T) {
+	created.ExecutionServers = nil
+	err := created.Update()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o, err := GetOrganization(created.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o == nil || len(o.ExecutionServers) != 0 {
+		t.Errorf("The pool of the organization should be emptied, got %+v", o)
+	}
+}
+
+func TestMachineOrganization(t *testing.T) {
+	_, err := MachineOrganization("manual:unknown")
+	if err != MachineNotFound {
+		t.Errorf("Unknown machines should belong to no organization, got %v", err)
+	}
+
+	err = SetMachineOrganization("manual:unknown", created.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	org, err := MachineOrganization("manual:unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if org != created.Id {
+		t.Errorf("The machine should belong to the organization, got %s", org)
+	}
+
+	err = AdoptMachines([]string{"manual:unknown", "manual:adopted"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	org, err = MachineOrganization("manual:unknown")
+	if err != nil || org != created.Id {
+		t.Errorf("Adopting a machine shouldn't change its organization, got %s, %v", org, err)
+	}
+	org, err = MachineOrganization("manual:adopted")
+	if err != nil || org != Default {
+		t.Errorf("Adopted machines should belong to the default organization, got %s, %v", org, err)
+	}
+
+	if created.Delete() != OrganizationNotEmpty {
+		t.Errorf("Organizations with machines shouldn't be deleted")
+	}
+
+	for _, id := range []string{"manual:unknown", "manual:adopted"} {
+		err = ForgetMachine(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDeleteOrganization(t *testing.T) {
+	err := created.Delete()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = created.Delete()
+	if err != OrganizationNotFound {
+		t.Errorf("Deleting a deleted organization should fail with OrganizationNotFound, got %v", err)
+	}
+}
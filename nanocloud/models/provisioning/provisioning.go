@@ -0,0 +1,134 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package provisioning
+
+import (
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/provisioner"
+)
+
+// Store persists the provisioning steps state of a machine.
+type Store struct {
+	MachineId string
+}
+
+func NewStore(machineId string) *Store {
+	return &Store{MachineId: machineId}
+}
+
+func (s *Store) Get(step string) (*provisioner.StepState, error) {
+	rows, err := db.Query(
+		`SELECT step, position, state, attempts, error
+		FROM provisioning_steps
+		WHERE machine_id = $1::varchar AND step = $2::varchar`,
+		s.MachineId, step,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+
+	state := provisioner.StepState{}
+	err = rows.Scan(&state.Step, &state.Position, &state.State, &state.Attempts, &state.Error)
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+func (s *Store) Set(state *provisioner.StepState) error {
+	res, err := db.Exec(
+		`UPDATE provisioning_steps
+		SET state = $3::varchar, attempts = $4::integer, error = $5::text,
+		position = $6::integer, updated_at = current_timestamp
+		WHERE machine_id = $1::varchar AND step = $2::varchar`,
+		s.MachineId, state.Step,
+		state.State, state.Attempts, state.Error, state.Position,
+	)
+	if err != nil {
+		return err
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated > 0 {
+		return nil
+	}
+
+	_, err = db.Exec(
+		`INSERT INTO provisioning_steps
+		(machine_id, step, state, attempts, error, position)
+		VALUES ($1::varchar, $2::varchar, $3::varchar, $4::integer, $5::text, $6::integer)`,
+		s.MachineId, state.Step,
+		state.State, state.Attempts, state.Error, state.Position,
+	)
+	return err
+}
+
+// Steps returns the state of every step already run on the machine, in the
+// order they run.
+func (s *Store) Steps() ([]*provisioner.StepState, error) {
+	rows, err := db.Query(
+		`SELECT step, position, state, attempts, error
+		FROM provisioning_steps
+		WHERE machine_id = $1::varchar
+		ORDER BY position, step`,
+		s.MachineId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	steps := make([]*provisioner.StepState, 0)
+	for rows.Next() {
+		state := provisioner.StepState{}
+		err = rows.Scan(&state.Step, &state.Position, &state.State, &state.Attempts, &state.Error)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, &state)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return steps, nil
+}
+
+// Reset forgets the steps state so the next provisioning starts over.
+func (s *Store) Reset() error {
+	_, err := db.Exec(
+		`DELETE FROM provisioning_steps
+		WHERE machine_id = $1::varchar`,
+		s.MachineId,
+	)
+	return err
+}
@@ -0,0 +1,95 @@
+package provisioning
+
+import (
+	"testing"
+
+	"github.com/Nanocloud/community/nanocloud/provisioner"
+)
+
+var store = NewStore("test:provisioning-machine")
+
+func TestSetStep(t *testing.T) {
+	err := store.Reset()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := store.Get("step-one")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != nil {
+		t.Errorf("Steps never run should have no state")
+	}
+
+	err = store.Set(&provisioner.StepState{
+		Step:     "step-one",
+		State:    provisioner.StepRunning,
+		Attempts: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Set(&provisioner.StepState{
+		Step:     "step-one",
+		Position: 1,
+		State:    provisioner.StepFailed,
+		Attempts: 2,
+		Error:    "fake-generated-error",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, err = store.Get("step-one")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	switch {
+	case state == nil:
+		t.Fatalf("Step state not saved")
+	case state.State != provisioner.StepFailed:
+		t.Errorf("'State' field doesn't match the inserted value")
+	case state.Position != 1:
+		t.Errorf("'Position' field doesn't match the inserted value")
+	case state.Attempts != 2:
+		t.Errorf("'Attempts' field doesn't match the inserted value")
+	case state.Error != "fake-generated-error":
+		t.Errorf("'Error' field doesn't match the inserted value")
+	}
+}
+
+func TestSteps(t *testing.T) {
+	err := store.Set(&provisioner.StepState{
+		Step:     "step-zero",
+		Position: 0,
+		State:    provisioner.StepDone,
+		Attempts: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps, err := store.Steps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 2 || steps[0].Step != "step-zero" || steps[1].Step != "step-one" {
+		t.Errorf("Steps should list the saved steps in their order")
+	}
+
+	err = store.Reset()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps, err = store.Steps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 0 {
+		t.Errorf("Reset should remove every step state")
+	}
+}
@@ -0,0 +1,136 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package roles
+
+import "strings"
+
+// Permissions are named after the resource they apply to and the action they
+// allow.
+const (
+	// AppsRead allows to see and open every application, whatever the
+	// assignments.
+	AppsRead    = "apps:read"
+	AppsPublish = "apps:publish"
+	AppsAssign  = "apps:assign"
+
+	GroupsRead  = "groups:read"
+	GroupsWrite = "groups:write"
+
+	HistoriesRead = "histories:read"
+
+	MachinesRead      = "machines:read"
+	MachinesWrite     = "machines:write"
+	MachinesPower     = "machines:power"
+	MachinesProvision = "machines:provision"
+
+	OAuthClientsRead  = "oauth-clients:read"
+	OAuthClientsWrite = "oauth-clients:write"
+
+	// OrganizationsRead and OrganizationsWrite only apply to the members
+	// of the default organization, which manages the others.
+	OrganizationsRead  = "organizations:read"
+	OrganizationsWrite = "organizations:write"
+
+	RolesRead  = "roles:read"
+	RolesWrite = "roles:write"
+
+	ServiceAccountsRead  = "service-accounts:read"
+	ServiceAccountsWrite = "service-accounts:write"
+
+	UsersRead  = "users:read"
+	UsersWrite = "users:write"
+
+	// All grants every permission.
+	All = "*"
+)
+
+// Permissions lists the permissions roles can be given.
+var Permissions = []string{
+	AppsRead, AppsPublish, AppsAssign,
+	GroupsRead, GroupsWrite,
+	HistoriesRead,
+	MachinesRead, MachinesWrite, MachinesPower, MachinesProvision,
+	OAuthClientsRead, OAuthClientsWrite,
+	OrganizationsRead, OrganizationsWrite,
+	RolesRead, RolesWrite,
+	ServiceAccountsRead, ServiceAccountsWrite,
+	UsersRead, UsersWrite,
+}
+
+// Scopes which aren't permissions: every user holds them, but an access token
+// only grants them when its scope does.
+const (
+	// FilesScope allows to download and upload the files of the user.
+	FilesScope = "files"
+
+	// SessionsScope allows to list and open the apps of the user, to list
+	// and close their sessions and to record their history.
+	SessionsScope = "sessions"
+)
+
+// UserScopes lists the scopes every user holds.
+var UserScopes = []string{FilesScope, SessionsScope}
+
+// ValidScope returns whether scope is a valid permission or one of
+// UserScopes.
+func ValidScope(scope string) bool {
+	for _, s := range UserScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return Valid(scope)
+}
+
+// Valid returns whether permission is known or is a wildcard, such as "*" or
+// "machines:*".
+func Valid(permission string) bool {
+	if permission == All {
+		return true
+	}
+
+	for _, p := range Permissions {
+		if p == permission {
+			return true
+		}
+		if strings.HasSuffix(permission, ":*") &&
+			strings.HasPrefix(p, strings.TrimSuffix(permission, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// Has returns whether permission is granted by one of granted.
+func Has(granted []string, permission string) bool {
+	for _, p := range granted {
+		if p == permission || p == All {
+			return true
+		}
+		if strings.HasSuffix(p, ":*") &&
+			strings.HasPrefix(permission, strings.TrimSuffix(p, "*")) {
+			return true
+		}
+	}
+	return false
+}
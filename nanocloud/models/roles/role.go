@@ -0,0 +1,48 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package roles
+
+// Administrator is the id of the built-in role granting every permission.
+const Administrator = "administrator"
+
+// Role is a named set of permissions given to users, directly or through
+// their groups. Built-in roles can't be changed and are shared by every
+// organization, the others belong to one.
+type Role struct {
+	Id          string   `json:"-"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Builtin     bool     `json:"builtin"`
+	Permissions []string `json:"permissions"`
+
+	OrganizationId string `json:"-"`
+}
+
+func (r *Role) GetID() string {
+	return r.Id
+}
+
+func (r *Role) SetID(id string) error {
+	r.Id = id
+	return nil
+}
@@ -0,0 +1,412 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package roles
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	uuid "github.com/satori/go.uuid"
+)
+
+var (
+	RoleNotFound       = errors.New("role not found")
+	RoleDuplicated     = errors.New("a role with this name already exists")
+	BuiltinRole        = errors.New("built-in roles can't be changed")
+	InvalidPermission  = errors.New("unknown permission")
+	AssignmentNotFound = errors.New("the role is not assigned to this user or group")
+)
+
+// kUserRoles selects the ids of the roles of the user $1, given directly or
+// through one of their groups.
+const kUserRoles = `SELECT role_id
+	FROM users_roles
+	WHERE user_id = $1::varchar
+	UNION
+	SELECT groups_roles.role_id
+	FROM groups_roles
+	JOIN users_groups
+		ON users_groups.group_id = groups_roles.group_id
+	WHERE users_groups.user_id = $1::varchar`
+
+func selectStrings(query string, args ...interface{}) ([]string, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rt := make([]string, 0)
+	for rows.Next() {
+		var s string
+		err = rows.Scan(&s)
+		if err != nil {
+			return nil, err
+		}
+		rt = append(rt, s)
+	}
+	return rt, rows.Err()
+}
+
+func find(query string, args ...interface{}) ([]*Role, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	roles := make([]*Role, 0)
+	for rows.Next() {
+		r := Role{}
+		err = rows.Scan(&r.Id, &r.Name, &r.Description, &r.Builtin, &r.OrganizationId)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		roles = append(roles, &r)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range roles {
+		r.Permissions, err = selectStrings(
+			`SELECT permission FROM roles_permissions
+			WHERE role_id = $1::varchar
+			ORDER BY permission`,
+			r.Id,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return roles, nil
+}
+
+// kColumns are the columns find expects. Built-in roles have no organization.
+const kColumns = `id, name, description, builtin, COALESCE(organization_id, '')`
+
+// FindAll returns the built-in roles and the roles of an organization.
+func FindAll(organizationId string) ([]*Role, error) {
+	return find(
+		`SELECT `+kColumns+`
+		FROM roles
+		WHERE organization_id IS NULL OR organization_id = $1::varchar
+		ORDER BY name`,
+		organizationId,
+	)
+}
+
+// GetRole returns nil if the role doesn't exist.
+func GetRole(id string) (*Role, error) {
+	roles, err := find(
+		`SELECT `+kColumns+`
+		FROM roles
+		WHERE id = $1::varchar`,
+		id,
+	)
+	if err != nil || len(roles) == 0 {
+		return nil, err
+	}
+	return roles[0], nil
+}
+
+// UserRoles returns the roles of a user, including the ones they get through
+// their groups.
+func UserRoles(userId string) ([]*Role, error) {
+	return find(
+		`SELECT `+kColumns+`
+		FROM roles
+		WHERE id IN (`+kUserRoles+`)
+		ORDER BY name`,
+		userId,
+	)
+}
+
+// nameTaken returns whether a built-in role or another role of the
+// organization has this name.
+func nameTaken(organizationId string, name string, id string) (bool, error) {
+	rows, err := db.Query(
+		`SELECT id FROM roles
+		WHERE name = $1::varchar AND id != $2::varchar
+		AND (organization_id IS NULL OR organization_id = $3::varchar)`,
+		name, id, organizationId,
+	)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	return rows.Next(), nil
+}
+
+func setPermissions(tx *sql.Tx, id string, permissions []string) error {
+	_, err := tx.Exec(
+		`DELETE FROM roles_permissions WHERE role_id = $1::varchar`,
+		id,
+	)
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool, len(permissions))
+	for _, p := range permissions {
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+
+		_, err = tx.Exec(
+			`INSERT INTO roles_permissions (role_id, permission)
+			VALUES ($1::varchar, $2::varchar)`,
+			id, p,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validate(r *Role) error {
+	taken, err := nameTaken(r.OrganizationId, r.Name, r.Id)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return RoleDuplicated
+	}
+
+	for _, p := range r.Permissions {
+		if !Valid(p) {
+			return InvalidPermission
+		}
+	}
+	return nil
+}
+
+// save runs query, which must write the role, and replaces its permissions in
+// a single transaction.
+func save(r *Role, query string) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := tx.Exec(query, r.Id, r.Name, r.Description, r.OrganizationId)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	saved, err := res.RowsAffected()
+	if err != nil || saved == 0 {
+		tx.Rollback()
+		return 0, err
+	}
+
+	err = setPermissions(tx, r.Id, r.Permissions)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	return saved, tx.Commit()
+}
+
+func CreateRole(organizationId string, name string, description string, permissions []string) (*Role, error) {
+	r := &Role{
+		Id:             uuid.NewV4().String(),
+		Name:           name,
+		Description:    description,
+		Permissions:    permissions,
+		OrganizationId: organizationId,
+	}
+
+	err := validate(r)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = save(r,
+		`INSERT INTO roles
+		(id, name, description, organization_id)
+		VALUES ($1::varchar, $2::varchar, $3::text, $4::varchar)`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return GetRole(r.Id)
+}
+
+func (r *Role) Update() error {
+	if r.Builtin {
+		return BuiltinRole
+	}
+
+	err := validate(r)
+	if err != nil {
+		return err
+	}
+
+	updated, err := save(r,
+		`UPDATE roles
+		SET name = $2::varchar, description = $3::text
+		WHERE id = $1::varchar AND organization_id = $4::varchar
+		AND NOT builtin`,
+	)
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return RoleNotFound
+	}
+	return nil
+}
+
+// Delete removes the role and its assignments.
+func (r *Role) Delete() error {
+	if r.Builtin {
+		return BuiltinRole
+	}
+
+	res, err := db.Exec(
+		`DELETE FROM roles
+		WHERE id = $1::varchar AND NOT builtin`,
+		r.Id,
+	)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return RoleNotFound
+	}
+	return nil
+}
+
+func assign(table string, column string, roleId string, id string) error {
+	_, err := db.Exec(
+		`INSERT INTO `+table+` (role_id, `+column+`)
+		SELECT $1::varchar, $2::varchar
+		WHERE NOT EXISTS (
+			SELECT 1 FROM `+table+`
+			WHERE role_id = $1::varchar AND `+column+` = $2::varchar
+		)`,
+		roleId, id,
+	)
+	return err
+}
+
+func unassign(table string, column string, roleId string, id string) error {
+	res, err := db.Exec(
+		`DELETE FROM `+table+`
+		WHERE role_id = $1::varchar AND `+column+` = $2::varchar`,
+		roleId, id,
+	)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return AssignmentNotFound
+	}
+	return nil
+}
+
+// AssignUser gives a role to a user. Assigning it twice is a no-op.
+func AssignUser(roleId string, userId string) error {
+	return assign("users_roles", "user_id", roleId, userId)
+}
+
+// UnassignUser takes a role back from a user. The role is kept if the user
+// gets it through one of their groups.
+func UnassignUser(roleId string, userId string) error {
+	return unassign("users_roles", "user_id", roleId, userId)
+}
+
+// RoleUsers returns the ids of the users of the organization the role is
+// directly assigned to.
+func RoleUsers(roleId string, organizationId string) ([]string, error) {
+	return selectStrings(
+		`SELECT users_roles.user_id
+		FROM users_roles
+		JOIN users
+			ON users.id = users_roles.user_id
+		WHERE users_roles.role_id = $1::varchar
+		AND users.organization_id = $2::varchar`,
+		roleId, organizationId,
+	)
+}
+
+// AssignGroup gives a role to every member of a group.
+func AssignGroup(roleId string, groupId string) error {
+	return assign("groups_roles", "group_id", roleId, groupId)
+}
+
+func UnassignGroup(roleId string, groupId string) error {
+	return unassign("groups_roles", "group_id", roleId, groupId)
+}
+
+// RoleGroups returns the ids of the groups of the organization the role is
+// assigned to.
+func RoleGroups(roleId string, organizationId string) ([]string, error) {
+	return selectStrings(
+		`SELECT groups_roles.group_id
+		FROM groups_roles
+		JOIN groups
+			ON groups.id = groups_roles.group_id
+		WHERE groups_roles.role_id = $1::varchar
+		AND groups.organization_id = $2::varchar`,
+		roleId, organizationId,
+	)
+}
+
+// UserPermissions returns the permissions a user gets from all their roles.
+func UserPermissions(userId string) ([]string, error) {
+	return selectStrings(
+		`SELECT DISTINCT permission
+		FROM roles_permissions
+		WHERE role_id IN (`+kUserRoles+`)
+		ORDER BY permission`,
+		userId,
+	)
+}
+
+// UserCan returns whether a user has a permission.
+func UserCan(userId string, permission string) (bool, error) {
+	permissions, err := UserPermissions(userId)
+	if err != nil {
+		return false, err
+	}
+	return Has(permissions, permission), nil
+}
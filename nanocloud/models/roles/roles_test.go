@@ -0,0 +1,206 @@
+package roles
+
+import (
+	"testing"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/models/organizations"
+	uuid "github.com/satori/go.uuid"
+)
+
+var created *Role
+
+func TestHas(t *testing.T) {
+	tests := []struct {
+		granted    []string
+		permission string
+		expected   bool
+	}{
+		{[]string{UsersRead}, UsersRead, true},
+		{[]string{UsersRead}, UsersWrite, false},
+		{[]string{All}, MachinesPower, true},
+		{[]string{"machines:*"}, MachinesPower, true},
+		{[]string{"machines:*"}, AppsPublish, false},
+		{nil, AppsRead, false},
+	}
+
+	for _, test := range tests {
+		if Has(test.granted, test.permission) != test.expected {
+			t.Errorf("Has(%v, %s) should be %v", test.granted, test.permission, test.expected)
+		}
+	}
+}
+
+func TestValid(t *testing.T) {
+	for _, p := range []string{AppsPublish, All, "machines:*"} {
+		if !Valid(p) {
+			t.Errorf("%s should be valid", p)
+		}
+	}
+	for _, p := range []string{"", "apps", "apps:launch", "foo:*"} {
+		if Valid(p) {
+			t.Errorf("%s should not be valid", p)
+		}
+	}
+}
+
+func TestValidScope(t *testing.T) {
+	for _, s := range []string{FilesScope, SessionsScope, AppsPublish, All} {
+		if !ValidScope(s) {
+			t.Errorf("%s should be a valid scope", s)
+		}
+	}
+	if ValidScope("sessions:*") || Valid(SessionsScope) {
+		t.Errorf("The scopes of every user should not be permissions")
+	}
+}
+
+func TestAdministrator(t *testing.T) {
+	r, err := GetRole(Administrator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r == nil || !r.Builtin || !Has(r.Permissions, UsersWrite) {
+		t.Fatalf("The built-in administrator role should grant every permission, got %+v", r)
+	}
+
+	r.Permissions = []string{}
+	if r.Update() != BuiltinRole {
+		t.Errorf("Built-in roles should not be updatable")
+	}
+	if r.Delete() != BuiltinRole {
+		t.Errorf("Built-in roles should not be deletable")
+	}
+}
+
+func TestCreateRole(t *testing.T) {
+	r, err := CreateRole(organizations.Default, "operator", "Runs the machines", []string{MachinesRead, MachinesPower})
+	if err != nil {
+		t.Fatal(err)
+	}
+	switch {
+	case r.Id == "":
+		t.Errorf("'Id' field should be generated")
+	case r.Name != "operator":
+		t.Errorf("'Name' field doesn't match the inserted value")
+	case r.Builtin:
+		t.Errorf("Created roles should not be built-in")
+	case len(r.Permissions) != 2:
+		t.Errorf("'Permissions' field doesn't match the inserted value: %v", r.Permissions)
+	}
+	created = r
+
+	_, err = CreateRole(organizations.Default, "operator", "", nil)
+	if err != RoleDuplicated {
+		t.Errorf("Role names should be unique, got %v", err)
+	}
+
+	_, err = CreateRole(organizations.Default, "invalid", "", []string{"apps:launch"})
+	if err != InvalidPermission {
+		t.Errorf("Unknown permissions should be rejected, got %v", err)
+	}
+}
+
+func TestUpdateRole(t *testing.T) {
+	created.Permissions = []string{"machines:*"}
+	err := created.Update()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := GetRole(created.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Permissions) != 1 || r.Permissions[0] != "machines:*" {
+		t.Errorf("Permissions should be replaced, got %v", r.Permissions)
+	}
+}
+
+func TestUserPermissions(t *testing.T) {
+	userId := uuid.NewV4().String()
+	groupId := uuid.NewV4().String()
+
+	_, err := db.Exec(
+		`INSERT INTO users (id, email, activated) VALUES ($1::varchar, $2::varchar, true)`,
+		userId, userId[:8]+"@nanocloud.com",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec(`DELETE FROM users WHERE id = $1::varchar`, userId)
+
+	_, err = db.Exec(
+		`INSERT INTO groups (id, name) VALUES ($1::varchar, $1::varchar)`,
+		groupId,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec(`DELETE FROM groups WHERE id = $1::varchar`, groupId)
+
+	can, err := UserCan(userId, MachinesPower)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if can {
+		t.Errorf("Users without roles should have no permission")
+	}
+
+	err = AssignGroup(created.Id, groupId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(
+		`INSERT INTO users_groups (user_id, group_id) VALUES ($1::varchar, $2::varchar)`,
+		userId, groupId,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	can, err = UserCan(userId, MachinesPower)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !can {
+		t.Errorf("Users should get the permissions of the roles of their groups")
+	}
+
+	err = AssignUser(Administrator, userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = AssignUser(Administrator, userId)
+	if err != nil {
+		t.Errorf("Assigning a role twice should not fail: %s", err)
+	}
+
+	can, err = UserCan(userId, UsersWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !can {
+		t.Errorf("Administrators should have every permission")
+	}
+
+	err = UnassignUser(Administrator, userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = UnassignUser(Administrator, userId)
+	if err != AssignmentNotFound {
+		t.Errorf("Unassigning an unassigned role should fail with AssignmentNotFound, got %v", err)
+	}
+}
+
+func TestDeleteRole(t *testing.T) {
+	err := created.Delete()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = created.Delete()
+	if err != RoleNotFound {
+		t.Errorf("Deleting a deleted role should fail with RoleNotFound, got %v", err)
+	}
+}
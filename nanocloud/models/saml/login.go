@@ -0,0 +1,280 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/models/directory"
+	"github.com/Nanocloud/community/nanocloud/models/organizations"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	log "github.com/Sirupsen/logrus"
+	"github.com/beevik/etree"
+)
+
+// requestId returns a random identifier for an authentication request. XML
+// identifiers can't start with a digit.
+func requestId() (string, error) {
+	b := make([]byte, 20)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return "_" + hex.EncodeToString(b), nil
+}
+
+// Request is an authentication request sent to a provider and not answered
+// yet.
+type Request struct {
+	Id       string
+	Provider string
+	Redirect string
+}
+
+// authnRequest returns the AuthnRequest id, XML encoded.
+func (p *Provider) authnRequest(id string, entityId string, acsURL string, now time.Time) ([]byte, error) {
+	doc := etree.NewDocument()
+	req := doc.CreateElement("samlp:AuthnRequest")
+	req.CreateAttr("xmlns:samlp", kProtocolNamespace)
+	req.CreateAttr("xmlns:saml", kAssertionNamespace)
+	req.CreateAttr("ID", id)
+	req.CreateAttr("Version", "2.0")
+	req.CreateAttr("IssueInstant", now.UTC().Format(time.RFC3339))
+	req.CreateAttr("Destination", p.IdPSSOURL)
+	req.CreateAttr("ProtocolBinding", kPostBinding)
+	req.CreateAttr("AssertionConsumerServiceURL", acsURL)
+	req.CreateElement("saml:Issuer").SetText(entityId)
+	policy := req.CreateElement("samlp:NameIDPolicy")
+	policy.CreateAttr("AllowCreate", "true")
+	return doc.WriteToBytes()
+}
+
+// AuthnRequestURL starts a login and returns the URL of the provider to
+// redirect the user to, with the HTTP-Redirect binding. redirect is where the
+// user goes once logged in.
+func (p *Provider) AuthnRequestURL(entityId string, acsURL string, redirect string) (string, error) {
+	id, err := requestId()
+	if err != nil {
+		return "", err
+	}
+
+	req, err := p.authnRequest(id, entityId, acsURL, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	var b bytes.Buffer
+	w, err := flate.NewWriter(&b, flate.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	w.Write(req)
+	w.Close()
+
+	db.Exec(`DELETE FROM saml_requests WHERE expires_at < NOW()`)
+	_, err = db.Exec(
+		`INSERT INTO saml_requests (id, provider, redirect, expires_at)
+		VALUES ($1::varchar, $2::varchar, $3::varchar,
+			NOW() + interval '10 minutes')`,
+		id, p.Name, redirect,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{
+		"SAMLRequest": {base64.StdEncoding.EncodeToString(b.Bytes())},
+	}
+	sep := "?"
+	if strings.Contains(p.IdPSSOURL, "?") {
+		sep = "&"
+	}
+	return p.IdPSSOURL + sep + query.Encode(), nil
+}
+
+// TakeRequest returns the request of the provider an assertion answers,
+// which can only be taken once. It returns InvalidRequest if there is none.
+func (p *Provider) TakeRequest(id string) (*Request, error) {
+	rows, err := db.Query(
+		`DELETE FROM saml_requests
+		WHERE id = $1::varchar AND provider = $2::varchar
+		AND expires_at > NOW()
+		RETURNING id, provider, redirect`,
+		id, p.Name,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, InvalidRequest
+	}
+
+	req := Request{}
+	err = rows.Scan(&req.Id, &req.Provider, &req.Redirect)
+	if err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+// email returns the email of the user of the assertion, from its attributes
+// or its name identifier.
+func (p *Provider) email(a *Assertion) string {
+	email := a.Attribute(p.EmailAttributes)
+	if email == "" && (a.NameIdFormat == kNameIdEmail || strings.Contains(a.NameId, "@")) {
+		email = a.NameId
+	}
+	return strings.TrimSpace(email)
+}
+
+// isAdmin returns whether the assertion grants the administrator role, and
+// whether the provider decides of it at all.
+func (p *Provider) isAdmin(a *Assertion) (bool, bool) {
+	if p.AdminAttribute == "" {
+		return false, false
+	}
+
+	for _, value := range a.Attributes[p.AdminAttribute] {
+		for _, admin := range p.AdminValues {
+			if strings.EqualFold(value, admin) {
+				return true, true
+			}
+		}
+	}
+	return false, true
+}
+
+// refresh updates the user with the attributes of the assertion.
+func (p *Provider) refresh(user *users.User, a *Assertion) error {
+	var err error
+
+	firstName := a.Attribute(p.FirstNameAttributes)
+	if firstName != "" && firstName != user.FirstName {
+		err = users.UpdateUserFirstName(user.Id, firstName)
+		if err != nil {
+			return err
+		}
+		user.FirstName = firstName
+	}
+
+	lastName := a.Attribute(p.LastNameAttributes)
+	if lastName != "" && lastName != user.LastName {
+		err = users.UpdateUserLastName(user.Id, lastName)
+		if err != nil {
+			return err
+		}
+		user.LastName = lastName
+	}
+
+	admin, decides := p.isAdmin(a)
+	if decides && admin != user.IsAdmin {
+		err = users.UpdateUserPrivilege(user.Id, admin)
+		if err != nil {
+			return err
+		}
+		user.IsAdmin = admin
+	}
+	return nil
+}
+
+// createUser creates the user of the assertion in the organization of the
+// provider.
+func (p *Provider) createUser(email string, a *Assertion) (*users.User, error) {
+	org, err := organizations.GetOrganization(p.OrganizationId)
+	if err != nil {
+		return nil, err
+	}
+	if org == nil {
+		return nil, fmt.Errorf("the organization %s of %s doesn't exist", p.OrganizationId, p.Name)
+	}
+
+	user, err := directory.CreateLocalUser(
+		org,
+		email,
+		a.Attribute(p.FirstNameAttributes),
+		a.Attribute(p.LastNameAttributes),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	log.WithFields(log.Fields{
+		"user":         user.Id,
+		"provider":     p.Name,
+		"organization": org.Id,
+	}).Info("SAML user created")
+	return user, nil
+}
+
+// User returns the user of the assertion: the user of the organization of
+// the provider with its email, who is created if there is none and the
+// provider creates users. Their names and administrator role are updated
+// with the attributes of the assertion.
+func (p *Provider) User(a *Assertion) (*users.User, error) {
+	email := p.email(a)
+	if email == "" {
+		return nil, EmailMissing
+	}
+
+	user, err := users.GetUserByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+
+	// the users of other organizations aren't the provider's to vouch for
+	if user != nil && user.OrganizationId != p.OrganizationId {
+		log.Errorf("%s asserted the email of a user of another organization", p.Name)
+		return nil, users.UserNotFound
+	}
+
+	if user == nil {
+		if !p.CreateUsers {
+			return nil, users.UserNotFound
+		}
+		user, err = p.createUser(email, a)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !user.Activated {
+		return nil, users.UserDisabled
+	}
+
+	err = p.refresh(user, a)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
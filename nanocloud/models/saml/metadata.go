@@ -0,0 +1,56 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package saml
+
+import (
+	"github.com/beevik/etree"
+)
+
+// Metadata returns the metadata of Nanocloud as the service provider of p,
+// to be imported in the identity provider. Nanocloud doesn't sign its
+// requests and wants signed assertions, posted to its assertion consumer
+// service.
+func (p *Provider) Metadata(entityId string, acsURL string) ([]byte, error) {
+	doc := etree.NewDocument()
+	doc.CreateProcInst("xml", `version="1.0" encoding="UTF-8"`)
+
+	entity := doc.CreateElement("md:EntityDescriptor")
+	entity.CreateAttr("xmlns:md", kMetadataNamespace)
+	entity.CreateAttr("entityID", entityId)
+
+	sp := entity.CreateElement("md:SPSSODescriptor")
+	sp.CreateAttr("AuthnRequestsSigned", "false")
+	sp.CreateAttr("WantAssertionsSigned", "true")
+	sp.CreateAttr("protocolSupportEnumeration", kProtocolNamespace)
+
+	sp.CreateElement("md:NameIDFormat").SetText(kNameIdEmail)
+
+	acs := sp.CreateElement("md:AssertionConsumerService")
+	acs.CreateAttr("Binding", kPostBinding)
+	acs.CreateAttr("Location", acsURL)
+	acs.CreateAttr("index", "0")
+	acs.CreateAttr("isDefault", "true")
+
+	doc.Indent(2)
+	return doc.WriteToBytes()
+}
@@ -0,0 +1,324 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package saml
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+// kClockSkew is the difference tolerated between the clocks of Nanocloud and
+// of the providers.
+const kClockSkew = 3 * time.Minute
+
+const (
+	kProtocolNamespace  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	kAssertionNamespace = "urn:oasis:names:tc:SAML:2.0:assertion"
+	kMetadataNamespace  = "urn:oasis:names:tc:SAML:2.0:metadata"
+	kPostBinding        = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+	kStatusSuccess      = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	kBearer             = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+	kNameIdEmail        = "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress"
+	kSignatureNamespace = "http://www.w3.org/2000/09/xmldsig#"
+)
+
+// response is the part of a Response Nanocloud uses. Elements are matched by
+// their local name as the namespace prefixes may be declared by an ancestor.
+type response struct {
+	InResponseTo string `xml:"InResponseTo,attr"`
+	Destination  string `xml:"Destination,attr"`
+	Issuer       string `xml:"Issuer"`
+	Status       struct {
+		StatusCode struct {
+			Value string `xml:"Value,attr"`
+		} `xml:"StatusCode"`
+	} `xml:"Status"`
+}
+
+// assertion is the part of an Assertion Nanocloud uses.
+type assertion struct {
+	Issuer  string `xml:"Issuer"`
+	Subject struct {
+		NameID struct {
+			Format string `xml:"Format,attr"`
+			Value  string `xml:",chardata"`
+		} `xml:"NameID"`
+		SubjectConfirmations []struct {
+			Method string `xml:"Method,attr"`
+			Data   struct {
+				InResponseTo string `xml:"InResponseTo,attr"`
+				Recipient    string `xml:"Recipient,attr"`
+				NotOnOrAfter string `xml:"NotOnOrAfter,attr"`
+			} `xml:"SubjectConfirmationData"`
+		} `xml:"SubjectConfirmation"`
+	} `xml:"Subject"`
+	Conditions *struct {
+		NotBefore            string `xml:"NotBefore,attr"`
+		NotOnOrAfter         string `xml:"NotOnOrAfter,attr"`
+		AudienceRestrictions []struct {
+			Audiences []string `xml:"Audience"`
+		} `xml:"AudienceRestriction"`
+	} `xml:"Conditions"`
+	AttributeStatements []struct {
+		Attributes []struct {
+			Name         string   `xml:"Name,attr"`
+			FriendlyName string   `xml:"FriendlyName,attr"`
+			Values       []string `xml:"AttributeValue"`
+		} `xml:"Attribute"`
+	} `xml:"AttributeStatement"`
+}
+
+// Assertion is what an identity provider asserts about a user, in response to
+// the request RequestId.
+type Assertion struct {
+	RequestId    string
+	NameId       string
+	NameIdFormat string
+	Attributes   map[string][]string
+}
+
+// Attribute returns the first value of the first of names the assertion has.
+func (a *Assertion) Attribute(names []string) string {
+	for _, name := range names {
+		values := a.Attributes[name]
+		if len(values) > 0 && values[0] != "" {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+// unmarshal decodes el into v.
+func unmarshal(el *etree.Element, v interface{}) error {
+	doc := etree.NewDocument()
+	doc.SetRoot(el.Copy())
+	b, err := doc.WriteToBytes()
+	if err != nil {
+		return err
+	}
+	return xml.Unmarshal(b, v)
+}
+
+// children returns the child elements of el named tag in the namespace ns.
+func children(el *etree.Element, ns string, tag string) []*etree.Element {
+	var l []*etree.Element
+	for _, child := range el.ChildElements() {
+		if child.Tag == tag && child.NamespaceURI() == ns {
+			l = append(l, child)
+		}
+	}
+	return l
+}
+
+// parseTime parses an xs:dateTime attribute, the zero time if it is empty.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+// signedAssertion returns the assertion of the response root, from the
+// element whose signature was verified: the response or the assertion
+// itself. Content outside of the signed element is never used, so that it
+// can't be swapped.
+func (p *Provider) signedAssertion(root *etree.Element, now time.Time) (*etree.Element, error) {
+	ctx := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{
+		Roots: p.Certificates,
+	})
+	ctx.Clock = dsig.NewFakeClockAt(now)
+
+	if len(children(root, kAssertionNamespace, "EncryptedAssertion")) > 0 {
+		return nil, fmt.Errorf("%s: encrypted assertions aren't supported", InvalidResponse)
+	}
+
+	signed := root
+	if len(children(root, kSignatureNamespace, "Signature")) > 0 {
+		var err error
+		signed, err = ctx.Validate(root)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", InvalidResponse, err)
+		}
+	}
+
+	assertions := children(signed, kAssertionNamespace, "Assertion")
+	if len(assertions) != 1 {
+		return nil, fmt.Errorf("%s: %d assertions", InvalidResponse, len(assertions))
+	}
+	el := assertions[0]
+
+	// an unsigned response needs a signed assertion
+	if signed == root || len(children(el, kSignatureNamespace, "Signature")) > 0 {
+		validated, err := ctx.Validate(el)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", InvalidResponse, err)
+		}
+		el = validated
+	}
+	return el, nil
+}
+
+// ParseResponse checks the base64 encoded SAMLResponse posted to acsURL, the
+// assertion consumer service of Nanocloud known as entityId, and returns its
+// assertion.
+func (p *Provider) ParseResponse(encoded string, entityId string, acsURL string) (*Assertion, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, InvalidResponse
+	}
+	return p.parseResponse(raw, entityId, acsURL, time.Now())
+}
+
+func (p *Provider) parseResponse(raw []byte, entityId string, acsURL string, now time.Time) (*Assertion, error) {
+	doc := etree.NewDocument()
+	err := doc.ReadFromBytes(raw)
+	if err != nil {
+		return nil, InvalidResponse
+	}
+
+	root := doc.Root()
+	if root == nil || root.Tag != "Response" || root.NamespaceURI() != kProtocolNamespace {
+		return nil, InvalidResponse
+	}
+
+	el, err := p.signedAssertion(root, now)
+	if err != nil {
+		return nil, err
+	}
+
+	res := response{}
+	err = unmarshal(root, &res)
+	if err != nil {
+		return nil, InvalidResponse
+	}
+	a := assertion{}
+	err = unmarshal(el, &a)
+	if err != nil {
+		return nil, InvalidResponse
+	}
+
+	if res.Status.StatusCode.Value != kStatusSuccess {
+		return nil, fmt.Errorf("the identity provider answered %s", res.Status.StatusCode.Value)
+	}
+	if res.Issuer != "" && strings.TrimSpace(res.Issuer) != p.IdPEntityId {
+		return nil, fmt.Errorf("%s: issued by %s", InvalidResponse, res.Issuer)
+	}
+	if res.Destination != "" && res.Destination != acsURL {
+		return nil, fmt.Errorf("%s: sent to %s", InvalidResponse, res.Destination)
+	}
+
+	err = p.validate(&a, entityId, acsURL, now)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", InvalidResponse, err)
+	}
+
+	requestId := ""
+	for _, c := range a.Subject.SubjectConfirmations {
+		if c.Method == kBearer && c.Data.Recipient == acsURL {
+			requestId = c.Data.InResponseTo
+		}
+	}
+	if res.InResponseTo != "" && res.InResponseTo != requestId {
+		return nil, fmt.Errorf("%s: in response to another request", InvalidResponse)
+	}
+
+	assertion := Assertion{
+		RequestId:    requestId,
+		NameId:       strings.TrimSpace(a.Subject.NameID.Value),
+		NameIdFormat: a.Subject.NameID.Format,
+		Attributes:   make(map[string][]string),
+	}
+	for _, statement := range a.AttributeStatements {
+		for _, attr := range statement.Attributes {
+			values := make([]string, len(attr.Values))
+			for i, v := range attr.Values {
+				values[i] = strings.TrimSpace(v)
+			}
+			assertion.Attributes[attr.Name] = append(assertion.Attributes[attr.Name], values...)
+			if attr.FriendlyName != "" && attr.FriendlyName != attr.Name {
+				assertion.Attributes[attr.FriendlyName] = append(assertion.Attributes[attr.FriendlyName], values...)
+			}
+		}
+	}
+	return &assertion, nil
+}
+
+// validate checks that the assertion a was issued by the provider for
+// Nanocloud, to log a user in through acsURL, and is valid as of now.
+func (p *Provider) validate(a *assertion, entityId string, acsURL string, now time.Time) error {
+	if strings.TrimSpace(a.Issuer) != p.IdPEntityId {
+		return fmt.Errorf("issued by %s", a.Issuer)
+	}
+
+	if a.Conditions == nil {
+		return fmt.Errorf("no conditions")
+	}
+	notBefore, err := parseTime(a.Conditions.NotBefore)
+	if err != nil {
+		return err
+	}
+	if !notBefore.IsZero() && now.Add(kClockSkew).Before(notBefore) {
+		return fmt.Errorf("not valid yet")
+	}
+	notOnOrAfter, err := parseTime(a.Conditions.NotOnOrAfter)
+	if err != nil {
+		return err
+	}
+	if !notOnOrAfter.IsZero() && !now.Add(-kClockSkew).Before(notOnOrAfter) {
+		return fmt.Errorf("expired")
+	}
+
+	// every audience restriction must be met
+	if len(a.Conditions.AudienceRestrictions) == 0 {
+		return fmt.Errorf("no audience restriction")
+	}
+	for _, restriction := range a.Conditions.AudienceRestrictions {
+		found := false
+		for _, audience := range restriction.Audiences {
+			if strings.TrimSpace(audience) == entityId {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("issued for another audience")
+		}
+	}
+
+	for _, c := range a.Subject.SubjectConfirmations {
+		if c.Method != kBearer || c.Data.Recipient != acsURL || c.Data.InResponseTo == "" {
+			continue
+		}
+		expiry, err := parseTime(c.Data.NotOnOrAfter)
+		if err != nil || expiry.IsZero() || !now.Add(-kClockSkew).Before(expiry) {
+			continue
+		}
+		return nil
+	}
+	return fmt.Errorf("no valid bearer subject confirmation")
+}
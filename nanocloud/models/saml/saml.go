@@ -0,0 +1,219 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package saml makes Nanocloud a SAML 2.0 service provider: users log in with
+// an external identity provider, which sends back a signed assertion of who
+// they are.
+package saml
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/Nanocloud/community/nanocloud/models/organizations"
+	"github.com/Nanocloud/community/nanocloud/utils"
+)
+
+var (
+	InvalidResponse = errors.New("invalid SAML response")
+	InvalidRequest  = errors.New("unknown or expired SAML request")
+	EmailMissing    = errors.New("the assertion has no email")
+)
+
+// The attributes holding the user fields, tried in order, unless configured
+// otherwise.
+const (
+	kEmailAttributes     = "email,mail,urn:oid:0.9.2342.19200300.100.1.3,http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress"
+	kFirstNameAttributes = "firstName,givenName,urn:oid:2.5.4.42,http://schemas.xmlsoap.org/ws/2005/05/identity/claims/givenname"
+	kLastNameAttributes  = "lastName,sn,surname,urn:oid:2.5.4.4,http://schemas.xmlsoap.org/ws/2005/05/identity/claims/surname"
+)
+
+// Provider is an identity provider, configured with the environment:
+// SAML_<NAME>_IDP_ENTITY_ID, SAML_<NAME>_IDP_SSO_URL and
+// SAML_<NAME>_IDP_CERTIFICATE are mandatory, the others are optional.
+type Provider struct {
+	Name                string
+	IdPEntityId         string
+	IdPSSOURL           string
+	Certificates        []*x509.Certificate
+	SPEntityId          string
+	ACSURL              string
+	OrganizationId      string
+	CreateUsers         bool
+	EmailAttributes     []string
+	FirstNameAttributes []string
+	LastNameAttributes  []string
+	AdminAttribute      string
+	AdminValues         []string
+}
+
+// envName returns the name of a provider as used in its environment
+// variables.
+func envName(name string) string {
+	return strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// splitList returns the non empty items of the comma separated list s.
+func splitList(s string) []string {
+	var l []string
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			l = append(l, item)
+		}
+	}
+	return l
+}
+
+// parseCertificates returns the certificates of the PEM data, several when
+// the identity provider rolls its key over.
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no certificate found")
+	}
+	return certs, nil
+}
+
+func newProvider(name string) (*Provider, error) {
+	prefix := "SAML_" + envName(name) + "_"
+
+	p := Provider{
+		Name:                name,
+		IdPEntityId:         utils.Env(prefix+"IDP_ENTITY_ID", ""),
+		IdPSSOURL:           utils.Env(prefix+"IDP_SSO_URL", ""),
+		SPEntityId:          utils.Env(prefix+"SP_ENTITY_ID", ""),
+		ACSURL:              utils.Env(prefix+"ACS_URL", ""),
+		OrganizationId:      utils.Env(prefix+"ORGANIZATION", organizations.Default),
+		CreateUsers:         utils.Env(prefix+"CREATE_USERS", "true") == "true",
+		EmailAttributes:     splitList(utils.Env(prefix+"EMAIL_ATTRIBUTE", kEmailAttributes)),
+		FirstNameAttributes: splitList(utils.Env(prefix+"FIRST_NAME_ATTRIBUTE", kFirstNameAttributes)),
+		LastNameAttributes:  splitList(utils.Env(prefix+"LAST_NAME_ATTRIBUTE", kLastNameAttributes)),
+		AdminAttribute:      utils.Env(prefix+"ADMIN_ATTRIBUTE", ""),
+		AdminValues:         splitList(utils.Env(prefix+"ADMIN_VALUES", "")),
+	}
+
+	certFile := utils.Env(prefix+"IDP_CERTIFICATE", "")
+	if p.IdPEntityId == "" || p.IdPSSOURL == "" || certFile == "" {
+		return nil, fmt.Errorf("%sIDP_ENTITY_ID, %sIDP_SSO_URL and %sIDP_CERTIFICATE are mandatory", prefix, prefix, prefix)
+	}
+
+	data, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	p.Certificates, err = parseCertificates(data)
+	if err != nil {
+		return nil, fmt.Errorf("%sIDP_CERTIFICATE: %s", prefix, err)
+	}
+	return &p, nil
+}
+
+var (
+	kProviders     map[string]*Provider
+	kProviderNames []string
+	kProvidersErr  error
+	kProvidersOnce sync.Once
+)
+
+func loadProviders() {
+	kProviders = make(map[string]*Provider)
+
+	for _, name := range splitList(utils.Env("SAML_PROVIDERS", "")) {
+		p, err := newProvider(name)
+		if err != nil {
+			kProvidersErr = err
+			return
+		}
+		kProviders[name] = p
+		kProviderNames = append(kProviderNames, name)
+	}
+}
+
+// Providers returns the identity providers of SAML_PROVIDERS, a comma
+// separated list of names, in order.
+func Providers() ([]*Provider, error) {
+	kProvidersOnce.Do(loadProviders)
+	if kProvidersErr != nil {
+		return nil, kProvidersErr
+	}
+
+	providers := make([]*Provider, len(kProviderNames))
+	for i, name := range kProviderNames {
+		providers[i] = kProviders[name]
+	}
+	return providers, nil
+}
+
+// GetProvider returns the identity provider named name, nil if there is none.
+func GetProvider(name string) (*Provider, error) {
+	kProvidersOnce.Do(loadProviders)
+	if kProvidersErr != nil {
+		return nil, kProvidersErr
+	}
+	return kProviders[name], nil
+}
+
+// path returns the path of the endpoint of the provider.
+func (p *Provider) path(endpoint string) string {
+	return "/saml/" + url.QueryEscape(p.Name) + "/" + endpoint
+}
+
+// EntityId returns the entity id of Nanocloud for the provider, the URL of
+// its metadata unless configured otherwise. baseURL is the URL Nanocloud is
+// reached at.
+func (p *Provider) EntityId(baseURL string) string {
+	if p.SPEntityId != "" {
+		return p.SPEntityId
+	}
+	return baseURL + p.path("metadata")
+}
+
+// AssertionConsumerServiceURL returns the URL the provider posts its
+// responses to.
+func (p *Provider) AssertionConsumerServiceURL(baseURL string) string {
+	if p.ACSURL != "" {
+		return p.ACSURL
+	}
+	return baseURL + p.path("acs")
+}
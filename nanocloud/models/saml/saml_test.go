@@ -0,0 +1,281 @@
+package saml
+
+import (
+	"crypto/x509"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+const (
+	kIdPEntityId = "https://idp.example.com"
+	kSPEntityId  = "https://nanocloud.example.com/saml/idp/metadata"
+	kACSURL      = "https://nanocloud.example.com/saml/idp/acs"
+	kRequestId   = "_4fd34a8b0c"
+)
+
+// mockIdP is a local identity provider signing its assertions with a key
+// pair of its own.
+type mockIdP struct {
+	t        *testing.T
+	keyStore dsig.X509KeyStore
+	cert     *x509.Certificate
+	now      time.Time
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	ks := dsig.RandomKeyStoreForTest()
+	_, der, err := ks.GetKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &mockIdP{t: t, keyStore: ks, cert: cert, now: time.Now().UTC()}
+}
+
+func (idp *mockIdP) provider() *Provider {
+	return &Provider{
+		Name:                "idp",
+		IdPEntityId:         kIdPEntityId,
+		Certificates:        []*x509.Certificate{idp.cert},
+		EmailAttributes:     splitList(kEmailAttributes),
+		FirstNameAttributes: splitList(kFirstNameAttributes),
+		LastNameAttributes:  splitList(kLastNameAttributes),
+		AdminAttribute:      "groups",
+		AdminValues:         []string{"nanocloud-admins"},
+	}
+}
+
+func (idp *mockIdP) sign(el *etree.Element) *etree.Element {
+	signed, err := dsig.NewDefaultSigningContext(idp.keyStore).SignEnveloped(el)
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	return signed
+}
+
+func instant(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// claims are what an assertion of the mock provider states.
+type claims struct {
+	id        string
+	audience  string
+	recipient string
+	expiry    time.Time
+}
+
+func (idp *mockIdP) claims() claims {
+	return claims{
+		id:        "_assertion",
+		audience:  kSPEntityId,
+		recipient: kACSURL,
+		expiry:    idp.now.Add(5 * time.Minute),
+	}
+}
+
+func (idp *mockIdP) assertion(c claims) *etree.Element {
+	a := etree.NewElement("saml:Assertion")
+	a.CreateAttr("xmlns:saml", kAssertionNamespace)
+	a.CreateAttr("ID", c.id)
+	a.CreateAttr("Version", "2.0")
+	a.CreateAttr("IssueInstant", instant(idp.now))
+	a.CreateElement("saml:Issuer").SetText(kIdPEntityId)
+
+	subject := a.CreateElement("saml:Subject")
+	nameId := subject.CreateElement("saml:NameID")
+	nameId.CreateAttr("Format", kNameIdEmail)
+	nameId.SetText("jane@example.com")
+	confirmation := subject.CreateElement("saml:SubjectConfirmation")
+	confirmation.CreateAttr("Method", kBearer)
+	data := confirmation.CreateElement("saml:SubjectConfirmationData")
+	data.CreateAttr("InResponseTo", kRequestId)
+	data.CreateAttr("Recipient", c.recipient)
+	data.CreateAttr("NotOnOrAfter", instant(c.expiry))
+
+	conditions := a.CreateElement("saml:Conditions")
+	conditions.CreateAttr("NotBefore", instant(idp.now.Add(-time.Minute)))
+	conditions.CreateAttr("NotOnOrAfter", instant(c.expiry))
+	conditions.CreateElement("saml:AudienceRestriction").CreateElement("saml:Audience").SetText(c.audience)
+
+	statement := a.CreateElement("saml:AttributeStatement")
+	for name, values := range map[string][]string{
+		"givenName": {"Jane"},
+		"sn":        {"Doe"},
+		"groups":    {"staff", "nanocloud-admins"},
+	} {
+		attr := statement.CreateElement("saml:Attribute")
+		attr.CreateAttr("Name", name)
+		for _, v := range values {
+			attr.CreateElement("saml:AttributeValue").SetText(v)
+		}
+	}
+	return a
+}
+
+func (idp *mockIdP) response(assertions ...*etree.Element) *etree.Element {
+	r := etree.NewElement("samlp:Response")
+	r.CreateAttr("xmlns:samlp", kProtocolNamespace)
+	r.CreateAttr("xmlns:saml", kAssertionNamespace)
+	r.CreateAttr("ID", "_response")
+	r.CreateAttr("Version", "2.0")
+	r.CreateAttr("IssueInstant", instant(idp.now))
+	r.CreateAttr("Destination", kACSURL)
+	r.CreateAttr("InResponseTo", kRequestId)
+	r.CreateElement("saml:Issuer").SetText(kIdPEntityId)
+	r.CreateElement("samlp:Status").CreateElement("samlp:StatusCode").CreateAttr("Value", kStatusSuccess)
+	for _, a := range assertions {
+		r.AddChild(a)
+	}
+	return r
+}
+
+func (idp *mockIdP) parse(p *Provider, response *etree.Element) (*Assertion, error) {
+	doc := etree.NewDocument()
+	doc.SetRoot(response)
+	raw, err := doc.WriteToBytes()
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	return p.parseResponse(raw, kSPEntityId, kACSURL, idp.now)
+}
+
+func checkAssertion(t *testing.T, a *Assertion) {
+	if a.RequestId != kRequestId {
+		t.Errorf("RequestId: expected %s, got %s", kRequestId, a.RequestId)
+	}
+	if a.NameId != "jane@example.com" {
+		t.Errorf("NameId: expected jane@example.com, got %s", a.NameId)
+	}
+	if a.Attribute([]string{"firstName", "givenName"}) != "Jane" {
+		t.Errorf("givenName: expected Jane, got %v", a.Attributes["givenName"])
+	}
+}
+
+func TestSignedAssertion(t *testing.T) {
+	idp := newMockIdP(t)
+	p := idp.provider()
+
+	a, err := idp.parse(p, idp.response(idp.sign(idp.assertion(idp.claims()))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkAssertion(t, a)
+
+	if p.email(a) != "jane@example.com" {
+		t.Errorf("email: expected jane@example.com, got %s", p.email(a))
+	}
+	if admin, decides := p.isAdmin(a); !admin || !decides {
+		t.Errorf("expected an administrator")
+	}
+}
+
+func TestSignedResponse(t *testing.T) {
+	idp := newMockIdP(t)
+
+	a, err := idp.parse(idp.provider(), idp.sign(idp.response(idp.assertion(idp.claims()))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkAssertion(t, a)
+}
+
+func TestRejectedResponses(t *testing.T) {
+	idp := newMockIdP(t)
+	p := idp.provider()
+
+	otherAudience := idp.claims()
+	otherAudience.audience = "https://other.example.com"
+	otherRecipient := idp.claims()
+	otherRecipient.recipient = "https://other.example.com/acs"
+	expired := idp.claims()
+	expired.expiry = idp.now.Add(-10 * time.Minute)
+
+	tampered := idp.sign(idp.assertion(idp.claims()))
+	tampered.FindElement("./Subject/NameID").SetText("admin@example.com")
+
+	// the signed assertion of a user, hidden next to a forged one
+	forged := idp.claims()
+	forged.id = "_forged"
+	wrapped := idp.response(idp.assertion(forged))
+	wrapped.CreateElement("samlp:Extensions").AddChild(idp.sign(idp.assertion(idp.claims())))
+
+	// a second assertion added to a signed response is out of the signature
+	signed := idp.sign(idp.response(idp.assertion(idp.claims())))
+	signed.AddChild(idp.assertion(forged))
+
+	other := newMockIdP(t)
+
+	for name, response := range map[string]*etree.Element{
+		"unsigned":        idp.response(idp.assertion(idp.claims())),
+		"tampered":        idp.response(tampered),
+		"wrapped":         wrapped,
+		"added assertion": signed,
+		"two assertions":  idp.response(idp.sign(idp.assertion(idp.claims())), idp.sign(idp.assertion(forged))),
+		"other key":       idp.response(other.sign(other.assertion(other.claims()))),
+		"other audience":  idp.response(idp.sign(idp.assertion(otherAudience))),
+		"other recipient": idp.response(idp.sign(idp.assertion(otherRecipient))),
+		"expired":         idp.response(idp.sign(idp.assertion(expired))),
+	} {
+		_, err := idp.parse(p, response)
+		if err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestOtherIssuer(t *testing.T) {
+	idp := newMockIdP(t)
+	p := idp.provider()
+	p.IdPEntityId = "https://other.example.com"
+
+	_, err := idp.parse(p, idp.response(idp.sign(idp.assertion(idp.claims()))))
+	if err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+func TestAuthnRequest(t *testing.T) {
+	p := &Provider{Name: "idp", IdPSSOURL: "https://idp.example.com/sso"}
+
+	req, err := p.authnRequest(kRequestId, kSPEntityId, kACSURL, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := etree.NewDocument()
+	err = doc.ReadFromBytes(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := doc.Root()
+	if root.Tag != "AuthnRequest" || root.NamespaceURI() != kProtocolNamespace {
+		t.Fatalf("expected an AuthnRequest, got %s", req)
+	}
+	if root.SelectAttrValue("ID", "") != kRequestId ||
+		root.SelectAttrValue("AssertionConsumerServiceURL", "") != kACSURL ||
+		root.FindElement("./Issuer").Text() != kSPEntityId {
+		t.Errorf("unexpected request %s", req)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	p := &Provider{Name: "idp"}
+
+	metadata, err := p.Metadata(kSPEntityId, kACSURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{`entityID="` + kSPEntityId + `"`, `Location="` + kACSURL + `"`, `WantAssertionsSigned="true"`} {
+		if !strings.Contains(string(metadata), s) {
+			t.Errorf("expected %s in %s", s, metadata)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package serviceaccounts
+
+// ServiceAccount lets automation call the API without a user. It logs in with
+// the client_credentials grant, its key and secret, and is given roles as
+// users are. Its tokens only carry its scopes, and last
+// AccessTokenLifetime seconds. Secret is only known once created or reset.
+type ServiceAccount struct {
+	Id                  string   `json:"-"`
+	Name                string   `json:"name"`
+	Activated           bool     `json:"activated"`
+	Key                 string   `json:"key"`
+	Secret              string   `json:"secret,omitempty"`
+	Scopes              []string `json:"scopes"`
+	AccessTokenLifetime int      `json:"access-token-lifetime"`
+
+	OrganizationId string `json:"-"`
+}
+
+func (a *ServiceAccount) GetID() string {
+	return a.Id
+}
+
+func (a *ServiceAccount) SetID(id string) error {
+	a.Id = id
+	return nil
+}
+
+// GetName is the type of the service accounts in the API.
+func (a *ServiceAccount) GetName() string {
+	return "service-accounts"
+}
@@ -0,0 +1,226 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package serviceaccounts
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/models/oauth"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+)
+
+var (
+	ServiceAccountNotFound = errors.New("service account not found")
+	InvalidName            = errors.New("names of service accounts must have 1 to 36 characters")
+)
+
+// kColumns are the columns find expects.
+const kColumns = `users.id, users.first_name, users.activated,
+	users.organization_id, oauth_clients.key, oauth_clients.scopes,
+	oauth_clients.access_token_lifetime`
+
+func find(condition string, args ...interface{}) ([]*ServiceAccount, error) {
+	rows, err := db.Query(
+		`SELECT `+kColumns+`
+		FROM users
+		JOIN oauth_clients
+			ON oauth_clients.service_account_id = users.id
+		WHERE users.service_account AND `+condition+`
+		ORDER BY users.first_name`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := make([]*ServiceAccount, 0)
+	for rows.Next() {
+		a := ServiceAccount{}
+		var scopes string
+		err = rows.Scan(
+			&a.Id, &a.Name, &a.Activated,
+			&a.OrganizationId, &a.Key, &scopes,
+			&a.AccessTokenLifetime,
+		)
+		if err != nil {
+			return nil, err
+		}
+		a.Scopes = strings.Fields(scopes)
+		accounts = append(accounts, &a)
+	}
+	return accounts, rows.Err()
+}
+
+// FindAll returns the service accounts of an organization.
+func FindAll(organizationId string) ([]*ServiceAccount, error) {
+	return find(`users.organization_id = $1::varchar`, organizationId)
+}
+
+// GetServiceAccount returns the service account of the organization with this
+// id, nil if there is none.
+func GetServiceAccount(organizationId string, id string) (*ServiceAccount, error) {
+	accounts, err := find(
+		`users.organization_id = $1::varchar AND users.id = $2::varchar`,
+		organizationId, id,
+	)
+	if err != nil || len(accounts) == 0 {
+		return nil, err
+	}
+	return accounts[0], nil
+}
+
+// validName returns whether name fits the first name of the user of a
+// service account.
+func validName(name string) bool {
+	return name != "" && utf8.RuneCountInString(name) <= 36
+}
+
+// Create creates a service account of the organization along with its OAuth
+// client. Its secret is only known from the returned account.
+func Create(organizationId string, a *ServiceAccount) (*ServiceAccount, error) {
+	if !validName(a.Name) {
+		return nil, InvalidName
+	}
+
+	user, err := users.CreateServiceAccount(organizationId, a.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := oauth.CreateClient(organizationId, &oauth.Client{
+		Name:                a.Name,
+		Confidential:        true,
+		Scopes:              a.Scopes,
+		AccessTokenLifetime: a.AccessTokenLifetime,
+		ServiceAccountId:    user.Id,
+	})
+	if err != nil {
+		users.DeleteUser(user.Id)
+		return nil, err
+	}
+
+	return &ServiceAccount{
+		Id:                  user.Id,
+		Name:                user.FirstName,
+		Activated:           user.Activated,
+		Key:                 client.Key,
+		Secret:              client.Secret,
+		Scopes:              client.Scopes,
+		AccessTokenLifetime: client.AccessTokenLifetime,
+		OrganizationId:      organizationId,
+	}, nil
+}
+
+// client returns the OAuth client of the service account.
+func (a *ServiceAccount) client() (*oauth.Client, error) {
+	client, err := oauth.GetServiceAccountClient(a.Id)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		return nil, ServiceAccountNotFound
+	}
+	return client, nil
+}
+
+// Update saves the name, scopes, token lifetime and activation of the service
+// account. Disabling it revokes its tokens.
+func (a *ServiceAccount) Update() error {
+	if !validName(a.Name) {
+		return InvalidName
+	}
+
+	client, err := a.client()
+	if err != nil {
+		return err
+	}
+
+	client.Name = a.Name
+	client.Scopes = a.Scopes
+	client.AccessTokenLifetime = a.AccessTokenLifetime
+	err = client.Update()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(
+		`UPDATE users
+		SET first_name = $2::varchar, activated = $3::bool
+		WHERE id = $1::varchar AND service_account`,
+		a.Id, a.Name, a.Activated,
+	)
+	if err != nil {
+		return err
+	}
+
+	a.Key, a.Secret, a.Scopes = client.Key, "", client.Scopes
+	if !a.Activated {
+		return oauth.RevokeTokens(a.Id)
+	}
+	return nil
+}
+
+// ResetSecret gives the service account a new secret, which is only known
+// from the account once it returns. Its tokens are revoked.
+func (a *ServiceAccount) ResetSecret() error {
+	client, err := a.client()
+	if err != nil {
+		return err
+	}
+
+	err = client.ResetSecret()
+	if err != nil {
+		return err
+	}
+	a.Secret = client.Secret
+
+	return oauth.RevokeTokens(a.Id)
+}
+
+// Delete deletes the service account along with its client and tokens.
+func (a *ServiceAccount) Delete() error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		`DELETE FROM oauth_access_tokens WHERE user_id = $1::varchar`,
+		a.Id,
+	)
+	if err == nil {
+		err = users.DeleteUserTx(tx, a.Id)
+	}
+	if err == users.UserNotFound {
+		err = ServiceAccountNotFound
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
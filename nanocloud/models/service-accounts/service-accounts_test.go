@@ -0,0 +1,154 @@
+package serviceaccounts
+
+import (
+	"testing"
+
+	"github.com/Nanocloud/community/nanocloud/models/oauth"
+	"github.com/Nanocloud/community/nanocloud/models/organizations"
+	"github.com/Nanocloud/community/nanocloud/models/roles"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+)
+
+var created *ServiceAccount
+
+func TestCreateServiceAccount(t *testing.T) {
+	_, err := Create(organizations.Default, &ServiceAccount{
+		Name:                "",
+		AccessTokenLifetime: 3600,
+	})
+	if err != InvalidName {
+		t.Errorf("Service accounts should have a name, got %v", err)
+	}
+
+	created, err = Create(organizations.Default, &ServiceAccount{
+		Name:                "ci",
+		Scopes:              []string{roles.AppsPublish, roles.UsersWrite},
+		AccessTokenLifetime: 3600,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Key == "" || created.Secret == "" || !created.Activated {
+		t.Errorf("Unexpected service account: %+v", created)
+	}
+
+	user, err := users.GetUser(created.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user == nil || !user.ServiceAccount || user.FirstName != "ci" {
+		t.Errorf("Unexpected user of the service account: %+v", user)
+	}
+
+	client, err := oauth.GetServiceAccountClient(created.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client == nil || !client.Confidential || !client.CheckSecret(created.Secret) ||
+		client.RefreshTokenLifetime != 0 {
+		t.Errorf("Unexpected client of the service account: %+v", client)
+	}
+
+	_, err = Create(organizations.Default, &ServiceAccount{
+		Name:                "ci",
+		AccessTokenLifetime: 3600,
+	})
+	if err != oauth.ClientDuplicated {
+		t.Errorf("Service account names should be unique, got %v", err)
+	}
+}
+
+func TestHiddenFromUsers(t *testing.T) {
+	list, err := users.FindUsers(organizations.Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range list {
+		if u.Id == created.Id {
+			t.Errorf("Service accounts should not be listed with the users")
+		}
+	}
+
+	list, err = users.FindUsersWithoutWindowsAccount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range list {
+		if u.Id == created.Id {
+			t.Errorf("Service accounts should not need a Windows account")
+		}
+	}
+
+	clients, err := oauth.FindClients(organizations.Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range clients {
+		if c.ServiceAccountId == created.Id {
+			t.Errorf("The clients of service accounts should not be listed with the others")
+		}
+	}
+}
+
+func TestUpdateServiceAccount(t *testing.T) {
+	a, err := GetServiceAccount(organizations.Default, created.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == nil || a.Secret != "" {
+		t.Fatalf("Unexpected service account: %+v", a)
+	}
+
+	a.Name = "deploy"
+	a.Activated = false
+	a.Scopes = []string{roles.AppsPublish}
+	err = a.Update()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err = GetServiceAccount(organizations.Default, created.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Name != "deploy" || a.Activated || len(a.Scopes) != 1 {
+		t.Errorf("Unexpected updated service account: %+v", a)
+	}
+
+	secret := created.Secret
+	err = a.ResetSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := oauth.GetServiceAccountClient(created.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.CheckSecret(secret) || !client.CheckSecret(a.Secret) {
+		t.Errorf("Only the new secret should be accepted")
+	}
+}
+
+func TestDeleteServiceAccount(t *testing.T) {
+	err := created.Delete()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := GetServiceAccount(organizations.Default, created.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a != nil {
+		t.Errorf("The service account should be deleted")
+	}
+
+	client, err := oauth.GetServiceAccountClient(created.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client != nil {
+		t.Errorf("The client of the service account should be deleted")
+	}
+}
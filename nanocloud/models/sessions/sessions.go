@@ -1,81 +1,63 @@
 package sessions
 
 import (
-	"encoding/json"
+	"strconv"
+
 	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/plaza"
 	"github.com/Nanocloud/community/nanocloud/utils"
-	log "github.com/Sirupsen/logrus"
-	"io/ioutil"
-	"net/http"
 )
 
-var kServer string
-var kPort string
-
-type hash map[string]interface{}
-
-func GetAll(userSam string) ([]Session, error) {
-
-	var sessionList []Session
-
-	resp, err := http.Get("http://" + kServer + ":" + kPort + "/sessions/" + userSam)
-
+// userId returns the id of the user whose Windows account is sam, or an empty
+// string.
+func userId(sam string) (string, error) {
+	rows, err := db.Query(
+		`SELECT users.id FROM users
+		left join users_windows_user on users.id = users_windows_user.user_id
+		left join windows_users on users_windows_user.windows_user_id = windows_users.id
+		WHERE windows_users.sam = $1::varchar`,
+		sam)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
+	defer rows.Close()
 
-	b, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Error(err)
-		return nil, err
+	var id string
+	if rows.Next() {
+		err = rows.Scan(&id)
 	}
+	return id, err
+}
 
-	var body struct {
-		Data [][]string
+// GetAll returns the sessions of a user on an execution server.
+func GetAll(server string, userSam string) ([]Session, error) {
+	port, err := strconv.Atoi(utils.Env("PLAZA_PORT", "9090"))
+	if err != nil {
+		return nil, err
 	}
 
-	err = json.Unmarshal(b, &body)
+	found, err := plaza.Sessions(server, port, userSam)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, tab := range body.Data {
-
-		rows, err := db.Query(
-			`SELECT users.id FROM users
-			left join users_windows_user on users.id = users_windows_user.user_id
-			left join windows_users on users_windows_user.windows_user_id = windows_users.id
-			WHERE windows_users.sam = $1::varchar`,
-			tab[1])
-
+	sessionList := make([]Session, 0, len(found))
+	for _, s := range found {
+		id, err := userId(s.Username)
 		if err != nil {
 			return nil, err
 		}
-
-		defer rows.Close()
-		var user_id string
-		if rows.Next() {
-			err = rows.Scan(
-				&user_id,
-			)
-
-			if err != nil {
-				return nil, err
-			}
-
-			var session Session
-			session.SessionName = tab[0]
-			session.Username = tab[1]
-			session.Id = tab[2]
-			session.State = tab[3]
-			session.UserId = user_id
-			sessionList = append(sessionList, session)
+		if id == "" {
+			continue
 		}
+
+		sessionList = append(sessionList, Session{
+			Id:          s.Id,
+			SessionName: s.Name,
+			Username:    s.Username,
+			State:       s.State,
+			UserId:      id,
+		})
 	}
 	return sessionList, nil
 }
-
-func init() {
-	kServer = utils.Env("EXECUTION_SERVERS", "iaas-module")
-	kPort = utils.Env("PLAZA_PORT", "9090")
-}
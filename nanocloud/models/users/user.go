@@ -4,6 +4,7 @@ import (
 	"errors"
 
 	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/models/roles"
 )
 
 type WindowsUser struct {
@@ -21,6 +22,14 @@ type User struct {
 	FirstName  string `json:"first-name"`
 	LastName   string `json:"last-name"`
 	SignupDate int    `json:"signup-date,omitempty"`
+
+	OrganizationId string `json:"organization-id"`
+	DirectoryDN    string `json:"directory-dn"`
+	ServiceAccount bool   `json:"service-account,omitempty"`
+
+	// Scopes are the permissions the access token the user authenticated
+	// with grants, nil if they didn't authenticate with one.
+	Scopes []string `json:"-"`
 }
 
 func (u *User) GetID() string {
@@ -32,6 +41,33 @@ func (u *User) SetID(id string) error {
 	return nil
 }
 
+// FromDirectory returns whether the user is authenticated by the directory
+// rather than by a local password.
+func (u *User) FromDirectory() bool {
+	return u.DirectoryDN != ""
+}
+
+// InScope returns whether the access token the user authenticated with grants
+// permission, whether or not they have it.
+func (u *User) InScope(permission string) bool {
+	return u.Scopes == nil || roles.Has(u.Scopes, permission)
+}
+
+// FullScope returns whether the access token the user authenticated with
+// grants every permission.
+func (u *User) FullScope() bool {
+	return u.InScope(roles.All)
+}
+
+// Can returns whether the user has permission and the access token they
+// authenticated with grants it.
+func (u *User) Can(permission string) (bool, error) {
+	if !u.InScope(permission) {
+		return false, nil
+	}
+	return roles.UserCan(u.Id, permission)
+}
+
 func (u *User) WindowsCredentials() (*WindowsUser, error) {
 	res, err := db.Query(
 		`SELECT
@@ -23,9 +23,12 @@
 package users
 
 import (
+	"database/sql"
 	errors "errors"
 
 	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/models/roles"
+	"github.com/Nanocloud/community/nanocloud/utils"
 	log "github.com/Sirupsen/logrus"
 	uuid "github.com/satori/go.uuid"
 	"golang.org/x/crypto/bcrypt"
@@ -39,6 +42,22 @@ var (
 	UserNotCreated     = errors.New("user not created")
 )
 
+// kIsAdmin is true for the users having the administrator role, directly or
+// through one of their groups.
+const kIsAdmin = `users.id IN (
+	SELECT user_id
+	FROM users_roles
+	WHERE role_id = '` + roles.Administrator + `'
+	UNION
+	SELECT users_groups.user_id
+	FROM users_groups
+	JOIN groups_roles
+		ON groups_roles.group_id = users_groups.group_id
+	WHERE groups_roles.role_id = '` + roles.Administrator + `'
+)`
+
+// GetUserFromEmailPassword authenticates a local user. Directory users aren't
+// found.
 func GetUserFromEmailPassword(email, password string) (*User, error) {
 	if len(email) < 1 || len(password) < 1 {
 		return nil, UserNotFound
@@ -48,9 +67,10 @@ func GetUserFromEmailPassword(email, password string) (*User, error) {
 		`SELECT id, activated,
 		email, password,
 		first_name, last_name,
-		is_admin
+		`+kIsAdmin+`, organization_id
 		FROM users
-		WHERE email = $1::varchar`,
+		WHERE email = $1::varchar AND directory_dn = ''
+		AND NOT service_account`,
 		email,
 	)
 	if err != nil {
@@ -67,7 +87,7 @@ func GetUserFromEmailPassword(email, password string) (*User, error) {
 		&user.Id, &user.Activated,
 		&user.Email, &passwordHash,
 		&user.FirstName, &user.LastName,
-		&user.IsAdmin,
+		&user.IsAdmin, &user.OrganizationId,
 	)
 	rows.Close()
 
@@ -84,11 +104,16 @@ func GetUserFromEmailPassword(email, password string) (*User, error) {
 	return &user, nil
 }
 
-func FindUsers() ([]*User, error) {
+// FindUsers returns the users of an organization, without its service
+// accounts.
+func FindUsers(organizationId string) ([]*User, error) {
 	rows, err := db.Query(
 		`SELECT id, first_name, last_name, email,
-			is_admin, activated, extract(epoch from signup_date)
-		FROM users`,
+			`+kIsAdmin+`, activated, extract(epoch from signup_date),
+			organization_id, directory_dn
+		FROM users
+		WHERE organization_id = $1::varchar AND NOT service_account`,
+		organizationId,
 	)
 	if err != nil {
 		return nil, err
@@ -108,6 +133,8 @@ func FindUsers() ([]*User, error) {
 			&user.IsAdmin,
 			&user.Activated,
 			&timestamp,
+			&user.OrganizationId,
+			&user.DirectoryDN,
 		)
 		// javascript time is in millisecond not in second
 		user.SignupDate = int(1000 * timestamp)
@@ -139,15 +166,24 @@ func UserExists(id string) (bool, error) {
 	return false, nil
 }
 
+// execFunc runs a statement on the database or within a transaction.
+type execFunc func(query string, args ...interface{}) (sql.Result, error)
+
 func DisableUser(id string) error {
-	rows, err := db.Query(
+	return disableUser(db.Exec, id)
+}
+
+// DisableUserTx disables a user within tx.
+func DisableUserTx(tx *sql.Tx, id string) error {
+	return disableUser(tx.Exec, id)
+}
+
+func disableUser(exec execFunc, id string) error {
+	_, err := exec(
 		`UPDATE users
 		SET activated = false
 		WHERE id = $1::varchar`,
 		id)
-	if err != nil {
-		rows.Close()
-	}
 	return err
 }
 
@@ -158,6 +194,7 @@ func CreateUser(
 	lastName string,
 	password string,
 	isAdmin bool,
+	organizationId string,
 ) (*User, error) {
 	id := uuid.NewV4().String()
 
@@ -170,17 +207,16 @@ func CreateUser(
 		`INSERT INTO users
     (id, email, activated,
     first_name, last_name,
-    password, is_admin)
+    password, organization_id)
     VALUES(
       $1::varchar, $2::varchar, $3::bool,
       $4::varchar, $5::varchar,
-      $6::varchar, $7::bool)
+      $6::varchar, $7::varchar)
     RETURNING id, email, activated,
-    first_name, last_name,
-    is_admin`,
+    first_name, last_name, organization_id`,
 		id, email, activated,
 		firstName, lastName,
-		pass, isAdmin)
+		pass, organizationId)
 
 	if err != nil {
 		switch err.Error() {
@@ -204,10 +240,18 @@ func CreateUser(
 	rows.Scan(
 		&user.Id, &user.Email,
 		&user.Activated, &user.FirstName,
-		&user.LastName, &user.IsAdmin,
+		&user.LastName, &user.OrganizationId,
 	)
 
-	return &user, err
+	if isAdmin {
+		err = roles.AssignUser(roles.Administrator, user.Id)
+		if err != nil {
+			return nil, err
+		}
+		user.IsAdmin = true
+	}
+
+	return &user, nil
 }
 
 func UpdateUserAd(userID, sam, password, domain string) error {
@@ -256,7 +300,16 @@ func UpdateUserAd(userID, sam, password, domain string) error {
 }
 
 func DeleteUser(id string) error {
-	res, err := db.Exec("DELETE FROM users WHERE id = $1::varchar", id)
+	return deleteUser(db.Exec, id)
+}
+
+// DeleteUserTx deletes a user within tx.
+func DeleteUserTx(tx *sql.Tx, id string) error {
+	return deleteUser(tx.Exec, id)
+}
+
+func deleteUser(exec execFunc, id string) error {
+	res, err := exec("DELETE FROM users WHERE id = $1::varchar", id)
 	if err != nil {
 		return err
 	}
@@ -271,12 +324,21 @@ func DeleteUser(id string) error {
 }
 
 func UpdateUserPassword(id string, password string) error {
+	return updateUserPassword(db.Exec, id, password)
+}
+
+// UpdateUserPasswordTx changes the password of a user within tx.
+func UpdateUserPasswordTx(tx *sql.Tx, id string, password string) error {
+	return updateUserPassword(tx.Exec, id, password)
+}
+
+func updateUserPassword(exec execFunc, id string, password string) error {
 	pass, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		return err
 	}
 
-	res, err := db.Exec(
+	res, err := exec(
 		`UPDATE users
 		SET password = $1::varchar
 		WHERE id = $2::varchar`,
@@ -295,24 +357,26 @@ func UpdateUserPassword(id string, password string) error {
 	return nil
 }
 
+// UpdateUserPrivilege gives or takes back the administrator role of a user.
+// Users getting the role through one of their groups keep it.
 func UpdateUserPrivilege(id string, rank bool) error {
-	res, err := db.Exec(
-		`UPDATE users
-		SET is_admin = $1::boolean
-		WHERE id = $2::varchar`,
-		rank, id)
+	exists, err := UserExists(id)
 	if err != nil {
 		return err
 	}
+	if !exists {
+		return UserNotFound
+	}
 
-	updated, err := res.RowsAffected()
-	if err != nil {
-		return err
+	if rank {
+		return roles.AssignUser(roles.Administrator, id)
 	}
-	if updated == 0 {
-		return UserNotFound
+
+	err = roles.UnassignUser(roles.Administrator, id)
+	if err == roles.AssignmentNotFound {
+		return nil
 	}
-	return nil
+	return err
 }
 
 func UpdateUserEmail(id string, email string) error {
@@ -379,12 +443,250 @@ func UpdateUserLastName(id string, lastname string) error {
 }
 
 func GetUser(id string) (*User, error) {
+	return findUser(`id = $1::varchar`, id)
+}
+
+// GetUserByEmail returns the user whose email is email, whatever its case,
+// nil if there is none.
+func GetUserByEmail(email string) (*User, error) {
+	return findUser(`lower(email) = lower($1::varchar)`, email)
+}
+
+// GetDirectoryUser returns the user authenticated by the directory entry dn,
+// nil if there is none.
+func GetDirectoryUser(dn string) (*User, error) {
+	return findUser(`lower(directory_dn) = lower($1::varchar)`, dn)
+}
+
+// CreateDirectoryUser creates a user authenticated by the directory entry dn.
+// Their local password is random so that they can't log in without the
+// directory.
+func CreateDirectoryUser(
+	organizationId string,
+	dn string,
+	email string,
+	firstName string,
+	lastName string,
+) (*User, error) {
+	user, err := CreateUser(
+		true,
+		email,
+		firstName,
+		lastName,
+		utils.RandomString(32),
+		false,
+		organizationId,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(
+		`UPDATE users
+		SET directory_dn = $1::varchar
+		WHERE id = $2::varchar`,
+		dn, user.Id)
+	if err != nil {
+		DeleteUser(user.Id)
+		return nil, err
+	}
+
+	user.DirectoryDN = dn
+	return user, nil
+}
+
+// CreateServiceAccount creates the user a service account authenticates as.
+// It has no password and its email is its id, so that it can only log in with
+// the client_credentials grant.
+func CreateServiceAccount(organizationId string, name string) (*User, error) {
+	id := uuid.NewV4().String()
+
+	_, err := db.Exec(
+		`INSERT INTO users
+		(id, email, activated, first_name, organization_id, service_account)
+		VALUES ($1::varchar, $1::varchar, true, $2::varchar, $3::varchar, true)`,
+		id, name, organizationId,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &User{
+		Id:             id,
+		Email:          id,
+		Activated:      true,
+		FirstName:      name,
+		OrganizationId: organizationId,
+		ServiceAccount: true,
+	}, nil
+}
+
+// UpdateWindowsPassword changes the password of the Windows account of a
+// user.
+func UpdateWindowsPassword(id string, password string) error {
+	_, err := db.Exec(
+		`UPDATE windows_users
+		SET password = $2::varchar
+		WHERE id IN (
+			SELECT windows_user_id FROM users_windows_user
+			WHERE user_id = $1::varchar
+		)`,
+		id, password,
+	)
+	return err
+}
+
+// FindUsersWithoutWindowsAccount returns the users of every organization who
+// have no Windows account to open their sessions with. Service accounts never
+// have one.
+func FindUsersWithoutWindowsAccount() ([]*User, error) {
 	rows, err := db.Query(
-		`SELECT id, first_name, last_name, email, is_admin,
-			activated, extract(epoch from signup_date)
+		`SELECT id, email, first_name, last_name, activated,
+			organization_id, directory_dn
 		FROM users
-		WHERE id = $1::varchar`,
-		id)
+		WHERE id NOT IN (SELECT user_id FROM users_windows_user)
+		AND NOT service_account
+		ORDER BY organization_id, email`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]*User, 0)
+	for rows.Next() {
+		user := User{}
+		err = rows.Scan(
+			&user.Id, &user.Email,
+			&user.FirstName, &user.LastName,
+			&user.Activated, &user.OrganizationId,
+			&user.DirectoryDN,
+		)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, &user)
+	}
+	return users, rows.Err()
+}
+
+// FindDirectoryUsers returns the directory users of an organization.
+func FindDirectoryUsers(organizationId string) ([]*User, error) {
+	rows, err := db.Query(
+		`SELECT id, email, first_name, last_name, activated, directory_dn
+		FROM users
+		WHERE organization_id = $1::varchar AND directory_dn != ''`,
+		organizationId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]*User, 0)
+	for rows.Next() {
+		user := User{OrganizationId: organizationId}
+		err = rows.Scan(
+			&user.Id, &user.Email,
+			&user.FirstName, &user.LastName,
+			&user.Activated, &user.DirectoryDN,
+		)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, &user)
+	}
+	return users, rows.Err()
+}
+
+// LinkWindowsUser makes sam the Windows account of a user. A known account
+// which isn't linked to anyone is reused, otherwise one without password is
+// created, its password is set the next time the user logs in. It returns
+// false if sam already was their account.
+func LinkWindowsUser(userId, sam, domain string) (bool, error) {
+	rows, err := db.Query(
+		`SELECT windows_users.sam
+		FROM users_windows_user
+		JOIN windows_users
+			ON windows_users.id = users_windows_user.windows_user_id
+		WHERE users_windows_user.user_id = $1::varchar
+		AND lower(windows_users.sam) = lower($2::varchar)`,
+		userId, sam,
+	)
+	if err != nil {
+		return false, err
+	}
+	linked := rows.Next()
+	rows.Close()
+
+	if linked {
+		return false, nil
+	}
+
+	rows, err = db.Query(
+		`SELECT id FROM windows_users
+		WHERE lower(sam) = lower($1::varchar)
+		AND id NOT IN (SELECT windows_user_id FROM users_windows_user)
+		LIMIT 1`,
+		sam,
+	)
+	if err != nil {
+		return false, err
+	}
+	known := rows.Next()
+	winUserId := 0
+	if known {
+		err = rows.Scan(&winUserId)
+	}
+	rows.Close()
+	if err != nil {
+		return false, err
+	}
+
+	if !known {
+		rows, err = db.Query(
+			`INSERT INTO windows_users (sam, password, domain)
+			VALUES ($1::varchar, '', $2::varchar)
+			RETURNING id`,
+			sam, domain,
+		)
+		if err != nil {
+			return false, err
+		}
+		if rows.Next() {
+			err = rows.Scan(&winUserId)
+		} else {
+			err = UserNotCreated
+		}
+		rows.Close()
+		if err != nil {
+			return false, err
+		}
+	}
+
+	_, err = db.Exec(
+		`INSERT INTO users_windows_user
+		(user_id, windows_user_id)
+		VALUES ($1::varchar, $2::integer)
+		ON CONFLICT (user_id)
+		DO UPDATE SET windows_user_id = EXCLUDED.windows_user_id`,
+		userId, winUserId,
+	)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// findUser returns the user matching condition, which takes one argument.
+func findUser(condition string, arg string) (*User, error) {
+	rows, err := db.Query(
+		`SELECT id, first_name, last_name, email, `+kIsAdmin+`,
+			activated, extract(epoch from signup_date), organization_id,
+			directory_dn, service_account
+		FROM users
+		WHERE `+condition,
+		arg)
 	if err != nil {
 		return nil, err
 	}
@@ -402,6 +704,9 @@ func GetUser(id string) (*User, error) {
 			&user.IsAdmin,
 			&user.Activated,
 			&timestamp,
+			&user.OrganizationId,
+			&user.DirectoryDN,
+			&user.ServiceAccount,
 		)
 		if err != nil {
 			return nil, err
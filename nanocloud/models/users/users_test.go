@@ -3,6 +3,8 @@ package users
 import (
 	"log"
 	"testing"
+
+	"github.com/Nanocloud/community/nanocloud/models/organizations"
 )
 
 var (
@@ -41,11 +43,13 @@ func compareUser(user *User) {
 		log.Fatalln("'user.Password' field should be empty")
 	case user.IsAdmin != isAdmin:
 		log.Fatalln("'user.IsAdmin' field doesn't match the inserted value")
+	case user.OrganizationId != organizations.Default:
+		log.Fatalln("'user.OrganizationId' field doesn't match the inserted value")
 	}
 }
 
 func TestCreateUser(t *testing.T) {
-	user, err := CreateUser(activated, email, firstName, lastName, password, isAdmin)
+	user, err := CreateUser(activated, email, firstName, lastName, password, isAdmin, organizations.Default)
 
 	if err != nil {
 		log.Fatalln("Cannot create the user:", err.Error())
@@ -163,3 +167,23 @@ func TestDeleteUser(t *testing.T) {
 		log.Fatalln("User exists even after deletion")
 	}
 }
+
+func TestInScope(t *testing.T) {
+	user := User{}
+	if !user.InScope("users:write") || !user.FullScope() {
+		t.Fatalf("A user without scopes should be in every scope")
+	}
+
+	user.Scopes = []string{"users:read", "apps:*"}
+	if !user.InScope("users:read") || !user.InScope("apps:write") {
+		t.Fatalf("A user should be in the scopes granted")
+	}
+	if user.InScope("users:write") || user.FullScope() {
+		t.Fatalf("A user shouldn't be in the scopes not granted")
+	}
+
+	user.Scopes = []string{"*"}
+	if !user.FullScope() {
+		t.Fatalf("A user granted * should be in every scope")
+	}
+}
@@ -29,8 +29,18 @@ type Connector interface {
 	AuthenticateUser(username, password string) (interface{}, error)
 	GetAccessToken(interface{}, interface{}, *http.Request) (interface{}, error)
 	RevokeAccessToken(interface{}, interface{}, string) error
+	GetDefaultClient() (interface{}, error)
+	RefreshAccessToken(interface{}, string, *http.Request) (interface{}, error)
+	RevokeRefreshToken(interface{}, string) error
+	ExchangeAuthorizationCode(interface{}, string, string, string, *http.Request) (interface{}, error)
+	GetClientAccessToken(interface{}, *http.Request) (interface{}, error)
+	IntrospectToken(interface{}, string, string) (interface{}, error)
 }
 
+// ErrIntrospectionForbidden is returned by IntrospectToken when the client
+// isn't allowed to introspect tokens.
+var ErrIntrospectionForbidden = errors.New("the client isn't allowed to introspect tokens")
+
 var kConnector Connector
 
 type OAuthError struct {
@@ -126,6 +136,21 @@ func GetAuthorizationHeaderValue(req *http.Request, authType string) (string, *O
 	return token, nil
 }
 
+// clientAuth authenticates the client of a request to the token endpoint,
+// with HTTP basic authentication or, for clients unable to use it, such as
+// public clients, with the client_id and client_secret parameters.
+func clientAuth(req *http.Request) (interface{}, *OAuthError) {
+	if req.Header.Get("Authorization") == "" && req.PostFormValue("client_id") != "" {
+		client, fail := kConnector.GetClient(req.PostFormValue("client_id"), req.PostFormValue("client_secret"))
+		if fail != nil {
+			log.Error("[Oauth] Unable to retreive client: " + fail.Error())
+			return nil, &OAuthError{500, SERVER_ERROR, "Internal Server Error"}
+		}
+		return client, nil
+	}
+	return clientBasicAuth(req)
+}
+
 func clientBasicAuth(req *http.Request) (interface{}, *OAuthError) {
 	rawAuthToken, err := GetAuthorizationHeaderValue(req, "Basic")
 	if err != nil {
@@ -178,7 +203,7 @@ func revokeToken(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	client, err := clientBasicAuth(req)
+	client, err := clientAuth(req)
 	if err != nil {
 		oauthErrorReply(res, *err)
 		return
@@ -196,7 +221,7 @@ func revokeToken(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	if tokenTypeHint != "access_token" {
+	if tokenTypeHint != "access_token" && tokenTypeHint != "refresh_token" {
 		oauthErrorReply(res, OAuthError{http.StatusBadRequest, INVALID_REQUEST, "unsupported token type"})
 		return
 	}
@@ -208,6 +233,19 @@ func revokeToken(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	// revoking an unknown refresh token isn't an error, RFC 7009
+	if tokenTypeHint == "refresh_token" {
+		fail := kConnector.RevokeRefreshToken(client, accessToken)
+		if fail != nil {
+			log.Error("[OAuth] Cannot revoke refresh token: " + fail.Error())
+			oauthErrorReply(res, OAuthError{500, SERVER_ERROR, "Internal Server Error"})
+			return
+		}
+
+		res.WriteHeader(http.StatusOK)
+		return
+	}
+
 	user, fail := kConnector.GetUserFromAccessToken(accessToken)
 	if fail != nil {
 		log.Error("[OAuth] Cannot retreive user form access token: " + fail.Error())
@@ -231,8 +269,16 @@ func revokeToken(res http.ResponseWriter, req *http.Request) {
 	return
 }
 
-func createToken(res http.ResponseWriter, req *http.Request) {
-	client, err := clientBasicAuth(req)
+// introspectToken describes a token to the client, RFC 7662. Tokens the
+// connector doesn't know as active are only described as inactive.
+func introspectToken(res http.ResponseWriter, req *http.Request) {
+	fail := req.ParseForm()
+	if fail != nil {
+		oauthErrorReply(res, OAuthError{http.StatusBadRequest, INVALID_REQUEST, "Unable to parse the request body"})
+		return
+	}
+
+	client, err := clientAuth(req)
 	if err != nil {
 		oauthErrorReply(res, *err)
 		return
@@ -243,60 +289,183 @@ func createToken(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	fail := req.ParseForm()
+	token := req.FormValue("token")
+	if token == "" {
+		oauthErrorReply(res, OAuthError{http.StatusBadRequest, INVALID_REQUEST, "token is missing"})
+		return
+	}
 
+	introspection, fail := kConnector.IntrospectToken(client, token, req.FormValue("token_type_hint"))
+	if fail == ErrIntrospectionForbidden {
+		oauthErrorReply(res, OAuthError{http.StatusUnauthorized, INVALID_CLIENT, "Only confidential clients can introspect tokens"})
+		return
+	}
 	if fail != nil {
-		oauthErrorReply(res, OAuthError{http.StatusBadRequest, INVALID_REQUEST, "Unable to parse the request body"})
+		log.Error("[OAuth] Cannot introspect token: " + fail.Error())
+		oauthErrorReply(res, OAuthError{http.StatusInternalServerError, SERVER_ERROR, "Internal Server Error"})
 		return
 	}
 
-	// grant_type
-	grantType := req.FormValue("grant_type")
-	if grantType == "" {
-		oauthErrorReply(res, OAuthError{http.StatusBadRequest, INVALID_REQUEST, "grant_type is missing"})
-		return
+	if introspection == nil {
+		introspection = map[string]bool{"active": false}
 	}
 
-	if grantType != "password" {
-		oauthErrorReply(res, OAuthError{http.StatusBadRequest, INVALID_REQUEST, "Invalid grant_type"})
+	rt, fail := json.Marshal(introspection)
+	if fail != nil {
+		log.Error("[OAuth] Unable to serialize introspection: " + fail.Error())
+		oauthErrorReply(res, OAuthError{http.StatusInternalServerError, SERVER_ERROR, "Internal Server Error"})
 		return
 	}
 
+	res.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	res.Write(rt)
+}
+
+// passwordGrant issues an access token to the user whose credentials are
+// sent, RFC 6749 section 4.3.
+func passwordGrant(client interface{}, req *http.Request) (interface{}, *OAuthError) {
 	// username
 	username := req.FormValue("username")
 	if username == "" {
-		oauthErrorReply(res, OAuthError{http.StatusBadRequest, INVALID_REQUEST, "username is missing"})
-		return
+		return nil, &OAuthError{http.StatusBadRequest, INVALID_REQUEST, "username is missing"}
 	}
 
 	// password
 	password := req.FormValue("password")
 	if password == "" {
-		oauthErrorReply(res, OAuthError{http.StatusBadRequest, INVALID_REQUEST, "password is missing"})
-		return
+		return nil, &OAuthError{http.StatusBadRequest, INVALID_REQUEST, "password is missing"}
 	}
 
 	user, fail := kConnector.AuthenticateUser(username, password)
 
 	if fail != nil {
-		if fail.Error() == "invalid credentials" || fail.Error() == "user not found" {
-			oauthErrorReply(res, OAuthError{http.StatusUnauthorized, ACCESS_DENIED, "Invalid User Credentials"})
-			return
+		if fail.Error() == "invalid credentials" || fail.Error() == "user not found" || fail.Error() == "user disabled" {
+			return nil, &OAuthError{http.StatusUnauthorized, ACCESS_DENIED, "Invalid User Credentials"}
 		}
 		log.Error("[OAuth] Cannot Authenticate User: " + fail.Error())
-		oauthErrorReply(res, OAuthError{http.StatusInternalServerError, SERVER_ERROR, "Internal Server Error"})
-		return
+		return nil, &OAuthError{http.StatusInternalServerError, SERVER_ERROR, "Internal Server Error"}
 	}
 
 	accessToken, fail := kConnector.GetAccessToken(user, client, req)
 	if fail != nil {
 		log.Error("[OAuth] Cannot Get Access Token: " + fail.Error())
-		oauthErrorReply(res, OAuthError{http.StatusInternalServerError, SERVER_ERROR, "Internal Server Error"})
-		return
+		return nil, &OAuthError{http.StatusInternalServerError, SERVER_ERROR, "Internal Server Error"}
 	}
 
 	if accessToken == nil {
-		oauthErrorReply(res, OAuthError{http.StatusUnauthorized, ACCESS_DENIED, "Access token request denied for the given client"})
+		return nil, &OAuthError{http.StatusUnauthorized, ACCESS_DENIED, "Access token request denied for the given client"}
+	}
+	return accessToken, nil
+}
+
+// refreshTokenGrant trades a refresh token for a new access token and refresh
+// token, RFC 6749 section 6.
+func refreshTokenGrant(client interface{}, req *http.Request) (interface{}, *OAuthError) {
+	refreshToken := req.FormValue("refresh_token")
+	if refreshToken == "" {
+		return nil, &OAuthError{http.StatusBadRequest, INVALID_REQUEST, "refresh_token is missing"}
+	}
+
+	accessToken, fail := kConnector.RefreshAccessToken(client, refreshToken, req)
+	if fail != nil {
+		log.Error("[OAuth] Cannot Refresh Access Token: " + fail.Error())
+		return nil, &OAuthError{http.StatusInternalServerError, SERVER_ERROR, "Internal Server Error"}
+	}
+
+	if accessToken == nil {
+		return nil, &OAuthError{http.StatusBadRequest, INVALID_GRANT, "Invalid refresh token"}
+	}
+	return accessToken, nil
+}
+
+// authorizationCodeGrant trades an authorization code, issued to the client
+// after the user approved its request, for an access token, RFC 6749 section
+// 4.1.3. The code_verifier of PKCE is required.
+func authorizationCodeGrant(client interface{}, req *http.Request) (interface{}, *OAuthError) {
+	code := req.FormValue("code")
+	if code == "" {
+		return nil, &OAuthError{http.StatusBadRequest, INVALID_REQUEST, "code is missing"}
+	}
+
+	redirectURI := req.FormValue("redirect_uri")
+	if redirectURI == "" {
+		return nil, &OAuthError{http.StatusBadRequest, INVALID_REQUEST, "redirect_uri is missing"}
+	}
+
+	verifier := req.FormValue("code_verifier")
+	if verifier == "" {
+		return nil, &OAuthError{http.StatusBadRequest, INVALID_REQUEST, "code_verifier is missing"}
+	}
+
+	accessToken, fail := kConnector.ExchangeAuthorizationCode(client, code, redirectURI, verifier, req)
+	if fail != nil {
+		log.Error("[OAuth] Cannot Exchange Authorization Code: " + fail.Error())
+		return nil, &OAuthError{http.StatusInternalServerError, SERVER_ERROR, "Internal Server Error"}
+	}
+
+	if accessToken == nil {
+		return nil, &OAuthError{http.StatusBadRequest, INVALID_GRANT, "Invalid authorization code"}
+	}
+	return accessToken, nil
+}
+
+// clientCredentialsGrant issues an access token to the client itself, RFC 6749
+// section 4.4. Only the clients acting as a service account can use it.
+func clientCredentialsGrant(client interface{}, req *http.Request) (interface{}, *OAuthError) {
+	accessToken, fail := kConnector.GetClientAccessToken(client, req)
+	if fail != nil {
+		log.Error("[OAuth] Cannot Get Client Access Token: " + fail.Error())
+		return nil, &OAuthError{http.StatusInternalServerError, SERVER_ERROR, "Internal Server Error"}
+	}
+
+	if accessToken == nil {
+		return nil, &OAuthError{http.StatusBadRequest, UNAUTHORIZED_CLIENT, "The client is not an enabled service account"}
+	}
+	return accessToken, nil
+}
+
+func createToken(res http.ResponseWriter, req *http.Request) {
+	client, err := clientAuth(req)
+	if err != nil {
+		oauthErrorReply(res, *err)
+		return
+	}
+
+	if client == nil {
+		oauthErrorReply(res, OAuthError{http.StatusUnauthorized, INVALID_CLIENT, "Invalid OAuth Client Credentials"})
+		return
+	}
+
+	fail := req.ParseForm()
+
+	if fail != nil {
+		oauthErrorReply(res, OAuthError{http.StatusBadRequest, INVALID_REQUEST, "Unable to parse the request body"})
+		return
+	}
+
+	// grant_type
+	grantType := req.FormValue("grant_type")
+	if grantType == "" {
+		oauthErrorReply(res, OAuthError{http.StatusBadRequest, INVALID_REQUEST, "grant_type is missing"})
+		return
+	}
+
+	var accessToken interface{}
+	var oauthErr *OAuthError
+	switch grantType {
+	case "password":
+		accessToken, oauthErr = passwordGrant(client, req)
+	case "refresh_token":
+		accessToken, oauthErr = refreshTokenGrant(client, req)
+	case "authorization_code":
+		accessToken, oauthErr = authorizationCodeGrant(client, req)
+	case "client_credentials":
+		accessToken, oauthErr = clientCredentialsGrant(client, req)
+	default:
+		oauthErr = &OAuthError{http.StatusBadRequest, UNSUPPORTED_GRANT_TYPE, "Invalid grant_type"}
+	}
+	if oauthErr != nil {
+		oauthErrorReply(res, *oauthErr)
 		return
 	}
 
@@ -313,6 +482,20 @@ func createToken(res http.ResponseWriter, req *http.Request) {
 	return
 }
 
+// IssueAccessToken issues an access token of the default client to a user
+// authenticated by an identity provider rather than by the token endpoint. It
+// returns nil if the client can't issue tokens to the user.
+func IssueAccessToken(user interface{}, req *http.Request) (interface{}, error) {
+	client, err := kConnector.GetDefaultClient()
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		return nil, errors.New("no default client")
+	}
+	return kConnector.GetAccessToken(user, client, req)
+}
+
 func HandleRequest(res http.ResponseWriter, req *http.Request) {
 	res.Header().Add("Cache-Control", "no-store")
 	res.Header().Add("Pragma", "no-cache")
@@ -327,6 +510,11 @@ func HandleRequest(res http.ResponseWriter, req *http.Request) {
 			createToken(res, req)
 			return
 		}
+
+		if req.URL.Path == "/oauth/introspect" {
+			introspectToken(res, req)
+			return
+		}
 	}
 
 	oauthErrorReply(res, OAuthError{http.StatusNotFound, INVALID_REQUEST, "Invalid Endpoint"})
@@ -354,6 +542,30 @@ func (c dummyConnector) RevokeAccessToken(client, user interface{}, accessToken
 	return errors.New("RevokeAccessToken is not implemented")
 }
 
+func (c dummyConnector) GetDefaultClient() (interface{}, error) {
+	return nil, errors.New("GetDefaultClient is not implemented")
+}
+
+func (c dummyConnector) RefreshAccessToken(client interface{}, refreshToken string, req *http.Request) (interface{}, error) {
+	return nil, errors.New("RefreshAccessToken is not implemented")
+}
+
+func (c dummyConnector) RevokeRefreshToken(client interface{}, refreshToken string) error {
+	return errors.New("RevokeRefreshToken is not implemented")
+}
+
+func (c dummyConnector) ExchangeAuthorizationCode(client interface{}, code, redirectURI, verifier string, req *http.Request) (interface{}, error) {
+	return nil, errors.New("ExchangeAuthorizationCode is not implemented")
+}
+
+func (c dummyConnector) GetClientAccessToken(client interface{}, req *http.Request) (interface{}, error) {
+	return nil, errors.New("GetClientAccessToken is not implemented")
+}
+
+func (c dummyConnector) IntrospectToken(client interface{}, token, hint string) (interface{}, error) {
+	return nil, errors.New("IntrospectToken is not implemented")
+}
+
 func init() {
 	SetConnector(dummyConnector{})
 }
@@ -0,0 +1,267 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package placement chooses the execution server on which a user's session
+// is opened.
+package placement
+
+import (
+	"errors"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nanocloud/community/nanocloud/plaza"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+)
+
+var (
+	NoServerAvailable = errors.New("No execution server available")
+)
+
+var (
+	kCapacity int
+	kPort     int
+
+	// kSessions keeps the sessions of the servers a few seconds, so that
+	// users opening their apps together don't query every server each.
+	kSessions = &sessionCache{
+		ttl:     10 * time.Second,
+		timeout: 10 * time.Second,
+		query:   querySessions,
+		entries: make(map[string]cachedSessions),
+	}
+)
+
+func querySessions(address string) ([]plaza.Session, error) {
+	return plaza.QuerySessions(address, kPort, "", "")
+}
+
+type cachedSessions struct {
+	sessions []plaza.Session
+	at       time.Time
+}
+
+// sessionCache keeps the sessions of the servers for ttl. Servers which don't
+// answer within timeout are given up on.
+type sessionCache struct {
+	ttl     time.Duration
+	timeout time.Duration
+	query   func(address string) ([]plaza.Session, error)
+
+	mut     sync.Mutex
+	entries map[string]cachedSessions
+}
+
+func (c *sessionCache) get(address string) ([]plaza.Session, error) {
+	c.mut.Lock()
+	cached, ok := c.entries[address]
+	c.mut.Unlock()
+
+	if ok && time.Since(cached.at) < c.ttl {
+		return cached.sessions, nil
+	}
+
+	type result struct {
+		sessions []plaza.Session
+		err      error
+	}
+	done := make(chan result, 1)
+	go func() {
+		sessions, err := c.query(address)
+		done <- result{sessions, err}
+	}()
+
+	select {
+	case r := <-done:
+		if r.err != nil {
+			return nil, r.err
+		}
+		c.mut.Lock()
+		c.entries[address] = cachedSessions{r.sessions, time.Now()}
+		c.mut.Unlock()
+		return r.sessions, nil
+
+	case <-time.After(c.timeout):
+		return nil, errors.New("the server didn't list its sessions in time")
+	}
+}
+
+// LoadFunc returns the number of sessions opened on the server at address.
+type LoadFunc func(address string) (int, error)
+
+// Load counts the sessions opened on the server at address.
+func Load(address string) (int, error) {
+	sessions, err := kSessions.get(address)
+	if err != nil {
+		return 0, err
+	}
+	return len(sessions), nil
+}
+
+// leastLoaded returns the server with the fewest sessions among those below
+// capacity. A capacity of 0 means unlimited. Servers whose load can't be
+// retrieved are ignored and ties are broken randomly.
+func leastLoaded(servers []string, capacity int, load LoadFunc) (string, error) {
+	loads := make([]int, len(servers))
+	errs := make([]error, len(servers))
+
+	var wg sync.WaitGroup
+	for i, address := range servers {
+		wg.Add(1)
+		go func(i int, address string) {
+			defer wg.Done()
+			loads[i], errs[i] = load(address)
+		}(i, address)
+	}
+	wg.Wait()
+
+	min := -1
+	var candidates []string
+	for i, address := range servers {
+		if errs[i] != nil {
+			log.WithFields(log.Fields{
+				"server": address,
+			}).Error("Unable to retrieve the server load: ", errs[i])
+			continue
+		}
+		if capacity > 0 && loads[i] >= capacity {
+			continue
+		}
+
+		switch {
+		case min == -1 || loads[i] < min:
+			min = loads[i]
+			candidates = []string{address}
+		case loads[i] == min:
+			candidates = append(candidates, address)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return "", NoServerAvailable
+	}
+	return candidates[rand.Intn(len(candidates))], nil
+}
+
+// SessionsFunc returns the sessions of a user on the server at address.
+type SessionsFunc func(address string, username string) ([]plaza.Session, error)
+
+func userSessions(address string, username string) ([]plaza.Session, error) {
+	all, err := kSessions.get(address)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]plaza.Session, 0)
+	for _, session := range all {
+		if strings.EqualFold(session.Username, username) {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
+
+// sessionServer returns the server on which the user already has a session,
+// or an empty string. Active sessions are preferred over disconnected ones.
+func sessionServer(servers []string, username string, sessions SessionsFunc) string {
+	found := make([][]plaza.Session, len(servers))
+
+	var wg sync.WaitGroup
+	for i, address := range servers {
+		wg.Add(1)
+		go func(i int, address string) {
+			defer wg.Done()
+
+			var err error
+			found[i], err = sessions(address, username)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"server": address,
+				}).Error("Unable to retrieve the sessions: ", err)
+			}
+		}(i, address)
+	}
+	wg.Wait()
+
+	rt := ""
+	for i, address := range servers {
+		for _, session := range found[i] {
+			if !strings.EqualFold(session.Username, username) {
+				continue
+			}
+			if session.State == "Active" {
+				return address
+			}
+			if rt == "" {
+				rt = address
+			}
+		}
+	}
+	return rt
+}
+
+func place(servers []string, username string, capacity int, load LoadFunc, sessions SessionsFunc) (string, error) {
+	if username != "" {
+		server := sessionServer(servers, username, sessions)
+		if server != "" {
+			log.WithFields(log.Fields{
+				"server": server,
+				"user":   username,
+			}).Info("Reconnecting user to their session")
+			return server, nil
+		}
+	}
+	return leastLoaded(servers, capacity, load)
+}
+
+// Place returns the server on which the Windows user already has a session so
+// a reconnecting user gets their running applications back. Otherwise, the least
+// loaded server that can accept a new session is returned.
+func Place(servers []string, username string) (string, error) {
+	return place(servers, username, kCapacity, Load, userSessions)
+}
+
+// Setup reads the port of the plaza agents and the capacity of the execution
+// servers from the environment.
+func Setup() error {
+	port, err := strconv.Atoi(utils.Env("PLAZA_PORT", "9090"))
+	if err != nil {
+		return errors.New("PLAZA_PORT is invalid")
+	}
+
+	capacity, err := strconv.Atoi(utils.Env("EXECUTION_SERVER_CAPACITY", "0"))
+	if err != nil || capacity < 0 {
+		return errors.New("EXECUTION_SERVER_CAPACITY is invalid")
+	}
+
+	kPort = port
+	kCapacity = capacity
+	return nil
+}
+
+func init() {
+	rand.Seed(time.Now().UTC().UnixNano())
+}
@@ -0,0 +1,152 @@
+package placement
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Nanocloud/community/nanocloud/plaza"
+)
+
+type fakeLoads map[string]int
+
+func (f fakeLoads) load(address string) (int, error) {
+	load, ok := f[address]
+	if !ok {
+		return 0, errors.New("fake-generated-error")
+	}
+	return load, nil
+}
+
+func TestLeastLoaded(t *testing.T) {
+	loads := fakeLoads{"a": 4, "b": 1, "c": 3}
+
+	server, err := leastLoaded([]string{"a", "b", "c"}, 0, loads.load)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server != "b" {
+		t.Errorf("The least loaded server should be picked, got: %s", server)
+	}
+}
+
+func TestCapacity(t *testing.T) {
+	loads := fakeLoads{"a": 4, "b": 2}
+
+	server, err := leastLoaded([]string{"a", "b"}, 3, loads.load)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server != "b" {
+		t.Errorf("Server b should be picked, got: %s", server)
+	}
+
+	loads["b"] = 3
+	_, err = leastLoaded([]string{"a", "b"}, 3, loads.load)
+	if err != NoServerAvailable {
+		t.Errorf("Full servers should not be picked, got: %v", err)
+	}
+}
+
+func TestUnreachable(t *testing.T) {
+	loads := fakeLoads{"a": 7}
+
+	server, err := leastLoaded([]string{"down", "a"}, 0, loads.load)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server != "a" {
+		t.Errorf("Unreachable servers should be ignored, got: %s", server)
+	}
+
+	_, err = leastLoaded([]string{}, 0, loads.load)
+	if err != NoServerAvailable {
+		t.Errorf("No server should be available, got: %v", err)
+	}
+}
+
+type fakeSessions map[string][]plaza.Session
+
+func (f fakeSessions) sessions(address string, username string) ([]plaza.Session, error) {
+	sessions, ok := f[address]
+	if !ok {
+		return nil, errors.New("fake-generated-error")
+	}
+	return sessions, nil
+}
+
+func TestAffinity(t *testing.T) {
+	loads := fakeLoads{"a": 1, "b": 5, "c": 5}
+	sessions := fakeSessions{
+		"a": {},
+		"b": {{Username: "user", Id: "3", State: "Disc"}},
+		"c": {},
+	}
+
+	server, err := place([]string{"a", "b", "c"}, "user", 5, loads.load, sessions.sessions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server != "b" {
+		t.Errorf("User should be routed back to their session, got: %s", server)
+	}
+
+	sessions["c"] = []plaza.Session{{Name: "rdp-tcp#2", Username: "user", Id: "2", State: "Active"}}
+	server, err = place([]string{"a", "b", "c"}, "user", 5, loads.load, sessions.sessions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server != "c" {
+		t.Errorf("Active sessions should be preferred, got: %s", server)
+	}
+
+	server, err = place([]string{"a", "b", "c"}, "USER", 5, loads.load, sessions.sessions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server != "c" {
+		t.Errorf("Usernames should be compared case-insensitively, got: %s", server)
+	}
+
+	server, err = place([]string{"a", "b", "c"}, "other", 5, loads.load, sessions.sessions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server != "a" {
+		t.Errorf("Users without session should be placed normally, got: %s", server)
+	}
+}
+
+func TestSessionCache(t *testing.T) {
+	queries := 0
+	cache := &sessionCache{
+		ttl:     time.Hour,
+		timeout: 50 * time.Millisecond,
+		query: func(address string) ([]plaza.Session, error) {
+			queries++
+			if address == "slow" {
+				time.Sleep(time.Second)
+			}
+			return []plaza.Session{{Username: "user"}}, nil
+		},
+		entries: make(map[string]cachedSessions),
+	}
+
+	for i := 0; i < 2; i++ {
+		sessions, err := cache.get("a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sessions) != 1 {
+			t.Errorf("The sessions of the server should be returned, got %v", sessions)
+		}
+	}
+	if queries != 1 {
+		t.Errorf("The sessions should be cached, the server was queried %d times", queries)
+	}
+
+	_, err := cache.get("slow")
+	if err == nil {
+		t.Errorf("Servers answering too late should be given up on")
+	}
+}
@@ -0,0 +1,120 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package plaza
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+)
+
+// Requests to the plaza agents are authenticated with an HMAC-SHA256 of the
+// method, the request URI, a timestamp, a nonce and the payload hash keyed
+// with the secret shared with the agents (PLAZA_SECRET). Agents reject
+// requests that are too old or whose nonce has already been used.
+const (
+	HeaderTimestamp = "X-Plaza-Timestamp"
+	HeaderNonce     = "X-Plaza-Nonce"
+	HeaderContent   = "X-Plaza-Content-Sha256"
+	HeaderSignature = "X-Plaza-Signature"
+
+	// UnsignedPayload replaces the payload hash of streamed requests. Agents
+	// only accept it for uploads.
+	UnsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+var kSecret []byte
+
+func signature(secret []byte, method, uri, timestamp, nonce, payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(method + "\n" + uri + "\n" + timestamp + "\n" + nonce + "\n" + payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func sign(req *http.Request, payload string) {
+	if len(kSecret) == 0 {
+		return
+	}
+
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		log.Error("Unable to generate a nonce: ", err)
+		return
+	}
+
+	nonce := hex.EncodeToString(b)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderNonce, nonce)
+	req.Header.Set(HeaderContent, payload)
+	req.Header.Set(
+		HeaderSignature,
+		signature(kSecret, req.Method, req.URL.RequestURI(), timestamp, nonce, payload),
+	)
+}
+
+// Sign adds the authentication headers to a request to a plaza agent. body is
+// the payload of the request, nil if it has none.
+func Sign(req *http.Request, body []byte) {
+	sum := sha256.Sum256(body)
+	sign(req, hex.EncodeToString(sum[:]))
+}
+
+// SignStream authenticates a request whose payload is streamed. The payload
+// isn't covered by the signature.
+func SignStream(req *http.Request) {
+	sign(req, UnsignedPayload)
+}
+
+// NewRequest returns a signed request to a plaza agent.
+func NewRequest(method string, url string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	Sign(req, body)
+	return req, nil
+}
+
+// Setup reads the secret shared with the plaza agents. It returns an error
+// when it isn't set as the agents would reject every request.
+func Setup() error {
+	secret := utils.Env("PLAZA_SECRET", "")
+	if secret == "" {
+		return errors.New("PLAZA_SECRET is not set, plaza would reject the requests of nanocloud")
+	}
+
+	kSecret = []byte(secret)
+	return nil
+}
@@ -0,0 +1,67 @@
+package plaza
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func TestSign(t *testing.T) {
+	kSecret = []byte("secret")
+	body := []byte(`{"command":["whoami"]}`)
+
+	req, err := NewRequest("POST", "http://plaza:9090/exec?wait=true", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256(body)
+	if req.Header.Get(HeaderContent) != hex.EncodeToString(sum[:]) {
+		t.Errorf("The payload hash should be sent")
+	}
+
+	expected := signature(
+		[]byte("secret"),
+		"POST", "/exec?wait=true",
+		req.Header.Get(HeaderTimestamp),
+		req.Header.Get(HeaderNonce),
+		req.Header.Get(HeaderContent),
+	)
+	if req.Header.Get(HeaderSignature) != expected {
+		t.Errorf("Invalid signature: %s", req.Header.Get(HeaderSignature))
+	}
+
+	other, err := NewRequest("POST", "http://plaza:9090/exec?wait=true", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Header.Get(HeaderNonce) == req.Header.Get(HeaderNonce) {
+		t.Errorf("Every request should have its own nonce")
+	}
+}
+
+func TestSignStream(t *testing.T) {
+	kSecret = []byte("secret")
+
+	req, err := NewRequest("POST", "http://plaza:9090/upload", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SignStream(req)
+
+	if req.Header.Get(HeaderContent) != UnsignedPayload {
+		t.Errorf("Streamed payloads should not be hashed")
+	}
+}
+
+func TestNoSecret(t *testing.T) {
+	kSecret = nil
+
+	req, err := NewRequest("GET", "http://plaza:9090/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Header.Get(HeaderSignature) != "" {
+		t.Errorf("Requests should not be signed without secret")
+	}
+}
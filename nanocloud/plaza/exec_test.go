@@ -0,0 +1,68 @@
+package plaza
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// fakePlaza serves a job whose output stream is interrupted after the first
+// event.
+func fakePlaza(t *testing.T, starts *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/exec/jobs":
+			*starts++
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `{"id":"job"}`)
+
+		case r.Method == "GET" && r.URL.Path == "/exec/jobs/job/output":
+			switch r.URL.Query().Get("from") {
+			case "0":
+				fmt.Fprintln(w, `{"seq":0,"stream":"stdout","data":"hello "}`)
+			case "1":
+				fmt.Fprintln(w, `{"seq":1,"stream":"stderr","data":"oops"}`)
+				fmt.Fprintln(w, `{"seq":2,"stream":"stdout","data":"world"}`)
+				fmt.Fprintln(w, `{"seq":3,"state":"exited","code":3}`)
+			default:
+				t.Errorf("Unexpected offset: %s", r.URL.Query().Get("from"))
+			}
+
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestExecStream(t *testing.T) {
+	kExecRetryWait = 0
+	starts := 0
+	server := fakePlaza(t, &starts)
+	defer server.Close()
+
+	host, p, _ := net.SplitHostPort(server.Listener.Addr().String())
+	port, _ := strconv.Atoi(p)
+
+	var output bytes.Buffer
+	res, err := ExecStream(host, port, &Cmd_t{Id: "job", Command: []string{"cmd"}}, &output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if starts != 1 {
+		t.Errorf("The job should be started once, got %d", starts)
+	}
+	if res.Stdout != "hello world" || res.Stderr != "oops" {
+		t.Errorf("Unexpected output: %+v", res)
+	}
+	if output.String() != "hello oopsworld" {
+		t.Errorf("Output should be streamed, got: %q", output.String())
+	}
+	if res.State != "exited" || res.Code != 3 {
+		t.Errorf("The exit code should be returned, got: %+v", res)
+	}
+}
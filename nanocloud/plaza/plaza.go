@@ -23,7 +23,6 @@
 package plaza
 
 import (
-	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -38,71 +37,197 @@ import (
 	"github.com/Nanocloud/community/nanocloud/utils"
 	"github.com/Nanocloud/community/nanocloud/vms"
 	log "github.com/Sirupsen/logrus"
+	uuid "github.com/satori/go.uuid"
+)
+
+var (
+	kPollInterval  = 5 * time.Second
+	kRebootTimeout = 15 * time.Minute
+	kExecRetries   = 10
+	kExecRetryWait = 5 * time.Second
 )
 
 type Cmd_t struct {
+	// Id identifies the job running the command on plaza. Starting a job with
+	// the id of an existing one doesn't run its command again.
+	Id         string   `json:"id"`
 	Username   string   `json:"username"`
 	Domain     string   `json:"domain"`
 	Command    []string `json:"command"`
 	Stdin      string   `json:"stdin"`
 	HideWindow bool     `json:"hide-window"`
 	Wait       bool     `json:"wait"`
+	Dir        string   `json:"dir,omitempty"`
+	Env        []string `json:"env,omitempty"`
+
+	// Timeout is in seconds, the command is killed when it expires.
+	Timeout int `json:"timeout,omitempty"`
 }
 
 type result_t struct {
 	Stdout string `json:"stdout"`
 	Stderr string `json:"stderr"`
-	Code   string `json:"code"`
+	Code   int    `json:"code"`
+	State  string `json:"state"`
 }
 
-func Exec(address string, port int, cmd *Cmd_t) (*result_t, error) {
-	var err error
+// outputEvent is a chunk of the output of a job or, for the last event, its
+// result.
+type outputEvent struct {
+	Seq    int    `json:"seq"`
+	Stream string `json:"stream"`
+	Data   string `json:"data"`
+	State  string `json:"state"`
+	Code   *int   `json:"code"`
+	Error  string `json:"error"`
+}
 
-	client := &http.Client{}
+func jobsURL(address string, port int) string {
+	return fmt.Sprintf("http://%s:%d/exec/jobs", address, port)
+}
 
-	instr, err := json.Marshal(&cmd)
+// startJob starts the job of cmd. The request is sent again on network errors
+// as the job id prevents the command from being run twice.
+func startJob(client *http.Client, address string, port int, cmd *Cmd_t) error {
+	instr, err := json.Marshal(cmd)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	buff := bytes.NewBuffer(instr)
+	for i := 0; ; i++ {
+		req, err := NewRequest("POST", jobsURL(address, port), instr)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			if i+1 < kExecRetries {
+				time.Sleep(kExecRetryWait)
+				continue
+			}
+			return err
+		}
 
-	var resp *http.Response
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
 
-	for i := 0; i < 10; i++ {
-		resp, err = client.Post(
-			fmt.Sprintf("http://%s:%d/exec", address, port),
-			"application/json",
-			buff,
-		)
-		if err == nil {
-			break
-		} else {
-			time.Sleep(5 * time.Second)
+		if resp.StatusCode != http.StatusCreated {
+			return errors.New(string(body))
 		}
+		return nil
 	}
+}
+
+// readOutput reads the output events of a job from seq, writes them to
+// output and returns the last one read.
+func readOutput(client *http.Client, address string, port int, id string, seq int, res *result_t, output io.Writer) (*outputEvent, error) {
+	req, err := NewRequest("GET", fmt.Sprintf("%s/%s/output?from=%d", jobsURL(address, port), id, seq), nil)
 	if err != nil {
 		return nil, err
 	}
 
-	defer resp.Body.Close()
-
-	body, err := ioutil.ReadAll(resp.Body)
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
 		return nil, errors.New(string(body))
 	}
 
-	r := result_t{}
-	err = json.Unmarshal(body, &r)
+	var last *outputEvent
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		e := outputEvent{}
+		err = decoder.Decode(&e)
+		if err == io.EOF {
+			return last, nil
+		}
+		if err != nil {
+			return last, err
+		}
+		last = &e
+
+		switch e.Stream {
+		case "stdout":
+			res.Stdout += e.Data
+		case "stderr":
+			res.Stderr += e.Data
+		}
+		if output != nil && e.Data != "" {
+			output.Write([]byte(e.Data))
+		}
+	}
+}
+
+// ExecStream runs a command on a plaza agent and writes its output to output,
+// which can be nil, while it runs. If the output stream is interrupted, it
+// resumes where it stopped.
+func ExecStream(address string, port int, cmd *Cmd_t, output io.Writer) (*result_t, error) {
+	client := &http.Client{}
+
+	if cmd.Id == "" {
+		cmd.Id = uuid.NewV4().String()
+	}
+
+	err := startJob(client, address, port, cmd)
 	if err != nil {
 		return nil, err
 	}
 
-	return &r, nil
+	res := result_t{}
+	seq := 0
+	for i := 0; i < kExecRetries; i++ {
+		last, err := readOutput(client, address, port, cmd.Id, seq, &res, output)
+		if last != nil {
+			if last.State != "" {
+				if last.Code == nil {
+					return nil, fmt.Errorf("command %s: %s", last.State, last.Error)
+				}
+				res.Code = *last.Code
+				res.State = last.State
+				return &res, nil
+			}
+			seq = last.Seq + 1
+		}
+
+		if err != nil {
+			log.Error(err)
+		}
+		time.Sleep(kExecRetryWait)
+	}
+	return nil, errors.New("unable to read the output of the command")
+}
+
+func Exec(address string, port int, cmd *Cmd_t) (*result_t, error) {
+	return ExecStream(address, port, cmd, nil)
+}
+
+// Kill stops the command run by the job id.
+func Kill(address string, port int, id string) error {
+	req, err := NewRequest("DELETE", jobsURL(address, port)+"/"+id, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return errors.New(string(body))
+	}
+	return nil
 }
 
 func PowershellExec(
@@ -128,92 +253,122 @@ func PowershellExec(
 		return nil, err
 	}
 
-	if res.Code != "exit status 0" {
+	if res.State != "exited" || res.Code != 0 {
 		return nil, errors.New("STDOUT: " + res.Stdout + "\nSTDERR: " + res.Stderr)
 	}
 	return res, nil
 }
 
-func PublishApp(
-	address string, port int,
-	username string, domain string,
-	collectionName string, displayName string, filePath string,
-) ([]byte, error) {
-	res, err := PowershellExec(
-		address, port,
-		username, domain,
-		"Try {",
-		"Import-module RemoteDesktop;",
-		fmt.Sprintf(
-			"New-RDRemoteApp -CollectionName '%s' -DisplayName '%s' -FilePath '%s' -ErrorAction Stop | ConvertTo-Json",
-			collectionName, displayName, filePath,
-		),
-		"}",
-		"Catch {",
-		"$ErrorMessage = $_.Exception.Message;",
-		"Write-Output -InputObject $ErrorMessage;",
-		"exit 1;",
-		"}",
-	)
+// CheckHealth returns an error if the plaza agent of the server doesn't reply
+// or if its Remote Desktop Management service isn't running.
+func CheckHealth(client *http.Client, address string, port int) error {
+	base := fmt.Sprintf("http://%s:%d", address, port)
 
+	req, err := NewRequest("GET", base+"/", nil)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("plaza replied with status %d", resp.StatusCode)
+	}
+
+	req, err = NewRequest("GET", base+"/checkrds", nil)
+	if err != nil {
+		return err
+	}
+	resp, err = client.Do(req)
+	if err != nil {
+		return err
 	}
+	defer resp.Body.Close()
 
-	out := res.Stdout
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("RDS check failed: %s", string(body))
+	}
 
-	return []byte(out), nil
+	rds := struct {
+		State string `json:"state"`
+	}{}
+	err = json.Unmarshal(body, &rds)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(rds.State) != "Running" {
+		return fmt.Errorf("RDS is not running: %s", strings.TrimSpace(rds.State))
+	}
+	return nil
 }
 
-func UnpublishApp(
-	address string, port int,
-	username string, domain string, password string,
-	collectionName string, alias string,
-) ([]byte, error) {
-	res, err := PowershellExec(
-		address, port,
-		username, domain,
-		"Try {",
-		"Import-module RemoteDesktop;",
-		fmt.Sprintf(
-			"Remove-RDRemoteApp -CollectionName '%s' -Alias '%s' -Force -ErrorAction Stop | ConvertTo-Json",
-			collectionName, alias,
-		),
-		"}",
-		"Catch {",
-		"$ErrorMessage = $_.Exception.Message;",
-		"Write-Output -InputObject $ErrorMessage;",
-		"exit 1;",
-		"}",
-	)
+// Session is a Remote Desktop session opened on an execution server.
+type Session struct {
+	Name     string
+	Username string
+	Id       string
+	State    string
+}
 
+// Sessions lists the sessions of a user on the server.
+func Sessions(address string, port int, username string) ([]Session, error) {
+	all, err := QuerySessions(address, port, "", "")
 	if err != nil {
 		return nil, err
 	}
 
-	out := res.Stdout
-
-	return []byte(out), nil
+	sessions := make([]Session, 0)
+	for _, session := range all {
+		if strings.EqualFold(session.Username, username) {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
 }
 
 func checkPlaza(ip string, port string) bool {
-	_, err := http.Get("http://" + ip + ":" + port + "/")
+	req, err := NewRequest("GET", "http://"+ip+":"+port+"/", nil)
 	if err != nil {
 		return false
-	} else {
-		return true
 	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return true
 }
 
-func provExec(p io.Writer, machine vms.Machine, command string) (string, error) {
-
-	machine.Status()
-	ip, _ := machine.IP()
-	for checkPlaza(ip.String(), utils.Env("PLAZA_PORT", "9090")) != true {
+// waitPlaza waits for the plaza agent of the machine to answer, which it
+// doesn't until the machine has booted.
+func waitPlaza(machine vms.Machine) error {
+	timeout := time.Now().Add(kRebootTimeout)
 
+	for {
 		machine.Status()
-		ip, _ = machine.IP()
-		time.Sleep(time.Millisecond * 500)
+		ip, err := machine.IP()
+		if err == nil && checkPlaza(ip.String(), utils.Env("PLAZA_PORT", "9090")) {
+			return nil
+		}
+		if time.Now().After(timeout) {
+			return errors.New("plaza of the machine is unreachable")
+		}
+		time.Sleep(kPollInterval)
+	}
+}
+
+// provExec runs command on the machine. Commands aren't echoed to the
+// provisioning output since some carry the Windows password.
+func provExec(machine vms.Machine, command string) (string, error) {
+	err := waitPlaza(machine)
+	if err != nil {
+		return "", err
 	}
 
 	plazaAddress, err := machine.IP()
@@ -234,7 +389,7 @@ func provExec(p io.Writer, machine vms.Machine, command string) (string, error)
 		return "", errors.New("domain unknown")
 	}
 
-	username, _, err := machine.Credentials()
+	username, password, err := machine.Credentials()
 	if err != nil {
 		log.Error(err.Error())
 		return "", err
@@ -245,10 +400,9 @@ func provExec(p io.Writer, machine vms.Machine, command string) (string, error)
 		plazaPort,
 		username,
 		domain,
+		password,
 		command,
 	)
-
-	p.Write([]byte(command))
 	if err != nil {
 		return "", err
 	}
@@ -256,164 +410,181 @@ func provExec(p io.Writer, machine vms.Machine, command string) (string, error)
 	return res.Stdout, nil
 }
 
-func isStopped(machine vms.Machine) bool {
+func waitStatus(machine vms.Machine, expected vms.MachineStatus) error {
+	timeout := time.Now().Add(kRebootTimeout)
 
-	status, err := machine.Status()
-	if err != nil {
-		log.Error(err.Error())
-		return false
-	}
-	if status == vms.StatusDown {
-		return true
-	} else {
-		return false
-	}
-}
-
-func Provision(machine vms.Machine) provisioner.ProvFunc {
-
-	return func(p io.Writer) {
-		resp, err := provExec(p, machine, "New-Item HKLM:\\SOFTWARE\\Policies\\Microsoft\\Windows -Name WindowsUpdate")
-		if err != nil {
-			p.Write([]byte(err.Error()))
-		} else {
-			p.Write([]byte(resp))
-		}
-
-		username, password, err := machine.Credentials()
-		if err != nil {
-			p.Write([]byte(err.Error()))
-		}
-		pcname, err := provExec(p, machine, "hostname")
-		if err != nil {
-			p.Write([]byte(err.Error()))
-		}
-		pcname = strings.TrimSpace(pcname)
-		domain := utils.Env("WINDOWS_DOMAIN", "")
-
-		resp, err = provExec(p, machine, "New-Item HKLM:\\SOFTWARE\\Policies\\Microsoft\\Windows\\WindowsUpdate -Name AU")
-		if err != nil {
-			p.Write([]byte(err.Error()))
-		} else {
-			p.Write([]byte(resp))
-		}
-
-		resp, err = provExec(p, machine, "New-ItemProperty HKLM:\\SOFTWARE\\Policies\\Microsoft\\Windows\\WindowsUpdate\\AU -Name NoAutoUpdate -Value 1")
-		if err != nil {
-			p.Write([]byte(err.Error()))
-		} else {
-			p.Write([]byte(resp))
-		}
-
-		resp, err = provExec(p, machine, "Install-windowsfeature AD-domain-services")
-		if err != nil {
-			p.Write([]byte(err.Error()))
-		} else {
-			p.Write([]byte(resp))
-		}
-
-		resp, err = provExec(p, machine, "Import-Module ADDSDeployment; $pwd=ConvertTo-SecureString '"+password+"' -asplaintext -force; Install-ADDSForest -CreateDnsDelegation:$false -DatabasePath 'C:\\Windows\\NTDS' -DomainMode 'Win2012R2' -DomainName '"+domain+"' -SafeModeAdministratorPassword:$pwd -DomainNetbiosName 'INTRA' -ForestMode 'Win2012R2' -InstallDns:$true -LogPath 'C:\\Windows\\NTDS' -NoRebootOnCompletion:$true -SysvolPath 'C:\\Windows\\SYSVOL' -Force:$true")
-		if err != nil {
-			p.Write([]byte(err.Error()))
-		} else {
-			p.Write([]byte(resp))
-		}
-
-		machine.Stop()
-		for isStopped(machine) != true {
-		}
-		machine.Start()
-
-		resp, err = provExec(p, machine, "set-ItemProperty -Path 'HKLM:\\System\\CurrentControlSet\\Control\\Terminal Server'-name 'fDenyTSConnections' -Value 0")
-		if err != nil {
-			p.Write([]byte(err.Error()))
-		} else {
-			p.Write([]byte(resp))
-		}
-
-		resp, err = provExec(p, machine, "Enable-NetFirewallRule -DisplayGroup 'Remote Desktop'")
-		if err != nil {
-			p.Write([]byte(err.Error()))
-		} else {
-			p.Write([]byte(resp))
-		}
-
-		resp, err = provExec(p, machine, "set-ItemProperty -Path 'HKLM:\\System\\CurrentControlSet\\Control\\Terminal Server\\WinStations\\RDP-Tcp' -name 'UserAuthentication' -Value 1")
+	for time.Now().Before(timeout) {
+		status, err := machine.Status()
 		if err != nil {
-			p.Write([]byte(err.Error()))
-		} else {
-			p.Write([]byte(resp))
+			log.Error(err.Error())
+		} else if status == expected {
+			return nil
 		}
+		time.Sleep(kPollInterval)
+	}
+	return fmt.Errorf("machine still not %s", vms.StatusToString(expected))
+}
 
-		resp, err = provExec(p, machine, "import-module RemoteDesktop; Import-module ServerManager; Add-WindowsFeature -Name RDS-RD-Server -IncludeAllSubFeature; Add-WindowsFeature -Name RDS-Web-Access -IncludeAllSubFeature; Add-WindowsFeature -Name RDS-Connection-Broker -IncludeAllSubFeature")
-		if err != nil {
-			p.Write([]byte(err.Error()))
-		} else {
-			p.Write([]byte(resp))
-		}
+// machineRunner runs the provisioning steps through the plaza agent of the
+// machine. The secrets are masked in what the commands return, since
+// PowerShell errors quote the command that failed.
+type machineRunner struct {
+	machine vms.Machine
+	secrets []string
+}
 
-		resp, err = provExec(p, machine, "import-module RemoteDesktop; Import-module ServerManager; Install-windowsfeature RSAT-AD-AdminCenter")
-		if err != nil {
-			p.Write([]byte(err.Error()))
-		} else {
-			p.Write([]byte(resp))
+func (r *machineRunner) redact(s string) string {
+	for _, secret := range r.secrets {
+		if secret != "" {
+			s = strings.Replace(s, secret, "********", -1)
 		}
+	}
+	return s
+}
 
-		machine.Stop()
-		for isStopped(machine) != true {
+func (r *machineRunner) Exec(command string) (string, error) {
+	out, err := provExec(r.machine, command)
+	if err != nil {
+		err = errors.New(r.redact(err.Error()))
+	}
+	return r.redact(out), err
+}
 
-		}
-		machine.Start()
+func (r *machineRunner) Reboot() error {
+	err := r.machine.Stop()
+	if err != nil {
+		return err
+	}
 
-		resp, err = provExec(p, machine, "sc.exe config RDMS start= auto")
-		if err != nil {
-			p.Write([]byte(err.Error()))
-		} else {
-			p.Write([]byte(resp))
-		}
+	err = waitStatus(r.machine, vms.StatusDown)
+	if err != nil {
+		return err
+	}
 
-		resp, err = provExec(p, machine, "Import-Module ServerManager; Add-WindowsFeature Adcs-Cert-Authority")
-		if err != nil {
-			p.Write([]byte(err.Error()))
-		} else {
-			p.Write([]byte(resp))
-		}
+	err = r.machine.Start()
+	if err != nil {
+		return err
+	}
+	return waitStatus(r.machine, vms.StatusUp)
+}
 
-		resp, err = provExec(p, machine, "$secpasswd = ConvertTo-SecureString '"+password+"' -AsPlainText -Force;$mycreds = New-Object System.Management.Automation.PSCredential ('"+username+"', $secpasswd); Install-AdcsCertificationAuthority -CAType 'EnterpriseRootCa' -Credential:$mycreds -force:$true ")
-		if err != nil {
-			p.Write([]byte(err.Error()))
-		} else {
-			p.Write([]byte(resp))
-		}
+// quote returns s as a PowerShell single quoted string.
+func quote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
 
-		resp, err = provExec(p, machine, "Start-Service RDMS; import-module remotedesktop ; New-RDSessionDeployment -ConnectionBroker "+pcname+"."+domain+" -WebAccessServer "+pcname+"."+domain+" -SessionHost "+pcname+"."+domain)
-		if err != nil {
-			p.Write([]byte(err.Error()))
-		} else {
-			p.Write([]byte(resp))
-		}
+func provisioningSteps(username, password, domain, pcname string) []provisioner.Step {
+	fqdn := pcname + "." + domain
+	ou := "DC=intra,DC=localdomain,DC=com"
+
+	return []provisioner.Step{
+		{
+			Name:  "disable-windows-update",
+			Check: "(Get-ItemProperty HKLM:\\SOFTWARE\\Policies\\Microsoft\\Windows\\WindowsUpdate\\AU -ErrorAction SilentlyContinue).NoAutoUpdate -eq 1",
+			Apply: "New-Item HKLM:\\SOFTWARE\\Policies\\Microsoft\\Windows -Name WindowsUpdate -Force; " +
+				"New-Item HKLM:\\SOFTWARE\\Policies\\Microsoft\\Windows\\WindowsUpdate -Name AU -Force; " +
+				"New-ItemProperty HKLM:\\SOFTWARE\\Policies\\Microsoft\\Windows\\WindowsUpdate\\AU -Name NoAutoUpdate -Value 1 -Force",
+		},
+		{
+			Name:  "ad-domain-services",
+			Check: "(Get-WindowsFeature AD-domain-services).Installed",
+			Apply: "Install-windowsfeature AD-domain-services",
+		},
+		{
+			Name:   "ad-forest",
+			Check:  "try { $null -ne (Get-ADDomain -ErrorAction Stop) } catch { $false }",
+			Apply:  "Import-Module ADDSDeployment; $pwd=ConvertTo-SecureString " + quote(password) + " -asplaintext -force; Install-ADDSForest -CreateDnsDelegation:$false -DatabasePath 'C:\\Windows\\NTDS' -DomainMode 'Win2012R2' -DomainName " + quote(domain) + " -SafeModeAdministratorPassword:$pwd -DomainNetbiosName 'INTRA' -ForestMode 'Win2012R2' -InstallDns:$true -LogPath 'C:\\Windows\\NTDS' -NoRebootOnCompletion:$true -SysvolPath 'C:\\Windows\\SYSVOL' -Force:$true",
+			Reboot: true,
+		},
+		{
+			Name:  "remote-desktop",
+			Check: "(Get-ItemProperty 'HKLM:\\System\\CurrentControlSet\\Control\\Terminal Server').fDenyTSConnections -eq 0",
+			Apply: "set-ItemProperty -Path 'HKLM:\\System\\CurrentControlSet\\Control\\Terminal Server' -name 'fDenyTSConnections' -Value 0; " +
+				"Enable-NetFirewallRule -DisplayGroup 'Remote Desktop'; " +
+				"set-ItemProperty -Path 'HKLM:\\System\\CurrentControlSet\\Control\\Terminal Server\\WinStations\\RDP-Tcp' -name 'UserAuthentication' -Value 1",
+		},
+		{
+			Name:  "rds-features",
+			Check: "$null -eq (Get-WindowsFeature RDS-RD-Server,RDS-Web-Access,RDS-Connection-Broker | Where-Object { -not $_.Installed })",
+			Apply: "import-module RemoteDesktop; Import-module ServerManager; Add-WindowsFeature -Name RDS-RD-Server -IncludeAllSubFeature; Add-WindowsFeature -Name RDS-Web-Access -IncludeAllSubFeature; Add-WindowsFeature -Name RDS-Connection-Broker -IncludeAllSubFeature",
+		},
+		{
+			Name:   "rsat-ad-admin-center",
+			Check:  "(Get-WindowsFeature RSAT-AD-AdminCenter).Installed",
+			Apply:  "import-module RemoteDesktop; Import-module ServerManager; Install-windowsfeature RSAT-AD-AdminCenter",
+			Reboot: true,
+		},
+		{
+			Name:  "rdms-autostart",
+			Check: "(Get-WmiObject Win32_Service -Filter \"Name='RDMS'\").StartMode -eq 'Auto'",
+			Apply: "sc.exe config RDMS start= auto",
+		},
+		{
+			Name:  "adcs-feature",
+			Check: "(Get-WindowsFeature Adcs-Cert-Authority).Installed",
+			Apply: "Import-Module ServerManager; Add-WindowsFeature Adcs-Cert-Authority",
+		},
+		{
+			Name:  "certification-authority",
+			Check: "$null -ne (Get-ItemProperty 'HKLM:\\SYSTEM\\CurrentControlSet\\Services\\CertSvc\\Configuration' -ErrorAction SilentlyContinue).Active",
+			Apply: "$secpasswd = ConvertTo-SecureString " + quote(password) + " -AsPlainText -Force;$mycreds = New-Object System.Management.Automation.PSCredential (" + quote(username) + ", $secpasswd); Install-AdcsCertificationAuthority -CAType 'EnterpriseRootCa' -Credential:$mycreds -force:$true ",
+		},
+		{
+			Name:    "session-deployment",
+			Check:   "import-module remotedesktop; $null -ne (Get-RDServer -ConnectionBroker " + quote(fqdn) + " -ErrorAction SilentlyContinue)",
+			Apply:   "Start-Service RDMS; import-module remotedesktop ; New-RDSessionDeployment -ConnectionBroker " + quote(fqdn) + " -WebAccessServer " + quote(fqdn) + " -SessionHost " + quote(fqdn),
+			Retries: 3,
+			Backoff: time.Minute,
+		},
+		{
+			Name:    "session-collection",
+			Check:   "import-module remotedesktop; $null -ne (Get-RDSessionCollection -CollectionName collection -ConnectionBroker " + quote(fqdn) + " -ErrorAction SilentlyContinue)",
+			Apply:   "import-module remotedesktop ; New-RDSessionCollection -CollectionName collection -SessionHost " + quote(fqdn) + " -CollectionDescription 'Nanocloud collection' -ConnectionBroker " + quote(fqdn),
+			Retries: 5,
+			Backoff: time.Minute,
+		},
+		{
+			Name:  "disable-nla",
+			Check: "(Get-WmiObject -class 'Win32_TSGeneralSetting' -Namespace root\\cimv2\\terminalservices -Filter \"TerminalName='RDP-tcp'\").UserAuthenticationRequired -eq 0",
+			Apply: "(Get-WmiObject -class 'Win32_TSGeneralSetting' -Namespace root\\cimv2\\terminalservices -ComputerName " + quote(pcname) + ").SetUserAuthenticationRequired(0)",
+		},
+		{
+			Name:    "users-ou",
+			Check:   "try { $null -ne (Get-ADOrganizationalUnit -Identity 'OU=NanocloudUsers," + ou + "' -ErrorAction Stop) } catch { $false }",
+			Apply:   "NEW-ADOrganizationalUnit 'NanocloudUsers' -path '" + ou + "'",
+			Retries: 3,
+			Backoff: 15 * time.Second,
+		},
+	}
+}
 
-		time.Sleep(time.Second * 60)
+// Provision sets up Active Directory and Remote Desktop Services on the
+// machine. The state of every step is saved in store so provisioning again a
+// machine resumes at the step that failed.
+func Provision(machine vms.Machine, store provisioner.Store, output io.Writer) error {
+	username, password, err := machine.Credentials()
+	if err != nil {
+		return err
+	}
 
-		resp, err = provExec(p, machine, "import-module remotedesktop ; New-RDSessionCollection -CollectionName collection -SessionHost "+pcname+"."+domain+" -CollectionDescription 'Nanocloud collection' -ConnectionBroker "+pcname+"."+domain)
-		if err != nil {
-			p.Write([]byte(err.Error()))
-		} else {
-			p.Write([]byte(resp))
-		}
+	domain := utils.Env("WINDOWS_DOMAIN", "")
+	if domain == "" {
+		return errors.New("domain unknown")
+	}
 
-		resp, err = provExec(p, machine, "(Get-WmiObject -class 'Win32_TSGeneralSetting' -Namespace root\\cimv2\\terminalservices -ComputerName "+pcname+").SetUserAuthenticationRequired(0)")
-		if err != nil {
-			p.Write([]byte(err.Error()))
-		} else {
-			p.Write([]byte(resp))
-		}
+	runner := &machineRunner{
+		machine: machine,
+		secrets: []string{quote(password), password},
+	}
 
-		resp, err = provExec(p, machine, "NEW-ADOrganizationalUnit 'NanocloudUsers' -path 'DC=intra,DC=localdomain,DC=com'")
-		if err != nil {
-			p.Write([]byte(err.Error()))
-		} else {
-			p.Write([]byte(resp))
-		}
+	pcname, err := runner.Exec("hostname")
+	if err != nil {
+		return err
 	}
+	pcname = strings.TrimSpace(pcname)
+
+	return provisioner.RunSteps(
+		provisioningSteps(username, password, domain, pcname),
+		runner, store, output,
+	)
 }
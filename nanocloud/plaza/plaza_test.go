@@ -0,0 +1,14 @@
+package plaza
+
+import (
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	r := &machineRunner{secrets: []string{quote("it's secret"), "it's secret", ""}}
+
+	out := r.redact("At line:1 char:9\n+ $pwd = 'it''s secret'; echo it's secret")
+	if out != "At line:1 char:9\n+ $pwd = ********; echo ********" {
+		t.Errorf("The secrets should be masked, got %q", out)
+	}
+}
@@ -0,0 +1,291 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package plaza
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// PowerShell operations never build scripts from their arguments. The script
+// of an operation is constant and its parameters are passed as a base64
+// encoded JSON document, decoded by the script into $params. The whole script
+// is sent with -EncodedCommand so nothing is interpreted by the shell either.
+
+const kPowershell = "C:\\Windows\\System32\\WindowsPowershell\\v1.0\\powershell.exe"
+
+// kScriptTemplate runs an operation and prints its result, or the error
+// raised, as JSON. %s are the encoded parameters and the operation.
+const kScriptTemplate = `$ErrorActionPreference = 'Stop'
+$params = [Text.Encoding]::UTF8.GetString([Convert]::FromBase64String('%s')) | ConvertFrom-Json
+try {
+	$result = & {
+%s
+	}
+	@{ data = $result } | ConvertTo-Json -Depth 4 -Compress
+} catch {
+	@{ error = $_.Exception.Message } | ConvertTo-Json -Compress
+	exit 1
+}
+`
+
+// PowershellError is the error raised by a PowerShell operation.
+type PowershellError struct {
+	Message string
+}
+
+func (e *PowershellError) Error() string {
+	return e.Message
+}
+
+// encodeCommand encodes a script for powershell's -EncodedCommand.
+func encodeCommand(script string) string {
+	u := utf16.Encode([]rune(script))
+	b := make([]byte, len(u)*2)
+	for i, c := range u {
+		b[i*2] = byte(c)
+		b[i*2+1] = byte(c >> 8)
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// powershellScript returns the complete script of an operation.
+func powershellScript(operation string, params interface{}) (string, error) {
+	p, err := json.Marshal(params)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(kScriptTemplate, base64.StdEncoding.EncodeToString(p), operation), nil
+}
+
+// parseResult unmarshals the output of an operation into result.
+func parseResult(stdout string, result interface{}) error {
+	var out struct {
+		Data  json.RawMessage `json:"data"`
+		Error string          `json:"error"`
+	}
+
+	err := json.Unmarshal([]byte(strings.TrimSpace(stdout)), &out)
+	if err != nil {
+		return errors.New("invalid output: " + stdout)
+	}
+	if out.Error != "" {
+		return &PowershellError{out.Error}
+	}
+	if result == nil || len(out.Data) == 0 || string(out.Data) == "null" {
+		return nil
+	}
+	return json.Unmarshal(out.Data, result)
+}
+
+// RunPowershell runs operation as the user with params available in $params
+// and unmarshals what it returns into result.
+func RunPowershell(
+	address string, port int,
+	username string, domain string,
+	operation string, params interface{}, result interface{},
+) error {
+	script, err := powershellScript(operation, params)
+	if err != nil {
+		return err
+	}
+
+	res, err := Exec(address, port, &Cmd_t{
+		Username:   username,
+		Domain:     domain,
+		HideWindow: true,
+		Wait:       true,
+		Command: []string{
+			kPowershell,
+			"-NoProfile",
+			"-NonInteractive",
+			"-EncodedCommand",
+			encodeCommand(script),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	err = parseResult(res.Stdout, result)
+	if err != nil {
+		return err
+	}
+	if res.State != "exited" || res.Code != 0 {
+		return errors.New("STDOUT: " + res.Stdout + "\nSTDERR: " + res.Stderr)
+	}
+	return nil
+}
+
+// RemoteApp is an application published on a session collection.
+type RemoteApp struct {
+	CollectionName string
+	Alias          string
+	DisplayName    string
+	FilePath       string
+	IconContents   []byte
+}
+
+// kSelectRemoteApp converts the RemoteApp objects to RemoteApp. Icons are
+// base64 encoded to be decoded as []byte.
+const kSelectRemoteApp = `Select-Object CollectionName, Alias, DisplayName, FilePath, @{ Name = 'IconContents'; Expression = { if ($_.IconContents) { [Convert]::ToBase64String($_.IconContents) } } }`
+
+func PublishRemoteApp(
+	address string, port int,
+	username string, domain string,
+	collectionName string, displayName string, filePath string,
+) (*RemoteApp, error) {
+	app := RemoteApp{}
+	err := RunPowershell(
+		address, port, username, domain,
+		`Import-Module RemoteDesktop
+New-RDRemoteApp -CollectionName $params.collection -DisplayName $params.name -FilePath $params.path | `+kSelectRemoteApp,
+		map[string]string{
+			"collection": collectionName,
+			"name":       displayName,
+			"path":       filePath,
+		},
+		&app,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &app, nil
+}
+
+func UnpublishRemoteApp(
+	address string, port int,
+	username string, domain string,
+	collectionName string, alias string,
+) error {
+	return RunPowershell(
+		address, port, username, domain,
+		`Import-Module RemoteDesktop
+Remove-RDRemoteApp -CollectionName $params.collection -Alias $params.alias -Force`,
+		map[string]string{
+			"collection": collectionName,
+			"alias":      alias,
+		},
+		nil,
+	)
+}
+
+func RemoteApps(
+	address string, port int,
+	username string, domain string,
+	collectionName string,
+) ([]RemoteApp, error) {
+	var apps []RemoteApp
+	err := RunPowershell(
+		address, port, username, domain,
+		`Import-Module RemoteDesktop
+,@(Get-RDRemoteApp -CollectionName $params.collection | `+kSelectRemoteApp+`)`,
+		map[string]string{
+			"collection": collectionName,
+		},
+		&apps,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return apps, nil
+}
+
+// parseSessions parses the output of "query session". Disconnected sessions
+// have no session name.
+func parseSessions(lines []string) []Session {
+	sessions := make([]Session, 0)
+	for _, line := range lines {
+		fields := strings.Fields(strings.TrimPrefix(line, ">"))
+
+		switch {
+		case len(fields) == 4:
+			if _, err := strconv.Atoi(fields[2]); err == nil {
+				sessions = append(sessions, Session{
+					Name:     fields[0],
+					Username: fields[1],
+					Id:       fields[2],
+					State:    fields[3],
+				})
+			}
+
+		case len(fields) == 3 && fields[2] == "Disc":
+			if fields[0] == "services" || fields[0] == "console" {
+				continue
+			}
+			if _, err := strconv.Atoi(fields[1]); err == nil {
+				sessions = append(sessions, Session{
+					Username: fields[0],
+					Id:       fields[1],
+					State:    fields[2],
+				})
+			}
+		}
+	}
+	return sessions
+}
+
+// QuerySessions lists the users' sessions opened on the server.
+func QuerySessions(
+	address string, port int,
+	username string, domain string,
+) ([]Session, error) {
+	var lines []string
+	err := RunPowershell(
+		address, port, username, domain,
+		`,@(query session)`,
+		nil,
+		&lines,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return parseSessions(lines), nil
+}
+
+// LogoffSession closes the session id.
+func LogoffSession(
+	address string, port int,
+	username string, domain string,
+	id string,
+) error {
+	_, err := strconv.Atoi(id)
+	if err != nil {
+		return errors.New("invalid session id: " + id)
+	}
+
+	return RunPowershell(
+		address, port, username, domain,
+		`logoff ([int]$params.id)
+if ($LASTEXITCODE -ne 0) { throw "logoff failed with code $LASTEXITCODE" }`,
+		map[string]string{
+			"id": id,
+		},
+		nil,
+	)
+}
@@ -0,0 +1,98 @@
+package plaza
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+func TestEncodeCommand(t *testing.T) {
+	encoded := encodeCommand("Write-Host 'é'")
+
+	b, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = uint16(b[i*2]) | uint16(b[i*2+1])<<8
+	}
+	if string(utf16.Decode(u)) != "Write-Host 'é'" {
+		t.Errorf("The command should be encoded in UTF-16LE, got: %s", string(utf16.Decode(u)))
+	}
+}
+
+func TestScriptParams(t *testing.T) {
+	params := map[string]string{
+		"name": "O'Brien'; Remove-Item C:\\ -Recurse; '",
+	}
+
+	script, err := powershellScript("$params.name", params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(script, "O'Brien") {
+		t.Errorf("Parameters should not appear in the script")
+	}
+
+	start := strings.Index(script, "FromBase64String('") + len("FromBase64String('")
+	end := strings.Index(script[start:], "'")
+	b, err := base64.StdEncoding.DecodeString(script[start : start+end])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := make(map[string]string)
+	err = json.Unmarshal(b, &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded["name"] != params["name"] {
+		t.Errorf("Parameters should be passed unchanged, got: %s", decoded["name"])
+	}
+}
+
+func TestParseResult(t *testing.T) {
+	app := RemoteApp{}
+	err := parseResult(`{"data":{"CollectionName":"collection","Alias":"notepad","DisplayName":"Notepad","FilePath":"C:\\notepad.exe","IconContents":"aWNvbg=="}}`+"\r\n", &app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app.Alias != "notepad" || string(app.IconContents) != "icon" {
+		t.Errorf("Unexpected result: %+v", app)
+	}
+
+	err = parseResult(`{"error":"The collection doesn't exist"}`, &app)
+	if e, ok := err.(*PowershellError); !ok || e.Message != "The collection doesn't exist" {
+		t.Errorf("The error raised should be returned, got: %v", err)
+	}
+
+	err = parseResult("not json", &app)
+	if err == nil {
+		t.Errorf("Invalid outputs should fail")
+	}
+}
+
+func TestParseSessions(t *testing.T) {
+	sessions := parseSessions([]string{
+		" SESSIONNAME       USERNAME                 ID  STATE   TYPE        DEVICE",
+		" services                                    0  Disc",
+		" console                                     1  Conn",
+		">rdp-tcp#0         Administrator             2  Active",
+		"                   john                      3  Disc",
+		" rdp-tcp                                 65536  Listen",
+	})
+
+	if len(sessions) != 2 {
+		t.Fatalf("Only users' sessions should be returned, got: %+v", sessions)
+	}
+	if sessions[0] != (Session{"rdp-tcp#0", "Administrator", "2", "Active"}) {
+		t.Errorf("Unexpected session: %+v", sessions[0])
+	}
+	if sessions[1] != (Session{"", "john", "3", "Disc"}) {
+		t.Errorf("Unexpected session: %+v", sessions[1])
+	}
+}
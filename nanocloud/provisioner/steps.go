@@ -0,0 +1,184 @@
+package provisioner
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	StepPending = "pending"
+	StepRunning = "running"
+	StepDone    = "done"
+	StepFailed  = "failed"
+
+	// StepRebooting is the state of the steps applied whose reboot hasn't
+	// completed yet.
+	StepRebooting = "rebooting"
+)
+
+// Step is an idempotent provisioning operation.
+type Step struct {
+	Name string
+
+	// Check is a command printing "True" when the step is already applied.
+	// Steps without check are only skipped when they are marked as done.
+	Check string
+
+	// Apply is the command performing the step.
+	Apply string
+
+	// Retries is the number of times Apply is run again when it fails.
+	// The delay between two attempts starts at Backoff and doubles every time.
+	Retries int
+	Backoff time.Duration
+
+	// Reboot is set when the machine must be rebooted once the step is
+	// applied.
+	Reboot bool
+}
+
+// StepState is the persisted progress of a step.
+type StepState struct {
+	Step     string `json:"step"`
+	Position int    `json:"position"`
+	State    string `json:"state"`
+	Attempts int    `json:"attempts"`
+	Error    string `json:"error"`
+}
+
+// Runner executes the steps on a machine.
+type Runner interface {
+	Exec(command string) (string, error)
+	Reboot() error
+}
+
+// Store persists the state of the steps of a machine so an interrupted
+// provisioning resumes where it stopped.
+type Store interface {
+	// Get returns nil if the step has never been run.
+	Get(step string) (*StepState, error)
+	Set(state *StepState) error
+}
+
+var sleep = time.Sleep
+
+func isApplied(step *Step, runner Runner) bool {
+	if step.Check == "" {
+		return false
+	}
+
+	out, err := runner.Exec(step.Check)
+	if err != nil {
+		return false
+	}
+	return strings.TrimSpace(out) == "True"
+}
+
+func applyStep(step *Step, state *StepState, runner Runner, store Store, output io.Writer) error {
+	backoff := step.Backoff
+	var err error
+
+	for attempt := 0; attempt <= step.Retries; attempt++ {
+		if attempt > 0 {
+			fmt.Fprintf(output, "Retrying %s in %s\n", step.Name, backoff)
+			sleep(backoff)
+			backoff *= 2
+		}
+
+		state.State = StepRunning
+		state.Attempts++
+		err = store.Set(state)
+		if err != nil {
+			return err
+		}
+
+		var out string
+		out, err = runner.Exec(step.Apply)
+		if out != "" {
+			fmt.Fprintln(output, out)
+		}
+		if err == nil {
+			return nil
+		}
+		fmt.Fprintln(output, err)
+	}
+	return err
+}
+
+// RunSteps runs in order the steps that aren't done yet and stops at the
+// first one failing. Running it again resumes at the failed step. A step is
+// only done once the reboot it needs has completed.
+func RunSteps(steps []Step, runner Runner, store Store, output io.Writer) error {
+	for i := range steps {
+		step := &steps[i]
+
+		state, err := store.Get(step.Name)
+		if err != nil {
+			return err
+		}
+		if state == nil {
+			state = &StepState{Step: step.Name, State: StepPending}
+		}
+		state.Position = i
+
+		if state.State == StepDone {
+			fmt.Fprintf(output, "Step %s already done\n", step.Name)
+			continue
+		}
+
+		if state.State == StepRebooting {
+			fmt.Fprintf(output, "Step %s already applied, resuming its reboot\n", step.Name)
+		} else if isApplied(step, runner) {
+			fmt.Fprintf(output, "Step %s already applied\n", step.Name)
+			state.State = StepDone
+			state.Error = ""
+			err = store.Set(state)
+			if err != nil {
+				return err
+			}
+			continue
+		} else {
+			fmt.Fprintf(output, "Applying step %s\n", step.Name)
+			err = applyStep(step, state, runner, store, output)
+			if err != nil {
+				state.State = StepFailed
+				state.Error = err.Error()
+				e := store.Set(state)
+				if e != nil {
+					return e
+				}
+				return fmt.Errorf("step %s failed: %s", step.Name, err)
+			}
+		}
+
+		if step.Reboot {
+			state.State = StepRebooting
+			state.Error = ""
+			err = store.Set(state)
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(output, "Rebooting after step %s\n", step.Name)
+			err = runner.Reboot()
+			if err != nil {
+				state.Error = err.Error()
+				e := store.Set(state)
+				if e != nil {
+					return e
+				}
+				return fmt.Errorf("reboot after step %s failed: %s", step.Name, err)
+			}
+		}
+
+		state.State = StepDone
+		state.Error = ""
+		err = store.Set(state)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
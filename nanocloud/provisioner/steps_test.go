@@ -0,0 +1,193 @@
+package provisioner
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeRunner struct {
+	applied  map[string]bool
+	fail     map[string]int
+	executed []string
+	reboots  int
+
+	failReboots int
+}
+
+func newFakeRunner() *fakeRunner {
+	return &fakeRunner{
+		applied: make(map[string]bool),
+		fail:    make(map[string]int),
+	}
+}
+
+func (r *fakeRunner) Exec(command string) (string, error) {
+	if strings.HasPrefix(command, "check:") {
+		if r.applied[strings.TrimPrefix(command, "check:")] {
+			return "True\r\n", nil
+		}
+		return "False\r\n", nil
+	}
+
+	r.executed = append(r.executed, command)
+	if r.fail[command] > 0 {
+		r.fail[command]--
+		return "", errors.New("fake-generated-error")
+	}
+	r.applied[command] = true
+	return "", nil
+}
+
+func (r *fakeRunner) Reboot() error {
+	if r.failReboots > 0 {
+		r.failReboots--
+		return errors.New("fake-generated-error")
+	}
+	r.reboots++
+	return nil
+}
+
+type memoryStore map[string]StepState
+
+func (s memoryStore) Get(step string) (*StepState, error) {
+	state, ok := s[step]
+	if !ok {
+		return nil, nil
+	}
+	return &state, nil
+}
+
+func (s memoryStore) Set(state *StepState) error {
+	s[state.Step] = *state
+	return nil
+}
+
+var testSteps = []Step{
+	{Name: "one", Check: "check:one", Apply: "one"},
+	{Name: "two", Apply: "two", Reboot: true},
+	{Name: "three", Check: "check:three", Apply: "three", Retries: 2, Backoff: time.Second},
+}
+
+func init() {
+	sleep = func(time.Duration) {}
+}
+
+func TestRunSteps(t *testing.T) {
+	runner := newFakeRunner()
+	store := make(memoryStore)
+
+	err := RunSteps(testSteps, runner, store, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(runner.executed) != 3 {
+		t.Errorf("Every step should be applied once, got: %v", runner.executed)
+	}
+	if runner.reboots != 1 {
+		t.Errorf("The machine should be rebooted once, got %d", runner.reboots)
+	}
+	for _, step := range testSteps {
+		if store[step.Name].State != StepDone {
+			t.Errorf("Step %s should be done", step.Name)
+		}
+	}
+
+	runner.executed = nil
+	err = RunSteps(testSteps, runner, store, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runner.executed) != 0 {
+		t.Errorf("Done steps should not be applied again, got: %v", runner.executed)
+	}
+}
+
+func TestAlreadyApplied(t *testing.T) {
+	runner := newFakeRunner()
+	runner.applied["one"] = true
+	store := make(memoryStore)
+
+	err := RunSteps(testSteps[:1], runner, store, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runner.executed) != 0 {
+		t.Errorf("Applied steps should be skipped, got: %v", runner.executed)
+	}
+	if store["one"].State != StepDone {
+		t.Errorf("Applied steps should be marked as done")
+	}
+}
+
+func TestRetries(t *testing.T) {
+	runner := newFakeRunner()
+	runner.fail["three"] = 2
+	store := make(memoryStore)
+
+	err := RunSteps(testSteps, runner, store, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store["three"].Attempts != 3 {
+		t.Errorf("Step three should have been attempted 3 times, got %d", store["three"].Attempts)
+	}
+}
+
+func TestResume(t *testing.T) {
+	runner := newFakeRunner()
+	runner.fail["three"] = 3
+	store := make(memoryStore)
+
+	err := RunSteps(testSteps, runner, store, ioutil.Discard)
+	if err == nil {
+		t.Fatalf("Provisioning should fail when a step runs out of retries")
+	}
+	if store["three"].State != StepFailed || store["three"].Error == "" {
+		t.Errorf("The failure should be persisted, got: %+v", store["three"])
+	}
+
+	runner.executed = nil
+	err = RunSteps(testSteps, runner, store, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runner.executed) != 1 || runner.executed[0] != "three" {
+		t.Errorf("Provisioning should resume at the failed step, got: %v", runner.executed)
+	}
+	if runner.reboots != 1 {
+		t.Errorf("Resuming should not reboot again, got %d reboots", runner.reboots)
+	}
+}
+
+func TestRebootResume(t *testing.T) {
+	runner := newFakeRunner()
+	runner.failReboots = 1
+	store := make(memoryStore)
+
+	err := RunSteps(testSteps, runner, store, ioutil.Discard)
+	if err == nil {
+		t.Fatalf("Provisioning should fail when the reboot fails")
+	}
+	if store["two"].State != StepRebooting || store["two"].Error == "" {
+		t.Errorf("A step should not be done before its reboot completes, got: %+v", store["two"])
+	}
+
+	runner.executed = nil
+	err = RunSteps(testSteps, runner, store, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runner.reboots != 1 {
+		t.Errorf("Resuming should reboot the machine, got %d reboots", runner.reboots)
+	}
+	if len(runner.executed) != 1 || runner.executed[0] != "three" {
+		t.Errorf("Resuming should not apply the step again, got: %v", runner.executed)
+	}
+	if store["two"].State != StepDone || store["three"].Position != 2 {
+		t.Errorf("Steps should be done and keep their position, got: %+v", store)
+	}
+}
@@ -27,7 +27,10 @@ import (
 	"io/ioutil"
 	"net/http"
 
+	apiErrors "github.com/Nanocloud/community/nanocloud/errors"
 	"github.com/Nanocloud/community/nanocloud/models/apps"
+	"github.com/Nanocloud/community/nanocloud/models/groups"
+	"github.com/Nanocloud/community/nanocloud/models/roles"
 	"github.com/Nanocloud/community/nanocloud/models/users"
 	"github.com/Nanocloud/community/nanocloud/utils"
 	log "github.com/Sirupsen/logrus"
@@ -50,6 +53,11 @@ func GetConnections(c *echo.Context) error {
 			"error": "Unable to retrieve applications list",
 		})
 	}
+	if err == apps.NoServerAvailable {
+		return c.JSON(http.StatusServiceUnavailable, hash{
+			"error": "No execution server available",
+		})
+	}
 
 	var response = make([]hash, len(connections))
 	for i, val := range connections {
@@ -66,7 +74,17 @@ func GetConnections(c *echo.Context) error {
 func ListApplications(c *echo.Context) error {
 	user := c.Get("user").(*users.User)
 
-	if !user.IsAdmin {
+	all, err := user.Can(roles.AppsRead)
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+
+	if !all && !user.InScope(roles.SessionsScope) {
+		return apiErrors.PermissionRequired.Detail("The access token isn't granted the " + roles.SessionsScope + " scope")
+	}
+
+	if !all {
 		applications, err := apps.GetUserApps(user.Id)
 		if err == apps.GetAppsFailed {
 			return c.JSON(http.StatusInternalServerError, hash{
@@ -90,7 +108,7 @@ func ListApplications(c *echo.Context) error {
 		return c.JSON(http.StatusOK, hash{"data": response})
 	}
 
-	applications, err := apps.GetAllApps()
+	applications, err := apps.GetAllApps(user.OrganizationId)
 	if err == apps.GetAppsFailed {
 		return c.JSON(http.StatusInternalServerError, hash{
 			"error": [1]hash{
@@ -128,7 +146,12 @@ func UnpublishApplication(c *echo.Context) error {
 		})
 	}
 
-	err := apps.UnpublishApp(user, appId)
+	err := checkApp(c, appId)
+	if err != nil {
+		return err
+	}
+
+	err = apps.UnpublishApp(user, appId)
 	if err == apps.UnpublishFailed {
 		return c.JSON(http.StatusInternalServerError, hash{
 			"error": [1]hash{
@@ -197,22 +220,11 @@ func ChangeAppName(c *echo.Context) error {
 		})
 	}
 
-	exists, err := apps.AppExists(appId)
+	err = checkApp(c, appId)
 	if err != nil {
-		log.Errorf("Unable to check app existence: %s", err.Error())
 		return err
 	}
 
-	if !exists {
-		return c.JSON(http.StatusNotFound, hash{
-			"error": [1]hash{
-				hash{
-					"detail": "App not found",
-				},
-			},
-		})
-	}
-
 	err = apps.ChangeName(appId, Name.Data.Attributes.DisplayName)
 	if err == apps.FailedNameChange {
 		return c.JSON(http.StatusInternalServerError, hash{
@@ -238,3 +250,167 @@ func ChangeAppName(c *echo.Context) error {
 
 	return utils.JSON(c, http.StatusOK, application)
 }
+
+// assignment describes a kind of target apps can be assigned to.
+type assignment struct {
+	kind string
+	// organization returns the organization of the target, "" if it doesn't
+	// exist
+	organization func(id string) (string, error)
+	notFound     error
+	list         func(appId string) ([]string, error)
+	grant        func(appId string, id string) error
+	revoke       func(appId string, id string) error
+}
+
+var (
+	userAssignment = &assignment{
+		kind:         "users",
+		organization: userOrganization,
+		notFound:     apiErrors.UserNotFound,
+		list:         apps.AppUsers,
+		grant:        apps.GrantUser,
+		revoke:       apps.RevokeUser,
+	}
+
+	groupAssignment = &assignment{
+		kind:         "groups",
+		organization: groupOrganization,
+		notFound:     apiErrors.GroupNotFound,
+		list:         apps.AppGroups,
+		grant:        apps.GrantGroup,
+		revoke:       apps.RevokeGroup,
+	}
+)
+
+func userOrganization(id string) (string, error) {
+	u, err := users.GetUser(id)
+	if err != nil || u == nil {
+		return "", err
+	}
+	return u.OrganizationId, nil
+}
+
+func groupOrganization(id string) (string, error) {
+	g, err := groups.GetGroup(id)
+	if err != nil || g == nil {
+		return "", err
+	}
+	return g.OrganizationId, nil
+}
+
+// checkApp returns an error unless the app belongs to the organization of the
+// current user.
+func checkApp(c *echo.Context, appId string) error {
+	user := c.Get("user").(*users.User)
+
+	app, err := apps.GetApp(appId)
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+	if app == nil || app.OrganizationId != user.OrganizationId {
+		return apiErrors.AppNotFound
+	}
+	return nil
+}
+
+func (a *assignment) listHandler(c *echo.Context) error {
+	appId := c.Param("app_id")
+	err := checkApp(c, appId)
+	if err != nil {
+		return err
+	}
+
+	ids, err := a.list(appId)
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+
+	response := make([]hash, len(ids))
+	for i, id := range ids {
+		response[i] = hash{
+			"type": a.kind,
+			"id":   id,
+		}
+	}
+	return c.JSON(http.StatusOK, hash{"data": response})
+}
+
+func (a *assignment) grantHandler(c *echo.Context) error {
+	appId := c.Param("app_id")
+	err := checkApp(c, appId)
+	if err != nil {
+		return err
+	}
+
+	id := c.Param("id")
+	org, err := a.organization(id)
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+	if org != c.Get("user").(*users.User).OrganizationId {
+		return a.notFound
+	}
+
+	err = a.grant(appId, id)
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+
+	return c.JSON(http.StatusOK, hash{
+		"data": hash{
+			"type": a.kind,
+			"id":   id,
+		},
+	})
+}
+
+func (a *assignment) revokeHandler(c *echo.Context) error {
+	err := checkApp(c, c.Param("app_id"))
+	if err != nil {
+		return err
+	}
+
+	err = a.revoke(c.Param("app_id"), c.Param("id"))
+	if err == apps.AssignmentNotFound {
+		return apiErrors.AssignmentNotFound
+	}
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+
+	return c.JSON(http.StatusOK, hash{
+		"meta": hash{},
+	})
+}
+
+func ListAppUsers(c *echo.Context) error {
+	return userAssignment.listHandler(c)
+}
+
+// Give a user access to an application
+func GrantUser(c *echo.Context) error {
+	return userAssignment.grantHandler(c)
+}
+
+func RevokeUser(c *echo.Context) error {
+	return userAssignment.revokeHandler(c)
+}
+
+func ListAppGroups(c *echo.Context) error {
+	return groupAssignment.listHandler(c)
+}
+
+// Give every member of a group access to an application
+func GrantGroup(c *echo.Context) error {
+	return groupAssignment.grantHandler(c)
+}
+
+func RevokeGroup(c *echo.Context) error {
+	return groupAssignment.revokeHandler(c)
+}
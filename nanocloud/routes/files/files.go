@@ -16,8 +16,10 @@ import (
 	"fmt"
 	"github.com/Nanocloud/community/nanocloud/connectors/db"
 	apiErrors "github.com/Nanocloud/community/nanocloud/errors"
+	"github.com/Nanocloud/community/nanocloud/models/roles"
 	"github.com/Nanocloud/community/nanocloud/models/users"
 	"github.com/Nanocloud/community/nanocloud/oauth2"
+	"github.com/Nanocloud/community/nanocloud/plaza"
 	"github.com/Nanocloud/community/nanocloud/utils"
 	log "github.com/Sirupsen/logrus"
 	"github.com/labstack/echo"
@@ -115,7 +117,8 @@ func createDownloadToken(user *users.User, accessToken string, filename string)
 }
 
 /**
- * Return the appropriate user for the download token and the filename.
+ * Return the appropriate user for the download token and the filename, with
+ * the scopes of the access token it was created from.
  */
 func checkDownloadToken(token, filename string) (*users.User, error) {
 	splt := strings.SplitN(token, ":", 2)
@@ -125,7 +128,7 @@ func checkDownloadToken(token, filename string) (*users.User, error) {
 
 	accessTokenId := splt[0]
 	rows, err := db.Query(
-		"SELECT token, user_id FROM oauth_access_tokens WHERE id = $1::varchar",
+		"SELECT token, user_id, scope FROM oauth_access_tokens WHERE id = $1::varchar",
 		accessTokenId,
 	)
 	if err != nil {
@@ -142,7 +145,8 @@ func checkDownloadToken(token, filename string) (*users.User, error) {
 
 	var accessToken string
 	var userId string
-	err = rows.Scan(&accessToken, &userId)
+	var scope string
+	err = rows.Scan(&accessToken, &userId, &scope)
 	if err != nil {
 		return nil, err
 	}
@@ -157,7 +161,12 @@ func checkDownloadToken(token, filename string) (*users.User, error) {
 		}
 	}
 
-	return users.GetUser(userId)
+	user, err := users.GetUser(userId)
+	if err != nil || user == nil {
+		return nil, err
+	}
+	user.Scopes = strings.Fields(scope)
+	return user, nil
 }
 
 func GetDownloadToken(c *echo.Context) error {
@@ -239,6 +248,10 @@ func Get(c *echo.Context) error {
 		user = u.(*users.User)
 	}
 
+	if !user.InScope(roles.FilesScope) {
+		return apiErrors.PermissionRequired.Detail("The access token isn't granted the " + roles.FilesScope + " scope")
+	}
+
 	winUser, err := user.WindowsCredentials()
 	if err != nil {
 		return err
@@ -248,7 +261,11 @@ func Get(c *echo.Context) error {
 		System       string `json:"System"`
 		Architecture string `json:"Architecture"`
 	}
-	resp, err := http.Get("http://" + utils.Env("PLAZA_ADDRESS", "iaas-module") + ":" + utils.Env("PLAZA_PORT", "9090"))
+	req, err := plaza.NewRequest("GET", "http://"+utils.Env("PLAZA_ADDRESS", "iaas-module")+":"+utils.Env("PLAZA_PORT", "9090"), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		log.Error(err)
 		return apiErrors.WindowsNotOnline.Detail(err.Error())
@@ -283,7 +300,11 @@ func Get(c *echo.Context) error {
 		path = filename
 	}
 
-	resp, err = http.Get("http://" + utils.Env("PLAZA_ADDRESS", "iaas-module") + ":" + utils.Env("PLAZA_PORT", "9090") + "/files?create=true&path=" + url.QueryEscape(path))
+	req, err = plaza.NewRequest("GET", "http://"+utils.Env("PLAZA_ADDRESS", "iaas-module")+":"+utils.Env("PLAZA_PORT", "9090")+"/files?create=true&path="+url.QueryEscape(path), nil)
+	if err != nil {
+		return err
+	}
+	resp, err = http.DefaultClient.Do(req)
 	if err != nil {
 		log.Error(err)
 		return apiErrors.WindowsNotOnline.Detail(err.Error())
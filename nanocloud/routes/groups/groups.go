@@ -0,0 +1,258 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package groups
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/Nanocloud/community/nanocloud/errors"
+	"github.com/Nanocloud/community/nanocloud/models/groups"
+	"github.com/Nanocloud/community/nanocloud/models/organizations"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+type hash map[string]interface{}
+
+// getGroup returns the group if it belongs to the organization of the current
+// user.
+func getGroup(c *echo.Context, id string) (*groups.Group, error) {
+	user := c.Get("user").(*users.User)
+
+	g, err := groups.GetGroup(id)
+	if err != nil {
+		log.Error(err)
+		return nil, errors.InternalError
+	}
+	if g == nil || g.OrganizationId != user.OrganizationId {
+		return nil, errors.GroupNotFound
+	}
+	return g, nil
+}
+
+func FindAll(c *echo.Context) error {
+	user := c.Get("user").(*users.User)
+
+	g, err := groups.FindAll(user.OrganizationId)
+	if err != nil {
+		log.Error(err)
+		return errors.InternalError
+	}
+	return utils.JSON(c, http.StatusOK, g)
+}
+
+func FindById(c *echo.Context) error {
+	g, err := getGroup(c, c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return utils.JSON(c, http.StatusOK, g)
+}
+
+func Create(c *echo.Context) error {
+	g := &groups.Group{}
+
+	err := utils.ParseJSONBody(c, g)
+	if err != nil {
+		log.Error(err)
+		return errors.InvalidRequest
+	}
+
+	g.Name = strings.TrimSpace(g.Name)
+	if g.Name == "" {
+		return errors.InvalidRequest.Detail("name is required")
+	}
+
+	user := c.Get("user").(*users.User)
+	g, err = groups.CreateGroup(user.OrganizationId, g.Name, g.Description)
+	if err == groups.GroupDuplicated {
+		return errors.InvalidRequest.Detail(err.Error())
+	}
+	if err != nil {
+		log.Error(err)
+		return errors.InternalError
+	}
+
+	return utils.JSON(c, http.StatusCreated, g)
+}
+
+func Update(c *echo.Context) error {
+	g, err := getGroup(c, c.Param("id"))
+	if err != nil {
+		return err
+	}
+	id, dn, org := g.Id, g.DirectoryDN, g.OrganizationId
+
+	err = utils.ParseJSONBody(c, g)
+	if err != nil {
+		log.Error(err)
+		return errors.InvalidRequest
+	}
+	// only the sync links groups to the directory
+	g.Id, g.DirectoryDN, g.OrganizationId = id, dn, org
+
+	g.Name = strings.TrimSpace(g.Name)
+	if g.Name == "" {
+		return errors.InvalidRequest.Detail("name is required")
+	}
+
+	err = g.Update()
+	if err == groups.GroupDuplicated {
+		return errors.InvalidRequest.Detail(err.Error())
+	}
+	if err == groups.GroupNotFound {
+		return errors.GroupNotFound
+	}
+	if err != nil {
+		log.Error(err)
+		return errors.InternalError
+	}
+
+	return utils.JSON(c, http.StatusOK, g)
+}
+
+func Delete(c *echo.Context) error {
+	g, err := getGroup(c, c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	err = g.Delete()
+	if err == groups.GroupNotFound {
+		return errors.GroupNotFound
+	}
+	if err != nil {
+		log.Error(err)
+		return errors.InternalError
+	}
+
+	return c.JSON(http.StatusOK, hash{"meta": hash{}})
+}
+
+func Members(c *echo.Context) error {
+	g, err := getGroup(c, c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	ids, err := g.Members()
+	if err != nil {
+		log.Error(err)
+		return errors.InternalError
+	}
+
+	response := make([]hash, len(ids))
+	for i, id := range ids {
+		response[i] = hash{
+			"type": "users",
+			"id":   id,
+		}
+	}
+	return c.JSON(http.StatusOK, hash{"data": response})
+}
+
+// getLocalGroup returns the group only if its members are managed by
+// Nanocloud. The members of synced groups come from Active Directory.
+func getLocalGroup(c *echo.Context, id string) (*groups.Group, error) {
+	g, err := getGroup(c, id)
+	if err != nil {
+		return nil, err
+	}
+	if g.Synced() {
+		return nil, errors.InvalidRequest.Detail("the members of this group are synced from Active Directory")
+	}
+	return g, nil
+}
+
+func AddMember(c *echo.Context) error {
+	g, err := getLocalGroup(c, c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	userId := c.Param("user_id")
+	member, err := users.GetUser(userId)
+	if err != nil {
+		log.Error(err)
+		return errors.InternalError
+	}
+	if member == nil || member.OrganizationId != g.OrganizationId {
+		return errors.UserNotFound
+	}
+
+	err = g.AddMember(userId)
+	if err != nil {
+		log.Error(err)
+		return errors.InternalError
+	}
+
+	return c.JSON(http.StatusOK, hash{
+		"data": hash{
+			"type": "users",
+			"id":   userId,
+		},
+	})
+}
+
+func RemoveMember(c *echo.Context) error {
+	g, err := getLocalGroup(c, c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	err = g.RemoveMember(c.Param("user_id"))
+	if err == groups.MembershipNotFound {
+		return errors.MembershipNotFound
+	}
+	if err != nil {
+		log.Error(err)
+		return errors.InternalError
+	}
+
+	return c.JSON(http.StatusOK, hash{"meta": hash{}})
+}
+
+// Sync mirrors the groups of the Active Directory organisation unit of the
+// organization right away.
+func Sync(c *echo.Context) error {
+	user := c.Get("user").(*users.User)
+
+	org, err := organizations.GetOrganization(user.OrganizationId)
+	if err != nil {
+		log.Error(err)
+		return errors.InternalError
+	}
+	if org == nil {
+		return errors.OrganizationNotFound
+	}
+
+	result, err := groups.Sync(org)
+	if err != nil {
+		log.Error(err)
+		return errors.InternalError.Detail("Unable to sync the groups from Active Directory")
+	}
+	return c.JSON(http.StatusOK, hash{"meta": result})
+}
@@ -26,6 +26,7 @@ import (
 	"net/http"
 
 	"github.com/Nanocloud/community/nanocloud/models/histories"
+	"github.com/Nanocloud/community/nanocloud/models/users"
 	"github.com/Nanocloud/community/nanocloud/utils"
 	log "github.com/Sirupsen/logrus"
 	"github.com/labstack/echo"
@@ -33,18 +34,21 @@ import (
 
 type hash map[string]interface{}
 
-// Get a list of all the log entries of the database
+// Get a list of all the log entries of the organization
 func List(c *echo.Context) error {
+	user := c.Get("user").(*users.User)
 
-	histories, err := histories.FindAll()
+	histories, err := histories.FindAll(user.OrganizationId)
 	if err != nil {
 		return err
 	}
 	return utils.JSON(c, http.StatusOK, histories)
 }
 
-// Add a new log entry to the database
+// Add a new log entry to the organization of the current user
 func Add(c *echo.Context) error {
+	user := c.Get("user").(*users.User)
+
 	history := histories.History{}
 
 	err := utils.ParseJSONBody(c, &history)
@@ -66,6 +70,7 @@ func Add(c *echo.Context) error {
 
 	err = utils.ParseJSONBody(c, &history)
 	newHistory, err := histories.CreateHistory(
+		user.OrganizationId,
 		history.UserId,
 		history.UserMail,
 		history.UserFirstname,
@@ -2,7 +2,7 @@
  * Nanocloud Community, a comprehensive platform to turn any application
  * into a cloud solution.
  *
- * Copyright (C) 2015 Nanocloud Software
+ * Copyright (C) 2016 Nanocloud Software
  *
  * This file is part of Nanocloud community.
  *
@@ -20,30 +20,37 @@
  * along with this program.  If not, see <http://www.gnu.org/licenses/>.
  */
 
-package middlewares
+package jobs
 
 import (
 	"net/http"
 
+	"github.com/Nanocloud/community/nanocloud/errors"
+	"github.com/Nanocloud/community/nanocloud/models/jobs"
 	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
 	"github.com/labstack/echo"
 )
 
-type hash map[string]interface{}
-
-func admin(c *echo.Context, handler echo.HandlerFunc) error {
-	user := c.Get("user").(*users.User)
-
-	if !user.IsAdmin {
-		return c.JSON(http.StatusForbidden, hash{
-			"error": "forbidden",
-		})
+func GetJob(c *echo.Context) error {
+	job, err := jobs.GetJob(c.Param("id"))
+	if err != nil {
+		log.Error(err)
+		return errors.InternalError
+	}
+	if job == nil {
+		return errors.JobNotFound
 	}
-	return handler(c)
-}
 
-func Admin(handler echo.HandlerFunc) echo.HandlerFunc {
-	return func(c *echo.Context) error {
-		return admin(c, handler)
+	// jobs are visible to the organization of the user who started them
+	owner, err := users.GetUser(job.UserId)
+	if err != nil {
+		log.Error(err)
+		return errors.InternalError
+	}
+	if owner == nil || owner.OrganizationId != c.Get("user").(*users.User).OrganizationId {
+		return errors.JobNotFound
 	}
+	return utils.JSON(c, http.StatusOK, job)
 }
@@ -0,0 +1,211 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package machinetypes
+
+import (
+	"net/http"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/vms"
+	"github.com/Nanocloud/community/nanocloud/errors"
+	"github.com/Nanocloud/community/nanocloud/models/machine-types"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	vm "github.com/Nanocloud/community/nanocloud/vms"
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+type hash map[string]interface{}
+
+func validate(t *machinetypes.MachineType) error {
+	switch {
+	case t.Name == "":
+		return errors.InvalidMachineType.Detail("name is required")
+	case t.CPU < 1:
+		return errors.InvalidMachineType.Detail("cpu must be greater than 0")
+	case t.RAM < 1:
+		return errors.InvalidMachineType.Detail("ram must be greater than 0")
+	case t.Disk < 1:
+		return errors.InvalidMachineType.Detail("disk must be greater than 0")
+	}
+	return nil
+}
+
+func driverExists(driver string) bool {
+	for _, d := range vms.Drivers() {
+		if d == driver {
+			return true
+		}
+	}
+	return false
+}
+
+// getMachineType returns the catalog entry of a namespaced machine type id.
+func getMachineType(id string) (*machinetypes.MachineType, error) {
+	driver, typeId, err := vms.SplitID(id)
+	if err != nil {
+		return nil, errors.MachineTypeNotFound
+	}
+
+	t, err := machinetypes.GetMachineType(driver, typeId)
+	if err != nil {
+		log.Error(err)
+		return nil, errors.InternalError
+	}
+	if t == nil {
+		return nil, errors.MachineTypeNotFound
+	}
+	return t, nil
+}
+
+func sendMachineType(c *echo.Context, status int, driver, id string) error {
+	t, err := vms.Type(vms.JoinID(driver, id))
+	if err != nil {
+		log.Error(err)
+		return errors.InternalError
+	}
+	if t == nil {
+		return errors.MachineTypeNotFound
+	}
+	return utils.JSON(c, status, t)
+}
+
+// FindAll lists the machine types of every opened driver, or of the driver
+// specified by the "driver" query parameter.
+func FindAll(c *echo.Context) error {
+	drivers := vms.Drivers()
+	if driver := c.Query("driver"); driver != "" {
+		if !driverExists(driver) {
+			return utils.JSON(c, http.StatusOK, []vm.MachineType{})
+		}
+		drivers = []string{driver}
+	}
+
+	rt := make([]vm.MachineType, 0)
+	for _, driver := range drivers {
+		types, err := vms.Types(driver)
+		if err != nil {
+			log.Error(err)
+			return errors.InternalError
+		}
+		rt = append(rt, types...)
+	}
+	return utils.JSON(c, http.StatusOK, rt)
+}
+
+func FindById(c *echo.Context) error {
+	t, err := vms.Type(c.Param("id"))
+	if err != nil || t == nil {
+		return errors.MachineTypeNotFound
+	}
+	return utils.JSON(c, http.StatusOK, t)
+}
+
+func Create(c *echo.Context) error {
+	t := &machinetypes.MachineType{}
+
+	err := utils.ParseJSONBody(c, t)
+	if err != nil {
+		log.Error(err)
+		return errors.InvalidRequest
+	}
+
+	if !driverExists(t.Driver) {
+		return errors.InvalidMachineType.Detail("unknown driver")
+	}
+
+	// IDs sent by the client are namespaced, the catalog stores them bare.
+	if t.ID != "" {
+		driver, id, err := vms.SplitID(t.ID)
+		if err != nil || driver != t.Driver {
+			return errors.InvalidMachineType.Detail("invalid id")
+		}
+		t.ID = id
+	}
+
+	err = validate(t)
+	if err != nil {
+		return err
+	}
+
+	t, err = machinetypes.CreateMachineType(t)
+	if err == machinetypes.MachineTypeDuplicated {
+		return errors.InvalidMachineType.Detail(err.Error())
+	}
+	if err != nil {
+		log.Error(err)
+		return errors.InternalError
+	}
+
+	return sendMachineType(c, http.StatusCreated, t.Driver, t.ID)
+}
+
+func Update(c *echo.Context) error {
+	t, err := getMachineType(c.Param("id"))
+	if err != nil {
+		return err
+	}
+	driver, id := t.Driver, t.ID
+
+	err = utils.ParseJSONBody(c, t)
+	if err != nil {
+		log.Error(err)
+		return errors.InvalidRequest
+	}
+
+	// a type can't be moved to another driver
+	t.Driver, t.ID = driver, id
+
+	err = validate(t)
+	if err != nil {
+		return err
+	}
+
+	err = t.Update()
+	if err == machinetypes.MachineTypeNotFound {
+		return errors.MachineTypeNotFound
+	}
+	if err != nil {
+		log.Error(err)
+		return errors.InternalError
+	}
+
+	return sendMachineType(c, http.StatusOK, driver, id)
+}
+
+func Delete(c *echo.Context) error {
+	t, err := getMachineType(c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	err = t.Delete()
+	if err == machinetypes.MachineTypeNotFound {
+		return errors.MachineTypeNotFound
+	}
+	if err != nil {
+		log.Error(err)
+		return errors.InternalError
+	}
+
+	return c.JSON(http.StatusOK, hash{"meta": hash{}})
+}
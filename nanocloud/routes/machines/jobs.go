@@ -0,0 +1,172 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package machines
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/vms"
+	"github.com/Nanocloud/community/nanocloud/models/jobs"
+	"github.com/Nanocloud/community/nanocloud/models/organizations"
+	vm "github.com/Nanocloud/community/nanocloud/vms"
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	ActionCreate    = "machine-create"
+	ActionStart     = "machine-start"
+	ActionStop      = "machine-stop"
+	ActionTerminate = "machine-terminate"
+)
+
+var (
+	kPollInterval  = 5 * time.Second
+	kPowerTimeout  = 15 * time.Minute
+	kCreateTimeout = time.Hour
+
+	errTimeout = errors.New("timed out")
+)
+
+// createMachine creates a machine owned by an organization.
+func createMachine(driver string, attr vm.MachineAttributes, organizationId string) jobs.JobFunc {
+	return func(job *jobs.Job, output io.Writer) error {
+		fmt.Fprintf(output, "Creating machine %s\n", attr.Name)
+
+		m, err := vms.Create(driver, attr)
+		if err != nil {
+			return err
+		}
+		if m == nil {
+			return errors.New("the driver did not return the created machine")
+		}
+
+		err = job.SetTarget(m.Id())
+		if err != nil {
+			return err
+		}
+
+		err = organizations.SetMachineOrganization(m.Id(), organizationId)
+		if err != nil {
+			return err
+		}
+
+		// Some drivers keep on creating the machine in the background.
+		err = poll(m, kCreateTimeout, func(status vm.MachineStatus) bool {
+			if status != vm.StatusCreating {
+				return true
+			}
+			reportProgress(job, m)
+			return false
+		})
+		if err == errTimeout {
+			return errors.New("machine still being created")
+		}
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(output, "Machine %s created\n", m.Id())
+		return nil
+	}
+}
+
+// reportProgress sets the progress of the job to the one of the machine.
+func reportProgress(job *jobs.Job, m vm.Machine) {
+	progress, err := m.Progress()
+	if err != nil {
+		log.Error("Unable to retrieve the progress of machine ", m.Id(), ": ", err)
+		return
+	}
+
+	err = job.SetProgress(int(progress))
+	if err != nil {
+		log.Error("Unable to set the progress of job ", job.Id, ": ", err)
+	}
+}
+
+// poll calls done with the status of the machine until it returns true. It
+// gives up with errTimeout after timeout.
+func poll(m vm.Machine, timeout time.Duration, done func(vm.MachineStatus) bool) error {
+	deadline := time.Now().Add(timeout)
+
+	for time.Now().Before(deadline) {
+		status, err := m.Status()
+		if err != nil {
+			return err
+		}
+		if done(status) {
+			return nil
+		}
+		time.Sleep(kPollInterval)
+	}
+	return errTimeout
+}
+
+func waitStatus(m vm.Machine, expected vm.MachineStatus) error {
+	err := poll(m, kPowerTimeout, func(status vm.MachineStatus) bool {
+		return status == expected
+	})
+	if err == errTimeout {
+		return fmt.Errorf("machine still not %s", vm.StatusToString(expected))
+	}
+	return err
+}
+
+func startMachine(m vm.Machine) jobs.JobFunc {
+	return func(job *jobs.Job, output io.Writer) error {
+		fmt.Fprintf(output, "Starting machine %s\n", m.Id())
+
+		err := m.Start()
+		if err != nil {
+			return err
+		}
+		return waitStatus(m, vm.StatusUp)
+	}
+}
+
+func stopMachine(m vm.Machine) jobs.JobFunc {
+	return func(job *jobs.Job, output io.Writer) error {
+		fmt.Fprintf(output, "Stopping machine %s\n", m.Id())
+
+		err := m.Stop()
+		if err != nil {
+			return err
+		}
+		return waitStatus(m, vm.StatusDown)
+	}
+}
+
+func terminateMachine(m vm.Machine) jobs.JobFunc {
+	return func(job *jobs.Job, output io.Writer) error {
+		fmt.Fprintf(output, "Terminating machine %s\n", m.Id())
+
+		err := m.Terminate()
+		if err != nil {
+			return err
+		}
+		return organizations.ForgetMachine(m.Id())
+	}
+}
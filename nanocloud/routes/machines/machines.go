@@ -27,6 +27,10 @@ import (
 
 	"github.com/Nanocloud/community/nanocloud/connectors/vms"
 	"github.com/Nanocloud/community/nanocloud/errors"
+	"github.com/Nanocloud/community/nanocloud/health"
+	"github.com/Nanocloud/community/nanocloud/models/jobs"
+	"github.com/Nanocloud/community/nanocloud/models/organizations"
+	"github.com/Nanocloud/community/nanocloud/models/users"
 	"github.com/Nanocloud/community/nanocloud/utils"
 	vm "github.com/Nanocloud/community/nanocloud/vms"
 	log "github.com/Sirupsen/logrus"
@@ -44,7 +48,9 @@ type machine struct {
 	Username      string `json:"username"`
 	AdminPassword string `json:"admin-password,omitempty"`
 	Platform      string `json:"platform"`
+	Driver        string `json:"driver"`
 	Progress      int    `json:"progress"`
+	Health        string `json:"health"`
 }
 
 func (m *machine) GetID() string {
@@ -63,6 +69,34 @@ func (m *machine) SetToOneReferenceID(name, ID string) error {
 	return nil
 }
 
+// inOrganization returns whether the machine belongs to the organization of
+// the current user. Machines belonging to no organization belong to none of
+// the users.
+func inOrganization(c *echo.Context, id string) (bool, error) {
+	org, err := organizations.MachineOrganization(id)
+	if err == organizations.MachineNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return org == c.Get("user").(*users.User).OrganizationId, nil
+}
+
+// checkMachine returns an error unless the machine belongs to the organization
+// of the current user.
+func checkMachine(c *echo.Context, id string) error {
+	ok, err := inOrganization(c, id)
+	if err != nil {
+		log.Error(err)
+		return errors.InternalError
+	}
+	if !ok {
+		return errors.MachineNotFound
+	}
+	return nil
+}
+
 func PatchMachine(c *echo.Context) error {
 	b := &machine{}
 
@@ -72,8 +106,13 @@ func PatchMachine(c *echo.Context) error {
 		return errors.UnableToUpdateMachineStatus
 	}
 
-	m, err := vms.Machine(b.Id)
+	err = checkMachine(c, b.Id)
 	if err != nil {
+		return err
+	}
+
+	m, err := vms.Machine(b.Id)
+	if err != nil || m == nil {
 		log.Error(err)
 		return errors.UnableToUpdateMachineStatus
 	}
@@ -84,46 +123,38 @@ func PatchMachine(c *echo.Context) error {
 		return errors.UnableToUpdateMachineStatus
 	}
 
+	user := c.Get("user").(*users.User)
+
+	var job *jobs.Job
 	switch b.Status {
 	case "up":
 		if status != vms.StatusDown {
 			return errors.UnableToUpdateMachineStatus
 		}
-
-		err = m.Start()
-		if err != nil {
-			log.Error(err)
-			return errors.UnableToUpdateMachineStatus
-		}
+		job, err = jobs.Enqueue(ActionStart, m.Id(), user.Id, startMachine(m))
 
 	case "down":
 		if status != vms.StatusUp {
 			return errors.UnableToUpdateMachineStatus
 		}
-		err = m.Stop()
-		if err != nil {
-			log.Error(err)
-			return errors.UnableToUpdateMachineStatus
-		}
+		job, err = jobs.Enqueue(ActionStop, m.Id(), user.Id, stopMachine(m))
 
 	default:
-		log.Error(err)
-		return errors.UnableToUpdateMachineStatus
+		return errors.InvalidMarchineStatus
 	}
 
-	m, err = vms.Machine(m.Id())
-	if err != nil {
-		log.Error(err)
-		return errors.UnableToUpdateMachineStatus
-	}
-
-	rt, err := getSerializableMachine(m.Id())
 	if err != nil {
 		log.Error(err)
 		return errors.UnableToUpdateMachineStatus
 	}
+	return sendJob(c, job)
+}
 
-	return utils.JSON(c, http.StatusOK, rt)
+// sendJob replies that the operation has been accepted and points to the job
+// performing it.
+func sendJob(c *echo.Context, job *jobs.Job) error {
+	c.Response().Header().Set("Location", "/api/jobs/"+job.Id)
+	return utils.JSON(c, http.StatusAccepted, job)
 }
 
 func getSerializableMachine(id string) (*machine, error) {
@@ -136,6 +167,10 @@ func getSerializableMachine(id string) (*machine, error) {
 
 	rt.Id = m.Id()
 	rt.Platform = m.Platform()
+	rt.Driver, _, err = vms.SplitID(rt.Id)
+	if err != nil {
+		return nil, err
+	}
 
 	rt.Name, err = m.Name()
 	if err != nil {
@@ -155,12 +190,20 @@ func getSerializableMachine(id string) (*machine, error) {
 
 	if ip != nil {
 		rt.Ip = ip.String()
+		rt.Health = health.Status(rt.Ip)
+	} else {
+		rt.Health = health.StatusUnknown
 	}
 
 	return &rt, nil
 }
 
 func GetMachine(c *echo.Context) error {
+	err := checkMachine(c, c.Param("id"))
+	if err != nil {
+		return err
+	}
+
 	m, err := getSerializableMachine(c.Param("id"))
 	if err != nil {
 		return err
@@ -169,6 +212,22 @@ func GetMachine(c *echo.Context) error {
 	return utils.JSON(c, http.StatusOK, m)
 }
 
+// AdoptMachines gives the machines of the drivers which belong to no
+// organization, like the ones created before organizations existed or outside
+// Nanocloud, to the default organization.
+func AdoptMachines() error {
+	machines, err := vms.Machines()
+	if err != nil {
+		return err
+	}
+
+	ids := make([]string, 0, len(machines))
+	for _, m := range machines {
+		ids = append(ids, m.Id())
+	}
+	return organizations.AdoptMachines(ids)
+}
+
 func Machines(c *echo.Context) error {
 	machines, err := vms.Machines()
 	if err != nil {
@@ -176,9 +235,18 @@ func Machines(c *echo.Context) error {
 		return errors.UnableToRetrieveMachineList
 	}
 
-	res := make([]*machine, len(machines))
+	res := make([]*machine, 0, len(machines))
+
+	for _, val := range machines {
+		ok, err := inOrganization(c, val.Id())
+		if err != nil {
+			log.Error(err)
+			return errors.UnableToRetrieveMachineList
+		}
+		if !ok {
+			continue
+		}
 
-	for i, val := range machines {
 		m := machine{}
 		m.Name, err = val.Name()
 		if err != nil {
@@ -194,6 +262,11 @@ func Machines(c *echo.Context) error {
 		}
 
 		m.Platform = val.Platform()
+		m.Driver, _, err = vms.SplitID(m.Id)
+		if err != nil {
+			log.Error(err)
+			return errors.UnableToRetrieveMachineList
+		}
 
 		m.Status = vm.StatusToString(status)
 
@@ -207,9 +280,12 @@ func Machines(c *echo.Context) error {
 		ip, _ := val.IP()
 		if ip != nil {
 			m.Ip = ip.String()
+			m.Health = health.Status(m.Ip)
+		} else {
+			m.Health = health.StatusUnknown
 		}
 
-		res[i] = &m
+		res = append(res, &m)
 	}
 
 	return utils.JSON(c, http.StatusOK, res)
@@ -225,6 +301,11 @@ func CreateMachine(c *echo.Context) error {
 	}
 
 	machineType, err := vms.Type(rt.Type)
+	if err != nil || machineType == nil {
+		return errors.MachineTypeNotFound
+	}
+
+	driver, _, err := vms.SplitID(machineType.GetID())
 	if err != nil {
 		return errors.MachineTypeNotFound
 	}
@@ -237,33 +318,36 @@ func CreateMachine(c *echo.Context) error {
 		Ip:       rt.Ip,
 	}
 
-	m, err := vms.Create(attr)
+	user := c.Get("user").(*users.User)
+
+	job, err := jobs.Enqueue(ActionCreate, "", user.Id, createMachine(driver, attr, user.OrganizationId))
 	if err != nil {
 		log.Error(err)
 		return errors.UnableToCreateTheMachine
 	}
-
-	rt, err = getSerializableMachine(m.Id())
-	if err != nil {
-		return err
-	}
-
-	return utils.JSON(c, http.StatusOK, rt)
+	return sendJob(c, job)
 }
 
 func DeleteMachine(c *echo.Context) error {
 	id := c.Param("id")
 
-	m, err := vms.Machine(id)
+	err := checkMachine(c, id)
 	if err != nil {
+		return err
+	}
+
+	m, err := vms.Machine(id)
+	if err != nil || m == nil {
 		log.Error(err)
 		return errors.UnableToTerminateTheMachine
 	}
 
-	err = m.Terminate()
+	user := c.Get("user").(*users.User)
+
+	job, err := jobs.Enqueue(ActionTerminate, m.Id(), user.Id, terminateMachine(m))
 	if err != nil {
 		log.Error(err)
 		return errors.UnableToTerminateTheMachine
 	}
-	return c.JSON(http.StatusOK, hash{"meta": hash{}})
+	return sendJob(c, job)
 }
@@ -0,0 +1,259 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package machines
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/vms"
+	"github.com/Nanocloud/community/nanocloud/errors"
+	"github.com/Nanocloud/community/nanocloud/models/jobs"
+	"github.com/Nanocloud/community/nanocloud/models/provisioning"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/plaza"
+	vm "github.com/Nanocloud/community/nanocloud/vms"
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+const ActionProvision = "machine-provision"
+
+// eventStream writes Server-Sent Events. The provisioning output is broadcast
+// from another goroutine so writes are refused once the request is over.
+type eventStream struct {
+	r      *echo.Response
+	mut    sync.Mutex
+	closed bool
+}
+
+func (s *eventStream) send(event string, data []byte) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if s.closed {
+		return io.ErrClosedPipe
+	}
+
+	var buff bytes.Buffer
+	if event != "" {
+		buff.WriteString("event: " + event + "\n")
+	}
+	for _, line := range bytes.Split(bytes.TrimSuffix(data, []byte("\n")), []byte("\n")) {
+		buff.WriteString("data: ")
+		buff.Write(line)
+		buff.WriteString("\n")
+	}
+	buff.WriteString("\n")
+
+	_, err := s.r.Write(buff.Bytes())
+	if err != nil {
+		s.closed = true
+		return err
+	}
+	s.r.Flush()
+	return nil
+}
+
+func (s *eventStream) Write(p []byte) (int, error) {
+	err := s.send("", p)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *eventStream) Close() {
+	s.mut.Lock()
+	s.closed = true
+	s.mut.Unlock()
+}
+
+func provisionMachine(m vm.Machine) jobs.JobFunc {
+	return func(job *jobs.Job, output io.Writer) error {
+		return plaza.Provision(m, provisioning.NewStore(m.Id()), output)
+	}
+}
+
+// ResumeProvisioning restarts the provisioning jobs interrupted by a previous
+// process. They resume at the step they were running.
+func ResumeProvisioning(interrupted []*jobs.Job) error {
+	for _, job := range interrupted {
+		m, err := vms.Machine(job.Target)
+		if err != nil || m == nil {
+			log.WithFields(log.Fields{
+				"machine": job.Target,
+			}).Error("Unable to resume provisioning: ", err)
+			continue
+		}
+
+		_, err = jobs.Enqueue(ActionProvision, m.Id(), job.UserId, provisionMachine(m))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Provision starts the provisioning of a machine unless it's already being
+// provisioned. The steps already done are skipped unless "reset" is set.
+func Provision(c *echo.Context) error {
+	err := checkMachine(c, c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	m, err := vms.Machine(c.Param("id"))
+	if err != nil || m == nil {
+		log.Error(err)
+		return errors.MachineNotFound
+	}
+
+	job, err := jobs.FindLatest(ActionProvision, m.Id())
+	if err != nil {
+		log.Error(err)
+		return errors.InternalError
+	}
+	if job != nil && !job.Done() {
+		return sendJob(c, job)
+	}
+
+	if c.Query("reset") == "true" {
+		err = provisioning.NewStore(m.Id()).Reset()
+		if err != nil {
+			log.Error(err)
+			return errors.InternalError
+		}
+	}
+
+	user := c.Get("user").(*users.User)
+
+	job, err = jobs.Enqueue(ActionProvision, m.Id(), user.Id, provisionMachine(m))
+	if err != nil {
+		log.Error(err)
+		return errors.InternalError
+	}
+	return sendJob(c, job)
+}
+
+// ProvisioningLog streams the output of the last provisioning of a machine as
+// Server-Sent Events. The output written before the client connected is sent
+// first. An "end" event carrying the job state closes the stream.
+func ProvisioningLog(c *echo.Context) error {
+	id := c.Param("id")
+
+	err := checkMachine(c, id)
+	if err != nil {
+		return err
+	}
+
+	job, err := jobs.FindLatest(ActionProvision, id)
+	if err != nil {
+		log.Error(err)
+		return errors.InternalError
+	}
+	if job == nil {
+		return errors.ProvisioningNotFound
+	}
+
+	r := c.Response()
+	r.Header().Set("Content-Type", "text/event-stream")
+	r.Header().Set("Cache-Control", "no-cache")
+	r.Header().Set("Connection", "keep-alive")
+	r.WriteHeader(http.StatusOK)
+	r.Flush()
+
+	stream := &eventStream{r: r}
+	defer stream.Close()
+
+	p := jobs.AddOutput(job.Id, stream)
+	if p != nil {
+		done := make(chan bool)
+		go func() {
+			p.Wait()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-r.CloseNotify():
+			return nil
+		}
+
+		job, err = jobs.GetJob(job.Id)
+		if err != nil || job == nil {
+			log.Error(err)
+			return nil
+		}
+	} else {
+		// the job is over, or run by a previous process: replay what it
+		// wrote
+		output, err := job.Output()
+		if err != nil {
+			log.Error(err)
+			return nil
+		}
+		if output != "" {
+			stream.send("", []byte(output))
+		}
+	}
+
+	end, err := json.Marshal(map[string]string{
+		"state": job.State,
+		"error": job.Error,
+	})
+	if err != nil {
+		log.Error(err)
+		return nil
+	}
+	stream.send("end", end)
+	return nil
+}
+
+// ProvisioningSteps lists the state of the provisioning steps already run on a
+// machine.
+func ProvisioningSteps(c *echo.Context) error {
+	err := checkMachine(c, c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	steps, err := provisioning.NewStore(c.Param("id")).Steps()
+	if err != nil {
+		log.Error(err)
+		return errors.InternalError
+	}
+
+	data := make([]hash, len(steps))
+	for i, step := range steps {
+		data[i] = hash{
+			"id":         step.Step,
+			"type":       "provisioning-steps",
+			"attributes": step,
+		}
+	}
+	return c.JSON(http.StatusOK, hash{"data": data})
+}
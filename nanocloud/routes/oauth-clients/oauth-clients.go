@@ -0,0 +1,173 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package oauthclients
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/Nanocloud/community/nanocloud/errors"
+	"github.com/Nanocloud/community/nanocloud/models/oauth"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+type hash map[string]interface{}
+
+// getClient returns the client if the organization of the current user
+// manages it.
+func getClient(c *echo.Context) (*oauth.Client, error) {
+	user := c.Get("user").(*users.User)
+
+	client, err := oauth.GetClientOf(user.OrganizationId, c.Param("id"))
+	if err != nil {
+		log.Error(err)
+		return nil, errors.InternalError
+	}
+	if client == nil {
+		return nil, errors.OAuthClientNotFound
+	}
+	return client, nil
+}
+
+func modelError(err error) error {
+	switch err {
+	case nil:
+		return nil
+	case oauth.ClientNotFound:
+		return errors.OAuthClientNotFound
+	case oauth.ClientDuplicated, oauth.DefaultClientChange, oauth.InvalidRedirectURI,
+		oauth.InvalidScope, oauth.InvalidLifetime:
+		return errors.InvalidRequest.Detail(err.Error())
+	}
+	log.Error(err)
+	return errors.InternalError
+}
+
+func FindAll(c *echo.Context) error {
+	user := c.Get("user").(*users.User)
+
+	clients, err := oauth.FindClients(user.OrganizationId)
+	if err != nil {
+		log.Error(err)
+		return errors.InternalError
+	}
+	return utils.JSON(c, http.StatusOK, clients)
+}
+
+func FindById(c *echo.Context) error {
+	client, err := getClient(c)
+	if err != nil {
+		return err
+	}
+	return utils.JSON(c, http.StatusOK, client)
+}
+
+// Create registers a client of the organization of the current user. Its
+// secret is only sent in the response.
+func Create(c *echo.Context) error {
+	client := &oauth.Client{
+		Confidential:         true,
+		AccessTokenLifetime:  oauth.DefaultAccessTokenLifetime,
+		RefreshTokenLifetime: oauth.DefaultRefreshTokenLifetime,
+	}
+
+	err := utils.ParseJSONBody(c, client)
+	if err != nil {
+		log.Error(err)
+		return errors.InvalidRequest
+	}
+
+	client.Name = strings.TrimSpace(client.Name)
+	if client.Name == "" {
+		return errors.InvalidRequest.Detail("name is required")
+	}
+
+	user := c.Get("user").(*users.User)
+	client, err = oauth.CreateClient(user.OrganizationId, client)
+	if err != nil {
+		return modelError(err)
+	}
+	return utils.JSON(c, http.StatusCreated, client)
+}
+
+func Update(c *echo.Context) error {
+	client, err := getClient(c)
+	if err != nil {
+		return err
+	}
+	id, key, confidential, org := client.Id, client.Key, client.Confidential, client.OrganizationId
+
+	err = utils.ParseJSONBody(c, client)
+	if err != nil {
+		log.Error(err)
+		return errors.InvalidRequest
+	}
+	client.Id, client.Key, client.Confidential, client.OrganizationId = id, key, confidential, org
+	client.Secret = ""
+
+	client.Name = strings.TrimSpace(client.Name)
+	if client.Name == "" {
+		return errors.InvalidRequest.Detail("name is required")
+	}
+
+	err = client.Update()
+	if err != nil {
+		return modelError(err)
+	}
+	return utils.JSON(c, http.StatusOK, client)
+}
+
+// ResetSecret gives a confidential client a new secret, only sent in the
+// response. The former one stops working right away.
+func ResetSecret(c *echo.Context) error {
+	client, err := getClient(c)
+	if err != nil {
+		return err
+	}
+	if !client.Confidential {
+		return errors.InvalidRequest.Detail("public clients have no secret")
+	}
+
+	err = client.ResetSecret()
+	if err != nil {
+		return modelError(err)
+	}
+	return utils.JSON(c, http.StatusOK, client)
+}
+
+// Delete deletes a client and revokes its tokens.
+func Delete(c *echo.Context) error {
+	client, err := getClient(c)
+	if err != nil {
+		return err
+	}
+
+	err = client.Delete()
+	if err != nil {
+		return modelError(err)
+	}
+	return c.JSON(http.StatusOK, hash{"meta": hash{}})
+}
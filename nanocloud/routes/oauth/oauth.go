@@ -1,10 +1,104 @@
 package oauth
 
 import (
-	"github.com/Nanocloud/community/nanocloud/oauth2"
 	"net/http"
+
+	apiErrors "github.com/Nanocloud/community/nanocloud/errors"
+	"github.com/Nanocloud/community/nanocloud/models/oauth"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/oauth2"
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
 )
 
+type hash map[string]interface{}
+
 func Handler(w http.ResponseWriter, r *http.Request) {
 	oauth2.HandleRequest(w, r)
 }
+
+// authorizationRequest returns the authorization request of the query string.
+// Requests with errors the client can be told of are returned with them.
+func authorizationRequest(c *echo.Context) (*oauth.AuthorizationRequest, *oauth.AuthorizationError, error) {
+	r, err := oauth.ParseAuthorizationRequest(c.Request().URL.Query())
+	switch err := err.(type) {
+	case nil:
+		return r, nil, nil
+	case *oauth.AuthorizationError:
+		return r, err, nil
+	}
+
+	if err == oauth.UnknownClient || err == oauth.UnknownRedirectURI {
+		return nil, nil, apiErrors.InvalidRequest.Detail(err.Error())
+	}
+	log.Error(err)
+	return nil, nil, apiErrors.InternalError
+}
+
+// Authorize is the authorization endpoint of the authorization code grant.
+// Valid requests are sent to the consent screen of the web interface, which
+// logs the user in first if needed.
+func Authorize(c *echo.Context) error {
+	r, authErr, err := authorizationRequest(c)
+	if err != nil {
+		return err
+	}
+	if authErr != nil {
+		return c.Redirect(http.StatusFound, r.ErrorRedirect(authErr))
+	}
+	return c.Redirect(http.StatusFound, "/#/authorize?"+c.Request().URL.RawQuery)
+}
+
+// Consent describes the authorization request of the query string to the
+// user asked to approve it.
+func Consent(c *echo.Context) error {
+	r, authErr, err := authorizationRequest(c)
+	if err != nil {
+		return err
+	}
+	if authErr != nil {
+		return apiErrors.InvalidRequest.Detail(authErr.Description)
+	}
+
+	user := c.Get("user").(*users.User)
+	if !r.Allowed(user) {
+		return apiErrors.PermissionRequired.Detail(oauth.ClientNotAllowed.Error())
+	}
+
+	return c.JSON(http.StatusOK, hash{"meta": hash{
+		"client":       r.Client.Name,
+		"redirect-uri": r.RedirectURI,
+		"scopes":       r.Scopes,
+	}})
+}
+
+// Decide approves the authorization request of the query string on behalf of
+// the current user, or denies it, as the approved parameter says. It returns
+// where to send the user back to the client.
+func Decide(c *echo.Context) error {
+	r, authErr, err := authorizationRequest(c)
+	if err != nil {
+		return err
+	}
+
+	var redirect string
+	switch {
+	case authErr != nil:
+		redirect = r.ErrorRedirect(authErr)
+	case c.Request().FormValue("approved") != "true":
+		redirect = r.Deny()
+	default:
+		redirect, err = r.Approve(c.Get("user").(*users.User))
+		if err == oauth.ClientNotAllowed {
+			return apiErrors.PermissionRequired.Detail(err.Error())
+		}
+		if err != nil {
+			log.Error(err)
+			return apiErrors.InternalError
+		}
+	}
+
+	return c.JSON(http.StatusOK, hash{"meta": hash{
+		"redirect": redirect,
+	}})
+}
@@ -0,0 +1,167 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package oidc
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	apiErrors "github.com/Nanocloud/community/nanocloud/errors"
+	"github.com/Nanocloud/community/nanocloud/models/oauth"
+	"github.com/Nanocloud/community/nanocloud/models/oidc"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/oauth2"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+type hash map[string]interface{}
+
+// provider returns the identity provider of the request.
+func provider(c *echo.Context) (*oidc.Provider, error) {
+	p, err := oidc.GetProvider(c.Param("provider"))
+	if err != nil {
+		log.Error(err)
+		return nil, apiErrors.InternalError
+	}
+	if p == nil {
+		return nil, apiErrors.IdentityProviderNotFound
+	}
+	return p, nil
+}
+
+// redirectURI returns the callback the provider sends the users back to.
+func redirectURI(c *echo.Context, p *oidc.Provider) string {
+	if p.RedirectURI != "" {
+		return p.RedirectURI
+	}
+
+	return utils.BaseURL(c.Request()) + "/oidc/" + url.QueryEscape(p.Name) + "/callback"
+}
+
+// Providers lists the identity providers users can log in with.
+func Providers(c *echo.Context) error {
+	providers, err := oidc.Providers()
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+
+	r := make([]hash, len(providers))
+	for i, p := range providers {
+		r[i] = hash{
+			"id":   p.Name,
+			"type": "identity-provider",
+			"attributes": hash{
+				"login-url": "/oidc/" + url.QueryEscape(p.Name) + "/login",
+			},
+		}
+	}
+	return c.JSON(http.StatusOK, hash{"data": r})
+}
+
+// Login redirects the user to the identity provider.
+func Login(c *echo.Context) error {
+	p, err := provider(c)
+	if err != nil {
+		return err
+	}
+
+	redirect := c.Query("redirect")
+	if redirect != "" && !utils.SafeRedirect(redirect) {
+		return apiErrors.InvalidRequest.Detail("redirect must be a path of Nanocloud without fragment")
+	}
+
+	authURL, err := p.AuthCodeURL(redirectURI(c, p), redirect)
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to reach the identity provider")
+	}
+	return c.Redirect(http.StatusFound, authURL)
+}
+
+// Callback finishes the login of a user sent back by the identity provider
+// and issues them a Nanocloud access token. It is sent in the fragment of the
+// redirect of the login, or as JSON, like /oauth/token, without redirect.
+func Callback(c *echo.Context) error {
+	p, err := provider(c)
+	if err != nil {
+		return err
+	}
+
+	if e := c.Query("error"); e != "" {
+		return apiErrors.Unauthorized.Detail("The identity provider refused the login: " + e)
+	}
+
+	login, err := p.TakeLogin(c.Query("state"))
+	if err == oidc.InvalidState {
+		return apiErrors.InvalidRequest.Detail("Invalid or expired login, please log in again")
+	}
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+
+	claims, err := p.Exchange(login, c.Query("code"), redirectURI(c, p))
+	if err != nil {
+		log.Error(err)
+		return apiErrors.Unauthorized.Detail("Unable to authenticate with the identity provider")
+	}
+
+	user, err := p.User(claims)
+	switch err {
+	case nil:
+	case users.UserNotFound, users.UserDisabled, users.UserDuplicated, oidc.EmailMissing, oidc.EmailNotVerified:
+		return apiErrors.Unauthorized.Detail("Unable to log in: " + err.Error())
+	default:
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+
+	rawToken, err := oauth2.IssueAccessToken(user, c.Request())
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to issue the access token")
+	}
+	if rawToken == nil {
+		return apiErrors.Unauthorized.Detail("Access token request denied")
+	}
+	token := rawToken.(oauth.AccessToken)
+
+	c.Response().Header().Set("Cache-Control", "no-store")
+	if login.Redirect == "" {
+		return c.JSON(http.StatusOK, token)
+	}
+
+	fragment := url.Values{
+		"access_token": {token.Token},
+		"token_type":   {token.Type},
+		"expires_in":   {strconv.Itoa(int(token.ExpiresIn))},
+	}
+	if token.RefreshToken != "" {
+		fragment.Set("refresh_token", token.RefreshToken)
+	}
+	return c.Redirect(http.StatusFound, login.Redirect+"#"+fragment.Encode())
+}
@@ -0,0 +1,200 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package organizations
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/Nanocloud/community/nanocloud/errors"
+	"github.com/Nanocloud/community/nanocloud/models/directory"
+	"github.com/Nanocloud/community/nanocloud/models/organizations"
+	"github.com/Nanocloud/community/nanocloud/models/service-accounts"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+type hash map[string]interface{}
+
+// Manage lets the members of the default organization only manage the
+// organizations.
+func Manage(handler echo.HandlerFunc) echo.HandlerFunc {
+	return func(c *echo.Context) error {
+		user := c.Get("user").(*users.User)
+		if user.OrganizationId != organizations.Default {
+			return errors.PermissionRequired.Detail("Only the members of the default organization manage the organizations")
+		}
+		return handler(c)
+	}
+}
+
+func getOrganization(id string) (*organizations.Organization, error) {
+	o, err := organizations.GetOrganization(id)
+	if err != nil {
+		log.Error(err)
+		return nil, errors.InternalError
+	}
+	if o == nil {
+		return nil, errors.OrganizationNotFound
+	}
+	return o, nil
+}
+
+func modelError(err error) error {
+	switch err {
+	case nil:
+		return nil
+	case organizations.OrganizationNotFound:
+		return errors.OrganizationNotFound
+	case organizations.OrganizationDuplicated,
+		organizations.OrganizationNotEmpty,
+		organizations.DefaultOrganization:
+		return errors.InvalidRequest.Detail(err.Error())
+	}
+	log.Error(err)
+	return errors.InternalError
+}
+
+func FindAll(c *echo.Context) error {
+	o, err := organizations.FindAll()
+	if err != nil {
+		log.Error(err)
+		return errors.InternalError
+	}
+	return utils.JSON(c, http.StatusOK, o)
+}
+
+func FindById(c *echo.Context) error {
+	o, err := getOrganization(c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return utils.JSON(c, http.StatusOK, o)
+}
+
+func Create(c *echo.Context) error {
+	o := &organizations.Organization{}
+
+	err := utils.ParseJSONBody(c, o)
+	if err != nil {
+		log.Error(err)
+		return errors.InvalidRequest
+	}
+
+	o.Name = strings.TrimSpace(o.Name)
+	if o.Name == "" {
+		return errors.InvalidRequest.Detail("name is required")
+	}
+
+	o, err = organizations.CreateOrganization(o)
+	if err != nil {
+		return modelError(err)
+	}
+	return utils.JSON(c, http.StatusCreated, o)
+}
+
+func Update(c *echo.Context) error {
+	o, err := getOrganization(c.Param("id"))
+	if err != nil {
+		return err
+	}
+	id := o.Id
+
+	err = utils.ParseJSONBody(c, o)
+	if err != nil {
+		log.Error(err)
+		return errors.InvalidRequest
+	}
+	o.Id = id
+
+	o.Name = strings.TrimSpace(o.Name)
+	if o.Name == "" {
+		return errors.InvalidRequest.Detail("name is required")
+	}
+
+	err = o.Update()
+	if err != nil {
+		return modelError(err)
+	}
+	return utils.JSON(c, http.StatusOK, o)
+}
+
+// deleteMembers deletes the users of an organization, queuing the deletion of
+// their Windows accounts, and its service accounts.
+func deleteMembers(o *organizations.Organization) error {
+	members, err := users.FindUsers(o.Id)
+	if err != nil {
+		return err
+	}
+	for _, member := range members {
+		err = directory.DeleteUser(member)
+		if err != nil {
+			return err
+		}
+	}
+
+	accounts, err := serviceaccounts.FindAll(o.Id)
+	if err != nil {
+		return err
+	}
+	for _, account := range accounts {
+		err = account.Delete()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes an organization with its users, service accounts, apps,
+// groups, roles and histories. Its machines have to be deleted first.
+func Delete(c *echo.Context) error {
+	o, err := getOrganization(c.Param("id"))
+	if err != nil {
+		return err
+	}
+	if o.Id == organizations.Default {
+		return modelError(organizations.DefaultOrganization)
+	}
+
+	busy, err := o.HasMachines()
+	if err != nil {
+		return modelError(err)
+	}
+	if busy {
+		return errors.InvalidRequest.Detail("the organization still has machines")
+	}
+
+	err = deleteMembers(o)
+	if err != nil {
+		return modelError(err)
+	}
+
+	err = o.Delete()
+	if err != nil {
+		return modelError(err)
+	}
+	return c.JSON(http.StatusOK, hash{"meta": hash{}})
+}
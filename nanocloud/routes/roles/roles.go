@@ -0,0 +1,366 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package roles
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/Nanocloud/community/nanocloud/errors"
+	"github.com/Nanocloud/community/nanocloud/models/groups"
+	"github.com/Nanocloud/community/nanocloud/models/roles"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+type hash map[string]interface{}
+
+// getRole returns the role if it is built-in or belongs to the organization
+// of the current user.
+func getRole(c *echo.Context, id string) (*roles.Role, error) {
+	user := c.Get("user").(*users.User)
+
+	r, err := roles.GetRole(id)
+	if err != nil {
+		log.Error(err)
+		return nil, errors.InternalError
+	}
+	if r == nil || (!r.Builtin && r.OrganizationId != user.OrganizationId) {
+		return nil, errors.RoleNotFound
+	}
+	return r, nil
+}
+
+// getUser returns the user if they belong to the organization of the current
+// user.
+func getUser(c *echo.Context, id string) (*users.User, error) {
+	u, err := users.GetUser(id)
+	if err != nil {
+		return nil, modelError(err)
+	}
+	if u == nil || u.OrganizationId != c.Get("user").(*users.User).OrganizationId {
+		return nil, errors.UserNotFound
+	}
+	return u, nil
+}
+
+// getGroup returns the group if it belongs to the organization of the current
+// user.
+func getGroup(c *echo.Context, id string) (*groups.Group, error) {
+	g, err := groups.GetGroup(id)
+	if err != nil {
+		return nil, modelError(err)
+	}
+	if g == nil || g.OrganizationId != c.Get("user").(*users.User).OrganizationId {
+		return nil, errors.GroupNotFound
+	}
+	return g, nil
+}
+
+// canGrant returns an error unless the current user holds every permission,
+// so that roles:write can't be used to give anyone, themselves included, more
+// than the current user has.
+func canGrant(c *echo.Context, permissions []string) error {
+	user := c.Get("user").(*users.User)
+
+	held, err := roles.UserPermissions(user.Id)
+	if err != nil {
+		log.Error(err)
+		return errors.InternalError
+	}
+
+	for _, p := range permissions {
+		if !user.InScope(p) || !roles.Has(held, p) {
+			return errors.PermissionRequired.Detail("You can't grant the " + p + " permission, you don't hold it")
+		}
+	}
+	return nil
+}
+
+// canAssign returns an error unless the current user can grant the
+// permissions of a role. Only the users holding every permission can assign
+// the administrator role.
+func canAssign(c *echo.Context, r *roles.Role) error {
+	if r.Id == roles.Administrator {
+		return canGrant(c, []string{roles.All})
+	}
+	return canGrant(c, r.Permissions)
+}
+
+func modelError(err error) error {
+	switch err {
+	case nil:
+		return nil
+	case roles.RoleNotFound:
+		return errors.RoleNotFound
+	case roles.RoleDuplicated, roles.BuiltinRole, roles.InvalidPermission:
+		return errors.InvalidRequest.Detail(err.Error())
+	case roles.AssignmentNotFound:
+		return errors.AssignmentNotFound
+	}
+	log.Error(err)
+	return errors.InternalError
+}
+
+func FindAll(c *echo.Context) error {
+	user := c.Get("user").(*users.User)
+
+	r, err := roles.FindAll(user.OrganizationId)
+	if err != nil {
+		log.Error(err)
+		return errors.InternalError
+	}
+	return utils.JSON(c, http.StatusOK, r)
+}
+
+func FindById(c *echo.Context) error {
+	r, err := getRole(c, c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return utils.JSON(c, http.StatusOK, r)
+}
+
+// Permissions lists the permissions roles can be given.
+func Permissions(c *echo.Context) error {
+	return c.JSON(http.StatusOK, hash{"meta": hash{
+		"permissions": roles.Permissions,
+	}})
+}
+
+func Create(c *echo.Context) error {
+	r := &roles.Role{}
+
+	err := utils.ParseJSONBody(c, r)
+	if err != nil {
+		log.Error(err)
+		return errors.InvalidRequest
+	}
+
+	r.Name = strings.TrimSpace(r.Name)
+	if r.Name == "" {
+		return errors.InvalidRequest.Detail("name is required")
+	}
+
+	err = canGrant(c, r.Permissions)
+	if err != nil {
+		return err
+	}
+
+	user := c.Get("user").(*users.User)
+	r, err = roles.CreateRole(user.OrganizationId, r.Name, r.Description, r.Permissions)
+	if err != nil {
+		return modelError(err)
+	}
+	return utils.JSON(c, http.StatusCreated, r)
+}
+
+func Update(c *echo.Context) error {
+	r, err := getRole(c, c.Param("id"))
+	if err != nil {
+		return err
+	}
+	id, builtin, org := r.Id, r.Builtin, r.OrganizationId
+
+	err = utils.ParseJSONBody(c, r)
+	if err != nil {
+		log.Error(err)
+		return errors.InvalidRequest
+	}
+	r.Id, r.Builtin, r.OrganizationId = id, builtin, org
+
+	r.Name = strings.TrimSpace(r.Name)
+	if r.Name == "" {
+		return errors.InvalidRequest.Detail("name is required")
+	}
+
+	err = canGrant(c, r.Permissions)
+	if err != nil {
+		return err
+	}
+
+	err = r.Update()
+	if err != nil {
+		return modelError(err)
+	}
+	return utils.JSON(c, http.StatusOK, r)
+}
+
+func Delete(c *echo.Context) error {
+	r, err := getRole(c, c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	err = r.Delete()
+	if err != nil {
+		return modelError(err)
+	}
+	return c.JSON(http.StatusOK, hash{"meta": hash{}})
+}
+
+func identifiers(kind string, ids []string) hash {
+	data := make([]hash, len(ids))
+	for i, id := range ids {
+		data[i] = hash{
+			"type": kind,
+			"id":   id,
+		}
+	}
+	return hash{"data": data}
+}
+
+func Users(c *echo.Context) error {
+	r, err := getRole(c, c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	user := c.Get("user").(*users.User)
+	ids, err := roles.RoleUsers(r.Id, user.OrganizationId)
+	if err != nil {
+		return modelError(err)
+	}
+	return c.JSON(http.StatusOK, identifiers("users", ids))
+}
+
+func AssignUser(c *echo.Context) error {
+	r, err := getRole(c, c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	userId := c.Param("user_id")
+	_, err = getUser(c, userId)
+	if err != nil {
+		return err
+	}
+
+	err = canAssign(c, r)
+	if err != nil {
+		return err
+	}
+
+	err = roles.AssignUser(r.Id, userId)
+	if err != nil {
+		return modelError(err)
+	}
+	return c.JSON(http.StatusOK, hash{"data": hash{
+		"type": "users",
+		"id":   userId,
+	}})
+}
+
+func UnassignUser(c *echo.Context) error {
+	user := c.Get("user").(*users.User)
+	if c.Param("id") == roles.Administrator && c.Param("user_id") == user.Id {
+		return errors.InvalidRequest.Detail("you can't take the administrator role back from yourself")
+	}
+
+	r, err := getRole(c, c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	_, err = getUser(c, c.Param("user_id"))
+	if err != nil {
+		return err
+	}
+
+	err = canAssign(c, r)
+	if err != nil {
+		return err
+	}
+
+	err = roles.UnassignUser(r.Id, c.Param("user_id"))
+	if err != nil {
+		return modelError(err)
+	}
+	return c.JSON(http.StatusOK, hash{"meta": hash{}})
+}
+
+func Groups(c *echo.Context) error {
+	r, err := getRole(c, c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	user := c.Get("user").(*users.User)
+	ids, err := roles.RoleGroups(r.Id, user.OrganizationId)
+	if err != nil {
+		return modelError(err)
+	}
+	return c.JSON(http.StatusOK, identifiers("groups", ids))
+}
+
+func AssignGroup(c *echo.Context) error {
+	r, err := getRole(c, c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	groupId := c.Param("group_id")
+	_, err = getGroup(c, groupId)
+	if err != nil {
+		return err
+	}
+
+	err = canAssign(c, r)
+	if err != nil {
+		return err
+	}
+
+	err = roles.AssignGroup(r.Id, groupId)
+	if err != nil {
+		return modelError(err)
+	}
+	return c.JSON(http.StatusOK, hash{"data": hash{
+		"type": "groups",
+		"id":   groupId,
+	}})
+}
+
+func UnassignGroup(c *echo.Context) error {
+	r, err := getRole(c, c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	_, err = getGroup(c, c.Param("group_id"))
+	if err != nil {
+		return err
+	}
+
+	err = canAssign(c, r)
+	if err != nil {
+		return err
+	}
+
+	err = roles.UnassignGroup(r.Id, c.Param("group_id"))
+	if err != nil {
+		return modelError(err)
+	}
+	return c.JSON(http.StatusOK, hash{"meta": hash{}})
+}
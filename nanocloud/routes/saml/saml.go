@@ -0,0 +1,188 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package saml
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	apiErrors "github.com/Nanocloud/community/nanocloud/errors"
+	"github.com/Nanocloud/community/nanocloud/models/oauth"
+	"github.com/Nanocloud/community/nanocloud/models/saml"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/oauth2"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+type hash map[string]interface{}
+
+// provider returns the identity provider of the request.
+func provider(c *echo.Context) (*saml.Provider, error) {
+	p, err := saml.GetProvider(c.Param("provider"))
+	if err != nil {
+		log.Error(err)
+		return nil, apiErrors.InternalError
+	}
+	if p == nil {
+		return nil, apiErrors.IdentityProviderNotFound
+	}
+	return p, nil
+}
+
+// endpoints returns the entity id and the assertion consumer service URL of
+// Nanocloud for the provider.
+func endpoints(c *echo.Context, p *saml.Provider) (string, string) {
+	baseURL := utils.BaseURL(c.Request())
+	return p.EntityId(baseURL), p.AssertionConsumerServiceURL(baseURL)
+}
+
+// Providers lists the identity providers users can log in with.
+func Providers(c *echo.Context) error {
+	providers, err := saml.Providers()
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+
+	r := make([]hash, len(providers))
+	for i, p := range providers {
+		r[i] = hash{
+			"id":   p.Name,
+			"type": "identity-provider",
+			"attributes": hash{
+				"login-url": "/saml/" + url.QueryEscape(p.Name) + "/login",
+			},
+		}
+	}
+	return c.JSON(http.StatusOK, hash{"data": r})
+}
+
+// Metadata serves the metadata of Nanocloud as a service provider, to be
+// imported in the identity provider.
+func Metadata(c *echo.Context) error {
+	p, err := provider(c)
+	if err != nil {
+		return err
+	}
+
+	entityId, acsURL := endpoints(c, p)
+	metadata, err := p.Metadata(entityId, acsURL)
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+
+	r := c.Response()
+	r.Header().Set("Content-Type", "application/samlmetadata+xml")
+	r.WriteHeader(http.StatusOK)
+	_, err = r.Write(metadata)
+	return err
+}
+
+// Login redirects the user to the identity provider with an authentication
+// request.
+func Login(c *echo.Context) error {
+	p, err := provider(c)
+	if err != nil {
+		return err
+	}
+
+	redirect := c.Query("redirect")
+	if redirect != "" && !utils.SafeRedirect(redirect) {
+		return apiErrors.InvalidRequest.Detail("redirect must be a path of Nanocloud without fragment")
+	}
+
+	entityId, acsURL := endpoints(c, p)
+	authURL, err := p.AuthnRequestURL(entityId, acsURL, redirect)
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+	return c.Redirect(http.StatusFound, authURL)
+}
+
+// ACS is the assertion consumer service: it finishes the login of a user
+// whose identity provider posted a response to one of the authentication
+// requests of Nanocloud, and issues them a Nanocloud access token. It is sent
+// in the fragment of the redirect of the login, or as JSON, like
+// /oauth/token, without redirect.
+func ACS(c *echo.Context) error {
+	p, err := provider(c)
+	if err != nil {
+		return err
+	}
+
+	entityId, acsURL := endpoints(c, p)
+	assertion, err := p.ParseResponse(c.Request().FormValue("SAMLResponse"), entityId, acsURL)
+	if err != nil {
+		log.Error(err)
+		return apiErrors.Unauthorized.Detail("Unable to authenticate with the identity provider")
+	}
+
+	req, err := p.TakeRequest(assertion.RequestId)
+	if err == saml.InvalidRequest {
+		return apiErrors.InvalidRequest.Detail("Invalid or expired login, please log in again")
+	}
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+
+	user, err := p.User(assertion)
+	switch err {
+	case nil:
+	case users.UserNotFound, users.UserDisabled, users.UserDuplicated, saml.EmailMissing:
+		return apiErrors.Unauthorized.Detail("Unable to log in: " + err.Error())
+	default:
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+
+	rawToken, err := oauth2.IssueAccessToken(user, c.Request())
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to issue the access token")
+	}
+	if rawToken == nil {
+		return apiErrors.Unauthorized.Detail("Access token request denied")
+	}
+	token := rawToken.(oauth.AccessToken)
+
+	c.Response().Header().Set("Cache-Control", "no-store")
+	if req.Redirect == "" {
+		return c.JSON(http.StatusOK, token)
+	}
+
+	fragment := url.Values{
+		"access_token": {token.Token},
+		"token_type":   {token.Type},
+		"expires_in":   {strconv.Itoa(int(token.ExpiresIn))},
+	}
+	if token.RefreshToken != "" {
+		fragment.Set("refresh_token", token.RefreshToken)
+	}
+	return c.Redirect(http.StatusFound, req.Redirect+"#"+fragment.Encode())
+}
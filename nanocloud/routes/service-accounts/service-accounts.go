@@ -0,0 +1,176 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package serviceaccounts
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/Nanocloud/community/nanocloud/errors"
+	"github.com/Nanocloud/community/nanocloud/models/oauth"
+	"github.com/Nanocloud/community/nanocloud/models/service-accounts"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+type hash map[string]interface{}
+
+// getServiceAccount returns the service account if it belongs to the
+// organization of the current user.
+func getServiceAccount(c *echo.Context) (*serviceaccounts.ServiceAccount, error) {
+	user := c.Get("user").(*users.User)
+
+	account, err := serviceaccounts.GetServiceAccount(user.OrganizationId, c.Param("id"))
+	if err != nil {
+		log.Error(err)
+		return nil, errors.InternalError
+	}
+	if account == nil {
+		return nil, errors.ServiceAccountNotFound
+	}
+	return account, nil
+}
+
+func modelError(err error) error {
+	switch err {
+	case nil:
+		return nil
+	case serviceaccounts.ServiceAccountNotFound:
+		return errors.ServiceAccountNotFound
+	case serviceaccounts.InvalidName, oauth.ClientDuplicated,
+		oauth.InvalidScope, oauth.InvalidLifetime:
+		return errors.InvalidRequest.Detail(err.Error())
+	}
+	log.Error(err)
+	return errors.InternalError
+}
+
+func FindAll(c *echo.Context) error {
+	user := c.Get("user").(*users.User)
+
+	accounts, err := serviceaccounts.FindAll(user.OrganizationId)
+	if err != nil {
+		log.Error(err)
+		return errors.InternalError
+	}
+	return utils.JSON(c, http.StatusOK, accounts)
+}
+
+func FindById(c *echo.Context) error {
+	account, err := getServiceAccount(c)
+	if err != nil {
+		return err
+	}
+	return utils.JSON(c, http.StatusOK, account)
+}
+
+// Create creates a service account in the organization of the current user.
+// Its secret is only sent in the response.
+func Create(c *echo.Context) error {
+	account := &serviceaccounts.ServiceAccount{
+		AccessTokenLifetime: oauth.DefaultAccessTokenLifetime,
+	}
+
+	err := utils.ParseJSONBody(c, account)
+	if err != nil {
+		log.Error(err)
+		return errors.InvalidRequest
+	}
+	account.Name = strings.TrimSpace(account.Name)
+
+	user := c.Get("user").(*users.User)
+	account, err = serviceaccounts.Create(user.OrganizationId, account)
+	if err != nil {
+		return modelError(err)
+	}
+	return utils.JSON(c, http.StatusCreated, account)
+}
+
+func Update(c *echo.Context) error {
+	account, err := getServiceAccount(c)
+	if err != nil {
+		return err
+	}
+	id, key, org := account.Id, account.Key, account.OrganizationId
+
+	err = utils.ParseJSONBody(c, account)
+	if err != nil {
+		log.Error(err)
+		return errors.InvalidRequest
+	}
+	account.Id, account.Key, account.OrganizationId = id, key, org
+	account.Name = strings.TrimSpace(account.Name)
+
+	err = account.Update()
+	if err != nil {
+		return modelError(err)
+	}
+	return utils.JSON(c, http.StatusOK, account)
+}
+
+// ResetSecret gives a service account a new secret, only sent in the
+// response. The former one and the tokens issued with it stop working right
+// away.
+func ResetSecret(c *echo.Context) error {
+	account, err := getServiceAccount(c)
+	if err != nil {
+		return err
+	}
+
+	err = account.ResetSecret()
+	if err != nil {
+		return modelError(err)
+	}
+	return utils.JSON(c, http.StatusOK, account)
+}
+
+// Tokens lists the access tokens of a service account.
+func Tokens(c *echo.Context) error {
+	account, err := getServiceAccount(c)
+	if err != nil {
+		return err
+	}
+
+	tokens, err := oauth.FindTokens(account.Id)
+	if err != nil {
+		log.Error(err)
+		return errors.InternalError
+	}
+	return utils.JSON(c, http.StatusOK, tokens)
+}
+
+// Delete deletes a service account and revokes its tokens.
+func Delete(c *echo.Context) error {
+	account, err := getServiceAccount(c)
+	if err != nil {
+		return err
+	}
+
+	err = account.Delete()
+	if err != nil {
+		return modelError(err)
+	}
+	return c.JSON(http.StatusOK, hash{"meta": hash{}})
+}
@@ -1,19 +1,18 @@
 package sessions
 
 import (
-	"io/ioutil"
 	"net/http"
+	"strconv"
 
+	"github.com/Nanocloud/community/nanocloud/models/apps"
 	"github.com/Nanocloud/community/nanocloud/models/sessions"
 	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/plaza"
 	"github.com/Nanocloud/community/nanocloud/utils"
 	log "github.com/Sirupsen/logrus"
 	"github.com/labstack/echo"
 )
 
-var kServer string
-var kPort string
-
 type hash map[string]interface{}
 
 func List(c *echo.Context) error {
@@ -25,7 +24,12 @@ func List(c *echo.Context) error {
 		return err
 	}
 
-	sessionList, err := sessions.GetAll(winUser.Sam)
+	server, err := apps.UserServer(user, winUser.Sam)
+	if err != nil {
+		return err
+	}
+
+	sessionList, err := sessions.GetAll(server, winUser.Sam)
 
 	if err != nil {
 		log.Error(err)
@@ -51,6 +55,8 @@ func List(c *echo.Context) error {
 	return c.JSON(http.StatusOK, hash{"data": response})
 }
 
+// Logoff closes the sessions of the current user on the server they are
+// placed on.
 func Logoff(c *echo.Context) error {
 	user := c.Get("user").(*users.User)
 
@@ -59,31 +65,25 @@ func Logoff(c *echo.Context) error {
 		return err
 	}
 
-	req, err := http.NewRequest("DELETE", "http://"+kServer+":"+kPort+"/sessions/"+winUser.Sam, nil)
+	server, err := apps.UserServer(user, winUser.Sam)
 	if err != nil {
-		log.Error(err)
-		return c.JSON(http.StatusInternalServerError, hash{
-			"error": [1]hash{
-				hash{
-					"detail": err.Error(),
-				},
-			},
-		})
+		return err
 	}
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil || resp.Status != "200 OK" {
-		log.Error(err)
-		return c.JSON(http.StatusInternalServerError, hash{
-			"error": [1]hash{
-				hash{
-					"detail": resp.Status,
-				},
-			},
-		})
+
+	port, err := strconv.Atoi(utils.Env("PLAZA_PORT", "9090"))
+	if err != nil {
+		return err
 	}
 
-	b, err := ioutil.ReadAll(resp.Body)
+	found, err := plaza.Sessions(server, port, winUser.Sam)
+	if err == nil {
+		for _, session := range found {
+			err = plaza.LogoffSession(server, port, "", "", session.Id)
+			if err != nil {
+				break
+			}
+		}
+	}
 	if err != nil {
 		log.Error(err)
 		return c.JSON(http.StatusInternalServerError, hash{
@@ -94,10 +94,5 @@ func Logoff(c *echo.Context) error {
 			},
 		})
 	}
-	return c.JSON(http.StatusOK, string(b))
-}
-
-func init() {
-	kServer = utils.Env("EXECUTION_SERVERS", "iaas-module")
-	kPort = utils.Env("PLAZA_PORT", "9090")
+	return c.JSON(http.StatusOK, hash{"meta": hash{}})
 }
@@ -27,6 +27,7 @@ import (
 	"net/http"
 
 	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/models/oauth"
 	"github.com/Nanocloud/community/nanocloud/models/users"
 	"github.com/labstack/echo"
 )
@@ -36,30 +37,21 @@ type hash map[string]interface{}
 func Get(c *echo.Context) error {
 	user := c.Get("user").(*users.User)
 
-	res, err := db.Query(
-		"SELECT id, created_at FROM oauth_access_tokens WHERE user_id = $1::varchar",
-		user.Id,
-	)
-
+	tokens, err := oauth.FindTokens(user.Id)
 	if err != nil {
 		return err
 	}
 
-	defer res.Close()
-
 	r := make([]hash, 0)
-	for res.Next() {
-		var id, createdAt string
-		err := res.Scan(&id, &createdAt)
-		if err != nil {
-			continue
-		}
-
+	for _, token := range tokens {
 		r = append(r, hash{
-			"id":   id,
+			"id":   token.Id,
 			"type": "token",
 			"attributes": hash{
-				"created-at": createdAt,
+				"created-at": token.CreatedAt,
+				"expires-at": token.ExpiresAt,
+				"client":     token.Client,
+				"scope":      token.Scope,
 			},
 		})
 	}
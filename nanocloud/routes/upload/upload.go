@@ -26,8 +26,10 @@ import (
 	"net/http"
 	"net/url"
 
+	"github.com/Nanocloud/community/nanocloud/models/roles"
 	"github.com/Nanocloud/community/nanocloud/models/users"
 	"github.com/Nanocloud/community/nanocloud/oauth2"
+	"github.com/Nanocloud/community/nanocloud/plaza"
 	"github.com/Nanocloud/community/nanocloud/utils"
 	log "github.com/Sirupsen/logrus"
 )
@@ -42,6 +44,10 @@ func Post(w http.ResponseWriter, r *http.Request) {
 	}
 
 	user := rawuser.(*users.User)
+	if !user.InScope(roles.FilesScope) {
+		http.Error(w, "The access token isn't granted the "+roles.FilesScope+" scope", http.StatusForbidden)
+		return
+	}
 
 	winUser, err := user.WindowsCredentials()
 	if err != nil {
@@ -61,6 +67,7 @@ func Post(w http.ResponseWriter, r *http.Request) {
 		log.Println("Unable de create request ", err)
 	}
 	request.Header = r.Header
+	plaza.SignStream(request)
 	client := &http.Client{}
 	resp, err := client.Do(request)
 	if err != nil {
@@ -27,7 +27,9 @@ import (
 	"net/http"
 
 	apiErrors "github.com/Nanocloud/community/nanocloud/errors"
-	"github.com/Nanocloud/community/nanocloud/models/ldap"
+	"github.com/Nanocloud/community/nanocloud/models/directory"
+	"github.com/Nanocloud/community/nanocloud/models/organizations"
+	"github.com/Nanocloud/community/nanocloud/models/roles"
 	"github.com/Nanocloud/community/nanocloud/models/users"
 	"github.com/Nanocloud/community/nanocloud/utils"
 	log "github.com/Sirupsen/logrus"
@@ -36,6 +38,28 @@ import (
 
 type hash map[string]interface{}
 
+// can returns an error unless the user has permission and their access token
+// grants it.
+func can(user *users.User, permission string) error {
+	ok, err := user.Can(permission)
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+	if !ok {
+		return apiErrors.PermissionRequired.Detail("The " + permission + " permission is required")
+	}
+	return nil
+}
+
+// inOrganization returns whether u belongs to the organization of the current
+// user. Users of other organizations are reported as not found, as are service
+// accounts, which are managed on their own.
+func inOrganization(c *echo.Context, u *users.User) bool {
+	return !u.ServiceAccount &&
+		u.OrganizationId == c.Get("user").(*users.User).OrganizationId
+}
+
 func Delete(c *echo.Context) error {
 	userId := c.Param("id")
 	if len(userId) == 0 {
@@ -53,7 +77,7 @@ func Delete(c *echo.Context) error {
 		return err
 	}
 
-	if user == nil {
+	if user == nil || !inOrganization(c, user) {
 		return c.JSON(http.StatusNotFound, hash{
 			"error": [1]hash{
 				hash{
@@ -73,9 +97,9 @@ func Delete(c *echo.Context) error {
 		})
 	}
 
-	err = users.DeleteUser(user.Id)
+	err = directory.DeleteUser(user)
 	if err != nil {
-		log.Errorf("Unable to delete user: ", err.Error())
+		log.Errorf("Unable to delete user: %s", err.Error())
 		return err
 	}
 
@@ -89,16 +113,16 @@ func Disable(userId string) (int, error) {
 		return http.StatusNotFound, errors.New("User id needed for desactivation")
 	}
 
-	exists, err := users.UserExists(userId)
+	user, err := users.GetUser(userId)
 	if err != nil {
 		return http.StatusConflict, err
 	}
 
-	if !exists {
+	if user == nil {
 		return http.StatusNotFound, errors.New("User not found")
 	}
 
-	err = users.DisableUser(userId)
+	err = directory.DisableUser(user)
 	if err != nil {
 		return http.StatusInternalServerError, errors.New("Unable to disable user: " + err.Error())
 	}
@@ -116,25 +140,43 @@ func Update(c *echo.Context) error {
 	}
 
 	currentUser, err := users.GetUser(updatedUser.GetID())
-	if err != nil {
+	if err != nil || currentUser == nil || !inOrganization(c, currentUser) {
 		return apiErrors.UserNotFound
 	}
+	updatedUser.OrganizationId = currentUser.OrganizationId
 
-	if !user.IsAdmin && (updatedUser.GetID() != user.GetID()) {
-		return apiErrors.Unauthorized.Detail("You can only update your account")
+	if updatedUser.GetID() != user.GetID() {
+		err = can(user, roles.UsersWrite)
+		if err != nil {
+			return apiErrors.Unauthorized.Detail("You can only update your account")
+		}
+	} else if !user.FullScope() {
+		return apiErrors.PermissionRequired.Detail("The access token must be granted every scope to update your account")
 	}
 
 	if updatedUser.IsAdmin != currentUser.IsAdmin {
 		if currentUser.Id == user.GetID() {
 			return apiErrors.Unauthorized.Detail("You cannot grant administration rights")
 		}
+		// the administrator role grants every permission, only the users
+		// holding them all can give it
+		err = can(user, roles.RolesWrite)
+		if err == nil {
+			err = can(user, roles.All)
+		}
+		if err != nil {
+			return apiErrors.Unauthorized.Detail("You cannot grant administration rights")
+		}
 		err = users.UpdateUserPrivilege(updatedUser.GetID(), updatedUser.IsAdmin)
 		if err != nil {
 			log.Error(err)
 			return apiErrors.InternalError.Detail("Unable to update the rank")
 		}
 	} else if updatedUser.Password != "" {
-		err = users.UpdateUserPassword(updatedUser.GetID(), updatedUser.Password)
+		if currentUser.FromDirectory() {
+			return apiErrors.InvalidRequest.Detail("The password of directory users is managed by the directory")
+		}
+		err = directory.UpdatePassword(currentUser, updatedUser.Password)
 		if err != nil {
 			log.Error(err)
 			return apiErrors.InternalError.Detail("Unable to update the password")
@@ -170,11 +212,12 @@ func Get(c *echo.Context) error {
 		return utils.JSON(c, http.StatusOK, user)
 	}
 
-	if !user.IsAdmin {
-		return apiErrors.AdminLevelRequired
+	err := can(user, roles.UsersRead)
+	if err != nil {
+		return err
 	}
 
-	users, err := users.FindUsers()
+	users, err := users.FindUsers(user.OrganizationId)
 	if err != nil {
 		log.Error(err)
 		return apiErrors.InternalError.Detail("Unable to retreive the user list")
@@ -231,13 +274,50 @@ func Post(c *echo.Context) error {
 		})
 	}
 
+	user := c.Get("user").(*users.User)
+	if u.OrganizationId == "" {
+		u.OrganizationId = user.OrganizationId
+	}
+
+	// the default organization creates the first users of the others,
+	// usually their administrators
+	if u.OrganizationId != user.OrganizationId {
+		if user.OrganizationId != organizations.Default {
+			return apiErrors.PermissionRequired.Detail("You can only create users in your organization")
+		}
+		err = can(user, roles.OrganizationsWrite)
+		if err != nil {
+			return err
+		}
+	}
+
+	if u.IsAdmin {
+		err = can(user, roles.RolesWrite)
+		if err == nil {
+			err = can(user, roles.All)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	org, err := organizations.GetOrganization(u.OrganizationId)
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+	if org == nil {
+		return apiErrors.OrganizationNotFound
+	}
+
 	newUser, err := users.CreateUser(
 		true,
 		u.Email,
 		u.FirstName,
 		u.LastName,
 		u.Password,
-		false,
+		u.IsAdmin,
+		org.Id,
 	)
 	switch err {
 	case users.UserDuplicated:
@@ -252,14 +332,9 @@ func Post(c *echo.Context) error {
 		return err
 	}
 
-	winpass := utils.RandomString(8) + "s4D+"
-	sam, err := ldap.AddUser(newUser.Id, winpass)
-	if err != nil {
-		return err
-	}
-
-	err = users.UpdateUserAd(newUser.Id, sam, winpass, "intra.localdomain.com")
+	err = directory.CreateAccount(newUser, org)
 	if err != nil {
+		directory.DeleteUser(newUser)
 		return err
 	}
 
@@ -289,13 +364,13 @@ func UpdatePassword(c *echo.Context) error {
 		return nil
 	}
 
-	exists, err := users.UserExists(userId)
+	target, err := users.GetUser(userId)
 	if err != nil {
 		log.Errorf("Unable to check user existance: %s", err.Error())
 		return err
 	}
 
-	if !exists {
+	if target == nil || !inOrganization(c, target) {
 		return c.JSON(http.StatusNotFound, hash{
 			"error": [1]hash{
 				hash{
@@ -305,7 +380,11 @@ func UpdatePassword(c *echo.Context) error {
 		})
 	}
 
-	err = users.UpdateUserPassword(userId, user.Data.Password)
+	if target.FromDirectory() {
+		return apiErrors.InvalidRequest.Detail("The password of directory users is managed by the directory")
+	}
+
+	err = directory.UpdatePassword(target, user.Data.Password)
 	if err != nil {
 		log.Errorf("Unable to update user password: %s", err.Error())
 		return err
@@ -330,12 +409,19 @@ func GetUser(c *echo.Context) error {
 		})
 	}
 
+	if userId != c.Get("user").(*users.User).Id {
+		err := can(c.Get("user").(*users.User), roles.UsersRead)
+		if err != nil {
+			return err
+		}
+	}
+
 	user, err := users.GetUser(userId)
 	if err != nil {
 		return err
 	}
 
-	if user == nil {
+	if user == nil || !inOrganization(c, user) {
 		return c.JSON(http.StatusNotFound, hash{
 			"error": [1]hash{
 				hash{
@@ -347,3 +433,28 @@ func GetUser(c *echo.Context) error {
 
 	return utils.JSON(c, http.StatusOK, user)
 }
+
+// Sync imports the directory users of the organisation unit of the
+// organization of the current user and returns what changed.
+func Sync(c *echo.Context) error {
+	user := c.Get("user").(*users.User)
+
+	org, err := organizations.GetOrganization(user.OrganizationId)
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+	if org == nil {
+		return apiErrors.OrganizationNotFound
+	}
+
+	report, err := directory.Sync(org)
+	if err == directory.SharedOrganisationUnit {
+		return apiErrors.InvalidRequest.Detail("The organisation unit of the organization belongs to another organization")
+	}
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to sync the users from the directory")
+	}
+	return c.JSON(http.StatusOK, hash{"meta": report})
+}
@@ -25,8 +25,12 @@ package utils
 import (
 	"io"
 	"math/rand"
+	"net/http"
+	"net/url"
 	"os"
+	"strings"
 	"time"
+	"unicode"
 )
 
 type hash map[string]interface{}
@@ -39,6 +43,42 @@ func Env(key, def string) string {
 	return v
 }
 
+// BaseURL returns the URL Nanocloud is reached at by req, behind the proxy
+// when TRUST_PROXY is true.
+func BaseURL(req *http.Request) string {
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	if os.Getenv("TRUST_PROXY") == "true" && req.Header.Get("X-Forwarded-Proto") != "" {
+		scheme = req.Header.Get("X-Forwarded-Proto")
+	}
+	return scheme + "://" + req.Host
+}
+
+// SafeRedirect tells whether users may be sent to redirect once logged in
+// with an identity provider: a path of Nanocloud, without fragment since the
+// token is sent in it. Browsers ignore some characters of URLs, such as tabs,
+// and read backslashes as slashes, so those are refused rather than letting
+// "/\t/host" become "//host".
+func SafeRedirect(redirect string) bool {
+	for _, r := range redirect {
+		if r == '\\' || unicode.IsSpace(r) || unicode.IsControl(r) {
+			return false
+		}
+	}
+
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") {
+		return false
+	}
+
+	u, err := url.Parse(redirect)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == "" && u.Host == "" && !strings.Contains(redirect, "#")
+}
+
 // randomString
 const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 const (
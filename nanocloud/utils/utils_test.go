@@ -19,3 +19,23 @@ func TestLength(t *testing.T) {
 		}
 	}
 }
+
+func TestSafeRedirect(t *testing.T) {
+	safe := []string{"/", "/apps", "/apps?tab=1", "/a/b/../c"}
+	for _, redirect := range safe {
+		if !SafeRedirect(redirect) {
+			t.Errorf("%q should be a safe redirect", redirect)
+		}
+	}
+
+	unsafe := []string{
+		"", "apps", "//evil.com", "/\\evil.com", "/\t/evil.com", "/\n/evil.com",
+		"/ /evil.com", "/\x00/evil.com", "https://evil.com", "/apps#token",
+		"/\u00a0/evil.com", "/\u2028/evil.com",
+	}
+	for _, redirect := range unsafe {
+		if SafeRedirect(redirect) {
+			t.Errorf("%q shouldn't be a safe redirect", redirect)
+		}
+	}
+}
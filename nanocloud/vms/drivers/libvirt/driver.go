@@ -0,0 +1,75 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package libvirt
+
+import (
+	"strings"
+
+	"github.com/Nanocloud/community/nanocloud/vms"
+	virt "github.com/libvirt/libvirt-go"
+)
+
+type driver struct{}
+
+func option(options map[string]string, key, def string) string {
+	v := options[key]
+	if v == "" {
+		v = def
+	}
+	return v
+}
+
+// Open connects to the libvirt daemon specified by the URI option.
+// "test:///default" can be used to run the driver without any hypervisor.
+func (d *driver) Open(options map[string]string) (vms.VM, error) {
+	conn, err := virt.NewConnect(option(options, "URI", defaultURI))
+	if err != nil {
+		return nil, err
+	}
+
+	hypervisor, err := conn.GetType()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	domainType := strings.ToLower(hypervisor)
+	if domainType == "qemu" {
+		domainType = "kvm"
+	}
+
+	v := &vm{
+		conn:        conn,
+		domainType:  domainType,
+		storagePool: option(options, "STORAGE_POOL", defaultStoragePool),
+		network:     option(options, "NETWORK", defaultNetwork),
+		image:       options["IMAGE"],
+	}
+
+	err = v.removeOrphans()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return v, nil
+}
@@ -20,26 +20,20 @@
  * along with this program.  If not, see <http://www.gnu.org/licenses/>.
  */
 
-package qemu
+package libvirt
 
-type machineType struct {
-	id   string
-	size string
-	cpu  int
-	ram  int
-}
+import "github.com/Nanocloud/community/nanocloud/vms"
 
-func (t *machineType) GetID() string {
-	return t.id
-}
+const (
+	defaultURI         = "qemu:///system"
+	defaultStoragePool = "default"
+	defaultNetwork     = "default"
 
-var defaultType *machineType
+	// Namespace of the metadata nanocloud stores in the domains it creates.
+	// Domains without this metadata are not managed by the driver.
+	metadataNamespace = "https://nanocloud.com/xmlns/machine/1.0"
+)
 
 func init() {
-	defaultType = &machineType{
-		id:   "default",
-		size: "60GB",
-		cpu:  2,
-		ram:  4096,
-	}
+	vms.Register("libvirt", &driver{})
 }
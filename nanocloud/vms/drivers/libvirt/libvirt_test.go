@@ -0,0 +1,195 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package libvirt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Nanocloud/community/nanocloud/vms"
+)
+
+var (
+	v          *vms.VM
+	machine_id string = ""
+)
+
+func getMachine(t *testing.T) vms.Machine {
+	machine, err := (*v).Machine(machine_id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if machine == nil {
+		t.Fatalf("Machine is nil")
+	}
+	return machine
+}
+
+func TestOpen(t *testing.T) {
+	var err error
+	v, err = vms.Open("libvirt", map[string]string{
+		"URI":          "test:///default",
+		"STORAGE_POOL": "default-pool",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	types, err := (*v).Types()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(types) < 1 {
+		t.Fatalf("empty type list returned")
+	}
+}
+
+func TestCreate(t *testing.T) {
+	machine_attributes := vms.MachineAttributes{
+		Type:     nil,
+		Name:     "O'Brien <machine>",
+		Username: "Administrator",
+		Password: "secret&\"",
+	}
+
+	machine, err := (*v).Create(machine_attributes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	machine_id = machine.Id()
+
+	status, err := machine.Status()
+	for err == nil && status == vms.StatusCreating {
+		time.Sleep(10 * time.Millisecond)
+		status, err = machine.Status()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != vms.StatusDown {
+		t.Errorf("VM status should be down, it is: %v", status)
+	}
+
+	progress, err := machine.Progress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress != 100 {
+		t.Errorf("VM creation progress should be 100, it is: %d", progress)
+	}
+
+	name, err := machine.Name()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != machine_attributes.Name {
+		t.Errorf("Machine name should be %q, got %q", machine_attributes.Name, name)
+	}
+
+	username, password, err := machine.Credentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if username != machine_attributes.Username || password != machine_attributes.Password {
+		t.Errorf("Credentials don't match the inserted values")
+	}
+}
+
+func TestMachines(t *testing.T) {
+	machines, err := (*v).Machines()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// test:///default comes with a "test" domain that isn't managed by nanocloud
+	if len(machines) != 1 || machines[0].Id() != machine_id {
+		t.Errorf("Only the created machine should be listed, got %d machines", len(machines))
+	}
+}
+
+func TestStart(t *testing.T) {
+	machine := getMachine(t)
+
+	err := machine.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := machine.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != vms.StatusUp {
+		t.Errorf("VM status should be up, it is: %v", status)
+	}
+}
+
+func TestStop(t *testing.T) {
+	machine := getMachine(t)
+
+	err := machine.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := machine.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != vms.StatusStopping && status != vms.StatusDown {
+		t.Errorf("VM status should be stopping or down, it is: %v", status)
+	}
+}
+
+func TestTerminate(t *testing.T) {
+	machine := getMachine(t)
+
+	err := machine.Terminate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := machine.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != vms.StatusTerminated {
+		t.Errorf("VM status should be terminated, it is: %v", status)
+	}
+
+	machines, err := (*v).Machines()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(machines) != 0 {
+		t.Errorf("Terminated machine should not be listed")
+	}
+
+	m, err := (*v).Machine(machine_id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m != nil {
+		t.Errorf("Terminated machine should not be found")
+	}
+}
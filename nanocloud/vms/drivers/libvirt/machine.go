@@ -0,0 +1,298 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package libvirt
+
+import (
+	"net"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/models/machine-types"
+	"github.com/Nanocloud/community/nanocloud/vms"
+	log "github.com/Sirupsen/logrus"
+	virt "github.com/libvirt/libvirt-go"
+)
+
+type machine struct {
+	id   string
+	conn *virt.Connect
+}
+
+func isNotFound(err error) bool {
+	e, ok := err.(virt.Error)
+	return ok && e.Code == virt.ERR_NO_DOMAIN
+}
+
+func (m *machine) domain() (*virt.Domain, error) {
+	return m.conn.LookupDomainByUUIDString(m.id)
+}
+
+func (m *machine) desc() (*domainDesc, error) {
+	dom, err := m.domain()
+	if err != nil {
+		return nil, err
+	}
+	defer dom.Free()
+
+	xmlDesc, err := dom.GetXMLDesc(0)
+	if err != nil {
+		return nil, err
+	}
+	return parseDomainDesc(xmlDesc)
+}
+
+func (m *machine) Id() string {
+	return m.id
+}
+
+func (m *machine) Platform() string {
+	return "libvirt"
+}
+
+func (m *machine) Name() (string, error) {
+	if c := creationState(m.id); c != nil {
+		return c.name, nil
+	}
+
+	desc, err := m.desc()
+	if err != nil {
+		return "", err
+	}
+
+	if desc.Title != "" {
+		return desc.Title, nil
+	}
+	return desc.Name, nil
+}
+
+func (m *machine) Status() (vms.MachineStatus, error) {
+	if c := creationState(m.id); c != nil {
+		if c.err != nil {
+			// The failure is reported once, the machine is gone afterwards.
+			creationDone(m.id)
+			return vms.StatusUnknown, c.err
+		}
+		return vms.StatusCreating, nil
+	}
+
+	dom, err := m.domain()
+	if err != nil {
+		if isNotFound(err) {
+			return vms.StatusTerminated, nil
+		}
+		return vms.StatusUnknown, err
+	}
+	defer dom.Free()
+
+	state, _, err := dom.GetState()
+	if err != nil {
+		return vms.StatusUnknown, err
+	}
+
+	switch state {
+	case virt.DOMAIN_RUNNING, virt.DOMAIN_BLOCKED:
+		return vms.StatusUp, nil
+	case virt.DOMAIN_SHUTDOWN:
+		return vms.StatusStopping, nil
+	case virt.DOMAIN_SHUTOFF, virt.DOMAIN_CRASHED:
+		return vms.StatusDown, nil
+	}
+	return vms.StatusUnknown, nil
+}
+
+func (m *machine) IP() (net.IP, error) {
+	if creationState(m.id) != nil {
+		return nil, nil
+	}
+
+	dom, err := m.domain()
+	if err != nil {
+		return nil, err
+	}
+	defer dom.Free()
+
+	active, err := dom.IsActive()
+	if err != nil || !active {
+		return nil, err
+	}
+
+	ifaces, err := dom.ListAllInterfaceAddresses(virt.DOMAIN_INTERFACE_ADDRESSES_SRC_LEASE)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, iface := range ifaces {
+		for _, addr := range iface.Addrs {
+			if addr.Type == virt.IP_ADDR_TYPE_IPV4 {
+				return net.ParseIP(addr.Addr), nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (m *machine) Type() (vms.MachineType, error) {
+	if c := creationState(m.id); c != nil {
+		return machinetypes.Type("libvirt", c.machineType)
+	}
+
+	desc, err := m.desc()
+	if err != nil {
+		return nil, err
+	}
+
+	meta := desc.Metadata.Machine
+	if meta == nil || meta.Type == "" {
+		return machinetypes.Type("libvirt", machinetypes.DefaultID)
+	}
+	return machinetypes.Type("libvirt", meta.Type)
+}
+
+// Progress reports the progress of the creation of the machine: half of it
+// is the creation of its storage, the other half the definition of its
+// domain.
+func (m *machine) Progress() (uint8, error) {
+	c := creationState(m.id)
+	if c == nil {
+		return 100, nil
+	}
+	if c.err != nil {
+		creationDone(m.id)
+	}
+	return c.progress, c.err
+}
+
+func (m *machine) Credentials() (string, string, error) {
+	rows, err := db.Query(
+		`SELECT username, password
+		FROM machines
+		WHERE id = $1::varchar AND type = 'libvirt'`,
+		m.id,
+	)
+	if err != nil {
+		return "", "", err
+	}
+	defer rows.Close()
+
+	var username, password string
+	if rows.Next() {
+		err = rows.Scan(&username, &password)
+		if err != nil {
+			return "", "", err
+		}
+	}
+	return username, password, rows.Err()
+}
+
+func (m *machine) Start() error {
+	log.WithFields(log.Fields{
+		"VM": m.id,
+	}).Info("Starting VM")
+
+	dom, err := m.domain()
+	if err != nil {
+		return err
+	}
+	defer dom.Free()
+
+	return dom.Create()
+}
+
+func (m *machine) Stop() error {
+	log.WithFields(log.Fields{
+		"VM": m.id,
+	}).Info("Stopping VM")
+
+	dom, err := m.domain()
+	if err != nil {
+		return err
+	}
+	defer dom.Free()
+
+	return dom.Shutdown()
+}
+
+// Terminate powers off the domain, deletes its disks and undefines it. The
+// credentials of the machine are deleted as well.
+func (m *machine) Terminate() error {
+	log.WithFields(log.Fields{
+		"VM": m.id,
+	}).Info("Deleting VM")
+
+	desc, err := m.desc()
+	if err != nil {
+		return err
+	}
+
+	dom, err := m.domain()
+	if err != nil {
+		return err
+	}
+	defer dom.Free()
+
+	active, err := dom.IsActive()
+	if err != nil {
+		return err
+	}
+
+	if active {
+		err = dom.Destroy()
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, disk := range desc.Disks {
+		if disk.Device != "disk" || disk.Source.File == "" {
+			continue
+		}
+
+		vol, err := m.conn.LookupStorageVolByPath(disk.Source.File)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"VM": m.id,
+			}).Error(err)
+			continue
+		}
+
+		err = vol.Delete(0)
+		vol.Free()
+		if err != nil {
+			return err
+		}
+	}
+
+	err = dom.Undefine()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("DELETE FROM machines WHERE id = $1::varchar", m.id)
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"VM": m.id,
+	}).Info("VM Deleted")
+	return nil
+}
@@ -0,0 +1,128 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package libvirt
+
+import (
+	"bytes"
+	"encoding/xml"
+	"text/template"
+)
+
+const domainTemplate = `<domain type='{{.Type}}'>
+  <name>{{.Name}}</name>
+  <uuid>{{.UUID}}</uuid>
+  <title>{{xml .Title}}</title>
+  <metadata>
+    <nanocloud:machine xmlns:nanocloud='{{.Namespace}}'>
+      <nanocloud:type>{{xml .MachineType}}</nanocloud:type>
+    </nanocloud:machine>
+  </metadata>
+  <memory unit='MiB'>{{.RAM}}</memory>
+  <vcpu>{{.CPU}}</vcpu>
+  <os>
+    <type arch='x86_64'>hvm</type>
+    <boot dev='hd'/>
+  </os>
+  <features>
+    <acpi/>
+    <apic/>
+  </features>
+  <clock offset='localtime'/>
+  <on_poweroff>destroy</on_poweroff>
+  <on_reboot>restart</on_reboot>
+  <on_crash>restart</on_crash>
+  <devices>
+    <disk type='file' device='disk'>
+      <driver name='qemu' type='qcow2'/>
+      <source file='{{xml .Disk}}'/>
+      <target dev='sda' bus='sata'/>
+    </disk>
+    <interface type='network'>
+      <source network='{{xml .Network}}'/>
+      <model type='e1000'/>
+    </interface>
+    <graphics type='vnc' autoport='yes'/>
+  </devices>
+</domain>`
+
+const volumeTemplate = `<volume>
+  <name>{{.Name}}</name>
+  <capacity unit='G'>{{.Size}}</capacity>
+  <target>
+    <format type='qcow2'/>
+  </target>
+  {{- if .Image}}
+  <backingStore>
+    <path>{{xml .Image}}</path>
+    <format type='qcow2'/>
+  </backingStore>
+  {{- end}}
+</volume>`
+
+// domainDesc holds the parts of a domain XML description read by the driver.
+type domainDesc struct {
+	Name     string `xml:"name"`
+	Title    string `xml:"title"`
+	Metadata struct {
+		Machine *machineMetadata `xml:"https://nanocloud.com/xmlns/machine/1.0 machine"`
+	} `xml:"metadata"`
+	Disks []struct {
+		Device string `xml:"device,attr"`
+		Source struct {
+			File string `xml:"file,attr"`
+		} `xml:"source"`
+	} `xml:"devices>disk"`
+}
+
+type machineMetadata struct {
+	Type string `xml:"type"`
+}
+
+func escape(s string) (string, error) {
+	var b bytes.Buffer
+	err := xml.EscapeText(&b, []byte(s))
+	return b.String(), err
+}
+
+var (
+	domainTmpl = template.Must(template.New("domain").Funcs(template.FuncMap{"xml": escape}).Parse(domainTemplate))
+	volumeTmpl = template.Must(template.New("volume").Funcs(template.FuncMap{"xml": escape}).Parse(volumeTemplate))
+)
+
+func render(tmpl *template.Template, data interface{}) (string, error) {
+	var b bytes.Buffer
+	err := tmpl.Execute(&b, data)
+	if err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func parseDomainDesc(desc string) (*domainDesc, error) {
+	d := domainDesc{}
+	err := xml.Unmarshal([]byte(desc), &d)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
@@ -0,0 +1,396 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package libvirt
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/models/machine-types"
+	"github.com/Nanocloud/community/nanocloud/vms"
+	log "github.com/Sirupsen/logrus"
+	virt "github.com/libvirt/libvirt-go"
+	uuid "github.com/satori/go.uuid"
+)
+
+type vm struct {
+	conn        *virt.Connect
+	domainType  string
+	storagePool string
+	network     string
+	image       string
+}
+
+// creation tracks a machine whose storage and domain are being created in the
+// background.
+type creation struct {
+	name        string
+	machineType string
+	progress    uint8
+	err         error
+}
+
+var (
+	creatingLock sync.Mutex
+	creating     = make(map[string]*creation)
+)
+
+// creationState returns the state of the creation of the machine or nil once
+// its domain is defined.
+func creationState(id string) *creation {
+	creatingLock.Lock()
+	defer creatingLock.Unlock()
+
+	c, ok := creating[id]
+	if !ok {
+		return nil
+	}
+	state := *c
+	return &state
+}
+
+func setCreationState(id string, progress uint8, err error) {
+	creatingLock.Lock()
+	defer creatingLock.Unlock()
+
+	if c, ok := creating[id]; ok {
+		c.progress = progress
+		c.err = err
+	}
+}
+
+func creationDone(id string) {
+	creatingLock.Lock()
+	defer creatingLock.Unlock()
+
+	delete(creating, id)
+}
+
+// managed reports whether the domain has been created by nanocloud.
+func managed(dom *virt.Domain) bool {
+	xmlDesc, err := dom.GetXMLDesc(0)
+	if err != nil {
+		return false
+	}
+
+	desc, err := parseDomainDesc(xmlDesc)
+	if err != nil {
+		return false
+	}
+	return desc.Metadata.Machine != nil
+}
+
+func (v *vm) createVolume(id string, t *machinetypes.MachineType) (string, error) {
+	pool, err := v.conn.LookupStoragePoolByName(v.storagePool)
+	if err != nil {
+		return "", err
+	}
+	defer pool.Free()
+
+	var conf struct {
+		Name  string
+		Size  uint64
+		Image string
+	}
+
+	conf.Name = id + ".qcow2"
+	conf.Size = uint64(t.Disk)
+	conf.Image = t.Image
+	if conf.Image == "" {
+		conf.Image = v.image
+	}
+
+	volXML, err := render(volumeTmpl, &conf)
+	if err != nil {
+		return "", err
+	}
+
+	vol, err := pool.StorageVolCreateXML(volXML, 0)
+	if err != nil {
+		return "", err
+	}
+	defer vol.Free()
+
+	return vol.GetPath()
+}
+
+func (v *vm) Create(attr vms.MachineAttributes) (vms.Machine, error) {
+	if attr.Type == nil {
+		var err error
+		attr.Type, err = v.Type(machinetypes.DefaultID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	t, ok := attr.Type.(*machinetypes.MachineType)
+	if !ok {
+		return nil, errors.New("VM Type not supported")
+	}
+
+	id := uuid.NewV4().String()
+
+	// The credentials are kept in the database like the ones of the manual
+	// machines rather than in the domain description.
+	_, err := db.Exec(
+		`INSERT INTO machines
+		(id, name, type, username, password)
+		VALUES ($1::varchar, $2::varchar, $3::varchar, $4::varchar, $5::varchar)`,
+		id, attr.Name, "libvirt", attr.Username, attr.Password,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	creatingLock.Lock()
+	creating[id] = &creation{
+		name:        attr.Name,
+		machineType: t.GetID(),
+	}
+	creatingLock.Unlock()
+
+	go v.create(id, attr.Name, t)
+
+	return &machine{
+		id:   id,
+		conn: v.conn,
+	}, nil
+}
+
+// create creates the storage and defines the domain of a machine. Its
+// progress is reported by the Progress method of the machine.
+func (v *vm) create(id string, name string, t *machinetypes.MachineType) {
+	log.WithFields(log.Fields{
+		"VM": id,
+	}).Info("Create VM storage")
+
+	disk, err := v.createVolume(id, t)
+	if err != nil {
+		v.createFailed(id, err)
+		return
+	}
+	setCreationState(id, 50, nil)
+
+	var conf struct {
+		Type        string
+		Name        string
+		UUID        string
+		Title       string
+		Namespace   string
+		MachineType string
+		RAM         int
+		CPU         int
+		Disk        string
+		Network     string
+	}
+
+	conf.Type = v.domainType
+	conf.Name = "nanocloud-" + id
+	conf.UUID = id
+	conf.Title = name
+	conf.Namespace = metadataNamespace
+	conf.MachineType = t.GetID()
+	conf.RAM = t.RAM
+	conf.CPU = t.CPU
+	conf.Disk = disk
+	conf.Network = v.network
+
+	domXML, err := render(domainTmpl, &conf)
+	if err != nil {
+		v.deleteVolume(disk)
+		v.createFailed(id, err)
+		return
+	}
+
+	dom, err := v.conn.DomainDefineXML(domXML)
+	if err != nil {
+		v.deleteVolume(disk)
+		v.createFailed(id, err)
+		return
+	}
+	dom.Free()
+
+	creationDone(id)
+
+	log.WithFields(log.Fields{
+		"VM": id,
+	}).Info("VM defined")
+}
+
+func (v *vm) deleteVolume(path string) {
+	vol, err := v.conn.LookupStorageVolByPath(path)
+	if err == nil {
+		vol.Delete(0)
+		vol.Free()
+	}
+}
+
+// createFailed records the error returned by the creation of a machine, until
+// it is reported by the Status or Progress methods, and forgets its
+// credentials.
+func (v *vm) createFailed(id string, err error) {
+	log.WithFields(log.Fields{
+		"VM": id,
+	}).Error(err)
+
+	setCreationState(id, 0, err)
+
+	_, e := db.Exec("DELETE FROM machines WHERE id = $1::varchar", id)
+	if e != nil {
+		log.WithFields(log.Fields{
+			"VM": id,
+		}).Error(e)
+	}
+}
+
+// removeOrphans forgets the credentials, and deletes the storage, of the
+// machines whose creation has been interrupted by a restart before their
+// domain was defined.
+func (v *vm) removeOrphans() error {
+	rows, err := db.Query(
+		`SELECT id FROM machines
+		WHERE type = 'libvirt'`,
+	)
+	if err != nil {
+		return err
+	}
+
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		err = rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		dom, err := v.conn.LookupDomainByUUIDString(id)
+		if err == nil {
+			dom.Free()
+			continue
+		}
+		if !isNotFound(err) {
+			return err
+		}
+
+		log.WithFields(log.Fields{
+			"VM": id,
+		}).Info("Removing the machine whose creation has been interrupted")
+
+		pool, err := v.conn.LookupStoragePoolByName(v.storagePool)
+		if err == nil {
+			vol, err := pool.LookupStorageVolByName(id + ".qcow2")
+			if err == nil {
+				vol.Delete(0)
+				vol.Free()
+			}
+			pool.Free()
+		}
+
+		_, err = db.Exec("DELETE FROM machines WHERE id = $1::varchar", id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *vm) Machines() ([]vms.Machine, error) {
+	domains, err := v.conn.ListAllDomains(0)
+	if err != nil {
+		return nil, err
+	}
+
+	machines := make([]vms.Machine, 0)
+
+	creatingLock.Lock()
+	for id, c := range creating {
+		if c.err == nil {
+			machines = append(machines, &machine{
+				id:   id,
+				conn: v.conn,
+			})
+		}
+	}
+	creatingLock.Unlock()
+
+	for _, dom := range domains {
+		if managed(&dom) {
+			id, err := dom.GetUUIDString()
+			if err == nil {
+				machines = append(machines, &machine{
+					id:   id,
+					conn: v.conn,
+				})
+			}
+		}
+		dom.Free()
+	}
+
+	return machines, nil
+}
+
+func (v *vm) Machine(id string) (vms.Machine, error) {
+	if creationState(id) != nil {
+		return &machine{
+			id:   id,
+			conn: v.conn,
+		}, nil
+	}
+
+	dom, err := v.conn.LookupDomainByUUIDString(id)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer dom.Free()
+
+	if !managed(dom) {
+		return nil, errors.New("Machine not managed by nanocloud")
+	}
+
+	return &machine{
+		id:   id,
+		conn: v.conn,
+	}, nil
+}
+
+func (v *vm) Types() ([]vms.MachineType, error) {
+	return machinetypes.Types("libvirt")
+}
+
+func (v *vm) Type(id string) (vms.MachineType, error) {
+	return machinetypes.Type("libvirt", id)
+}
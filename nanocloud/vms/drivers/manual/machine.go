@@ -28,25 +28,34 @@ import (
 	"net/http"
 
 	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/models/machine-types"
+	"github.com/Nanocloud/community/nanocloud/plaza"
 	"github.com/Nanocloud/community/nanocloud/vms"
 	"github.com/labstack/gommon/log"
 )
 
 type machine struct {
-	id        string
-	name      string
-	server    string
-	plazaport string
-	user      string
-	password  string
+	id          string
+	name        string
+	server      string
+	plazaport   string
+	user        string
+	password    string
+	machineType string
 }
 
 func (m *machine) Status() (vms.MachineStatus, error) {
-	resp, err := http.Get("http://" + m.server + ":" + m.plazaport + "/")
+	req, err := plaza.NewRequest("GET", "http://"+m.server+":"+m.plazaport+"/", nil)
+	if err != nil {
+		return vms.StatusUnknown, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		log.Error(err)
 		return vms.StatusDown, nil
 	}
+	resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		return vms.StatusUnknown, nil
@@ -60,7 +69,7 @@ func (m *machine) IP() (net.IP, error) {
 }
 
 func (m *machine) Type() (vms.MachineType, error) {
-	return defaultType, nil
+	return machinetypes.Type("manual", m.machineType)
 }
 
 func (m *machine) Platform() string {
@@ -23,9 +23,8 @@
 package manual
 
 import (
-	"fmt"
-
 	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/models/machine-types"
 	"github.com/Nanocloud/community/nanocloud/vms"
 	"github.com/labstack/gommon/log"
 	uuid "github.com/satori/go.uuid"
@@ -35,18 +34,24 @@ type vm struct {
 }
 
 func (v *vm) Create(attr vms.MachineAttributes) (vms.Machine, error) {
+	machineType := machinetypes.DefaultID
+	if attr.Type != nil {
+		machineType = attr.Type.GetID()
+	}
+
 	machine := &machine{
-		id:       uuid.NewV4().String(),
-		name:     attr.Name,
-		server:   attr.Ip,
-		user:     attr.Username,
-		password: attr.Password,
+		id:          uuid.NewV4().String(),
+		name:        attr.Name,
+		server:      attr.Ip,
+		user:        attr.Username,
+		password:    attr.Password,
+		machineType: machineType,
 	}
 	rows, err := db.Query(
 		`INSERT INTO machines
-		(id, name, type, ip, username, password)
-		VALUES( $1::varchar, $2::varchar, $3::varchar, $4::varchar, $5::varchar, $6::varchar)`,
-		machine.id, machine.Name, "manual", machine.server, machine.user, machine.password)
+		(id, name, type, ip, username, password, machine_type)
+		VALUES( $1::varchar, $2::varchar, $3::varchar, $4::varchar, $5::varchar, $6::varchar, $7::varchar)`,
+		machine.id, machine.name, "manual", machine.server, machine.user, machine.password, machine.machineType)
 	if err != nil {
 		return nil, err
 	}
@@ -58,7 +63,7 @@ func (v *vm) Machines() ([]vms.Machine, error) {
 
 	rows, err := db.Query(
 		`SELECT type, id, name, ip,
-		plazaport, username, password
+		plazaport, username, password, machine_type
 		FROM machines`,
 	)
 	if err != nil {
@@ -78,6 +83,7 @@ func (v *vm) Machines() ([]vms.Machine, error) {
 			&machine.plazaport,
 			&machine.user,
 			&machine.password,
+			&machine.machineType,
 		)
 		if vmType == "manual" {
 			machines = append(machines, machine)
@@ -93,24 +99,28 @@ func (v *vm) Machines() ([]vms.Machine, error) {
 
 func (v *vm) Machine(id string) (vms.Machine, error) {
 	rows, err := db.Query(
-		`SELECT id, name, ip, plazaport, username, password
-		FROM machines WHERE id = $1::varchar`, id)
+		`SELECT id, name, ip, plazaport, username, password, machine_type
+		FROM machines WHERE id = $1::varchar AND type = 'manual'`, id)
 	if err != nil {
-		fmt.Println(err.Error())
+		log.Error(err.Error())
 		return nil, err
 	}
-	machine := &machine{}
-	for rows.Next() {
-		rows.Scan(
-			&machine.id,
-			&machine.name,
-			&machine.server,
-			&machine.plazaport,
-			&machine.user,
-			&machine.password,
-		)
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, rows.Err()
 	}
-	err = rows.Err()
+
+	machine := &machine{}
+	err = rows.Scan(
+		&machine.id,
+		&machine.name,
+		&machine.server,
+		&machine.plazaport,
+		&machine.user,
+		&machine.password,
+		&machine.machineType,
+	)
 	if err != nil {
 		log.Error(err.Error())
 		return nil, err
@@ -119,9 +129,9 @@ func (v *vm) Machine(id string) (vms.Machine, error) {
 }
 
 func (v *vm) Types() ([]vms.MachineType, error) {
-	return []vms.MachineType{defaultType}, nil
+	return machinetypes.Types("manual")
 }
 
 func (v *vm) Type(id string) (vms.MachineType, error) {
-	return defaultType, nil
+	return machinetypes.Type("manual", id)
 }
@@ -29,6 +29,8 @@ import (
 	"net/http"
 	"strconv"
 
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/models/machine-types"
 	"github.com/Nanocloud/community/nanocloud/vms"
 	log "github.com/Sirupsen/logrus"
 )
@@ -93,8 +95,33 @@ func (m *machine) IP() (net.IP, error) {
 	return net.ParseIP(m.server), nil
 }
 
+// Type returns the type the machine has been created with, or the default
+// one for the machines created before it was stored.
 func (m *machine) Type() (vms.MachineType, error) {
-	return defaultType, nil
+	rows, err := db.Query(
+		`SELECT machine_type
+		FROM machines
+		WHERE id = $1::varchar AND type = 'qemu'`,
+		m.id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	machineType := machinetypes.DefaultID
+	if rows.Next() {
+		err = rows.Scan(&machineType)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return machinetypes.Type("qemu", machineType)
 }
 
 func (m *machine) Platform() string {
@@ -27,6 +27,8 @@ import (
 	"io/ioutil"
 	"net/http"
 
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/models/machine-types"
 	"github.com/Nanocloud/community/nanocloud/vms"
 	log "github.com/Sirupsen/logrus"
 )
@@ -44,6 +46,22 @@ func (v *vm) Create(attr vms.MachineAttributes) (vms.Machine, error) {
 		log.Error(err)
 		return nil, err
 	}
+
+	machineType := machinetypes.DefaultID
+	if attr.Type != nil {
+		machineType = attr.Type.GetID()
+	}
+
+	_, err = db.Exec(
+		`INSERT INTO machines
+		(id, name, type, machine_type)
+		VALUES ($1::varchar, $2::varchar, $3::varchar, $4::varchar)
+		ON CONFLICT (id) DO UPDATE SET machine_type = $4::varchar`,
+		m.id, attr.Name, "qemu", machineType,
+	)
+	if err != nil {
+		return nil, err
+	}
 	return &m, nil
 }
 
@@ -84,9 +102,9 @@ func (v *vm) Machine(id string) (vms.Machine, error) {
 }
 
 func (v *vm) Types() ([]vms.MachineType, error) {
-	return []vms.MachineType{defaultType}, nil
+	return machinetypes.Types("qemu")
 }
 
 func (v *vm) Type(id string) (vms.MachineType, error) {
-	return defaultType, nil
+	return machinetypes.Type("qemu", id)
 }
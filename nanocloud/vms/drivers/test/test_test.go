@@ -39,11 +39,16 @@ func TestOpen(t *testing.T) {
 		t.Fatalf("empty type list retured")
 	}
 
-	connector.SetVM(v)
+	connector.Add("test", v)
+
+	drivers := connector.Drivers()
+	if len(drivers) != 1 || drivers[0] != "test" {
+		t.Fatalf("test driver should be registered, got: %v", drivers)
+	}
 }
 
 func TestType(t *testing.T) {
-	machine_type, err := connector.Type("default-test-machine-type")
+	machine_type, err := connector.Type("test:default-test-machine-type")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -53,7 +58,7 @@ func TestType(t *testing.T) {
 }
 
 func TestTypes(t *testing.T) {
-	v, err := connector.Types()
+	v, err := connector.Types("test")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -71,7 +76,7 @@ func TestCreate(t *testing.T) {
 		Ip:       "127.0.0.1",
 	}
 
-	new_machine, err := connector.Create(machine_attributes)
+	new_machine, err := connector.Create("test", machine_attributes)
 	if err != nil {
 		log.Panicln(err)
 	}
@@ -82,6 +87,31 @@ func TestCreate(t *testing.T) {
 	machine_id = new_machine.Id()
 }
 
+func TestMachines(t *testing.T) {
+	driver, _, err := connector.SplitID(machine_id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if driver != "test" {
+		t.Errorf("Machine id should be namespaced by its driver, got: %s", machine_id)
+	}
+
+	machines, err := connector.Machines()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := false
+	for _, machine := range machines {
+		if machine.Id() == machine_id {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Machine %s should be listed", machine_id)
+	}
+}
+
 func TestStart(t *testing.T) {
 	machine := getMachine()
 
@@ -140,19 +170,19 @@ func TestSetNil(t *testing.T) {
 	}
 
 	SetNil()
-	new_machine, _ := connector.Create(machine_attributes)
+	new_machine, _ := connector.Create("test", machine_attributes)
 	if new_machine != nil {
 		t.Errorf("Create(): Returned value should be nil")
 	}
 
 	SetNil()
-	machine_type, _ := connector.Type("default-test-machine-type")
+	machine_type, _ := connector.Type("test:default-test-machine-type")
 	if machine_type != nil {
 		t.Errorf("Type(): Returned value should be nil")
 	}
 
 	SetNil()
-	all_machines, _ := connector.Types()
+	all_machines, _ := connector.Types("test")
 	if all_machines != nil {
 		t.Errorf("Types(): Returned value should be nil")
 	}
@@ -169,19 +199,19 @@ func TestSetFail(t *testing.T) {
 	}
 
 	SetFail()
-	_, err := connector.Create(machine_attributes)
+	_, err := connector.Create("test", machine_attributes)
 	if err == nil {
 		t.Errorf("Create(): Error should not be nil")
 	}
 
 	SetFail()
-	_, err = connector.Type("default-test-machine-type")
+	_, err = connector.Type("test:default-test-machine-type")
 	if err == nil {
 		t.Errorf("Type(): Error should not be nil")
 	}
 
 	SetFail()
-	_, err = connector.Types()
+	_, err = connector.Types("test")
 	if err == nil {
 		t.Errorf("Types(): Error should not be nil")
 	}
@@ -217,21 +247,21 @@ func TestSetDelay(t *testing.T) {
 
 	SetDelay(1000)
 	called := time.Now()
-	connector.Create(machine_attributes)
+	connector.Create("test", machine_attributes)
 	if time.Since(called) < time.Duration(1000) {
 		t.Errorf("Create(): Delay should be observed")
 	}
 
 	SetDelay(1000)
 	called = time.Now()
-	connector.Type("default-test-machine-type")
+	connector.Type("test:default-test-machine-type")
 	if time.Since(called) < time.Duration(1000) {
 		t.Errorf("Type(): Delay should be observed")
 	}
 
 	SetDelay(1000)
 	called = time.Now()
-	connector.Types()
+	connector.Types("test")
 	if time.Since(called) < time.Duration(1000) {
 		t.Errorf("Types(): Delay should be observed")
 	}
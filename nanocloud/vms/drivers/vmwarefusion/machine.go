@@ -13,6 +13,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/Nanocloud/community/nanocloud/models/machine-types"
 	"github.com/Nanocloud/community/nanocloud/vms"
 	log "github.com/Sirupsen/logrus"
 )
@@ -138,8 +139,17 @@ func (m *machine) Platform() string {
 	return "vmwarefusion"
 }
 
+// Type returns the type stored in the VMX file when the machine has been
+// created, or the default one for the machines created before.
 func (m *machine) Type() (vms.MachineType, error) {
-	return defaultType, nil
+	machineType, err := m.vmx("nanocloud.machineType")
+	if err != nil {
+		return nil, err
+	}
+	if machineType == "" {
+		machineType = machinetypes.DefaultID
+	}
+	return machinetypes.Type("vmwarefusion", machineType)
 }
 
 func (m *machine) Start() error {
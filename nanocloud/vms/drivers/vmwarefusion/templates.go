@@ -3,6 +3,7 @@ package vmwarefusion
 const vmxTemplate = `.encoding = "UTF-8"
 config.version = "8"
 displayName = "{{.Name}}"
+nanocloud.machineType = "{{.MachineType}}"
 ethernet0.present = "TRUE"
 ethernet0.connectionType = "nat"
 ethernet0.virtualDev = "e1000e"
@@ -4,13 +4,16 @@ import (
 	"crypto/sha1"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"html/template"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"os/exec"
 	"path"
 
+	"github.com/Nanocloud/community/nanocloud/models/machine-types"
 	"github.com/Nanocloud/community/nanocloud/utils"
 	"github.com/Nanocloud/community/nanocloud/vms"
 	log "github.com/Sirupsen/logrus"
@@ -22,7 +25,7 @@ type vm struct {
 	storageDir    string
 }
 
-func (v *vm) createMachineStorage(vmId string, t *machineType) (string, error) {
+func (v *vm) createMachineStorage(vmId string, t *machinetypes.MachineType) (string, error) {
 	log.WithFields(log.Fields{
 		"VM": vmId,
 	}).Info("Create VM storage")
@@ -38,13 +41,13 @@ func (v *vm) createMachineStorage(vmId string, t *machineType) (string, error) {
 	log.Debugln(
 		"Executing:",
 		vdiskmanager, "-c", "-t", "0",
-		"-s", t.size,
+		"-s", fmt.Sprintf("%dGB", t.Disk),
 		"-a", "lsilogic",
 		dst,
 	)
 	cmd := exec.Command(
 		vdiskmanager, "-c", "-t", "0",
-		"-s", t.size,
+		"-s", fmt.Sprintf("%dGB", t.Disk),
 		"-a", "lsilogic",
 		dst,
 	)
@@ -102,7 +105,7 @@ func (v *vm) Machines() ([]vms.Machine, error) {
 	return machines, nil
 }
 
-func (v *vm) createVmxForSetup(vmId string, name string, t *machineType, hdd string, iso string, installISO string) (string, error) {
+func (v *vm) createVmxForSetup(vmId string, name string, t *machinetypes.MachineType, hdd string, iso string, installISO string) (string, error) {
 	log.WithFields(log.Fields{
 		"VM": vmId,
 	}).Info("Create setup VMX file")
@@ -123,6 +126,7 @@ func (v *vm) createVmxForSetup(vmId string, name string, t *machineType, hdd str
 
 	var conf struct {
 		Name                string
+		MachineType         string
 		WindowsInstallISO   string
 		NanocloudInstallISO string
 		RAM                 int
@@ -131,10 +135,11 @@ func (v *vm) createVmxForSetup(vmId string, name string, t *machineType, hdd str
 	}
 
 	conf.Name = name
+	conf.MachineType = t.GetID()
 	conf.WindowsInstallISO = iso
 	conf.NanocloudInstallISO = installISO
-	conf.RAM = t.ram
-	conf.CPU = t.cpu
+	conf.RAM = t.RAM
+	conf.CPU = t.CPU
 	conf.WMDKHardDrive = hdd
 
 	err = vmx.Execute(vmxFile, &conf)
@@ -167,10 +172,13 @@ func (v *vm) Create(attr vms.MachineAttributes) (vms.Machine, error) {
 	}
 
 	if attr.Type == nil {
-		attr.Type = defaultType
+		attr.Type, err = v.Type(machinetypes.DefaultID)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	t, ok := attr.Type.(*machineType)
+	t, ok := attr.Type.(*machinetypes.MachineType)
 	if !ok {
 		return nil, errors.New("VM Type not supported")
 	}
@@ -266,6 +274,14 @@ func (v *vm) createInstallDisk(vmId string, password string) (string, error) {
 		return "", err
 	}
 
+	secret := utils.Env("PLAZA_SECRET", "")
+	if secret != "" {
+		err = ioutil.WriteFile(path.Join(installDir, "plaza.secret"), []byte(secret), 0600)
+		if err != nil {
+			return "", err
+		}
+	}
+
 	autounattendTmpl := template.Must(template.New("autounattend").Parse(autounattend))
 
 	autounattendFile, err := os.Create(path.Join(installDir, "Autounattend.xml"))
@@ -311,9 +327,9 @@ func (v *vm) createInstallDisk(vmId string, password string) (string, error) {
 }
 
 func (v *vm) Types() ([]vms.MachineType, error) {
-	return []vms.MachineType{defaultType}, nil
+	return machinetypes.Types("vmwarefusion")
 }
 
 func (v *vm) Type(id string) (vms.MachineType, error) {
-	return defaultType, nil
+	return machinetypes.Type("vmwarefusion", id)
 }
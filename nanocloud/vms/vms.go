@@ -36,7 +36,10 @@ func Register(name string, driver Driver) {
 func Open(driverName string, options map[string]string) (*VM, error) {
 	driver := drivers[driverName]
 	if driver != nil {
-		d, _ := driver.Open(options)
+		d, err := driver.Open(options)
+		if err != nil {
+			return nil, err
+		}
 		return &d, nil
 	}
 	return nil, errors.New("Invalid driver name")
@@ -0,0 +1,217 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package router
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+// Requests from nanocloud are authenticated with an HMAC-SHA256 of the method,
+// the request URI, a timestamp, a nonce and the payload hash keyed with a
+// secret shared with nanocloud. The secret is read from the PLAZA_SECRET
+// environment variable or from the plaza.secret file next to the executable.
+const (
+	headerTimestamp = "X-Plaza-Timestamp"
+	headerNonce     = "X-Plaza-Nonce"
+	headerContent   = "X-Plaza-Content-Sha256"
+	headerSignature = "X-Plaza-Signature"
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+
+	kSecretFile = "plaza.secret"
+	kMaxSkew    = 5 * time.Minute
+)
+
+var (
+	errNoSecret         = errors.New("no secret is configured")
+	errMissingSignature = errors.New("missing signature")
+	errExpired          = errors.New("request expired")
+	errReplayed         = errors.New("nonce already used")
+	errBadPayload       = errors.New("payload doesn't match its hash")
+	errUnsignedPayload  = errors.New("payload must be signed")
+	errBadSignature     = errors.New("invalid signature")
+)
+
+var (
+	kSecret []byte
+
+	nonces     = make(map[string]time.Time)
+	noncesLock sync.Mutex
+)
+
+func loadSecret() []byte {
+	secret := os.Getenv("PLAZA_SECRET")
+	if secret != "" {
+		return []byte(secret)
+	}
+
+	dir, err := filepath.Abs(filepath.Dir(os.Args[0]))
+	if err != nil {
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, kSecretFile))
+	if err != nil {
+		return nil
+	}
+	return bytes.TrimSpace(b)
+}
+
+func signature(secret []byte, method, uri, timestamp, nonce, payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(method + "\n" + uri + "\n" + timestamp + "\n" + nonce + "\n" + payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// useNonce returns false if the nonce has already been seen. Nonces are kept
+// as long as the requests carrying them are valid.
+func useNonce(nonce string, now time.Time) bool {
+	noncesLock.Lock()
+	defer noncesLock.Unlock()
+
+	for n, expiration := range nonces {
+		if now.After(expiration) {
+			delete(nonces, n)
+		}
+	}
+
+	if _, exists := nonces[nonce]; exists {
+		return false
+	}
+	nonces[nonce] = now.Add(2 * kMaxSkew)
+	return true
+}
+
+// verify checks the signature of a request. The payload of the requests whose
+// body is streamed, when allowUnsigned is set, may be left out of it.
+func verify(r *http.Request, allowUnsigned bool) error {
+	timestamp := r.Header.Get(headerTimestamp)
+	nonce := r.Header.Get(headerNonce)
+	payload := r.Header.Get(headerContent)
+	sig := r.Header.Get(headerSignature)
+
+	if timestamp == "" || nonce == "" || payload == "" || sig == "" {
+		return errMissingSignature
+	}
+
+	expected := signature(kSecret, r.Method, r.RequestURI, timestamp, nonce, payload)
+	if !hmac.Equal([]byte(sig), []byte(expected)) {
+		return errBadSignature
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errBadSignature
+	}
+
+	now := time.Now()
+	skew := now.Sub(time.Unix(seconds, 0))
+	if skew > kMaxSkew || skew < -kMaxSkew {
+		return errExpired
+	}
+
+	if payload == unsignedPayload && !allowUnsigned {
+		return errUnsignedPayload
+	}
+
+	if payload != unsignedPayload {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		r.Body.Close()
+
+		sum := sha256.Sum256(body)
+		if !strings.EqualFold(payload, hex.EncodeToString(sum[:])) {
+			return errBadPayload
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	if !useNonce(nonce, now) {
+		return errReplayed
+	}
+	return nil
+}
+
+// authenticate returns an error if the request isn't signed with the shared
+// secret. Every request is rejected when no secret is configured.
+func authenticate(r *http.Request, allowUnsigned bool) error {
+	var err error
+	if len(kSecret) == 0 {
+		err = errNoSecret
+	} else {
+		err = verify(r, allowUnsigned)
+	}
+
+	if err != nil {
+		log.WithFields(log.Fields{
+			"remote": r.RemoteAddr,
+			"uri":    r.RequestURI,
+		}).Warn("Rejected request: ", err)
+	}
+	return err
+}
+
+// signed rejects the requests that aren't signed with the shared secret.
+func signed(handler echo.HandlerFunc) echo.HandlerFunc {
+	return func(c *echo.Context) error {
+		err := authenticate(c.Request(), false)
+		if err != nil {
+			return c.JSON(
+				http.StatusUnauthorized,
+				hash{
+					"error": err.Error(),
+				},
+			)
+		}
+		return handler(c)
+	}
+}
+
+// signedHTTP is signed for plain HTTP handlers streaming their body, whose
+// payload may be left unsigned.
+func signedHTTP(handler func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := authenticate(r, true)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
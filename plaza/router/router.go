@@ -26,11 +26,9 @@ import (
 	"net/http"
 
 	"github.com/Nanocloud/community/plaza/routes/about"
-	"github.com/Nanocloud/community/plaza/routes/apps"
 	"github.com/Nanocloud/community/plaza/routes/exec"
 	"github.com/Nanocloud/community/plaza/routes/files"
 	"github.com/Nanocloud/community/plaza/routes/power"
-	"github.com/Nanocloud/community/plaza/routes/sessions"
 	log "github.com/Sirupsen/logrus"
 	"github.com/labstack/echo"
 	mw "github.com/labstack/echo/middleware"
@@ -39,42 +37,37 @@ import (
 type hash map[string]interface{}
 
 func Start() {
+	kSecret = loadSecret()
+	if len(kSecret) == 0 {
+		log.Error("No secret is configured, plaza won't start as it would reject every request of nanocloud")
+		return
+	}
+
 	e := echo.New()
 
 	e.Use(mw.Recover())
 
-	e.Post("/exec", exec.Route)
+	e.Post("/exec", signed(exec.Route))
+	e.Post("/exec/jobs", signed(exec.Start))
+	e.Get("/exec/jobs/:id", signed(exec.Get))
+	e.Get("/exec/jobs/:id/output", signed(exec.Output))
+	e.Delete("/exec/jobs/:id", signed(exec.Kill))
 	e.Get("/", about.Get)
 
 	/***
 	FILES
 	***/
 
-	e.Get("/files", files.Get)
-	e.Post("/upload", files.Post)
+	e.Get("/files", signed(files.Get))
+	e.Post("/upload", signedHTTP(files.Post))
 
 	/***
 	POWER
 	***/
 
-	e.Get("/shutdown", power.ShutDown)
-	e.Get("/restart", power.Restart)
-	e.Get("/checkrds", power.CheckRDS)
-
-	/***
-	SESSIONS
-	***/
-
-	e.Get("/sessions/:id", sessions.Get)
-	e.Delete("/sessions/:id", sessions.Logoff)
-
-	/***
-	APPS
-	***/
-
-	e.Post("/publishapp", apps.PublishApp)
-	e.Get("/apps", apps.GetApps)
-	e.Delete("/apps/:id", apps.UnpublishApp)
+	e.Get("/shutdown", signed(power.ShutDown))
+	e.Get("/restart", signed(power.Restart))
+	e.Get("/checkrds", signed(power.CheckRDS))
 
 	e.SetHTTPErrorHandler(func(err error, c *echo.Context) {
 		c.JSON(
@@ -25,62 +25,63 @@
 package exec
 
 import (
-	"bytes"
-	"encoding/json"
-	"io/ioutil"
-	"net/http"
+	"io"
+	"os"
 	"os/exec"
 	"strings"
-
-	log "github.com/Sirupsen/logrus"
-	"github.com/labstack/echo"
+	"syscall"
 )
 
-type bodyRequest struct {
-	Command []string `json:"command"`
-	Stdin   string   `json:"stdin"`
+type posixProcess struct {
+	cmd *exec.Cmd
+}
+
+func (p *posixProcess) Pid() int {
+	return p.cmd.Process.Pid
 }
 
-func Route(c *echo.Context) error {
-	b, err := ioutil.ReadAll(c.Request().Body)
+func (p *posixProcess) Wait() (int, error) {
+	err := p.cmd.Wait()
 	if err != nil {
-		return err
+		if _, ok := err.(*exec.ExitError); !ok {
+			return 0, err
+		}
 	}
 
-	body := bodyRequest{}
-	err = json.Unmarshal(b, &body)
-	if err != nil {
-		return err
+	status, ok := p.cmd.ProcessState.Sys().(syscall.WaitStatus)
+	if !ok {
+		if p.cmd.ProcessState.Success() {
+			return 0, nil
+		}
+		return 1, nil
 	}
+	if status.Signaled() {
+		return 128 + int(status.Signal()), nil
+	}
+	return status.ExitStatus(), nil
+}
 
+func (p *posixProcess) Kill() error {
+	return p.cmd.Process.Kill()
+}
+
+func startProcess(body *bodyRequest, stdout io.Writer, stderr io.Writer) (process, error) {
 	cmd := exec.Command(body.Command[0], body.Command[1:]...)
 	if body.Stdin != "" {
 		cmd.Stdin = strings.NewReader(body.Stdin)
 	}
 
-	res := make(map[string]interface{})
-	var stdout bytes.Buffer
-	var stderr bytes.Buffer
+	cmd.Dir = body.Dir
+	if len(body.Env) > 0 {
+		cmd.Env = append(os.Environ(), body.Env...)
+	}
 
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
 
-	err = cmd.Run()
+	err := cmd.Start()
 	if err != nil {
-		log.Error(err)
-		res["success"] = false
-	} else {
-		res["success"] = cmd.ProcessState.Success()
-	}
-
-	sstdout := stdout.String()
-	if len(sstdout) > 0 {
-		res["stdout"] = sstdout
-	}
-	sstderr := stderr.String()
-	if len(sstderr) > 0 {
-		res["stderr"] = sstderr
+		return nil, err
 	}
-
-	return c.JSON(http.StatusOK, res)
+	return &posixProcess{cmd}, nil
 }
@@ -25,67 +25,49 @@
 package exec
 
 import (
-	"bytes"
-	"encoding/json"
-	"io/ioutil"
-	"net/http"
+	"io"
 	"strings"
 
 	"github.com/Nanocloud/community/plaza/windows"
-	log "github.com/Sirupsen/logrus"
-	"github.com/labstack/echo"
 )
 
-type bodyRequest struct {
-	Username   string   `json:"username"`
-	Domain     string   `json:"domain"`
-	Command    []string `json:"command"`
-	Stdin      string   `json:"stdin"`
-	HideWindow bool     `json:"hide-window"`
-	Wait       bool     `json:"wait"`
+type windowsProcess struct {
+	cmd *windows.Cmd
 }
 
-func Route(c *echo.Context) error {
-	b, err := ioutil.ReadAll(c.Request().Body)
-	if err != nil {
-		return err
-	}
+func (p *windowsProcess) Pid() int {
+	return p.cmd.Process.Pid
+}
 
-	body := bodyRequest{}
-	err = json.Unmarshal(b, &body)
-	if err != nil {
-		return err
+// Wait returns the exit code of the process. The command's own Wait reports
+// a failure for non zero exit codes, so only the process state is checked.
+func (p *windowsProcess) Wait() (int, error) {
+	err := p.cmd.Wait()
+	if p.cmd.ProcessState == nil {
+		return 0, err
 	}
+	return p.cmd.ProcessState.Status.ExitStatus(), nil
+}
 
+func (p *windowsProcess) Kill() error {
+	return p.cmd.Process.Kill()
+}
+
+func startProcess(body *bodyRequest, stdout io.Writer, stderr io.Writer) (process, error) {
 	cmd := windows.Command(body.Username, body.Domain, body.HideWindow, body.Command[0], body.Command[1:]...)
 	if body.Stdin != "" {
 		cmd.Stdin = strings.NewReader(body.Stdin)
 	}
 
-	res := make(map[string]interface{})
-	if body.Wait {
-		var stdout bytes.Buffer
-		var stderr bytes.Buffer
-
-		cmd.Stdout = &stdout
-		cmd.Stderr = &stderr
+	cmd.Dir = body.Dir
+	cmd.Env = body.Env
 
-		err = cmd.Run()
-		if err != nil {
-			log.Error(err)
-			return err
-		}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
 
-		res["stdout"] = stdout.String()
-		res["stderr"] = stderr.String()
-	} else {
-		err = cmd.Start()
-		if err != nil {
-			log.Error(err)
-			return err
-		}
-		res["pid"] = cmd.Process.Pid
+	err := cmd.Start()
+	if err != nil {
+		return nil, err
 	}
-
-	return c.JSON(http.StatusOK, res)
+	return &windowsProcess{cmd}, nil
 }
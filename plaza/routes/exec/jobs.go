@@ -0,0 +1,492 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package exec
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+const (
+	StateRunning = "running"
+	StateExited  = "exited"
+	StateKilled  = "killed"
+	StateTimeout = "timeout"
+	StateFailed  = "failed"
+)
+
+const (
+	// kMaxOutput is the size of the output kept for each job. The oldest output
+	// is dropped beyond.
+	kMaxOutput = 4 << 20
+
+	// kRetention is how long a finished job can still be queried.
+	kRetention = time.Hour
+)
+
+var (
+	jobNotFound = errors.New("job not found")
+	noCommand   = errors.New("no command to execute")
+)
+
+type hash map[string]interface{}
+
+type bodyRequest struct {
+	// Id is optional. Starting twice a job with the same id runs its command
+	// only once, so starting a job can safely be retried.
+	Id         string   `json:"id"`
+	Username   string   `json:"username"`
+	Domain     string   `json:"domain"`
+	Command    []string `json:"command"`
+	Stdin      string   `json:"stdin"`
+	HideWindow bool     `json:"hide-window"`
+	Wait       bool     `json:"wait"`
+	Dir        string   `json:"dir"`
+	Env        []string `json:"env"`
+
+	// Timeout is in seconds, 0 means no timeout.
+	Timeout int `json:"timeout"`
+}
+
+// process is a command started by the platform.
+type process interface {
+	Pid() int
+	// Wait returns the exit code of the process.
+	Wait() (int, error)
+	Kill() error
+}
+
+// event is a chunk of output or, for the last event of a job, its result.
+type event struct {
+	Seq    int    `json:"seq"`
+	Stream string `json:"stream,omitempty"`
+	Data   string `json:"data,omitempty"`
+	State  string `json:"state,omitempty"`
+	Code   *int   `json:"code,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type job struct {
+	Id        string    `json:"id"`
+	Pid       int       `json:"pid"`
+	State     string    `json:"state"`
+	Code      *int      `json:"code"`
+	Error     string    `json:"error,omitempty"`
+	StartedAt time.Time `json:"started-at"`
+	EndedAt   time.Time `json:"ended-at,omitempty"`
+
+	process  process
+	stopping string
+	timeout  *time.Timer
+	events   []event
+	first    int
+	size     int
+	cond     *sync.Cond
+}
+
+var (
+	jobs      = make(map[string]*job)
+	jobsMutex sync.Mutex
+)
+
+// output is the writer for one of the streams of a job.
+type output struct {
+	job    *job
+	stream string
+}
+
+func (o *output) Write(p []byte) (int, error) {
+	j := o.job
+	j.cond.L.Lock()
+	defer j.cond.L.Unlock()
+
+	j.events = append(j.events, event{
+		Seq:    j.first + len(j.events),
+		Stream: o.stream,
+		Data:   string(p),
+	})
+	j.size += len(p)
+
+	for j.size > kMaxOutput && len(j.events) > 1 {
+		j.size -= len(j.events[0].Data)
+		j.events = j.events[1:]
+		j.first++
+	}
+
+	j.cond.Broadcast()
+	return len(p), nil
+}
+
+func newId() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// cleanJobs forgets the jobs finished for longer than kRetention. jobsMutex
+// must be held.
+func cleanJobs() {
+	limit := time.Now().Add(-kRetention)
+	for id, j := range jobs {
+		j.cond.L.Lock()
+		if j.State != StateRunning && j.EndedAt.Before(limit) {
+			delete(jobs, id)
+		}
+		j.cond.L.Unlock()
+	}
+}
+
+func getJob(id string) *job {
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+	return jobs[id]
+}
+
+// startJob runs the command of body in background. If a job with the same id
+// already exists, it is returned instead.
+func startJob(body *bodyRequest) (*job, error) {
+	if len(body.Command) == 0 {
+		return nil, noCommand
+	}
+
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+
+	cleanJobs()
+
+	if body.Id == "" {
+		id, err := newId()
+		if err != nil {
+			return nil, err
+		}
+		body.Id = id
+	} else if j, exists := jobs[body.Id]; exists {
+		return j, nil
+	}
+
+	j := &job{
+		Id:        body.Id,
+		State:     StateRunning,
+		StartedAt: time.Now(),
+		cond:      sync.NewCond(&sync.Mutex{}),
+	}
+
+	p, err := startProcess(body, &output{j, "stdout"}, &output{j, "stderr"})
+	if err != nil {
+		return nil, err
+	}
+	j.process = p
+	j.Pid = p.Pid()
+
+	if body.Timeout > 0 {
+		j.timeout = time.AfterFunc(time.Duration(body.Timeout)*time.Second, func() {
+			j.kill(StateTimeout)
+		})
+	}
+
+	jobs[j.Id] = j
+	go j.wait()
+	return j, nil
+}
+
+func (j *job) wait() {
+	code, err := j.process.Wait()
+	if j.timeout != nil {
+		j.timeout.Stop()
+	}
+
+	j.cond.L.Lock()
+	defer j.cond.L.Unlock()
+
+	if j.State == StateRunning {
+		j.State = StateExited
+		if j.stopping != "" {
+			j.State = j.stopping
+		}
+	}
+	if err != nil {
+		log.Error(err)
+		j.State = StateFailed
+		j.Error = err.Error()
+	} else {
+		j.Code = &code
+	}
+	j.EndedAt = time.Now()
+
+	j.events = append(j.events, event{
+		Seq:   j.first + len(j.events),
+		State: j.State,
+		Code:  j.Code,
+		Error: j.Error,
+	})
+	j.cond.Broadcast()
+}
+
+// kill stops the process of the job. state tells why it has been stopped, it
+// is only set once the process has been killed.
+func (j *job) kill(state string) error {
+	j.cond.L.Lock()
+	if j.State != StateRunning || j.stopping != "" {
+		j.cond.L.Unlock()
+		return nil
+	}
+	j.stopping = state
+	j.cond.L.Unlock()
+
+	err := j.process.Kill()
+
+	j.cond.L.Lock()
+	defer j.cond.L.Unlock()
+
+	if err != nil {
+		j.stopping = ""
+		// The process may have exited meanwhile.
+		if j.done() {
+			return nil
+		}
+		return err
+	}
+	if j.State == StateRunning {
+		j.State = state
+	}
+	return nil
+}
+
+// done returns true once the process has exited.
+func (j *job) done() bool {
+	return !j.EndedAt.IsZero()
+}
+
+// next waits for the events following seq and returns them. The returned
+// boolean is false once the job is done and every event has been returned, or
+// when closed fires as the client has gone away.
+func (j *job) next(seq int, closed <-chan bool) ([]event, bool) {
+	stop := make(chan struct{})
+	defer close(stop)
+
+	gone := false
+	go func() {
+		select {
+		case <-closed:
+			j.cond.L.Lock()
+			gone = true
+			j.cond.Broadcast()
+			j.cond.L.Unlock()
+		case <-stop:
+		}
+	}()
+
+	j.cond.L.Lock()
+	defer j.cond.L.Unlock()
+
+	for j.first+len(j.events) <= seq && !j.done() && !gone {
+		j.cond.Wait()
+	}
+	if gone {
+		return nil, false
+	}
+
+	if seq < j.first {
+		seq = j.first
+	}
+	events := make([]event, j.first+len(j.events)-seq)
+	copy(events, j.events[seq-j.first:])
+	return events, len(events) > 0 || !j.done()
+}
+
+func (j *job) snapshot() job {
+	j.cond.L.Lock()
+	defer j.cond.L.Unlock()
+	return *j
+}
+
+func parseBody(c *echo.Context) (*bodyRequest, error) {
+	b, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return nil, err
+	}
+
+	body := bodyRequest{}
+	err = json.Unmarshal(b, &body)
+	if err != nil {
+		return nil, err
+	}
+	return &body, nil
+}
+
+func notFound(c *echo.Context) error {
+	return c.JSON(
+		http.StatusNotFound,
+		hash{
+			"error": jobNotFound.Error(),
+		},
+	)
+}
+
+// Start runs a command in background and replies with the job running it.
+func Start(c *echo.Context) error {
+	body, err := parseBody(c)
+	if err != nil {
+		return err
+	}
+
+	j, err := startJob(body)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	return c.JSON(http.StatusCreated, j.snapshot())
+}
+
+// Get replies with the state of a job.
+func Get(c *echo.Context) error {
+	j := getJob(c.Param("id"))
+	if j == nil {
+		return notFound(c)
+	}
+	return c.JSON(http.StatusOK, j.snapshot())
+}
+
+// Output streams the output of a job as newline delimited JSON events until
+// the job ends. The last event holds the state and exit code of the job. The
+// "from" parameter is the sequence number of the first event to send, so a
+// client can resume an interrupted stream.
+func Output(c *echo.Context) error {
+	j := getJob(c.Param("id"))
+	if j == nil {
+		return notFound(c)
+	}
+
+	seq, _ := strconv.Atoi(c.Query("from"))
+
+	w := c.Response()
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(w)
+	closed := w.CloseNotify()
+	for {
+		events, more := j.next(seq, closed)
+		if !more {
+			return nil
+		}
+
+		for _, e := range events {
+			err := encoder.Encode(e)
+			if err != nil {
+				return nil
+			}
+			seq = e.Seq + 1
+		}
+		w.Flush()
+	}
+}
+
+// Kill stops the process of a job.
+func Kill(c *echo.Context) error {
+	j := getJob(c.Param("id"))
+	if j == nil {
+		return notFound(c)
+	}
+
+	err := j.kill(StateKilled)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	return c.JSON(http.StatusOK, j.snapshot())
+}
+
+// Route runs a command. If wait is set, it replies with the output and the exit
+// code of the command once it has exited.
+func Route(c *echo.Context) error {
+	body, err := parseBody(c)
+	if err != nil {
+		return err
+	}
+
+	j, err := startJob(body)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
+	if !body.Wait {
+		return c.JSON(
+			http.StatusOK,
+			hash{
+				"id":  j.Id,
+				"pid": j.Pid,
+			},
+		)
+	}
+
+	var stdout, stderr []byte
+	var last event
+	seq := 0
+	closed := c.Response().CloseNotify()
+	for {
+		events, more := j.next(seq, closed)
+		if !more {
+			break
+		}
+		for _, e := range events {
+			switch e.Stream {
+			case "stdout":
+				stdout = append(stdout, e.Data...)
+			case "stderr":
+				stderr = append(stderr, e.Data...)
+			}
+			last = e
+			seq = e.Seq + 1
+		}
+	}
+
+	res := hash{
+		"id":     j.Id,
+		"stdout": string(stdout),
+		"stderr": string(stderr),
+		"state":  last.State,
+	}
+	if last.Code != nil {
+		res["code"] = *last.Code
+		res["success"] = *last.Code == 0
+	} else {
+		res["success"] = false
+		res["error"] = last.Error
+	}
+	return c.JSON(http.StatusOK, res)
+}
@@ -36,6 +36,11 @@ type Cmd struct {
 	// calling process's current directory.
 	Dir string
 
+	// Env specifies environment variables added to the environment of the
+	// user running the command, or of plaza when there is no Username, in
+	// the form "key=value".
+	Env []string
+
 	// Stdin specifies the process's standard input.
 	// If Stdin is nil, the process reads from the null device (os.DevNull).
 	// If Stdin is an *os.File, the process's standard input is connected
@@ -254,6 +259,7 @@ func (c *Cmd) Start() error {
 		c.Username, c.Domain,
 		&os.ProcAttr{
 			Dir:   c.Dir,
+			Env:   c.Env,
 			Files: c.childFiles,
 			Sys:   c.SysProcAttr,
 		},
@@ -69,11 +69,12 @@ func (p *ProcessState) String() string {
 type Process struct {
 	Pid    int
 	handle uintptr // handle is accessed atomically on Windows
+	job    uintptr // job object of the process and its children, 0 if none
 	isdone uint32  // process has been successfully waited on, non zero if true
 }
 
-func newProcess(pid int, handle uintptr) *Process {
-	p := &Process{Pid: pid, handle: handle}
+func newProcess(pid int, handle uintptr, job uintptr) *Process {
+	p := &Process{Pid: pid, handle: handle, job: job}
 	runtime.SetFinalizer(p, (*Process).Release)
 	return p
 }
@@ -87,9 +88,22 @@ func (p *Process) done() bool {
 }
 
 func (p *Process) kill() error {
+	job := atomic.LoadUintptr(&p.job)
+	if job != 0 && !p.done() {
+		e := terminateJobObject(syscall.Handle(job), 1)
+		if e != nil {
+			return os.NewSyscallError("TerminateJobObject", e)
+		}
+		return nil
+	}
 	return p.signal(os.Kill)
 }
 
+// Kill causes the Process and the processes it started to exit immediately.
+func (p *Process) Kill() error {
+	return p.kill()
+}
+
 func (p *Process) Wait() (ps *ProcessState, err error) {
 	handle := atomic.LoadUintptr(&p.handle)
 	s, e := syscall.WaitForSingleObject(syscall.Handle(handle), syscall.INFINITE)
@@ -156,6 +170,9 @@ func (p *Process) Release() error {
 		return os.NewSyscallError("CloseHandle", e)
 	}
 	atomic.StoreUintptr(&p.handle, uintptr(syscall.InvalidHandle))
+	if job := atomic.SwapUintptr(&p.job, 0); job != 0 {
+		syscall.CloseHandle(syscall.Handle(job))
+	}
 	// no need for a finalizer anymore
 	runtime.SetFinalizer(p, nil)
 	return nil
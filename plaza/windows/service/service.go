@@ -215,6 +215,15 @@ func InstallItSelf() error {
 		if err != nil {
 			return err
 		}
+
+		// the secret shared with nanocloud is installed with the executable
+		secret := path.Join(filepath.Dir(exepath), "plaza.secret")
+		if _, err := os.Stat(secret); err == nil {
+			err = copyFile(secret, path.Join(installDir, "plaza.secret"))
+			if err != nil {
+				return err
+			}
+		}
 	}
 
 	err = installService(serviceName, dst)
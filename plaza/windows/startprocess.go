@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strings"
 	"syscall"
 	"unicode/utf16"
 	"unsafe"
@@ -166,6 +167,47 @@ func createEnvBlock(envv []string) *uint16 {
 	return &utf16.Encode([]rune(string(b)))[0]
 }
 
+// envBlockToList converts an environment block returned by
+// CreateEnvironmentBlock to a list of "key=value" strings.
+func envBlockToList(block *uint16) []string {
+	var env []string
+
+	b := (*[1 << 20]uint16)(unsafe.Pointer(block))
+	start := 0
+	for i := range b {
+		if b[i] != 0 {
+			continue
+		}
+		if i == start {
+			break
+		}
+		env = append(env, string(utf16.Decode(b[start:i])))
+		start = i + 1
+	}
+	return env
+}
+
+// mergeEnv returns env with the variables of extra added. Variables of env
+// also defined in extra are replaced. Names are case insensitive.
+func mergeEnv(env []string, extra []string) []string {
+	name := func(v string) string {
+		return strings.ToUpper(strings.SplitN(v, "=", 2)[0])
+	}
+
+	overridden := make(map[string]bool, len(extra))
+	for _, v := range extra {
+		overridden[name(v)] = true
+	}
+
+	merged := make([]string, 0, len(env)+len(extra))
+	for _, v := range env {
+		if !overridden[name(v)] {
+			merged = append(merged, v)
+		}
+	}
+	return append(merged, extra...)
+}
+
 func getUserSessionID(username string) (DWord, error) {
 	/* Retreive the user's session */
 	var session *wtsSessionInfo1
@@ -183,13 +225,16 @@ func getUserSessionID(username string) (DWord, error) {
 	return 0, errors.New("Session not found")
 }
 
+// startProcessAsUser starts a process in the session of username, or as plaza
+// itself if username is empty. It returns the handles of the process and of
+// the job object it runs in.
 func startProcessAsUser(
 	argv0 string, argv []string,
 	username string, domain string,
 	attr *syscall.ProcAttr,
-) (pid int, handle uintptr, err error) {
+) (pid int, handle uintptr, job uintptr, err error) {
 	if len(argv0) == 0 {
-		return 0, 0, syscall.EWINDOWS
+		return 0, 0, 0, syscall.EWINDOWS
 	}
 	if attr == nil {
 		attr = &zeroProcAttr
@@ -200,10 +245,10 @@ func startProcessAsUser(
 	}
 
 	if len(attr.Files) > 3 {
-		return 0, 0, syscall.EWINDOWS
+		return 0, 0, 0, syscall.EWINDOWS
 	}
 	if len(attr.Files) < 3 {
-		return 0, 0, syscall.EINVAL
+		return 0, 0, 0, syscall.EINVAL
 	}
 
 	if len(attr.Dir) != 0 {
@@ -215,12 +260,12 @@ func startProcessAsUser(
 		// for that difference here by making argv0 absolute.
 		argv0, err = joinExeDirAndFName(attr.Dir, argv0)
 		if err != nil {
-			return 0, 0, err
+			return 0, 0, 0, err
 		}
 	}
 	argv0p, err := syscall.UTF16PtrFromString(argv0)
 	if err != nil {
-		return 0, 0, err
+		return 0, 0, 0, err
 	}
 
 	var cmdline string
@@ -237,7 +282,7 @@ func startProcessAsUser(
 	if len(cmdline) != 0 {
 		argvp, err = syscall.UTF16PtrFromString(cmdline)
 		if err != nil {
-			return 0, 0, err
+			return 0, 0, 0, err
 		}
 	}
 
@@ -253,7 +298,7 @@ func startProcessAsUser(
 		if attr.Files[i] > 0 {
 			err = syscall.DuplicateHandle(p, syscall.Handle(attr.Files[i]), p, &fd[i], 0, true, syscall.DUPLICATE_SAME_ACCESS)
 			if err != nil {
-				return 0, 0, errors.New("DuplicateHandle: " + err.Error())
+				return 0, 0, 0, errors.New("DuplicateHandle: " + err.Error())
 			}
 			defer syscall.CloseHandle(syscall.Handle(fd[i]))
 		}
@@ -269,54 +314,68 @@ func startProcessAsUser(
 	si.StdOutput = fd[1]
 	si.StdErr = fd[2]
 
-	wsDesktop, err := syscall.UTF16PtrFromString(`winsta0\default`)
-	if err != nil {
-		return 0, 0, err
+	var dirp *uint16
+	if len(attr.Dir) != 0 {
+		dirp, err = syscall.UTF16PtrFromString(attr.Dir)
+		if err != nil {
+			return 0, 0, 0, err
+		}
 	}
 
-	si.Desktop = wsDesktop
+	var token syscall.Token
+	var env *uint16
 
-	sessionID, err := getUserSessionID(username)
-	if err != nil {
-		return 0, 0, err
-	}
+	if username == "" {
+		// the command runs as plaza, with its environment
+		env = createEnvBlock(mergeEnv(os.Environ(), attr.Env))
+	} else {
+		wsDesktop, err := syscall.UTF16PtrFromString(`winsta0\default`)
+		if err != nil {
+			return 0, 0, 0, err
+		}
 
-	token, err := wtsQueryUserToken(sessionID)
-	if err != nil {
-		return 0, 0, fmt.Errorf("Query User Token Failed: %s", err.Error())
-	}
-	defer token.Close()
+		si.Desktop = wsDesktop
 
-	err = enableAllPrivileges(token)
-	if err != nil {
-		return 0, 0, errors.New("enableAllPrivileges: " + err.Error())
-	}
+		sessionID, err := getUserSessionID(username)
+		if err != nil {
+			return 0, 0, 0, err
+		}
 
-	var dirp *uint16
-	if len(attr.Dir) != 0 {
-		dirp, err = syscall.UTF16PtrFromString(attr.Dir)
+		token, err = wtsQueryUserToken(sessionID)
 		if err != nil {
-			return 0, 0, err
+			return 0, 0, 0, fmt.Errorf("Query User Token Failed: %s", err.Error())
 		}
-	} else {
-		dirp, err = getUserProfileDirectory(token)
+		defer token.Close()
+
+		err = enableAllPrivileges(token)
 		if err != nil {
-			return 0, 0, err
+			return 0, 0, 0, errors.New("enableAllPrivileges: " + err.Error())
 		}
-	}
 
-	var env *uint16
-	env, err = createEnvironmentBlock(token, false)
-	if err != nil {
-		return 0, 0, errors.New("createEnvironmentBlock: " + err.Error())
-	}
-	defer destroyEnvironmentBlock(env)
+		if dirp == nil {
+			dirp, err = getUserProfileDirectory(token)
+			if err != nil {
+				return 0, 0, 0, err
+			}
+		}
 
-	err = impersonateLoggedOnUser(token)
-	if err != nil {
-		return 0, 0, errors.New("impersonateLoggedOnUser: " + err.Error())
+		userEnv, err := createEnvironmentBlock(token, false)
+		if err != nil {
+			return 0, 0, 0, errors.New("createEnvironmentBlock: " + err.Error())
+		}
+		defer destroyEnvironmentBlock(userEnv)
+
+		env = userEnv
+		if len(attr.Env) > 0 {
+			env = createEnvBlock(mergeEnv(envBlockToList(userEnv), attr.Env))
+		}
+
+		err = impersonateLoggedOnUser(token)
+		if err != nil {
+			return 0, 0, 0, errors.New("impersonateLoggedOnUser: " + err.Error())
+		}
+		defer revertToSelf()
 	}
-	defer revertToSelf()
 
 	pi := new(syscall.ProcessInformation)
 
@@ -325,27 +384,76 @@ func startProcessAsUser(
 	flags |= syscall.CREATE_UNICODE_ENVIRONMENT
 	flags |= uint32(normalPriorityClass)
 	flags |= uint32(createNewConsole)
+	flags |= uint32(createSuspended)
+
+	if username == "" {
+		err = syscall.CreateProcess(
+			argv0p,
+			argvp,
+			nil,
+			nil,
+			true,
+			flags,
+			env,
+			dirp,
+			si,
+			pi,
+		)
+		if err != nil {
+			return 0, 0, 0, errors.New("CreateProcess: " + err.Error())
+		}
+	} else {
+		err = createProcessAsUser(
+			token,
+			argv0p,
+			argvp,
+			nil,
+			nil,
+			true,
+			flags,
+			env,
+			dirp,
+			si,
+			pi,
+		)
+		if err != nil {
+			return 0, 0, 0, errors.New("createProcessAsUser: " + err.Error())
+		}
+	}
+
+	defer syscall.CloseHandle(syscall.Handle(pi.Thread))
 
-	err = createProcessAsUser(
-		token,
-		argv0p,
-		argvp,
-		nil,
-		nil,
-		true,
-		flags,
-		env,
-		dirp,
-		si,
-		pi,
-	)
+	job, err = startInJob(pi)
 	if err != nil {
-		return 0, 0, errors.New("createProcessAsUser: " + err.Error())
+		return 0, 0, 0, err
 	}
+	return int(pi.ProcessId), uintptr(pi.Process), job, nil
+}
 
-	defer syscall.CloseHandle(syscall.Handle(pi.Thread))
+// startInJob puts a process created suspended in a job object of its own, so
+// that killing it also kills the processes it started, then resumes it. The
+// job is 0 if the process couldn't be put in one, only the process is killed
+// then.
+func startInJob(pi *syscall.ProcessInformation) (uintptr, error) {
+	job, err := createJobObject()
+	if err == nil {
+		err = assignProcessToJobObject(job, pi.Process)
+		if err != nil {
+			syscall.CloseHandle(job)
+			job = 0
+		}
+	}
 
-	return int(pi.ProcessId), uintptr(pi.Process), nil
+	err = resumeThread(pi.Thread)
+	if err != nil {
+		syscall.TerminateProcess(pi.Process, 1)
+		syscall.CloseHandle(pi.Process)
+		if job != 0 {
+			syscall.CloseHandle(job)
+		}
+		return 0, errors.New("ResumeThread: " + err.Error())
+	}
+	return uintptr(job), nil
 }
 
 func startProcess(
@@ -355,16 +463,17 @@ func startProcess(
 ) (p *Process, err error) {
 	sysattr := &syscall.ProcAttr{
 		Dir: attr.Dir,
+		Env: attr.Env,
 		Sys: attr.Sys,
 	}
 	for _, f := range attr.Files {
 		sysattr.Files = append(sysattr.Files, f.Fd())
 	}
 
-	pid, h, e := startProcessAsUser(name, argv, username, domain, sysattr)
+	pid, h, job, e := startProcessAsUser(name, argv, username, domain, sysattr)
 
 	if e != nil {
 		return nil, e
 	}
-	return newProcess(pid, h), nil
+	return newProcess(pid, h, job), nil
 }
@@ -76,6 +76,7 @@ const (
 
 	normalPriorityClass = 0x00000020
 	createNewConsole    = 0x00000010
+	createSuspended     = 0x00000004
 )
 
 func revertToSelf() error {
@@ -414,3 +415,55 @@ func adjustTokenPrivileges(token syscall.Token, uid luid) error {
 	}
 	return err
 }
+
+func createJobObject() (syscall.Handle, error) {
+	proc, err := loadProc("kernel32.dll", "CreateJobObjectW")
+	if err != nil {
+		return 0, err
+	}
+
+	r1, _, err := proc.Call(0, 0)
+	if r1 == 0 {
+		return 0, err
+	}
+	return syscall.Handle(r1), nil
+}
+
+func assignProcessToJobObject(job syscall.Handle, process syscall.Handle) error {
+	proc, err := loadProc("kernel32.dll", "AssignProcessToJobObject")
+	if err != nil {
+		return err
+	}
+
+	r1, _, err := proc.Call(uintptr(job), uintptr(process))
+	if r1 == 0 {
+		return err
+	}
+	return nil
+}
+
+func terminateJobObject(job syscall.Handle, exitCode uint32) error {
+	proc, err := loadProc("kernel32.dll", "TerminateJobObject")
+	if err != nil {
+		return err
+	}
+
+	r1, _, err := proc.Call(uintptr(job), uintptr(exitCode))
+	if r1 == 0 {
+		return err
+	}
+	return nil
+}
+
+func resumeThread(thread syscall.Handle) error {
+	proc, err := loadProc("kernel32.dll", "ResumeThread")
+	if err != nil {
+		return err
+	}
+
+	r1, _, err := proc.Call(uintptr(thread))
+	if int32(r1) == -1 {
+		return err
+	}
+	return nil
+}
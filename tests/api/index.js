@@ -53,3 +53,6 @@ require('./test-download')(admin);
 require('./test-files')(admin);
 require('./test-histories')(admin);
 require('./test-machines')(admin);
+require('./test-machine-types')(admin);
+require('./test-oauth-clients')(admin);
+require('./test-service-accounts')(admin);
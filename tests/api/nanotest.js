@@ -95,6 +95,16 @@ var nano = {
 
         return this;
       },
+      put: function(url, params) {
+        this.response = makeRequest('PUT', url, params);
+
+        return this;
+      },
+      patch: function(url, params) {
+        this.response = makeRequest('PATCH', url, params);
+
+        return this;
+      },
       get: function(url, data) {
         this.response = makeRequest('GET', url, data || null);
 
@@ -35,9 +35,11 @@ module.exports = function() {
       properties: {
         access_token: {type: 'string'},
         token_type: {'type': 'string'},
-        expires_in: {type: 'integer'}
+        expires_in: {type: 'integer'},
+        refresh_token: {type: 'string'},
+        scope: {type: 'string'}
       },
-      required: ['access_token', 'token_type', 'expires_in'],
+      required: ['access_token', 'token_type', 'expires_in', 'refresh_token'],
       additionalProperties: false
     };
 
@@ -115,6 +117,80 @@ module.exports = function() {
     });
   });
 
+  describe('Refresh tokens', function() {
+
+    var clientAuth = {
+      headers: {
+        Authorization: 'Basic ' + new Buffer(nano.CLIENTID).toString('base64')
+      }
+    };
+
+    var login = nano.post('oauth/token', {
+      username: nano.ADMIN_USERNAME,
+      password: nano.ADMIN_PASSWORD,
+      grant_type: 'password'
+    }, clientAuth).shouldReturn(200);
+
+    var first = login.response.data.refresh_token;
+
+    var refresh = nano.post('oauth/token', {
+      grant_type: 'refresh_token',
+      refresh_token: first
+    }, clientAuth).shouldReturn(200)
+        .shouldBeJSON();
+
+    var second = refresh.response.data.refresh_token;
+
+    it('should issue a new access token', function() {
+      expect(refresh.response.data.access_token).to.not.equal(login.response.data.access_token);
+    });
+
+    it('should rotate the refresh token', function() {
+      expect(second).to.be.a('string');
+      expect(second).to.not.equal(first);
+    });
+
+    describe('Reuse a refresh token', function() {
+      var reuse = nano.post('oauth/token', {
+        grant_type: 'refresh_token',
+        refresh_token: first
+      }, clientAuth).shouldReturn(400)
+          .shouldBeJSON()
+          .shouldComplyToNotJsonAPI(expectedErrorSchema);
+
+      it('should return invalid_grant', function() {
+        expect(reuse.response.data.error).to.equal('invalid_grant');
+      });
+
+      nano.post('oauth/token', {
+        grant_type: 'refresh_token',
+        refresh_token: second
+      }, clientAuth).shouldReturn(400);
+
+      nano.as(refresh.response.data).get('api/users').shouldReturn(401);
+    });
+
+    describe('Revoke a refresh token', function() {
+      var relogin = nano.post('oauth/token', {
+        username: nano.ADMIN_USERNAME,
+        password: nano.ADMIN_PASSWORD,
+        grant_type: 'password'
+      }, clientAuth).shouldReturn(200);
+
+      nano.post('oauth/revoke', {
+        token_type_hint: 'refresh_token',
+        token: relogin.response.data.refresh_token
+      }, clientAuth).shouldReturn(200);
+
+      nano.post('oauth/token', {
+        grant_type: 'refresh_token',
+        refresh_token: relogin.response.data.refresh_token
+      }, clientAuth).shouldReturn(400);
+
+      nano.as(relogin.response.data).get('api/users').shouldReturn(401);
+    });
+  });
+
   describe('Login without specifying grant_type', function() {
 
     var request = nano.post('oauth/token', {
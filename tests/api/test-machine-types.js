@@ -0,0 +1,67 @@
+#!/usr/bin/nodejs
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2015 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// jshint mocha:true
+
+var nano = require('./nanotest');
+
+module.exports = function(admin) {
+
+  var expectedSchema = {
+    type: 'object',
+    properties: {
+      driver: {type: 'string'},
+      name: {type: 'string'},
+      cpu: {type: 'number'},
+      ram: {type: 'number'},
+      disk: {type: 'number'},
+      image: {type: 'string'},
+      options: {type: ['object', 'null']},
+    },
+    required: ['driver', 'name', 'cpu', 'ram', 'disk', 'image'],
+    additionalProperties: false
+  };
+
+  describe('List machine types', function() {
+    nano.as(admin).get('api/machine-types')
+        .shouldReturn(200)
+        .shouldBeJSONAPI()
+        .shouldComplyTo(expectedSchema);
+  });
+
+  describe('Create machine type', function() {
+    nano.as(admin).post('api/machine-types', {
+      data: {
+        type: 'machine-types',
+        attributes: {
+          driver: 'manual',
+          name: '',
+          cpu: 2,
+          ram: 4096,
+          disk: 60
+        }
+      }
+    })
+        .shouldReturn(400);
+  });
+};
@@ -37,8 +37,10 @@ module.exports = function(admin) {
       'admin-password': {type: 'string'},
       platform: {type: 'string'},
       progress: {type: 'string'},
+      driver: {type: 'string'},
+      health: {type: 'string', enum: ['unknown', 'healthy', 'unhealthy']},
     },
-    required: ['name', 'ip', 'type', 'status', 'platform', 'progress'],
+    required: ['name', 'ip', 'type', 'status', 'platform', 'progress', 'driver'],
     additionalProperties: false
   };
 
@@ -0,0 +1,248 @@
+#!/usr/bin/nodejs
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2015 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+
+// jshint mocha:true
+
+var crypto = require('crypto');
+var querystring = require('querystring');
+var url = require('url');
+var nano = require('./nanotest');
+var expect = nano.expect;
+
+module.exports = function(admin) {
+
+  var expectedSchema = {
+    type: 'object',
+    properties: {
+      name: {type: 'string'},
+      key: {type: 'string'},
+      secret: {type: 'string'},
+      confidential: {type: 'boolean'},
+      'redirect-uris': {type: 'array', items: {type: 'string'}},
+      scopes: {type: 'array', items: {type: 'string'}},
+      'access-token-lifetime': {type: 'integer'},
+      'refresh-token-lifetime': {type: 'integer'}
+    },
+    required: ['name', 'key', 'confidential', 'redirect-uris', 'scopes', 'access-token-lifetime', 'refresh-token-lifetime'],
+    additionalProperties: false
+  };
+
+  var redirectURI = 'https://client.example.com/callback';
+
+  describe('List OAuth clients', function() {
+    nano.as(admin).get('api/oauth-clients')
+        .shouldReturn(200)
+        .shouldBeJSONAPI()
+        .shouldComplyTo(expectedSchema);
+  });
+
+  describe('Create an OAuth client with an http redirect URI', function() {
+    nano.as(admin).post('api/oauth-clients', {
+      data: {
+        type: 'oauth-clients',
+        attributes: {
+          name: 'Insecure client',
+          'redirect-uris': ['http://client.example.com/callback']
+        }
+      }
+    })
+        .shouldReturn(400);
+  });
+
+  describe('Create a confidential OAuth client', function() {
+    var request = nano.as(admin).post('api/oauth-clients', {
+      data: {
+        type: 'oauth-clients',
+        attributes: {
+          name: 'Confidential client',
+          'redirect-uris': [redirectURI],
+          scopes: ['users:read']
+        }
+      }
+    })
+        .shouldReturn(201)
+        .shouldBeJSONAPI()
+        .shouldComplyTo(expectedSchema);
+
+    var client = request.response.data.data;
+
+    it('should return its secret once', function() {
+      expect(client.attributes.secret).to.have.length(64);
+    });
+
+    describe('Get it back', function() {
+      var found = nano.as(admin).get('api/oauth-clients/' + client.id)
+          .shouldReturn(200);
+
+      it('should not return its secret', function() {
+        expect(found.response.data.data.attributes.secret).to.not.exist;
+      });
+    });
+
+    describe('Log in with a wrong secret', function() {
+      nano.post('oauth/token', {
+        username: nano.ADMIN_USERNAME,
+        password: nano.ADMIN_PASSWORD,
+        grant_type: 'password'
+      }, {
+        headers: {
+          Authorization: 'Basic ' + new Buffer(client.attributes.key + ':wrong').toString('base64')
+        }
+      })
+          .shouldReturn(401);
+    });
+
+    describe('Delete it', function() {
+      nano.as(admin).delete('api/oauth-clients/' + client.id)
+          .shouldReturn(200);
+    });
+  });
+
+  describe('Authorization code grant', function() {
+    var request = nano.as(admin).post('api/oauth-clients', {
+      data: {
+        type: 'oauth-clients',
+        attributes: {
+          name: 'Public client',
+          confidential: false,
+          'redirect-uris': [redirectURI]
+        }
+      }
+    })
+        .shouldReturn(201);
+
+    var client = request.response.data.data;
+    var verifier = crypto.randomBytes(32).toString('hex');
+    var challenge = crypto.createHash('sha256').update(verifier).digest('base64')
+        .replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+
+    var query = querystring.stringify({
+      response_type: 'code',
+      client_id: client.attributes.key,
+      redirect_uri: redirectURI,
+      scope: 'users:read',
+      state: 'xyz',
+      code_challenge: challenge,
+      code_challenge_method: 'S256'
+    });
+
+    describe('Describe the request to the user', function() {
+      var consent = nano.as(admin).get('api/oauth/authorize?' + query)
+          .shouldReturn(200);
+
+      it('should name the client and the scopes', function() {
+        expect(consent.response.data.meta.client).to.equal('Public client');
+        expect(consent.response.data.meta.scopes).to.deep.equal(['users:read']);
+      });
+    });
+
+    describe('Approve it', function() {
+      var approval = nano.as(admin).post('api/oauth/authorize?' + query + '&approved=true')
+          .shouldReturn(200);
+
+      var redirect = url.parse(approval.response.data.meta.redirect, true);
+
+      it('should send the user back with a code', function() {
+        expect(redirect.query.code).to.exist;
+        expect(redirect.query.state).to.equal('xyz');
+      });
+
+      describe('Exchange the code without its verifier', function() {
+        nano.post('oauth/token', {
+          grant_type: 'authorization_code',
+          client_id: client.attributes.key,
+          code: redirect.query.code,
+          redirect_uri: redirectURI,
+          code_verifier: crypto.randomBytes(32).toString('hex')
+        })
+            .shouldReturn(400);
+      });
+
+      describe('Exchange the code afterwards', function() {
+        var token = nano.post('oauth/token', {
+          grant_type: 'authorization_code',
+          client_id: client.attributes.key,
+          code: redirect.query.code,
+          redirect_uri: redirectURI,
+          code_verifier: verifier
+        })
+            .shouldReturn(400);
+
+        it('should have been used up by the failed exchange', function() {
+          expect(token.response.data.error).to.equal('invalid_grant');
+        });
+      });
+    });
+
+    describe('Approve it again', function() {
+      var approval = nano.as(admin).post('api/oauth/authorize?' + query + '&approved=true')
+          .shouldReturn(200);
+
+      var code = url.parse(approval.response.data.meta.redirect, true).query.code;
+
+      describe('Exchange the code', function() {
+        var token = nano.post('oauth/token', {
+          grant_type: 'authorization_code',
+          client_id: client.attributes.key,
+          code: code,
+          redirect_uri: redirectURI,
+          code_verifier: verifier
+        })
+            .shouldReturn(200)
+            .shouldBeJSON();
+
+        it('should be limited to the approved scopes', function() {
+          expect(token.response.data.scope).to.equal('users:read');
+        });
+      });
+
+      describe('Exchange it again', function() {
+        nano.post('oauth/token', {
+          grant_type: 'authorization_code',
+          client_id: client.attributes.key,
+          code: code,
+          redirect_uri: redirectURI,
+          code_verifier: verifier
+        })
+            .shouldReturn(400);
+      });
+    });
+
+    describe('Deny it', function() {
+      var denial = nano.as(admin).post('api/oauth/authorize?' + query)
+          .shouldReturn(200);
+
+      it('should send the user back with an error', function() {
+        var redirect = url.parse(denial.response.data.meta.redirect, true);
+        expect(redirect.query.error).to.equal('access_denied');
+        expect(redirect.query.code).to.not.exist;
+      });
+    });
+
+    describe('Delete the client', function() {
+      nano.as(admin).delete('api/oauth-clients/' + client.id)
+          .shouldReturn(200);
+    });
+  });
+};
@@ -0,0 +1,265 @@
+#!/usr/bin/nodejs
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2015 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+
+// jshint mocha:true
+
+var nano = require('./nanotest');
+var expect = nano.expect;
+
+module.exports = function(admin) {
+
+  var expectedSchema = {
+    type: 'object',
+    properties: {
+      name: {type: 'string'},
+      activated: {type: 'boolean'},
+      key: {type: 'string'},
+      secret: {type: 'string'},
+      scopes: {type: 'array', items: {type: 'string'}},
+      'access-token-lifetime': {type: 'integer'}
+    },
+    required: ['name', 'activated', 'key', 'scopes', 'access-token-lifetime'],
+    additionalProperties: false
+  };
+
+  var tokenSchema = {
+    type: 'object',
+    properties: {
+      access_token: {type: 'string'},
+      token_type: {type: 'string'},
+      expires_in: {type: 'integer'},
+      scope: {type: 'string'}
+    },
+    required: ['access_token', 'token_type', 'expires_in', 'scope'],
+    additionalProperties: false
+  };
+
+  describe('List service accounts', function() {
+    nano.as(admin).get('api/service-accounts')
+        .shouldReturn(200)
+        .shouldBeJSONAPI()
+        .shouldComplyTo(expectedSchema);
+  });
+
+  describe('Create a service account without name', function() {
+    nano.as(admin).post('api/service-accounts', {
+      data: {
+        type: 'service-accounts',
+        attributes: {
+          name: ''
+        }
+      }
+    })
+        .shouldReturn(400);
+  });
+
+  describe('Create a service account', function() {
+    var request = nano.as(admin).post('api/service-accounts', {
+      data: {
+        type: 'service-accounts',
+        attributes: {
+          name: 'ci',
+          scopes: ['users:read']
+        }
+      }
+    })
+        .shouldReturn(201)
+        .shouldBeJSONAPI()
+        .shouldComplyTo(expectedSchema);
+
+    var account = request.response.data.data;
+    var clientAuth = {
+      headers: {
+        Authorization: 'Basic ' + new Buffer(account.attributes.key + ':' + account.attributes.secret).toString('base64')
+      }
+    };
+
+    describe('Should not be listed with the users', function() {
+      var users = nano.as(admin).get('api/users')
+          .shouldReturn(200);
+
+      it('should not contain the service account', function() {
+        users.response.data.data.forEach(function(user) {
+          expect(user.id).to.not.equal(account.id);
+        });
+      });
+    });
+
+    describe('Log in with a wrong secret', function() {
+      nano.post('oauth/token', {
+        grant_type: 'client_credentials'
+      }, {
+        headers: {
+          Authorization: 'Basic ' + new Buffer(account.attributes.key + ':wrong').toString('base64')
+        }
+      })
+          .shouldReturn(401);
+    });
+
+    describe('Log in with the password grant', function() {
+      nano.post('oauth/token', {
+        username: nano.ADMIN_USERNAME,
+        password: nano.ADMIN_PASSWORD,
+        grant_type: 'password'
+      }, clientAuth)
+          .shouldReturn(401);
+    });
+
+    describe('Log in with the client_credentials grant', function() {
+      var login = nano.post('oauth/token', {
+        grant_type: 'client_credentials'
+      }, clientAuth)
+          .shouldReturn(200)
+          .shouldBeJSON()
+          .shouldComplyToNotJsonAPI(tokenSchema);
+
+      var serviceAccount = {access_token: login.response.data.access_token};
+
+      describe('Without role', function() {
+        nano.as(serviceAccount).get('api/users')
+            .shouldReturn(403);
+      });
+
+      describe('With a role', function() {
+        var role = nano.as(admin).post('api/roles', {
+          data: {
+            type: 'roles',
+            attributes: {
+              name: 'CI',
+              permissions: ['users:read', 'users:write']
+            }
+          }
+        })
+            .shouldReturn(201);
+
+        var roleId = role.response.data.data.id;
+
+        nano.as(admin).put('api/roles/' + roleId + '/users/' + account.id)
+            .shouldReturn(200);
+
+        nano.as(serviceAccount).get('api/users')
+            .shouldReturn(200);
+
+        // the role grants users:write but the token is only scoped users:read
+        nano.as(serviceAccount).post('api/users', {
+          data: {
+            type: 'users',
+            attributes: {
+              email: 'scoped@nanocloud.com',
+              'first-name': 'Scoped',
+              'last-name': 'Token',
+              password: 'secret'
+            }
+          }
+        })
+            .shouldReturn(403);
+
+        nano.as(admin).delete('api/roles/' + roleId)
+            .shouldReturn(200);
+      });
+
+      describe('Introspect its token', function() {
+        var introspection = nano.post('oauth/introspect', {
+          token: serviceAccount.access_token
+        }, clientAuth)
+            .shouldReturn(200)
+            .shouldBeJSON();
+
+        it('should be active and scoped', function() {
+          var token = introspection.response.data;
+          expect(token.active).to.equal(true);
+          expect(token.scope).to.equal('users:read');
+          expect(token.client_id).to.equal(account.attributes.key);
+          expect(token.sub).to.equal(account.id);
+          expect(token.username).to.equal('ci');
+        });
+      });
+
+      describe('Introspect an unknown token', function() {
+        var introspection = nano.post('oauth/introspect', {
+          token: 'unknown'
+        }, clientAuth)
+            .shouldReturn(200)
+            .shouldBeJSON();
+
+        it('should be inactive', function() {
+          expect(introspection.response.data).to.deep.equal({active: false});
+        });
+      });
+
+      describe('Introspect without client credentials', function() {
+        nano.post('oauth/introspect', {
+          token: serviceAccount.access_token
+        })
+            .shouldReturn(401);
+      });
+
+      describe('List its tokens', function() {
+        var tokens = nano.as(admin).get('api/service-accounts/' + account.id + '/tokens')
+            .shouldReturn(200)
+            .shouldBeJSONAPI();
+
+        it('should contain its token', function() {
+          expect(tokens.response.data.data).to.have.lengthOf(1);
+          expect(tokens.response.data.data[0].attributes.client).to.equal('ci');
+        });
+      });
+
+      describe('Disable it', function() {
+        nano.as(admin).patch('api/service-accounts/' + account.id, {
+          data: {
+            type: 'service-accounts',
+            id: account.id,
+            attributes: {
+              activated: false
+            }
+          }
+        })
+            .shouldReturn(200);
+
+        nano.as(serviceAccount).get('api/tokens')
+            .shouldReturn(401);
+
+        var refused = nano.post('oauth/token', {
+          grant_type: 'client_credentials'
+        }, clientAuth)
+            .shouldReturn(400);
+
+        it('should be an unauthorized client', function() {
+          expect(refused.response.data.error).to.equal('unauthorized_client');
+        });
+      });
+    });
+
+    describe('Delete it', function() {
+      nano.as(admin).delete('api/service-accounts/' + account.id)
+          .shouldReturn(200);
+
+      nano.post('oauth/token', {
+        grant_type: 'client_credentials'
+      }, clientAuth)
+          .shouldReturn(401);
+    });
+  });
+};
@@ -0,0 +1,41 @@
+import Ember from 'ember';
+
+export default Ember.Controller.extend({
+  queryParams: [
+    'response_type',
+    'client_id',
+    'redirect_uri',
+    'scope',
+    'state',
+    'code_challenge',
+    'code_challenge_method'
+  ],
+
+  deciding: false,
+
+  decide(approved) {
+    this.set('deciding', true);
+    Ember.$.ajax({
+      type: 'POST',
+      headers: { Authorization : 'Bearer ' + this.get('session.data.authenticated.access_token')},
+      url: this.get('model.url'),
+      data: { approved: approved }
+    })
+    .then((response) => {
+      window.location.assign(response.meta.redirect);
+    }, () => {
+      this.set('deciding', false);
+      this.toast.error('The authorization request could not be answered');
+    });
+  },
+
+  actions: {
+    allow() {
+      this.decide(true);
+    },
+
+    deny() {
+      this.decide(false);
+    }
+  }
+});
@@ -0,0 +1,51 @@
+import Ember from 'ember';
+
+const parameters = [
+  'response_type',
+  'client_id',
+  'redirect_uri',
+  'scope',
+  'state',
+  'code_challenge',
+  'code_challenge_method'
+];
+
+export default Ember.Route.extend({
+
+  beforeModel(transition) {
+    if (this.get('session.isAuthenticated') === false) {
+      this.set('session.attemptedTransition', transition);
+      this.transitionTo('login');
+    }
+  },
+
+  model(params) {
+    let query = {};
+    parameters.forEach((name) => {
+      if (params[name]) {
+        query[name] = params[name];
+      }
+    });
+
+    let url = '/api/oauth/authorize?' + Ember.$.param(query);
+    let request = Ember.$.ajax({
+      type: 'GET',
+      headers: { Authorization : 'Bearer ' + this.get('session.data.authenticated.access_token')},
+      url: url
+    });
+
+    return Ember.RSVP.resolve(request)
+    .then((response) => {
+      return {
+        url: url,
+        client: response.meta.client,
+        redirectURI: response.meta['redirect-uri'],
+        scopes: response.meta.scopes.map((scope) => {
+          return scope === '*' ? 'Everything you are allowed to do' : scope;
+        })
+      };
+    }, (xhr) => {
+      throw xhr.responseJSON || xhr;
+    });
+  }
+});
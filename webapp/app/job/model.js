@@ -0,0 +1,17 @@
+import Ember from 'ember';
+import DS from 'ember-data';
+
+export default DS.Model.extend({
+  action: DS.attr('string'),
+  target: DS.attr('string'),
+  state: DS.attr('string'),
+  progress: DS.attr('number'),
+  error: DS.attr('string'),
+  userId: DS.attr('string'),
+  createdAt: DS.attr('date'),
+  updatedAt: DS.attr('date'),
+
+  isDone: Ember.computed('state', function() {
+    return this.get('state') === 'succeeded' || this.get('state') === 'failed';
+  }),
+});
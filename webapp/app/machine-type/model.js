@@ -1,4 +1,11 @@
 import DS from 'ember-data';
 
 export default DS.Model.extend({
+  name: DS.attr('string'),
+  driver: DS.attr('string'),
+  cpu: DS.attr('number'),
+  ram: DS.attr('number'),
+  disk: DS.attr('number'),
+  image: DS.attr('string'),
+  options: DS.attr()
 });
@@ -0,0 +1,14 @@
+import ApplicationAdapter from '../application/adapter';
+
+export default ApplicationAdapter.extend({
+
+  // Machine operations are asynchronous: the API replies 202 with the job
+  // performing the operation instead of the machine itself.
+  handleResponse(status, headers, payload) {
+    if (status === 202 && payload && payload.data && payload.data.type === 'jobs') {
+      this.get('store').pushPayload(payload);
+      return { data: null, meta: { job: payload.data.id } };
+    }
+    return this._super(...arguments);
+  }
+});
@@ -8,6 +8,7 @@ export default DS.Model.extend({
   adminPassword: DS.attr('string'),
   platform: DS.attr('string'),
   progress: DS.attr('number'),
+  health: DS.attr('string'),
 
   type: DS.belongsTo('machine-type'),
   driver: DS.belongsTo('machine-driver'),
@@ -20,11 +20,12 @@ export default Ember.Controller.extend({
 
     createMachine() {
       let type;
-      let driver = this.get('drivers').objectAt(0);
-      driver.get('types').forEach((item) => {
-        if (this.get('selectedItem') === item.id) {
-          type = item;
-        }
+      this.get('drivers').forEach((driver) => {
+        driver.get('types').forEach((item) => {
+          if (this.get('selectedItem') === item.id) {
+            type = item;
+          }
+        });
       });
       if (!this.machineName) {
         this.toast.error("Insert a machine name");
@@ -35,15 +36,28 @@ export default Ember.Controller.extend({
         return;
       }
 
-      let m = this.store.createRecord('machine', {
-        name: this.get('machineName'),
-        type: type,
-        driver: driver
-      });
-
-      m.save()
-      .then((machine) => {
-        this.transitionToRoute('protected.machines.machine', machine);
+      // The machine is created by a job, there is no machine record to save yet.
+      let adapter = this.store.adapterFor('machine');
+      adapter.ajax(adapter.buildURL('machine'), 'POST', {
+        data: {
+          data: {
+            type: 'machines',
+            attributes: {
+              name: this.get('machineName')
+            },
+            relationships: {
+              type: {
+                data: { type: 'machine-types', id: type.id }
+              }
+            }
+          }
+        }
+      })
+      .then(() => {
+        this.toast.success("The machine is being created");
+        this.transitionToRoute('protected.machines');
+      }, () => {
+        this.toast.error("The machine cannot be created");
       });
     }
   }
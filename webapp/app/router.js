@@ -27,6 +27,7 @@ Router.map(function() {
   });
 
   this.route('login');
+  this.route('authorize');
   this.route('direct-link');
 });
 